@@ -0,0 +1,167 @@
+package admission
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/diego_errors"
+	"code.cloudfoundry.org/stager/openapi"
+)
+
+const DefaultTimeout = 5 * time.Second
+
+var ErrUnavailable = diego_errors.ErrAdmissionUnavailable
+
+//go:generate counterfeiter -o fakes/fake_client.go . Client
+type Client interface {
+	Admit(logger lager.Logger, stagingGuid string, request cc_messages.StagingRequestFromCC) (cc_messages.StagingRequestFromCC, error)
+}
+
+type Review struct {
+	StagingGuid string                           `json:"staging_guid"`
+	Request     cc_messages.StagingRequestFromCC `json:"request"`
+}
+
+type Decision struct {
+	Allowed bool                              `json:"allowed"`
+	Message string                            `json:"message,omitempty"`
+	Request *cc_messages.StagingRequestFromCC `json:"request,omitempty"`
+}
+
+type DeniedError struct {
+	Message string
+}
+
+func (e *DeniedError) Error() string {
+	if e.Message == "" {
+		return diego_errors.ErrStagingRequestDenied.Message
+	}
+	return fmt.Sprintf("%s: %s", diego_errors.ErrStagingRequestDenied.Message, e.Message)
+}
+
+// TypedError shows the admission webhook's message to users.
+func (e *DeniedError) TypedError() *diego_errors.Error {
+	return diego_errors.New(diego_errors.CodeStagingRequestDenied, e.Error())
+}
+
+type client struct {
+	url        string
+	failOpen   bool
+	httpClient *http.Client
+}
+
+func NewClient(url string, timeout time.Duration, failOpen bool, httpClient *http.Client) Client {
+	// copy the client so the timeout does not leak into the caller's client
+	c := http.Client{}
+	if httpClient != nil {
+		c = *httpClient
+	}
+	c.Timeout = timeout
+
+	return &client{
+		url:        url,
+		failOpen:   failOpen,
+		httpClient: &c,
+	}
+}
+
+func (c *client) Admit(logger lager.Logger, stagingGuid string, request cc_messages.StagingRequestFromCC) (cc_messages.StagingRequestFromCC, error) {
+	logger = logger.Session("admission", lager.Data{"url": c.url, "fail-open": c.failOpen})
+
+	decision, mutation, err := c.review(stagingGuid, request)
+	if err != nil {
+		logger.Error("admission-review-failed", err)
+		if c.failOpen {
+			return request, nil
+		}
+		return request, ErrUnavailable
+	}
+
+	if !decision.Allowed {
+		logger.Info("staging-request-denied", lager.Data{"message": decision.Message})
+		return request, &DeniedError{Message: decision.Message}
+	}
+
+	if mutation != nil {
+		mutatedRequest, err := parseMutation(mutation)
+		if err != nil {
+			logger.Error("invalid-mutated-request", err)
+			return request, err
+		}
+
+		logger.Info("staging-request-mutated")
+		return mutatedRequest, nil
+	}
+
+	return request, nil
+}
+
+// parseMutation checks that a request mutated by the webhook passes the same
+// validation as one from CC. The webhook answered, so a broken mutation
+// rejects the staging even when failing open.
+func parseMutation(mutation json.RawMessage) (cc_messages.StagingRequestFromCC, error) {
+	var request cc_messages.StagingRequestFromCC
+
+	err := openapi.ValidateStagingRequest(mutation)
+	if err != nil {
+		return request, diego_errors.ErrInvalidAdmittedRequest.WithDetail(err.Error())
+	}
+
+	err = json.Unmarshal(mutation, &request)
+	if err != nil {
+		return request, diego_errors.ErrInvalidAdmittedRequest.WithDetail(err.Error())
+	}
+
+	return request, nil
+}
+
+// review asks the webhook for its decision, and returns the mutated request
+// of an allowed one, if any, unparsed.
+func (c *client) review(stagingGuid string, request cc_messages.StagingRequestFromCC) (*Decision, json.RawMessage, error) {
+	payload, err := json.Marshal(Review{
+		StagingGuid: stagingGuid,
+		Request:     request,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	httpRequest, err := http.NewRequest("POST", c.url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, nil, err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+
+	response, err := c.httpClient.Do(httpRequest)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("admission webhook responded with %d", response.StatusCode)
+	}
+
+	var body struct {
+		Allowed bool            `json:"allowed"`
+		Message string          `json:"message,omitempty"`
+		Request json.RawMessage `json:"request,omitempty"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// a denial stands whatever else the webhook returned
+	decision := &Decision{Allowed: body.Allowed, Message: body.Message}
+	if !body.Allowed || len(body.Request) == 0 || string(body.Request) == "null" {
+		return decision, nil, nil
+	}
+
+	return decision, body.Request, nil
+}
@@ -0,0 +1,201 @@
+package admission_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/admission"
+	"code.cloudfoundry.org/stager/diego_errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("Admission Client", func() {
+	var (
+		webhook *ghttp.Server
+		logger  lager.Logger
+
+		failOpen bool
+		timeout  time.Duration
+		client   admission.Client
+
+		stagingRequest  cc_messages.StagingRequestFromCC
+		admittedRequest cc_messages.StagingRequestFromCC
+		admitErr        error
+	)
+
+	BeforeEach(func() {
+		webhook = ghttp.NewServer()
+		logger = lagertest.NewTestLogger("test")
+
+		failOpen = false
+		timeout = time.Second
+
+		lifecycleData := json.RawMessage(`{"app_bits_download_uri":"http://example.com/bits","stack":"cflinuxfs2"}`)
+		stagingRequest = cc_messages.StagingRequestFromCC{
+			AppId:         "app-id",
+			MemoryMB:      4096,
+			Lifecycle:     "buildpack",
+			LifecycleData: &lifecycleData,
+		}
+	})
+
+	AfterEach(func() {
+		webhook.Close()
+	})
+
+	JustBeforeEach(func() {
+		client = admission.NewClient(webhook.URL()+"/admit", timeout, failOpen, nil)
+		admittedRequest, admitErr = client.Admit(logger, "staging-guid", stagingRequest)
+	})
+
+	Context("when the webhook allows the request", func() {
+		BeforeEach(func() {
+			webhook.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/admit"),
+				ghttp.VerifyHeaderKV("Content-Type", "application/json"),
+				func(w http.ResponseWriter, req *http.Request) {
+					var review admission.Review
+					err := json.NewDecoder(req.Body).Decode(&review)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(review.StagingGuid).To(Equal("staging-guid"))
+					Expect(review.Request.AppId).To(Equal("app-id"))
+					Expect(*review.Request.LifecycleData).To(MatchJSON(`{"app_bits_download_uri":"http://example.com/bits","stack":"cflinuxfs2"}`))
+				},
+				ghttp.RespondWithJSONEncoded(http.StatusOK, admission.Decision{Allowed: true}),
+			))
+		})
+
+		It("returns the request unchanged", func() {
+			Expect(admitErr).NotTo(HaveOccurred())
+			Expect(admittedRequest).To(Equal(stagingRequest))
+		})
+	})
+
+	Context("when the webhook mutates the request", func() {
+		BeforeEach(func() {
+			mutated := stagingRequest
+			mutated.MemoryMB = 1024
+			mutated.Environment = []*models.EnvironmentVariable{{Name: "INJECTED", Value: "true"}}
+
+			webhook.AppendHandlers(ghttp.RespondWithJSONEncoded(http.StatusOK, admission.Decision{
+				Allowed: true,
+				Request: &mutated,
+			}))
+		})
+
+		It("returns the mutated request", func() {
+			Expect(admitErr).NotTo(HaveOccurred())
+			Expect(admittedRequest.MemoryMB).To(Equal(1024))
+			Expect(admittedRequest.Environment).To(Equal([]*models.EnvironmentVariable{{Name: "INJECTED", Value: "true"}}))
+		})
+	})
+
+	Context("when the webhook mutates the request into an invalid one", func() {
+		BeforeEach(func() {
+			webhook.AppendHandlers(ghttp.RespondWith(http.StatusOK, `{
+				"allowed": true,
+				"request": {"app_id": "app-id", "lifecycle": "buildpack", "lifecycle_data": {"stack": "cflinuxfs2"}}
+			}`))
+		})
+
+		It("rejects the staging", func() {
+			Expect(admitErr).To(HaveOccurred())
+			Expect(diego_errors.FromError(admitErr).Message).To(Equal(diego_errors.ErrInvalidAdmittedRequest.Message))
+		})
+
+		Context("when configured to fail open", func() {
+			BeforeEach(func() {
+				failOpen = true
+			})
+
+			It("still rejects the staging", func() {
+				Expect(admitErr).To(HaveOccurred())
+				Expect(diego_errors.FromError(admitErr).Message).To(Equal(diego_errors.ErrInvalidAdmittedRequest.Message))
+			})
+		})
+	})
+
+	Context("when the webhook denies the request and returns an invalid one", func() {
+		BeforeEach(func() {
+			failOpen = true
+			webhook.AppendHandlers(ghttp.RespondWith(http.StatusOK, `{
+				"allowed": false,
+				"message": "memory quota exceeded",
+				"request": {"lifecycle": 42}
+			}`))
+		})
+
+		It("honours the denial", func() {
+			Expect(admitErr).To(MatchError(&admission.DeniedError{Message: "memory quota exceeded"}))
+		})
+	})
+
+	Context("when the webhook denies the request", func() {
+		BeforeEach(func() {
+			webhook.AppendHandlers(ghttp.RespondWithJSONEncoded(http.StatusOK, admission.Decision{
+				Allowed: false,
+				Message: "memory quota exceeded",
+			}))
+		})
+
+		It("returns a denied error with the message", func() {
+			Expect(admitErr).To(MatchError(&admission.DeniedError{Message: "memory quota exceeded"}))
+			Expect(admitErr.Error()).To(Equal("staging request denied: memory quota exceeded"))
+		})
+	})
+
+	Context("when the webhook fails", func() {
+		BeforeEach(func() {
+			webhook.AppendHandlers(ghttp.RespondWith(http.StatusInternalServerError, ""))
+		})
+
+		It("fails closed by default", func() {
+			Expect(admitErr).To(Equal(admission.ErrUnavailable))
+		})
+
+		Context("when configured to fail open", func() {
+			BeforeEach(func() {
+				failOpen = true
+			})
+
+			It("admits the request unchanged", func() {
+				Expect(admitErr).NotTo(HaveOccurred())
+				Expect(admittedRequest).To(Equal(stagingRequest))
+			})
+		})
+	})
+
+	Context("when given an http client", func() {
+		var httpClient *http.Client
+
+		BeforeEach(func() {
+			httpClient = &http.Client{Timeout: time.Minute}
+			webhook.AppendHandlers(ghttp.RespondWithJSONEncoded(http.StatusOK, admission.Decision{Allowed: true}))
+		})
+
+		It("leaves the client's timeout alone", func() {
+			admission.NewClient(webhook.URL()+"/admit", timeout, failOpen, httpClient)
+			Expect(httpClient.Timeout).To(Equal(time.Minute))
+		})
+	})
+
+	Context("when the webhook does not respond in time", func() {
+		BeforeEach(func() {
+			timeout = 50 * time.Millisecond
+			webhook.AppendHandlers(func(w http.ResponseWriter, req *http.Request) {
+				time.Sleep(200 * time.Millisecond)
+			})
+		})
+
+		It("returns an unavailable error", func() {
+			Expect(admitErr).To(Equal(admission.ErrUnavailable))
+		})
+	})
+})
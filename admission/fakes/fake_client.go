@@ -0,0 +1,61 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/admission"
+)
+
+type FakeClient struct {
+	AdmitStub        func(logger lager.Logger, stagingGuid string, request cc_messages.StagingRequestFromCC) (cc_messages.StagingRequestFromCC, error)
+	admitMutex       sync.RWMutex
+	admitArgsForCall []struct {
+		logger      lager.Logger
+		stagingGuid string
+		request     cc_messages.StagingRequestFromCC
+	}
+	admitReturns struct {
+		result1 cc_messages.StagingRequestFromCC
+		result2 error
+	}
+}
+
+func (fake *FakeClient) Admit(logger lager.Logger, stagingGuid string, request cc_messages.StagingRequestFromCC) (cc_messages.StagingRequestFromCC, error) {
+	fake.admitMutex.Lock()
+	fake.admitArgsForCall = append(fake.admitArgsForCall, struct {
+		logger      lager.Logger
+		stagingGuid string
+		request     cc_messages.StagingRequestFromCC
+	}{logger, stagingGuid, request})
+	fake.admitMutex.Unlock()
+	if fake.AdmitStub != nil {
+		return fake.AdmitStub(logger, stagingGuid, request)
+	} else {
+		return fake.admitReturns.result1, fake.admitReturns.result2
+	}
+}
+
+func (fake *FakeClient) AdmitCallCount() int {
+	fake.admitMutex.RLock()
+	defer fake.admitMutex.RUnlock()
+	return len(fake.admitArgsForCall)
+}
+
+func (fake *FakeClient) AdmitArgsForCall(i int) (lager.Logger, string, cc_messages.StagingRequestFromCC) {
+	fake.admitMutex.RLock()
+	defer fake.admitMutex.RUnlock()
+	return fake.admitArgsForCall[i].logger, fake.admitArgsForCall[i].stagingGuid, fake.admitArgsForCall[i].request
+}
+
+func (fake *FakeClient) AdmitReturns(result1 cc_messages.StagingRequestFromCC, result2 error) {
+	fake.AdmitStub = nil
+	fake.admitReturns = struct {
+		result1 cc_messages.StagingRequestFromCC
+		result2 error
+	}{result1, result2}
+}
+
+var _ admission.Client = new(FakeClient)
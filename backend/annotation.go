@@ -0,0 +1,50 @@
+package backend
+
+import (
+	"encoding/json"
+
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+)
+
+// StagingTaskAnnotation extends the annotation CC knows about with the
+// stager's own bookkeeping. The extra fields are omitted when unset so that
+// annotations of first attempts stay identical to cc_messages'.
+type StagingTaskAnnotation struct {
+	cc_messages.StagingTaskAnnotation
+
+	AppId       string `json:"app_id,omitempty"`
+	StagingGuid string `json:"staging_guid,omitempty"`
+	Attempt     int    `json:"attempt,omitempty"`
+	CacheKey    string `json:"cache_key,omitempty"`
+	Traceparent string `json:"traceparent,omitempty"`
+	RequestId   string `json:"request_id,omitempty"`
+
+	// LifecycleBundle, LifecycleBundleChecksum and LifecycleCanary record
+	// which bundle a lifecycle with a canary was staged with.
+	LifecycleBundle         string `json:"lifecycle_bundle,omitempty"`
+	LifecycleBundleChecksum string `json:"lifecycle_bundle_checksum,omitempty"`
+	LifecycleCanary         bool   `json:"lifecycle_canary,omitempty"`
+}
+
+func ParseStagingTaskAnnotation(annotation string) (StagingTaskAnnotation, error) {
+	var stagingAnnotation StagingTaskAnnotation
+	err := json.Unmarshal([]byte(annotation), &stagingAnnotation)
+	return stagingAnnotation, err
+}
+
+// StagingGuidFor returns the guid CC staged under, which differs from the
+// task guid for retried attempts.
+func (a StagingTaskAnnotation) StagingGuidFor(taskGuid string) string {
+	if a.StagingGuid != "" {
+		return a.StagingGuid
+	}
+	return taskGuid
+}
+
+// AttemptNumber returns the 1-based attempt of the staging task.
+func (a StagingTaskAnnotation) AttemptNumber() int {
+	if a.Attempt < 1 {
+		return 1
+	}
+	return a.Attempt
+}
@@ -0,0 +1,35 @@
+package backend
+
+import (
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/stager/diego_errors"
+	"github.com/cloudfoundry/dropsonde/logs"
+)
+
+// stagingLogSourceInstance matches the instance index Diego gives the logs
+// of staging tasks.
+const stagingLogSourceInstance = "0"
+
+// EmitStagingRejection tells the user why a staging failed before its task
+// could be desired. Nothing else would show up in their app's logs.
+func EmitStagingRejection(logger lager.Logger, classifier *diego_errors.Classifier, logGuid string, err error) {
+	if logGuid == "" {
+		return
+	}
+
+	sendErr := logs.SendAppErrorLog(logGuid, stagingRejectionMessage(classifier, err), TaskLogSource, stagingLogSourceInstance)
+	if sendErr != nil {
+		logger.Error("failed-to-emit-app-log", sendErr, lager.Data{"log-guid": logGuid})
+	}
+}
+
+// stagingRejectionMessage explains the errors the stager recognises. Others
+// are the operator's to look into, so they are not described.
+func stagingRejectionMessage(classifier *diego_errors.Classifier, err error) string {
+	stagingErr := classifier.FromError(err)
+	if stagingErr.Message == diego_errors.ErrStagingFailed.Message {
+		return "Staging failed: the staging task could not be started"
+	}
+
+	return "Staging failed: " + stagingErr.Message
+}
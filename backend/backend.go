@@ -1,16 +1,13 @@
 package backend
 
 import (
-	"errors"
 	"fmt"
 	"net/url"
-	"strconv"
-	"strings"
 	"time"
 
 	"code.cloudfoundry.org/bbs/models"
-	"code.cloudfoundry.org/buildpackapplifecycle"
 	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/canary"
 	"code.cloudfoundry.org/stager/diego_errors"
 )
 
@@ -24,14 +21,14 @@ type FailureReasonSanitizer func(string) *cc_messages.StagingError
 
 //go:generate counterfeiter -o fake_backend/fake_backend.go . Backend
 type Backend interface {
-	BuildRecipe(stagingGuid string, request cc_messages.StagingRequestFromCC) (*models.TaskDefinition, string, string, error)
+	BuildRecipe(requestId, stagingGuid string, request cc_messages.StagingRequestFromCC) (*models.TaskDefinition, string, string, error)
 	BuildStagingResponse(*models.TaskCallbackResponse) (cc_messages.StagingResponseForCC, error)
 }
 
-var ErrNoCompilerDefined = errors.New(diego_errors.NO_COMPILER_DEFINED_MESSAGE)
-var ErrMissingAppId = errors.New(diego_errors.MISSING_APP_ID_MESSAGE)
-var ErrMissingAppBitsDownloadUri = errors.New(diego_errors.MISSING_APP_BITS_DOWNLOAD_URI_MESSAGE)
-var ErrMissingLifecycleData = errors.New(diego_errors.MISSING_LIFECYCLE_DATA_MESSAGE)
+var ErrNoCompilerDefined = diego_errors.ErrNoCompilerDefined
+var ErrMissingAppId = diego_errors.ErrMissingAppId
+var ErrMissingAppBitsDownloadUri = diego_errors.ErrMissingAppBitsDownloadUri
+var ErrMissingLifecycleData = diego_errors.ErrMissingLifecycleData
 
 type Config struct {
 	TaskDomain               string
@@ -39,13 +36,22 @@ type Config struct {
 	FileServerURL            string
 	CCUploaderURL            string
 	Lifecycles               map[string]string
+	LifecycleChecksums       map[string]Checksum
+	RequireChecksums         bool
+	BundleVersionFetcher     BundleVersionFetcher
+	LifecycleCanaries        map[string]Canary
+	CanaryTracker            *canary.Tracker
 	DockerRegistryAddress    string
 	InsecureDockerRegistries []string
 	ConsulCluster            string
 	SkipCertVerify           bool
 	Sanitizer                FailureReasonSanitizer
+	Classifier               *diego_errors.Classifier
 	DockerStagingStack       string
 	PrivilegedContainers     bool
+	DockerImagePolicy        DockerImagePolicy
+	StagingResources         map[string]StagingResources
+	StagingTimeouts          map[string]TimeoutPolicy
 }
 
 func (c Config) CallbackURL(stagingGuid string) string {
@@ -60,42 +66,32 @@ func max(x, y uint64) uint64 {
 	}
 }
 
-func addTimeoutParamToURL(u url.URL, timeout time.Duration) *url.URL {
+// AddTimeoutParamToURL returns u with the time cc-uploader should allow for
+// an upload through it.
+func AddTimeoutParamToURL(u url.URL, timeout time.Duration) *url.URL {
 	query := u.Query()
 	query.Set(cc_messages.CcTimeoutKey, fmt.Sprintf("%.0f", timeout.Seconds()))
 	u.RawQuery = query.Encode()
 	return &u
 }
 
+// SanitizeErrorMessage classifies a Diego failure reason into the error
+// reported to CC.
 func SanitizeErrorMessage(message string) *cc_messages.StagingError {
-	const staging_failed = "staging failed"
-	id := cc_messages.STAGING_ERROR
-	switch {
-	case strings.HasSuffix(message, strconv.Itoa(buildpackapplifecycle.DETECT_FAIL_CODE)):
-		id = cc_messages.BUILDPACK_DETECT_FAILED
-		message = staging_failed
-	case strings.HasSuffix(message, strconv.Itoa(buildpackapplifecycle.COMPILE_FAIL_CODE)):
-		id = cc_messages.BUILDPACK_COMPILE_FAILED
-		message = staging_failed
-	case strings.HasSuffix(message, strconv.Itoa(buildpackapplifecycle.RELEASE_FAIL_CODE)):
-		id = cc_messages.BUILDPACK_RELEASE_FAILED
-		message = staging_failed
-	case strings.HasPrefix(message, diego_errors.INSUFFICIENT_RESOURCES_MESSAGE):
-		id = cc_messages.INSUFFICIENT_RESOURCES
-	case strings.HasPrefix(message, diego_errors.CELL_MISMATCH_MESSAGE):
-		id = cc_messages.NO_COMPATIBLE_CELL
-	case message == diego_errors.CELL_COMMUNICATION_ERROR:
-		id = cc_messages.CELL_COMMUNICATION_ERROR
-	case message == diego_errors.MISSING_DOCKER_IMAGE_URL:
-	case message == diego_errors.MISSING_DOCKER_REGISTRY:
-	case message == diego_errors.MISSING_DOCKER_CREDENTIALS:
-	case message == diego_errors.INVALID_DOCKER_REGISTRY_ADDRESS:
-	default:
-		message = "staging failed"
-	}
+	return diego_errors.Classify(message).StagingError()
+}
 
-	return &cc_messages.StagingError{
-		Id:      id,
-		Message: message,
+// NewSanitizer returns a FailureReasonSanitizer that classifies failure
+// reasons with the given classifier, e.g. one including operator rules.
+func NewSanitizer(classifier *diego_errors.Classifier) FailureReasonSanitizer {
+	return func(reason string) *cc_messages.StagingError {
+		return classifier.Classify(reason).StagingError()
 	}
 }
+
+// SanitizeError returns the error reported to CC for a failure of the
+// stager itself, keeping the message of typed errors and classifying the
+// others with classifier.
+func SanitizeError(classifier *diego_errors.Classifier, err error) *cc_messages.StagingError {
+	return classifier.FromError(err).StagingError()
+}
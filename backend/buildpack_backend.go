@@ -7,7 +7,6 @@ import (
 	"net/url"
 	"path"
 	"strings"
-	"time"
 
 	"code.cloudfoundry.org/bbs/models"
 	"code.cloudfoundry.org/buildpackapplifecycle"
@@ -37,8 +36,8 @@ func NewTraditionalBackend(config Config, logger lager.Logger) Backend {
 	}
 }
 
-func (backend *traditionalBackend) BuildRecipe(stagingGuid string, request cc_messages.StagingRequestFromCC) (*models.TaskDefinition, string, string, error) {
-	logger := backend.logger.Session("build-recipe", lager.Data{"app-id": request.AppId, "staging-guid": stagingGuid})
+func (backend *traditionalBackend) BuildRecipe(requestId, stagingGuid string, request cc_messages.StagingRequestFromCC) (*models.TaskDefinition, string, string, error) {
+	logger := backend.logger.Session("build-recipe", lager.Data{"app-id": request.AppId, "staging-guid": stagingGuid, "request-id": requestId})
 	logger.Info("staging-request")
 
 	if request.LifecycleData == nil {
@@ -56,7 +55,12 @@ func (backend *traditionalBackend) BuildRecipe(stagingGuid string, request cc_me
 		return &models.TaskDefinition{}, "", "", err
 	}
 
-	compilerURL, err := backend.compilerDownloadURL(request, lifecycleData)
+	bundle, ok := backend.config.lifecycleBundle(request.Lifecycle+"/"+lifecycleData.Stack, request.AppId)
+	if !ok {
+		return &models.TaskDefinition{}, "", "", ErrNoCompilerDefined
+	}
+
+	compilerURL, err := backend.compilerDownloadURL(bundle.Path)
 	if err != nil {
 		return &models.TaskDefinition{}, "", "", err
 	}
@@ -69,7 +73,7 @@ func (backend *traditionalBackend) BuildRecipe(stagingGuid string, request cc_me
 	skipDetect := len(lifecycleData.Buildpacks) == 1 && lifecycleData.Buildpacks[0].SkipDetect
 	builderConfig := buildpackapplifecycle.NewLifecycleBuilderConfig(buildpacksOrder, skipDetect, backend.config.SkipCertVerify)
 
-	timeout := traditionalTimeout(request, backend.logger)
+	timeout, uploadTimeout := stagingTimeouts(backend.logger, backend.config, TraditionalLifecycleName, lifecycleData.Stack, request)
 
 	actions := []models.ActionInterface{}
 
@@ -83,29 +87,42 @@ func (backend *traditionalBackend) BuildRecipe(stagingGuid string, request cc_me
 
 	actions = append(actions, appDownloadAction)
 
+	buildpackChecksums, err := parseBuildpackChecksums(*request.LifecycleData)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
 	cachedDependencies := []*models.CachedDependency{}
 	//Download builder
-	cachedDependencies = append(
-		cachedDependencies,
-		&models.CachedDependency{
-			From:     compilerURL.String(),
-			To:       path.Dir(builderConfig.ExecutablePath),
-			CacheKey: fmt.Sprintf("buildpack-%s-lifecycle", lifecycleData.Stack),
-		},
-	)
+	builderDependency := &models.CachedDependency{
+		From: compilerURL.String(),
+		To:   path.Dir(builderConfig.ExecutablePath),
+	}
+	err = backend.config.setChecksum(builderDependency, bundle.Checksum, bundle.HasChecksum)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+	builderDependency.CacheKey, err = backend.config.lifecycleCacheKey(logger, fmt.Sprintf("buildpack-%s-lifecycle", lifecycleData.Stack), builderDependency)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+	cachedDependencies = append(cachedDependencies, builderDependency)
 
 	//Download buildpacks
 	for _, buildpack := range lifecycleData.Buildpacks {
 		if buildpack.Name != cc_messages.CUSTOM_BUILDPACK {
-			cachedDependencies = append(
-				cachedDependencies,
-				&models.CachedDependency{
-					Name:     buildpack.Name,
-					From:     buildpack.Url,
-					To:       builderConfig.BuildpackPath(buildpack.Key),
-					CacheKey: buildpack.Key,
-				},
-			)
+			buildpackDependency := &models.CachedDependency{
+				Name: buildpack.Name,
+				From: buildpack.Url,
+				To:   builderConfig.BuildpackPath(buildpack.Key),
+			}
+			checksum, ok := buildpackChecksums[buildpack.Key]
+			err = backend.config.setChecksum(buildpackDependency, checksum, ok)
+			if err != nil {
+				return &models.TaskDefinition{}, "", "", err
+			}
+			buildpackDependency.CacheKey = buildpackCacheKey(buildpack.Key, buildpackDependency)
+			cachedDependencies = append(cachedDependencies, buildpackDependency)
 		}
 	}
 
@@ -127,7 +144,8 @@ func (backend *traditionalBackend) BuildRecipe(stagingGuid string, request cc_me
 		actions = append(actions, downloadAction)
 	}
 
-	fileDescriptorLimit := uint64(request.FileDescriptors)
+	resources := resolveStagingResources(logger, backend.config, TraditionalLifecycleName, lifecycleData.Stack, request.MemoryMB, request.DiskMB, request.FileDescriptors)
+	fileDescriptorLimit := resources.FileDescriptors
 
 	//Run Builder
 	runEnv := append(request.Environment, &models.EnvironmentVariable{"CF_STACK", lifecycleData.Stack})
@@ -162,7 +180,7 @@ func (backend *traditionalBackend) BuildRecipe(stagingGuid string, request cc_me
 		&models.UploadAction{
 			Artifact: "droplet",
 			From:     builderConfig.OutputDroplet(), // get the droplet
-			To:       addTimeoutParamToURL(*uploadURL, timeout).String(),
+			To:       AddTimeoutParamToURL(*uploadURL, uploadTimeout).String(),
 			User:     "vcap",
 		},
 	)
@@ -179,7 +197,7 @@ func (backend *traditionalBackend) BuildRecipe(stagingGuid string, request cc_me
 			&models.UploadAction{
 				Artifact: "build artifacts cache",
 				From:     builderConfig.OutputBuildArtifactsCache(), // get the compressed build artifacts cache
-				To:       addTimeoutParamToURL(*uploadURL, timeout).String(),
+				To:       AddTimeoutParamToURL(*uploadURL, uploadTimeout).String(),
 				User:     "vcap",
 			},
 		),
@@ -189,17 +207,26 @@ func (backend *traditionalBackend) BuildRecipe(stagingGuid string, request cc_me
 	uploadMsg := fmt.Sprintf("Uploading %s...", strings.Join(uploadNames, ", "))
 	actions = append(actions, models.EmitProgressFor(models.Parallel(uploadActions...), uploadMsg, "Uploading complete", "Uploading failed"))
 
-	annotationJson, _ := json.Marshal(cc_messages.StagingTaskAnnotation{
-		Lifecycle:          TraditionalLifecycleName,
-		CompletionCallback: request.CompletionCallback,
-	})
+	annotation := StagingTaskAnnotation{
+		StagingTaskAnnotation: cc_messages.StagingTaskAnnotation{
+			Lifecycle:          TraditionalLifecycleName,
+			CompletionCallback: request.CompletionCallback,
+		},
+		AppId: request.AppId,
+	}
+	if bundle.HasCanary {
+		annotation.LifecycleBundle = bundle.Path
+		annotation.LifecycleBundleChecksum = bundle.checksum()
+		annotation.LifecycleCanary = bundle.Canary
+	}
+	annotationJson, _ := json.Marshal(annotation)
 
 	taskDefinition := &models.TaskDefinition{
 		RootFs:                        models.PreloadedRootFS(lifecycleData.Stack),
 		ResultFile:                    builderConfig.OutputMetadata(),
-		MemoryMb:                      int32(request.MemoryMB),
-		DiskMb:                        int32(request.DiskMB),
-		CpuWeight:                     uint32(StagingTaskCpuWeight),
+		MemoryMb:                      resources.MemoryMB,
+		DiskMb:                        resources.DiskMB,
+		CpuWeight:                     resources.CpuWeight,
 		CachedDependencies:            cachedDependencies,
 		Action:                        models.WrapAction(models.Timeout(models.Serial(actions...), timeout)),
 		LogGuid:                       request.LogGuid,
@@ -223,20 +250,28 @@ func (backend *traditionalBackend) BuildStagingResponse(taskResponse *models.Tas
 
 	if taskResponse.Failed {
 		response.Error = backend.config.Sanitizer(taskResponse.FailureReason)
-	} else {
-		result := json.RawMessage([]byte(taskResponse.Result))
-		response.Result = &result
+		return response, nil
 	}
 
-	return response, nil
-}
+	stagingResult, err := ParseBuildpackStagingResult(taskResponse.Result)
+	if err != nil {
+		backend.logger.Error("invalid-staging-result", err, lager.Data{"task-guid": taskResponse.TaskGuid})
+		response.Error = SanitizeError(backend.config.Classifier, err)
+		return response, nil
+	}
 
-func (backend *traditionalBackend) compilerDownloadURL(request cc_messages.StagingRequestFromCC, buildpackData cc_messages.BuildpackStagingData) (*url.URL, error) {
-	compilerPath, ok := backend.config.Lifecycles[request.Lifecycle+"/"+buildpackData.Stack]
-	if !ok {
-		return nil, ErrNoCompilerDefined
+	resultJson, err := stagingResult.marshalKeepingUnknownFields(taskResponse.Result)
+	if err != nil {
+		return response, err
 	}
 
+	result := json.RawMessage(resultJson)
+	response.Result = &result
+
+	return response, nil
+}
+
+func (backend *traditionalBackend) compilerDownloadURL(compilerPath string) (*url.URL, error) {
 	parsed, err := url.Parse(compilerPath)
 	if err != nil {
 		return nil, errors.New("couldn't parse compiler URL")
@@ -262,14 +297,20 @@ func (backend *traditionalBackend) compilerDownloadURL(request cc_messages.Stagi
 }
 
 func (backend *traditionalBackend) dropletUploadURL(request cc_messages.StagingRequestFromCC, buildpackData cc_messages.BuildpackStagingData) (*url.URL, error) {
+	return DropletUploadURL(backend.config.CCUploaderURL, request.AppId, buildpackData.DropletUploadUri)
+}
+
+// DropletUploadURL returns the cc-uploader URL through which the droplet of
+// an app is uploaded to the given CC droplet upload URI.
+func DropletUploadURL(ccUploaderURL, appId, dropletUploadUri string) (*url.URL, error) {
 	path, err := ccuploader.Routes.CreatePathForRoute(ccuploader.UploadDropletRoute, rata.Params{
-		"guid": request.AppId,
+		"guid": appId,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("couldn't generate droplet upload URL: %s", err)
 	}
 
-	urlString := urljoiner.Join(backend.config.CCUploaderURL, path)
+	urlString := urljoiner.Join(ccUploaderURL, path)
 
 	u, err := url.ParseRequestURI(urlString)
 	if err != nil {
@@ -277,7 +318,7 @@ func (backend *traditionalBackend) dropletUploadURL(request cc_messages.StagingR
 	}
 
 	values := make(url.Values, 1)
-	values.Add(cc_messages.CcDropletUploadUriKey, buildpackData.DropletUploadUri)
+	values.Add(cc_messages.CcDropletUploadUriKey, dropletUploadUri)
 	u.RawQuery = values.Encode()
 
 	return u, nil
@@ -330,16 +371,3 @@ func (backend *traditionalBackend) validateRequest(stagingRequest cc_messages.St
 
 	return nil
 }
-
-func traditionalTimeout(request cc_messages.StagingRequestFromCC, logger lager.Logger) time.Duration {
-	if request.Timeout > 0 {
-		return time.Duration(request.Timeout) * time.Second
-	} else {
-		logger.Info("overriding requested timeout", lager.Data{
-			"requested-timeout": request.Timeout,
-			"default-timeout":   DefaultStagingTimeout,
-			"app-id":            request.AppId,
-		})
-		return DefaultStagingTimeout
-	}
-}
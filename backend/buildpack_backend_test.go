@@ -2,6 +2,7 @@ package backend_test
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 	"time"
@@ -12,6 +13,8 @@ import (
 	"code.cloudfoundry.org/lager/lagertest"
 	"code.cloudfoundry.org/runtimeschema/cc_messages"
 	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/backend/fake_backend"
+	"code.cloudfoundry.org/stager/canary"
 	"code.cloudfoundry.org/stager/diego_errors"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -84,7 +87,7 @@ var _ = Describe("TraditionalBackend", func() {
 		downloadBuilder = models.CachedDependency{
 			From:     "http://file-server.com/v1/static/rabbit-hole-compiler",
 			To:       "/tmp/lifecycle",
-			CacheKey: "buildpack-rabbit_hole-lifecycle",
+			CacheKey: urlCacheKey("buildpack-rabbit_hole-lifecycle", "http://file-server.com/v1/static/rabbit-hole-compiler"),
 		}
 
 		downloadAppAction = &models.DownloadAction{
@@ -215,7 +218,7 @@ var _ = Describe("TraditionalBackend", func() {
 			})
 
 			It("returns an error", func() {
-				_, _, _, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+				_, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
 				Expect(err).To(Equal(backend.ErrMissingAppBitsDownloadUri))
 			})
 		})
@@ -226,14 +229,14 @@ var _ = Describe("TraditionalBackend", func() {
 			})
 
 			It("returns an error", func() {
-				_, _, _, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+				_, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
 				Expect(err).To(Equal(backend.ErrMissingLifecycleData))
 			})
 		})
 	})
 
 	It("creates a cf-app-staging Task with staging instructions", func() {
-		taskDef, guid, domain, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+		taskDef, guid, domain, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
 		Expect(err).NotTo(HaveOccurred())
 
 		Expect(domain).To(Equal("config-task-domain"))
@@ -254,6 +257,10 @@ var _ = Describe("TraditionalBackend", func() {
 			CompletionCallback: "https://api.cc.com/v1/staging/some-staging-guid/droplet_completed",
 		}))
 
+		stagerAnnotation, err := backend.ParseStagingTaskAnnotation(taskDef.Annotation)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stagerAnnotation.AppId).To(Equal(appId))
+
 		actions := actionsFromTaskDef(taskDef)
 		Expect(actions).To(Equal(models.Serial(
 			downloadAppAction,
@@ -283,6 +290,140 @@ var _ = Describe("TraditionalBackend", func() {
 		Expect(taskDef.LegacyDownloadUser).To(Equal("vcap"))
 	})
 
+	Context("when staging timeouts are configured for the lifecycle", func() {
+		var policy backend.TimeoutPolicy
+
+		JustBeforeEach(func() {
+			config.StagingTimeouts = map[string]backend.TimeoutPolicy{"buildpack": policy}
+			traditional = backend.NewTraditionalBackend(config, lagertest.NewTestLogger("test"))
+		})
+
+		Context("and for the stack", func() {
+			BeforeEach(func() {
+				policy = backend.TimeoutPolicy{Maximum: 5 * time.Minute}
+			})
+
+			JustBeforeEach(func() {
+				config.StagingTimeouts["buildpack/"+stack] = backend.TimeoutPolicy{Upload: 2 * time.Minute}
+				traditional = backend.NewTraditionalBackend(config, lagertest.NewTestLogger("test"))
+			})
+
+			It("merges the policy for the stack over the policy for the lifecycle", func() {
+				taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+
+				timeoutAction := taskDef.Action.GetTimeoutAction()
+				Expect(timeoutAction.TimeoutMs).To(Equal(int64(5 * time.Minute / time.Millisecond)))
+
+				uploads := actionsFromTaskDef(taskDef)[3].GetEmitProgressAction().Action.GetParallelAction().Actions
+				Expect(uploads[0].GetUploadAction().To).To(HaveSuffix(cc_messages.CcTimeoutKey + "=120"))
+			})
+		})
+
+		Context("when the requested timeout exceeds the maximum", func() {
+			BeforeEach(func() {
+				policy = backend.TimeoutPolicy{Maximum: 5 * time.Minute}
+			})
+
+			It("caps the timeout", func() {
+				taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+
+				timeoutAction := taskDef.Action.GetTimeoutAction()
+				Expect(timeoutAction.TimeoutMs).To(Equal(int64(5 * time.Minute / time.Millisecond)))
+			})
+		})
+
+		Context("when no timeout is requested", func() {
+			BeforeEach(func() {
+				timeout = 0
+				policy = backend.TimeoutPolicy{Default: 30 * time.Minute}
+			})
+
+			It("uses the configured default", func() {
+				taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+
+				timeoutAction := taskDef.Action.GetTimeoutAction()
+				Expect(timeoutAction.TimeoutMs).To(Equal(int64(30 * time.Minute / time.Millisecond)))
+			})
+		})
+
+		Context("when an upload timeout is configured", func() {
+			BeforeEach(func() {
+				policy = backend.TimeoutPolicy{Upload: 2 * time.Minute}
+			})
+
+			It("passes the upload timeout to the cc-uploader", func() {
+				taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+
+				timeoutAction := taskDef.Action.GetTimeoutAction()
+				Expect(timeoutAction.TimeoutMs).To(Equal(int64(time.Duration(timeout) * time.Second / time.Millisecond)))
+
+				uploads := actionsFromTaskDef(taskDef)[3].GetEmitProgressAction().Action.GetParallelAction().Actions
+				Expect(uploads[0].GetUploadAction().To).To(HaveSuffix(cc_messages.CcTimeoutKey + "=120"))
+			})
+		})
+	})
+
+	Context("when staging resources are configured for the lifecycle", func() {
+		BeforeEach(func() {
+			config.StagingResources = map[string]backend.StagingResources{
+				"buildpack": {
+					MemoryMB:  backend.ResourceBounds{Maximum: 1024},
+					CpuWeight: backend.ResourceBounds{Default: 25},
+				},
+				"buildpack/rabbit_hole": {
+					DiskMB:          backend.ResourceBounds{Minimum: 4096},
+					FileDescriptors: backend.ResourceBounds{Maximum: 256},
+				},
+			}
+			traditional = backend.NewTraditionalBackend(config, lagertest.NewTestLogger("test"))
+		})
+
+		It("merges the bounds for the stack over the bounds for the lifecycle", func() {
+			taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(taskDef.MemoryMb).To(Equal(int32(1024)))
+			Expect(taskDef.DiskMb).To(Equal(int32(4096)))
+			Expect(taskDef.CpuWeight).To(Equal(uint32(25)))
+
+			runAction := actionsFromTaskDef(taskDef)[2].GetEmitProgressAction().Action.GetRunAction()
+			Expect(*runAction.ResourceLimits.Nofile).To(Equal(uint64(256)))
+		})
+
+		Context("when the stack has no bounds of its own", func() {
+			BeforeEach(func() {
+				stack = "penguin"
+			})
+
+			It("applies the bounds for the lifecycle", func() {
+				taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(taskDef.MemoryMb).To(Equal(int32(1024)))
+				Expect(taskDef.DiskMb).To(Equal(diskMb))
+				Expect(taskDef.CpuWeight).To(Equal(uint32(25)))
+			})
+		})
+	})
+
+	Context("when the request does not specify file descriptors", func() {
+		BeforeEach(func() {
+			fileDescriptors = 0
+		})
+
+		It("uses the default file descriptor limit", func() {
+			taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
+			Expect(err).NotTo(HaveOccurred())
+
+			runAction := actionsFromTaskDef(taskDef)[2].GetEmitProgressAction().Action.GetRunAction()
+			Expect(*runAction.ResourceLimits.Nofile).To(Equal(backend.DefaultStagingFileDescriptors))
+		})
+	})
+
 	Context("with a specified buildpack", func() {
 		BeforeEach(func() {
 			buildpacks = buildpacks[:1]
@@ -291,7 +432,7 @@ var _ = Describe("TraditionalBackend", func() {
 		})
 
 		It("it downloads the buildpack and skips detect", func() {
-			taskDef, _, _, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+			taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
 			Expect(err).NotTo(HaveOccurred())
 
 			actions := actionsFromTaskDef(taskDef)
@@ -318,6 +459,302 @@ var _ = Describe("TraditionalBackend", func() {
 		})
 	})
 
+	Describe("checksums", func() {
+		var buildpackChecksums map[string]interface{}
+
+		BeforeEach(func() {
+			config.LifecycleChecksums = map[string]backend.Checksum{
+				"buildpack/rabbit_hole": {Algorithm: "sha256", Value: "lifecycle-sha"},
+			}
+			buildpackChecksums = map[string]interface{}{
+				"zfirst-buildpack": map[string]string{"algorithm": "sha1", "value": "zfirst-sha"},
+			}
+		})
+
+		JustBeforeEach(func() {
+			var lifecycleData map[string]interface{}
+			Expect(json.Unmarshal(*stagingRequest.LifecycleData, &lifecycleData)).To(Succeed())
+			for _, buildpack := range lifecycleData["buildpacks"].([]interface{}) {
+				buildpack := buildpack.(map[string]interface{})
+				if checksum, ok := buildpackChecksums[buildpack["key"].(string)]; ok {
+					buildpack["checksum"] = checksum
+				}
+			}
+
+			lifecycleDataJSON, err := json.Marshal(lifecycleData)
+			Expect(err).NotTo(HaveOccurred())
+			rawLifecycleData := json.RawMessage(lifecycleDataJSON)
+			stagingRequest.LifecycleData = &rawLifecycleData
+
+			traditional = backend.NewTraditionalBackend(config, lagertest.NewTestLogger("test"))
+		})
+
+		It("verifies the lifecycle bundle and buildpacks against the checksums it has and keys their caches by them", func() {
+			taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
+			Expect(err).NotTo(HaveOccurred())
+
+			downloadBuilder.ChecksumAlgorithm = "sha256"
+			downloadBuilder.ChecksumValue = "lifecycle-sha"
+			downloadBuilder.CacheKey = "buildpack-rabbit_hole-lifecycle-sha256-lifecycle-sha"
+			downloadFirstBuildpack.ChecksumAlgorithm = "sha1"
+			downloadFirstBuildpack.ChecksumValue = "zfirst-sha"
+			downloadFirstBuildpack.CacheKey = "zfirst-buildpack-sha1-zfirst-sha"
+
+			Expect(taskDef.CachedDependencies).To(HaveLen(3))
+			Expect(*taskDef.CachedDependencies[0]).To(Equal(downloadBuilder))
+			Expect(*taskDef.CachedDependencies[1]).To(Equal(downloadFirstBuildpack))
+			Expect(*taskDef.CachedDependencies[2]).To(Equal(downloadSecondBuildpack))
+		})
+
+		Context("when a buildpack checksum uses an unsupported algorithm", func() {
+			BeforeEach(func() {
+				buildpackChecksums["asecond-buildpack"] = map[string]string{"algorithm": "crc32", "value": "asecond-crc"}
+			})
+
+			It("returns an error", func() {
+				_, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
+				Expect(diego_errors.FromError(err).Code).To(Equal(diego_errors.CodeInvalidStagingRequest))
+				Expect(err).To(MatchError(ContainSubstring("invalid checksum for cached dependency")))
+			})
+		})
+
+		Context("when checksums are required", func() {
+			BeforeEach(func() {
+				config.RequireChecksums = true
+			})
+
+			It("fails when a buildpack has no checksum", func() {
+				_, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
+				Expect(err).To(MatchError("missing checksum for cached dependency: second-buildpack-url"))
+			})
+
+			It("fails when the lifecycle bundle has no checksum", func() {
+				config.LifecycleChecksums = nil
+				traditional = backend.NewTraditionalBackend(config, lagertest.NewTestLogger("test"))
+
+				_, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
+				Expect(err).To(MatchError("missing checksum for cached dependency: http://file-server.com/v1/static/rabbit-hole-compiler"))
+			})
+
+			Context("when every dependency has a checksum", func() {
+				BeforeEach(func() {
+					buildpackChecksums["asecond-buildpack"] = map[string]string{"algorithm": "md5", "value": "asecond-md5"}
+				})
+
+				It("succeeds", func() {
+					_, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
+					Expect(err).NotTo(HaveOccurred())
+				})
+			})
+
+			Context("with a custom buildpack", func() {
+				BeforeEach(func() {
+					buildpacks = []cc_messages.Buildpack{
+						{Name: "custom", Key: "https://example.com/a/custom-buildpack.git", Url: "https://example.com/a/custom-buildpack.git", SkipDetect: true},
+					}
+				})
+
+				It("does not require a checksum for the custom buildpack, which is not cached", func() {
+					_, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
+					Expect(err).NotTo(HaveOccurred())
+				})
+			})
+		})
+	})
+
+	Describe("canary lifecycle bundles", func() {
+		var (
+			canaryPercent int
+			tracker       *canary.Tracker
+		)
+
+		annotationOf := func(taskDef *models.TaskDefinition) backend.StagingTaskAnnotation {
+			annotation, err := backend.ParseStagingTaskAnnotation(taskDef.Annotation)
+			Expect(err).NotTo(HaveOccurred())
+			return annotation
+		}
+
+		BeforeEach(func() {
+			canaryPercent = 100
+			tracker = canary.NewTracker(0.5, 1, 1)
+		})
+
+		JustBeforeEach(func() {
+			config.LifecycleCanaries = map[string]backend.Canary{
+				"buildpack/rabbit_hole": {Bundle: "rabbit-hole-canary", Percent: canaryPercent},
+			}
+			config.CanaryTracker = tracker
+			traditional = backend.NewTraditionalBackend(config, lagertest.NewTestLogger("test"))
+		})
+
+		It("stages the apps in the canary's share with the canary bundle and records it", func() {
+			taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(taskDef.CachedDependencies[0].From).To(Equal("http://file-server.com/v1/static/rabbit-hole-canary"))
+
+			annotation := annotationOf(taskDef)
+			Expect(annotation.LifecycleBundle).To(Equal("rabbit-hole-canary"))
+			Expect(annotation.LifecycleCanary).To(BeTrue())
+		})
+
+		Context("when the canary has been rolled back", func() {
+			BeforeEach(func() {
+				tracker.Record(lagertest.NewTestLogger("test"), "rabbit-hole-canary", true, true)
+			})
+
+			It("stages with the primary bundle", func() {
+				taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(*taskDef.CachedDependencies[0]).To(Equal(downloadBuilder))
+
+				annotation := annotationOf(taskDef)
+				Expect(annotation.LifecycleBundle).To(Equal("rabbit-hole-compiler"))
+				Expect(annotation.LifecycleCanary).To(BeFalse())
+			})
+		})
+
+		Context("when the canary has a checksum", func() {
+			JustBeforeEach(func() {
+				config.LifecycleCanaries = map[string]backend.Canary{
+					"buildpack/rabbit_hole": {
+						Bundle:   "rabbit-hole-canary",
+						Percent:  canaryPercent,
+						Checksum: &backend.Checksum{Algorithm: "sha256", Value: "fixed"},
+					},
+				}
+				traditional = backend.NewTraditionalBackend(config, lagertest.NewTestLogger("test"))
+			})
+
+			It("records the checksum with the bundle", func() {
+				taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(annotationOf(taskDef).LifecycleBundleChecksum).To(Equal("sha256:fixed"))
+			})
+
+			Context("when a canary with another checksum at the same path was rolled back", func() {
+				BeforeEach(func() {
+					tracker.Record(lagertest.NewTestLogger("test"), canary.Key("rabbit-hole-canary", "sha256:broken"), true, true)
+				})
+
+				It("stages with the canary bundle", func() {
+					taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(annotationOf(taskDef).LifecycleCanary).To(BeTrue())
+				})
+			})
+		})
+
+		Context("when the canary gets no traffic", func() {
+			BeforeEach(func() {
+				canaryPercent = 0
+			})
+
+			It("stages with the primary bundle", func() {
+				taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(*taskDef.CachedDependencies[0]).To(Equal(downloadBuilder))
+				Expect(annotationOf(taskDef).LifecycleBundle).To(Equal("rabbit-hole-compiler"))
+			})
+		})
+
+		Context("when the canary gets part of the traffic", func() {
+			BeforeEach(func() {
+				canaryPercent = 30
+			})
+
+			stagedOnCanary := func(appId string) bool {
+				stagingRequest.AppId = appId
+				taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+				return annotationOf(taskDef).LifecycleCanary
+			}
+
+			It("picks the bundle of each app deterministically", func() {
+				for i := 0; i < 20; i++ {
+					appId := fmt.Sprintf("app-%d", i)
+					Expect(stagedOnCanary(appId)).To(Equal(stagedOnCanary(appId)))
+				}
+			})
+
+			It("stages roughly its share of apps on the canary", func() {
+				canaries := 0
+				for i := 0; i < 1000; i++ {
+					if stagedOnCanary(fmt.Sprintf("app-%d", i)) {
+						canaries++
+					}
+				}
+				Expect(canaries).To(BeNumerically("~", 300, 60))
+			})
+		})
+
+		Context("when no canary is configured for the lifecycle", func() {
+			JustBeforeEach(func() {
+				config.LifecycleCanaries = map[string]backend.Canary{}
+				traditional = backend.NewTraditionalBackend(config, lagertest.NewTestLogger("test"))
+			})
+
+			It("leaves the annotation without a bundle", func() {
+				taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(annotationOf(taskDef).LifecycleBundle).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("when the file server reports versions of the lifecycle bundles", func() {
+		var fakeBundleVersionFetcher *fake_backend.FakeBundleVersionFetcher
+
+		BeforeEach(func() {
+			fakeBundleVersionFetcher = &fake_backend.FakeBundleVersionFetcher{}
+			fakeBundleVersionFetcher.BundleVersionReturns(`"v2"`, nil)
+			config.BundleVersionFetcher = fakeBundleVersionFetcher
+			traditional = backend.NewTraditionalBackend(config, lagertest.NewTestLogger("test"))
+		})
+
+		It("keys the lifecycle cache by the version of the bundle", func() {
+			taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeBundleVersionFetcher.BundleVersionCallCount()).To(Equal(1))
+			_, url := fakeBundleVersionFetcher.BundleVersionArgsForCall(0)
+			Expect(url).To(Equal("http://file-server.com/v1/static/rabbit-hole-compiler"))
+
+			Expect(taskDef.CachedDependencies[0].CacheKey).To(Equal(urlCacheKey("buildpack-rabbit_hole-lifecycle", `http://file-server.com/v1/static/rabbit-hole-compiler@"v2"`)))
+		})
+
+		Context("when the version cannot be fetched", func() {
+			BeforeEach(func() {
+				fakeBundleVersionFetcher.BundleVersionReturns("", errors.New("boom"))
+			})
+
+			It("fails rather than risk a stale bundle from the cells' caches", func() {
+				_, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
+				Expect(err).To(HaveOccurred())
+				Expect(diego_errors.FromError(err).Message).To(Equal(diego_errors.ErrBundleVersionUnavailable.Message))
+			})
+		})
+
+		Context("when the bundle has a checksum", func() {
+			BeforeEach(func() {
+				config.LifecycleChecksums = map[string]backend.Checksum{
+					"buildpack/rabbit_hole": {Algorithm: "sha256", Value: "lifecycle-sha"},
+				}
+				traditional = backend.NewTraditionalBackend(config, lagertest.NewTestLogger("test"))
+			})
+
+			It("does not ask the file server", func() {
+				taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeBundleVersionFetcher.BundleVersionCallCount()).To(Equal(0))
+				Expect(taskDef.CachedDependencies[0].CacheKey).To(Equal("buildpack-rabbit_hole-lifecycle-sha256-lifecycle-sha"))
+			})
+		})
+	})
+
 	Context("with a custom buildpack", func() {
 		var customBuildpack = "https://example.com/a/custom-buildpack.git"
 
@@ -329,7 +766,7 @@ var _ = Describe("TraditionalBackend", func() {
 		})
 
 		It("does not download any buildpacks and skips detect", func() {
-			taskDef, guid, domain, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+			taskDef, guid, domain, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
 			Expect(err).NotTo(HaveOccurred())
 
 			Expect(domain).To(Equal("config-task-domain"))
@@ -377,13 +814,13 @@ var _ = Describe("TraditionalBackend", func() {
 	})
 
 	It("gives the task a callback URL to call it back", func() {
-		taskDef, _, _, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+		taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(taskDef.CompletionCallbackUrl).To(Equal(fmt.Sprintf("%s/v1/staging/%s/completed", config.StagerURL, stagingGuid)))
 	})
 
 	It("gives the task a TrustedSystemCertificatesPath", func() {
-		taskDef, _, _, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+		taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(taskDef.TrustedSystemCertificatesPath).To(Equal(backend.TrustedSystemCertificatesPath))
 	})
@@ -395,7 +832,7 @@ var _ = Describe("TraditionalBackend", func() {
 			})
 
 			It("passes the timeout along", func() {
-				taskDef, _, _, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+				taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
 				Expect(err).NotTo(HaveOccurred())
 
 				timeoutAction := taskDef.Action.GetTimeoutAction()
@@ -410,7 +847,7 @@ var _ = Describe("TraditionalBackend", func() {
 			})
 
 			It("uses the default timeout", func() {
-				taskDef, _, _, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+				taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
 				Expect(err).NotTo(HaveOccurred())
 
 				timeoutAction := taskDef.Action.GetTimeoutAction()
@@ -425,7 +862,7 @@ var _ = Describe("TraditionalBackend", func() {
 			})
 
 			It("uses the default timeout", func() {
-				taskDef, _, _, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+				taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
 				Expect(err).NotTo(HaveOccurred())
 
 				timeoutAction := taskDef.Action.GetTimeoutAction()
@@ -441,7 +878,7 @@ var _ = Describe("TraditionalBackend", func() {
 		})
 
 		It("does not instruct the executor to download the cache", func() {
-			taskDef, _, _, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+			taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
 			Expect(err).NotTo(HaveOccurred())
 
 			Expect(actionsFromTaskDef(taskDef)).To(Equal(models.Serial(
@@ -466,7 +903,7 @@ var _ = Describe("TraditionalBackend", func() {
 		})
 
 		It("returns an error", func() {
-			_, _, _, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+			_, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
 
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(Equal("no compiler defined for requested stack"))
@@ -479,7 +916,7 @@ var _ = Describe("TraditionalBackend", func() {
 		})
 
 		It("uses the full URL in the builder CachedDependency", func() {
-			taskDef, _, _, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+			taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(taskDef.CachedDependencies[0].From).To(Equal("http://the-full-compiler-url"))
 		})
@@ -491,7 +928,7 @@ var _ = Describe("TraditionalBackend", func() {
 		})
 
 		It("returns an error", func() {
-			_, _, _, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+			_, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
 			Expect(err).To(HaveOccurred())
 		})
 	})
@@ -502,7 +939,7 @@ var _ = Describe("TraditionalBackend", func() {
 		})
 
 		It("return a url parsing error", func() {
-			_, _, _, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+			_, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
 
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("invalid URI"))
@@ -532,7 +969,7 @@ var _ = Describe("TraditionalBackend", func() {
 				"-skipDetect=false",
 			}
 
-			taskDef, _, _, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+			taskDef, _, _, err := traditional.BuildRecipe("request-id", stagingGuid, stagingRequest)
 
 			Expect(err).NotTo(HaveOccurred())
 
@@ -590,10 +1027,57 @@ var _ = Describe("TraditionalBackend", func() {
 				})
 
 				It("populates a staging response correctly", func() {
-					result := json.RawMessage(stagingResultJson)
-					Expect(response).To(Equal(cc_messages.StagingResponseForCC{
-						Result: &result,
-					}))
+					Expect(buildError).NotTo(HaveOccurred())
+					Expect(response.Error).To(BeNil())
+					Expect(response.Result).NotTo(BeNil())
+					Expect([]byte(*response.Result)).To(MatchJSON(stagingResultJson))
+				})
+			})
+
+			Context("with fields the stager does not know about", func() {
+				BeforeEach(func() {
+					stagingResultJson = []byte(`{
+						"lifecycle_type": "buildpack",
+						"lifecycle_metadata": {
+							"detected_buildpack": " ruby ",
+							"buildpacks": [{"key": "ruby-key", "sbom": "sbom.json"}],
+							"stack_version": "1.2"
+						},
+						"process_types": {" web ": "rackup"},
+						"sidecars": [{"name": "proxy", "process_types": ["web"], "command": "./proxy", "user": "vcap"}],
+						"execution_metadata": "",
+						"labels": {"team": "a"}
+					}`)
+				})
+
+				It("normalizes the known fields and passes the others through", func() {
+					Expect(buildError).NotTo(HaveOccurred())
+					Expect(response.Result).NotTo(BeNil())
+					Expect([]byte(*response.Result)).To(MatchJSON(`{
+						"lifecycle_type": "buildpack",
+						"lifecycle_metadata": {
+							"detected_buildpack": "ruby",
+							"buildpacks": [{"key": "ruby-key", "sbom": "sbom.json"}],
+							"stack_version": "1.2"
+						},
+						"process_types": {"web": "rackup"},
+						"sidecars": [{"name": "proxy", "process_types": ["web"], "command": "./proxy", "user": "vcap"}],
+						"execution_metadata": "",
+						"labels": {"team": "a"}
+					}`))
+				})
+			})
+
+			Context("with a malformed staging result", func() {
+				BeforeEach(func() {
+					stagingResultJson = []byte(`{"process_types": {"web": ["not", "a", "command"]}}`)
+				})
+
+				It("returns a staging error instead of the result", func() {
+					Expect(buildError).NotTo(HaveOccurred())
+					Expect(response.Result).To(BeNil())
+					Expect(response.Error).NotTo(BeNil())
+					Expect(response.Error.Message).To(HavePrefix(diego_errors.ErrMalformedStagingResult.Message))
 				})
 			})
 
@@ -630,9 +1114,9 @@ var _ = Describe("TraditionalBackend", func() {
 
 		Context("when the message is NoCompatibleCell", func() {
 			It("returns a NoCompatibleCell", func() {
-				stagingErr := backend.SanitizeErrorMessage(diego_errors.CELL_MISMATCH_MESSAGE)
+				stagingErr := backend.SanitizeErrorMessage(diego_errors.ErrNoCompatibleCell.Message)
 				Expect(stagingErr.Id).To(Equal(cc_messages.NO_COMPATIBLE_CELL))
-				Expect(stagingErr.Message).To(Equal(diego_errors.CELL_MISMATCH_MESSAGE))
+				Expect(stagingErr.Message).To(Equal(diego_errors.ErrNoCompatibleCell.Message))
 			})
 		})
 
@@ -640,7 +1124,7 @@ var _ = Describe("TraditionalBackend", func() {
 			It("returns a NoCompatibleCell", func() {
 				stagingErr := backend.SanitizeErrorMessage("found no compatible cell with volume drivers: [driver1]")
 				Expect(stagingErr.Id).To(Equal(cc_messages.NO_COMPATIBLE_CELL))
-				Expect(stagingErr.Message).To(ContainSubstring(diego_errors.CELL_MISMATCH_MESSAGE))
+				Expect(stagingErr.Message).To(ContainSubstring(diego_errors.ErrNoCompatibleCell.Message))
 			})
 		})
 
@@ -648,31 +1132,55 @@ var _ = Describe("TraditionalBackend", func() {
 			It("returns a NoCompatibleCell", func() {
 				stagingErr := backend.SanitizeErrorMessage("found no compatible cell with placement tags: [tag1, tag2]")
 				Expect(stagingErr.Id).To(Equal(cc_messages.NO_COMPATIBLE_CELL))
-				Expect(stagingErr.Message).To(ContainSubstring(diego_errors.CELL_MISMATCH_MESSAGE))
+				Expect(stagingErr.Message).To(ContainSubstring(diego_errors.ErrNoCompatibleCell.Message))
 			})
 		})
 
 		Context("when the message is CellCommunicationError", func() {
 			It("returns a CellCommunicationError", func() {
-				stagingErr := backend.SanitizeErrorMessage(diego_errors.CELL_COMMUNICATION_ERROR)
+				stagingErr := backend.SanitizeErrorMessage(diego_errors.ErrCellCommunicationError.Message)
 				Expect(stagingErr.Id).To(Equal(cc_messages.CELL_COMMUNICATION_ERROR))
-				Expect(stagingErr.Message).To(Equal(diego_errors.CELL_COMMUNICATION_ERROR))
+				Expect(stagingErr.Message).To(Equal(diego_errors.ErrCellCommunicationError.Message))
 			})
 		})
 
-		Context("when the message is missing docker image URL", func() {
-			It("returns a StagingError", func() {
-				stagingErr := backend.SanitizeErrorMessage(diego_errors.MISSING_DOCKER_IMAGE_URL)
+		Context("when the buildpack failed to detect", func() {
+			It("returns a NoAppDetectedError", func() {
+				stagingErr := backend.SanitizeErrorMessage("Exited with status 222")
+				Expect(stagingErr.Id).To(Equal(cc_messages.BUILDPACK_DETECT_FAILED))
+				Expect(stagingErr.Message).To(Equal("staging failed"))
+			})
+		})
+
+		Context("when the staging container ran out of memory", func() {
+			It("says so", func() {
+				stagingErr := backend.SanitizeErrorMessage("Exited with status 137 (out of memory)")
 				Expect(stagingErr.Id).To(Equal(cc_messages.STAGING_ERROR))
-				Expect(stagingErr.Message).To(Equal(diego_errors.MISSING_DOCKER_IMAGE_URL))
+				Expect(stagingErr.Message).To(Equal("staging ran out of memory"))
 			})
 		})
 
-		Context("when the message is missing docker registry", func() {
-			It("returns a StagingError", func() {
-				stagingErr := backend.SanitizeErrorMessage(diego_errors.MISSING_DOCKER_REGISTRY)
+		Context("when the staging task timed out", func() {
+			It("says so", func() {
+				stagingErr := backend.SanitizeErrorMessage("Exceeded 15m0s timeout")
 				Expect(stagingErr.Id).To(Equal(cc_messages.STAGING_ERROR))
-				Expect(stagingErr.Message).To(Equal(diego_errors.MISSING_DOCKER_REGISTRY))
+				Expect(stagingErr.Message).To(Equal("staging timed out"))
+			})
+		})
+
+		Context("when the app bits could not be downloaded", func() {
+			It("says so", func() {
+				stagingErr := backend.SanitizeErrorMessage("Failed to download app package: connection refused")
+				Expect(stagingErr.Id).To(Equal(cc_messages.STAGING_ERROR))
+				Expect(stagingErr.Message).To(Equal("failed to download app bits"))
+			})
+		})
+
+		Context("when the droplet could not be uploaded", func() {
+			It("says so without leaking the upload url", func() {
+				stagingErr := backend.SanitizeErrorMessage("Failed to upload droplet: Post https://cc-uploader/v1/droplet/x: EOF")
+				Expect(stagingErr.Id).To(Equal(cc_messages.STAGING_ERROR))
+				Expect(stagingErr.Message).To(Equal("failed to upload droplet"))
 			})
 		})
 
@@ -680,8 +1188,62 @@ var _ = Describe("TraditionalBackend", func() {
 			It("returns a StagingError", func() {
 				stagingErr := backend.SanitizeErrorMessage("some-error")
 				Expect(stagingErr.Id).To(Equal(cc_messages.STAGING_ERROR))
-				Expect(stagingErr.Message).To(Equal("staging failed"))
+				Expect(stagingErr.Message).To(Equal(diego_errors.ErrUnrecognizedFailure.Message))
 			})
 		})
 	})
+
+	Describe("NewSanitizer", func() {
+		It("classifies failure reasons with the given classifier", func() {
+			classifier, err := diego_errors.NewClassifier(append([]diego_errors.Rule{
+				{Contains: "private buildpack", Code: diego_errors.CodeBuildpackCompileFailed, Message: "could not authenticate to the private buildpack"},
+			}, diego_errors.DefaultRules...))
+			Expect(err).NotTo(HaveOccurred())
+
+			sanitize := backend.NewSanitizer(classifier)
+
+			stagingErr := sanitize("401 fetching private buildpack")
+			Expect(stagingErr.Id).To(Equal(cc_messages.BUILDPACK_COMPILE_FAILED))
+			Expect(stagingErr.Message).To(Equal("could not authenticate to the private buildpack"))
+
+			stagingErr = sanitize("insufficient resources: disk")
+			Expect(stagingErr.Id).To(Equal(cc_messages.INSUFFICIENT_RESOURCES))
+		})
+	})
+
+	Describe("SanitizeError", func() {
+		It("keeps the message of the stager's own errors", func() {
+			stagingErr := backend.SanitizeError(nil, backend.ErrMissingDockerImageUrl)
+			Expect(stagingErr.Id).To(Equal(cc_messages.STAGING_ERROR))
+			Expect(stagingErr.Message).To(Equal("missing docker image download url"))
+		})
+
+		It("keeps the reason of a docker image policy violation", func() {
+			stagingErr := backend.SanitizeError(nil, &backend.DockerImagePolicyViolationError{Reason: "registry docker.io is not allowed"})
+			Expect(stagingErr.Id).To(Equal(cc_messages.STAGING_ERROR))
+			Expect(stagingErr.Message).To(Equal("docker image rejected by policy: registry docker.io is not allowed"))
+		})
+
+		It("classifies any other error by its message", func() {
+			stagingErr := backend.SanitizeError(nil, errors.New("insufficient resources: memory"))
+			Expect(stagingErr.Id).To(Equal(cc_messages.INSUFFICIENT_RESOURCES))
+			Expect(stagingErr.Message).To(Equal("insufficient resources: memory"))
+		})
+
+		It("classifies it with the operator's rules", func() {
+			classifier, err := diego_errors.NewClassifier([]diego_errors.Rule{
+				{Contains: "proxy refused", Code: diego_errors.CodeStagingError, Message: "the staging proxy refused the connection"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			stagingErr := backend.SanitizeError(classifier, errors.New("dial tcp: proxy refused"))
+			Expect(stagingErr.Message).To(Equal("the staging proxy refused the connection"))
+		})
+
+		It("does not point to staging logs for errors nothing recognizes", func() {
+			stagingErr := backend.SanitizeError(nil, errors.New("connection refused"))
+			Expect(stagingErr.Id).To(Equal(cc_messages.STAGING_ERROR))
+			Expect(stagingErr.Message).To(Equal("staging failed"))
+		})
+	})
 })
@@ -0,0 +1,238 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"code.cloudfoundry.org/stager/diego_errors"
+)
+
+var processTypeNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+type BuildpackStagingResult struct {
+	LifecycleType     string                     `json:"lifecycle_type"`
+	LifecycleMetadata BuildpackLifecycleMetadata `json:"lifecycle_metadata"`
+	ProcessTypes      map[string]string          `json:"process_types"`
+	Sidecars          []Sidecar                  `json:"sidecars,omitempty"`
+	ExecutionMetadata string                     `json:"execution_metadata"`
+}
+
+type BuildpackLifecycleMetadata struct {
+	BuildpackKey      string              `json:"buildpack_key,omitempty"`
+	DetectedBuildpack string              `json:"detected_buildpack"`
+	Buildpacks        []BuildpackMetadata `json:"buildpacks,omitempty"`
+}
+
+type BuildpackMetadata struct {
+	Key     string `json:"key"`
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+type Sidecar struct {
+	Name         string   `json:"name"`
+	ProcessTypes []string `json:"process_types"`
+	Command      string   `json:"command"`
+	MemoryMB     int      `json:"memory,omitempty"`
+}
+
+type MalformedStagingResultError struct {
+	Reason string
+}
+
+func (e *MalformedStagingResultError) Error() string {
+	return fmt.Sprintf("%s: %s", diego_errors.ErrMalformedStagingResult.Message, e.Reason)
+}
+
+func (e *MalformedStagingResultError) TypedError() *diego_errors.Error {
+	return diego_errors.New(diego_errors.CodeMalformedStagingResult, e.Error())
+}
+
+func malformed(format string, args ...interface{}) error {
+	return &MalformedStagingResultError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// ParseBuildpackStagingResult decodes the builder's result file, validates
+// it and returns it in the normalized form CC expects.
+func ParseBuildpackStagingResult(result string) (BuildpackStagingResult, error) {
+	var stagingResult BuildpackStagingResult
+
+	decoder := json.NewDecoder(strings.NewReader(result))
+	err := decoder.Decode(&stagingResult)
+	if err != nil {
+		return BuildpackStagingResult{}, malformed("invalid json: %s", err)
+	}
+
+	stagingResult.normalize()
+
+	err = stagingResult.validate()
+	if err != nil {
+		return BuildpackStagingResult{}, err
+	}
+
+	return stagingResult, nil
+}
+
+// knownResultFields lists the fields the stager models for each object of a
+// staging result, keyed by the object's path. Other fields are passed on to
+// CC untouched. The keys of process_types are data, so it is not listed.
+var knownResultFields = map[string]map[string]bool{
+	"": {
+		"lifecycle_type":     true,
+		"lifecycle_metadata": true,
+		"process_types":      true,
+		"sidecars":           true,
+		"execution_metadata": true,
+	},
+	"lifecycle_metadata": {
+		"buildpack_key":      true,
+		"detected_buildpack": true,
+		"buildpacks":         true,
+	},
+	"lifecycle_metadata.buildpacks[]": {
+		"key":     true,
+		"name":    true,
+		"version": true,
+	},
+	"sidecars[]": {
+		"name":          true,
+		"process_types": true,
+		"command":       true,
+		"memory":        true,
+	},
+}
+
+// marshalKeepingUnknownFields marshals the normalized result together with
+// the fields of the original result the stager does not know about, so newer
+// lifecycles can pass information to CC.
+func (r BuildpackStagingResult) marshalKeepingUnknownFields(original string) ([]byte, error) {
+	normalizedJson, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var normalized, raw interface{}
+	err = json.Unmarshal(normalizedJson, &normalized)
+	if err != nil {
+		return nil, err
+	}
+	err = json.NewDecoder(strings.NewReader(original)).Decode(&raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(keepUnknownFields("", normalized, raw))
+}
+
+func keepUnknownFields(path string, normalized, original interface{}) interface{} {
+	switch n := normalized.(type) {
+	case map[string]interface{}:
+		o, ok := original.(map[string]interface{})
+		known, isObject := knownResultFields[path]
+		if !ok || !isObject {
+			return n
+		}
+
+		for key, value := range o {
+			if !known[key] {
+				n[key] = value
+				continue
+			}
+
+			child := key
+			if path != "" {
+				child = path + "." + key
+			}
+			if normalizedValue, ok := n[key]; ok {
+				n[key] = keepUnknownFields(child, normalizedValue, value)
+			}
+		}
+		return n
+
+	case []interface{}:
+		o, ok := original.([]interface{})
+		if !ok || len(o) != len(n) {
+			return n
+		}
+
+		for i := range n {
+			n[i] = keepUnknownFields(path+"[]", n[i], o[i])
+		}
+		return n
+	}
+
+	return normalized
+}
+
+func (r *BuildpackStagingResult) normalize() {
+	r.LifecycleType = strings.TrimSpace(r.LifecycleType)
+	if r.LifecycleType == "" {
+		r.LifecycleType = TraditionalLifecycleName
+	}
+
+	r.LifecycleMetadata.BuildpackKey = strings.TrimSpace(r.LifecycleMetadata.BuildpackKey)
+	r.LifecycleMetadata.DetectedBuildpack = strings.TrimSpace(r.LifecycleMetadata.DetectedBuildpack)
+
+	processTypes := make(map[string]string, len(r.ProcessTypes))
+	for name, command := range r.ProcessTypes {
+		processTypes[strings.TrimSpace(name)] = strings.TrimSpace(command)
+	}
+	r.ProcessTypes = processTypes
+
+	for i := range r.Sidecars {
+		r.Sidecars[i].Name = strings.TrimSpace(r.Sidecars[i].Name)
+		r.Sidecars[i].Command = strings.TrimSpace(r.Sidecars[i].Command)
+	}
+}
+
+func (r *BuildpackStagingResult) validate() error {
+	if r.LifecycleType != TraditionalLifecycleName {
+		return malformed("unexpected lifecycle type '%s'", r.LifecycleType)
+	}
+
+	for name := range r.ProcessTypes {
+		if !processTypeNamePattern.MatchString(name) {
+			return malformed("invalid process type name '%s'", name)
+		}
+	}
+
+	for _, buildpack := range r.LifecycleMetadata.Buildpacks {
+		if buildpack.Key == "" {
+			return malformed("buildpack metadata is missing a key")
+		}
+	}
+
+	sidecarNames := map[string]bool{}
+	for _, sidecar := range r.Sidecars {
+		if sidecar.Name == "" {
+			return malformed("sidecar is missing a name")
+		}
+
+		if sidecarNames[sidecar.Name] {
+			return malformed("duplicate sidecar '%s'", sidecar.Name)
+		}
+		sidecarNames[sidecar.Name] = true
+
+		if sidecar.Command == "" {
+			return malformed("sidecar '%s' is missing a command", sidecar.Name)
+		}
+
+		if sidecar.MemoryMB < 0 {
+			return malformed("sidecar '%s' has negative memory", sidecar.Name)
+		}
+
+		if len(sidecar.ProcessTypes) == 0 {
+			return malformed("sidecar '%s' is not attached to any process type", sidecar.Name)
+		}
+
+		for _, processType := range sidecar.ProcessTypes {
+			if _, ok := r.ProcessTypes[processType]; !ok {
+				return malformed("sidecar '%s' references unknown process type '%s'", sidecar.Name, processType)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package backend_test
+
+import (
+	"code.cloudfoundry.org/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseBuildpackStagingResult", func() {
+	It("normalizes the staging result", func() {
+		result, err := backend.ParseBuildpackStagingResult(`{
+			"lifecycle_metadata": {
+				"buildpack_key": " ruby-key ",
+				"detected_buildpack": "ruby ",
+				"buildpacks": [{"key": "ruby-key", "name": "ruby", "version": "1.6.28"}]
+			},
+			"process_types": {" web ": " bundle exec rackup ", "worker": "rake jobs"},
+			"sidecars": [{"name": " proxy ", "process_types": ["web"], "command": "./proxy", "memory": 64}],
+			"execution_metadata": ""
+		}`)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(result).To(Equal(backend.BuildpackStagingResult{
+			LifecycleType: "buildpack",
+			LifecycleMetadata: backend.BuildpackLifecycleMetadata{
+				BuildpackKey:      "ruby-key",
+				DetectedBuildpack: "ruby",
+				Buildpacks:        []backend.BuildpackMetadata{{Key: "ruby-key", Name: "ruby", Version: "1.6.28"}},
+			},
+			ProcessTypes: map[string]string{"web": "bundle exec rackup", "worker": "rake jobs"},
+			Sidecars:     []backend.Sidecar{{Name: "proxy", ProcessTypes: []string{"web"}, Command: "./proxy", MemoryMB: 64}},
+		}))
+	})
+
+	Describe("validation", func() {
+		expectMalformed := func(result, reason string) {
+			_, err := backend.ParseBuildpackStagingResult(result)
+			Expect(err).To(MatchError(&backend.MalformedStagingResultError{Reason: reason}))
+		}
+
+		It("rejects a result with the wrong lifecycle type", func() {
+			expectMalformed(`{"lifecycle_type": "docker"}`, "unexpected lifecycle type 'docker'")
+		})
+
+		It("rejects a result with an invalid process type name", func() {
+			expectMalformed(`{"process_types": {"we b": "x"}}`, "invalid process type name 'we b'")
+		})
+
+		It("rejects a result with keyless buildpack metadata", func() {
+			expectMalformed(`{"lifecycle_metadata": {"buildpacks": [{"name": "ruby"}]}}`, "buildpack metadata is missing a key")
+		})
+
+		It("rejects a result with an unnamed sidecar", func() {
+			expectMalformed(`{"sidecars": [{"command": "x", "process_types": ["web"]}]}`, "sidecar is missing a name")
+		})
+
+		It("rejects a result with a duplicate sidecar", func() {
+			expectMalformed(`{"process_types": {"web": ""}, "sidecars": [{"name": "a", "command": "x", "process_types": ["web"]}, {"name": "a", "command": "y", "process_types": ["web"]}]}`, "duplicate sidecar 'a'")
+		})
+
+		It("rejects a result with a sidecar without a command", func() {
+			expectMalformed(`{"sidecars": [{"name": "a", "process_types": ["web"]}]}`, "sidecar 'a' is missing a command")
+		})
+
+		It("rejects a result with a sidecar with negative memory", func() {
+			expectMalformed(`{"sidecars": [{"name": "a", "command": "x", "process_types": ["web"], "memory": -1}]}`, "sidecar 'a' has negative memory")
+		})
+
+		It("rejects a result with a detached sidecar", func() {
+			expectMalformed(`{"sidecars": [{"name": "a", "command": "x"}]}`, "sidecar 'a' is not attached to any process type")
+		})
+
+		It("rejects a result with a sidecar for an unknown process type", func() {
+			expectMalformed(`{"process_types": {"web": ""}, "sidecars": [{"name": "a", "command": "x", "process_types": ["worker"]}]}`, "sidecar 'a' references unknown process type 'worker'")
+		})
+	})
+
+	It("rejects invalid json", func() {
+		_, err := backend.ParseBuildpackStagingResult(`{"process_types":`)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(HavePrefix("malformed staging result: invalid json"))
+	})
+})
@@ -0,0 +1,169 @@
+package backend
+
+import (
+	"crypto/sha1"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/stager/diego_errors"
+)
+
+const bundleVersionRequestTimeout = 10 * time.Second
+
+//go:generate counterfeiter -o fake_backend/fake_bundle_version_fetcher.go . BundleVersionFetcher
+type BundleVersionFetcher interface {
+	// BundleVersion returns an identifier that changes whenever the bundle
+	// served at url does. It only fails if no version of the bundle is known.
+	BundleVersion(logger lager.Logger, url string) (string, error)
+}
+
+// lifecycleCacheKey identifies a lifecycle bundle by its content, so a
+// bundle replaced on the file server is downloaded again rather than
+// served from the cells' caches. Without a checksum, the bundle is
+// identified by its URL and, if a BundleVersionFetcher is configured, the
+// version the file server reports for it. Staging fails rather than risk a
+// stale bundle if that version is unknown.
+func (c Config) lifecycleCacheKey(logger lager.Logger, name string, dependency *models.CachedDependency) (string, error) {
+	if dependency.ChecksumValue != "" {
+		return checksumCacheKey(name, dependency), nil
+	}
+
+	identity := dependency.From
+	if c.BundleVersionFetcher != nil {
+		version, err := c.BundleVersionFetcher.BundleVersion(logger, dependency.From)
+		if err != nil {
+			logger.Error("failed-to-fetch-bundle-version", err, lager.Data{"url": dependency.From})
+			return "", diego_errors.ErrBundleVersionUnavailable.WithDetail(err.Error())
+		}
+		identity += "@" + version
+	}
+
+	return fmt.Sprintf("%s-%x", name, sha1.Sum([]byte(identity))), nil
+}
+
+// buildpackCacheKey identifies a buildpack by its key and, if CC sent one,
+// its checksum. Buildpack URLs are signed per request and so cannot
+// identify the buildpack.
+func buildpackCacheKey(key string, dependency *models.CachedDependency) string {
+	if dependency.ChecksumValue != "" {
+		return checksumCacheKey(key, dependency)
+	}
+	return key
+}
+
+func checksumCacheKey(name string, dependency *models.CachedDependency) string {
+	return fmt.Sprintf("%s-%s-%s", name, dependency.ChecksumAlgorithm, dependency.ChecksumValue)
+}
+
+type bundleVersion struct {
+	version    string
+	fetchedAt  time.Time
+	refreshing bool
+}
+
+type etagBundleVersionFetcher struct {
+	logger     lager.Logger
+	httpClient *http.Client
+	clock      clock.Clock
+	ttl        time.Duration
+
+	lock     sync.Mutex
+	versions map[string]bundleVersion
+}
+
+// NewETagBundleVersionFetcher returns a BundleVersionFetcher that asks the
+// file server for the ETag, or failing that the Last-Modified time, of a
+// bundle. Versions are remembered for ttl so that staging does not cost a
+// request to the file server per bundle. Expired versions are refreshed in
+// the background and keep being used until a refresh succeeds, so only the
+// first staging with a bundle waits for the file server. Background
+// refreshes log to logger rather than to the staging that triggered them.
+func NewETagBundleVersionFetcher(logger lager.Logger, skipCertVerify bool, clock clock.Clock, ttl time.Duration) BundleVersionFetcher {
+	return &etagBundleVersionFetcher{
+		logger: logger.Session("bundle-version-fetcher"),
+		httpClient: &http.Client{
+			Timeout: bundleVersionRequestTimeout,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: skipCertVerify,
+				},
+			},
+		},
+		clock:    clock,
+		ttl:      ttl,
+		versions: map[string]bundleVersion{},
+	}
+}
+
+func (fetcher *etagBundleVersionFetcher) BundleVersion(logger lager.Logger, url string) (string, error) {
+	fetcher.lock.Lock()
+	cached, ok := fetcher.versions[url]
+	if ok && !cached.refreshing && fetcher.clock.Now().Sub(cached.fetchedAt) >= fetcher.ttl {
+		cached.refreshing = true
+		fetcher.versions[url] = cached
+		go fetcher.refresh(url)
+	}
+	fetcher.lock.Unlock()
+
+	if ok {
+		return cached.version, nil
+	}
+
+	return fetcher.fetch(logger, url)
+}
+
+// refresh replaces an expired version. If the file server cannot tell, the
+// expired version is kept for another ttl.
+func (fetcher *etagBundleVersionFetcher) refresh(url string) {
+	logger := fetcher.logger.Session("refresh")
+
+	_, err := fetcher.fetch(logger, url)
+	if err == nil {
+		return
+	}
+
+	logger.Error("failed-to-refresh-bundle-version", err, lager.Data{"url": url})
+
+	fetcher.lock.Lock()
+	defer fetcher.lock.Unlock()
+	cached := fetcher.versions[url]
+	cached.fetchedAt = fetcher.clock.Now()
+	cached.refreshing = false
+	fetcher.versions[url] = cached
+}
+
+func (fetcher *etagBundleVersionFetcher) fetch(logger lager.Logger, url string) (string, error) {
+	logger = logger.Session("fetch-bundle-version", lager.Data{"url": url})
+
+	response, err := fetcher.httpClient.Head(url)
+	if err != nil {
+		return "", err
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("file server responded with %d", response.StatusCode)
+	}
+
+	version := response.Header.Get("ETag")
+	if version == "" {
+		version = response.Header.Get("Last-Modified")
+	}
+	if version == "" {
+		return "", errors.New("file server reported neither an ETag nor a Last-Modified time")
+	}
+
+	fetcher.lock.Lock()
+	fetcher.versions[url] = bundleVersion{version: version, fetchedAt: fetcher.clock.Now()}
+	fetcher.lock.Unlock()
+
+	logger.Debug("fetched-bundle-version", lager.Data{"version": version})
+	return version, nil
+}
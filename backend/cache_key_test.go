@@ -0,0 +1,130 @@
+package backend_test
+
+import (
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("ETagBundleVersionFetcher", func() {
+	var (
+		fileServer *ghttp.Server
+		fakeClock  *fakeclock.FakeClock
+		fetcher    backend.BundleVersionFetcher
+		logger     *lagertest.TestLogger
+		ownLogger  *lagertest.TestLogger
+		bundleURL  string
+	)
+
+	BeforeEach(func() {
+		fileServer = ghttp.NewServer()
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		logger = lagertest.NewTestLogger("test")
+		ownLogger = lagertest.NewTestLogger("fetcher")
+		fetcher = backend.NewETagBundleVersionFetcher(ownLogger, false, fakeClock, time.Minute)
+		bundleURL = fileServer.URL() + "/v1/static/buildpack_app_lifecycle.tgz"
+	})
+
+	AfterEach(func() {
+		fileServer.Close()
+	})
+
+	Context("when the file server reports an ETag", func() {
+		BeforeEach(func() {
+			fileServer.RouteToHandler("HEAD", "/v1/static/buildpack_app_lifecycle.tgz", ghttp.RespondWith(http.StatusOK, nil, http.Header{
+				"ETag":          []string{`"abc"`},
+				"Last-Modified": []string{"Mon, 02 Jan 2006 15:04:05 GMT"},
+			}))
+		})
+
+		It("returns it", func() {
+			version, err := fetcher.BundleVersion(logger, bundleURL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(Equal(`"abc"`))
+		})
+
+		It("remembers it until the ttl expires, then refreshes it in the background", func() {
+			_, err := fetcher.BundleVersion(logger, bundleURL)
+			Expect(err).NotTo(HaveOccurred())
+
+			fakeClock.Increment(59 * time.Second)
+			_, err = fetcher.BundleVersion(logger, bundleURL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fileServer.ReceivedRequests()).To(HaveLen(1))
+
+			fakeClock.Increment(time.Second)
+			version, err := fetcher.BundleVersion(logger, bundleURL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(Equal(`"abc"`))
+			Eventually(fileServer.ReceivedRequests).Should(HaveLen(2))
+		})
+
+		Context("when the version cannot be refreshed", func() {
+			It("keeps the last known version for another ttl", func() {
+				_, err := fetcher.BundleVersion(logger, bundleURL)
+				Expect(err).NotTo(HaveOccurred())
+
+				fileServer.RouteToHandler("HEAD", "/v1/static/buildpack_app_lifecycle.tgz", ghttp.RespondWith(http.StatusServiceUnavailable, nil))
+				fakeClock.Increment(time.Minute)
+
+				version, err := fetcher.BundleVersion(logger, bundleURL)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(version).To(Equal(`"abc"`))
+				Eventually(ownLogger).Should(gbytes.Say("failed-to-refresh-bundle-version"))
+				Expect(logger).NotTo(gbytes.Say("failed-to-refresh-bundle-version"))
+
+				version, err = fetcher.BundleVersion(logger, bundleURL)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(version).To(Equal(`"abc"`))
+				Consistently(fileServer.ReceivedRequests).Should(HaveLen(2))
+			})
+		})
+	})
+
+	Context("when the file server only reports a Last-Modified time", func() {
+		BeforeEach(func() {
+			fileServer.RouteToHandler("HEAD", "/v1/static/buildpack_app_lifecycle.tgz", ghttp.RespondWith(http.StatusOK, nil, http.Header{
+				"Last-Modified": []string{"Mon, 02 Jan 2006 15:04:05 GMT"},
+			}))
+		})
+
+		It("returns it", func() {
+			version, err := fetcher.BundleVersion(logger, bundleURL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(Equal("Mon, 02 Jan 2006 15:04:05 GMT"))
+		})
+	})
+
+	Context("when the file server reports no version", func() {
+		BeforeEach(func() {
+			fileServer.RouteToHandler("HEAD", "/v1/static/buildpack_app_lifecycle.tgz", ghttp.RespondWith(http.StatusOK, nil))
+		})
+
+		It("returns an error", func() {
+			_, err := fetcher.BundleVersion(logger, bundleURL)
+			Expect(err).To(MatchError("file server reported neither an ETag nor a Last-Modified time"))
+		})
+	})
+
+	Context("when the bundle does not exist", func() {
+		BeforeEach(func() {
+			fileServer.RouteToHandler("HEAD", "/v1/static/buildpack_app_lifecycle.tgz", ghttp.RespondWith(http.StatusNotFound, nil))
+		})
+
+		It("returns an error and remembers nothing", func() {
+			_, err := fetcher.BundleVersion(logger, bundleURL)
+			Expect(err).To(MatchError("file server responded with 404"))
+
+			_, err = fetcher.BundleVersion(logger, bundleURL)
+			Expect(err).To(HaveOccurred())
+			Expect(fileServer.ReceivedRequests()).To(HaveLen(2))
+		})
+	})
+})
@@ -0,0 +1,97 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+
+	"code.cloudfoundry.org/stager/canary"
+)
+
+// Canary is a lifecycle bundle rolled out to Percent of the apps staged
+// with a lifecycle, while the others keep the primary bundle from
+// Lifecycles.
+type Canary struct {
+	Bundle   string    `json:"bundle"`
+	Percent  int       `json:"percent"`
+	Checksum *Checksum `json:"checksum,omitempty"`
+}
+
+// key identifies the canary to the canary.Tracker.
+func (c Canary) key() string {
+	if c.Checksum == nil {
+		return canary.Key(c.Bundle, "")
+	}
+	return canary.Key(c.Bundle, c.Checksum.String())
+}
+
+func (c Canary) Validate() error {
+	if c.Bundle == "" {
+		return errors.New("missing bundle")
+	}
+	if c.Percent < 0 || c.Percent > 100 {
+		return fmt.Errorf("percent %d is outside of [0, 100]", c.Percent)
+	}
+	if c.Checksum != nil {
+		return c.Checksum.Validate()
+	}
+	return nil
+}
+
+// lifecycleBundle is the bundle chosen for a staging.
+type lifecycleBundle struct {
+	Path        string
+	Checksum    Checksum
+	HasChecksum bool
+	Canary      bool
+	// HasCanary is set when a canary is configured for the lifecycle,
+	// whether or not it was chosen.
+	HasCanary bool
+}
+
+// checksum returns the bundle's checksum as recorded in the staging task
+// annotation, or "" if it has none.
+func (b lifecycleBundle) checksum() string {
+	if !b.HasChecksum {
+		return ""
+	}
+	return b.Checksum.String()
+}
+
+// lifecycleBundle chooses the bundle of lifecycle key for an app. The
+// choice depends only on the app and the lifecycle, so an app keeps
+// staging on the same bundle for as long as the canary runs, unless the
+// canary is rolled back.
+func (c Config) lifecycleBundle(key, appId string) (lifecycleBundle, bool) {
+	path, ok := c.Lifecycles[key]
+	if !ok || path == "" {
+		return lifecycleBundle{}, false
+	}
+
+	checksum, hasChecksum := c.LifecycleChecksums[key]
+	bundle := lifecycleBundle{Path: path, Checksum: checksum, HasChecksum: hasChecksum}
+
+	canary, ok := c.LifecycleCanaries[key]
+	if !ok {
+		return bundle, true
+	}
+	bundle.HasCanary = true
+
+	if canaryBucket(key, appId) >= canary.Percent || c.CanaryTracker.RolledBack(canary.key()) {
+		return bundle, true
+	}
+
+	bundle.Path = canary.Bundle
+	bundle.Canary = true
+	bundle.Checksum, bundle.HasChecksum = Checksum{}, false
+	if canary.Checksum != nil {
+		bundle.Checksum, bundle.HasChecksum = *canary.Checksum, true
+	}
+	return bundle, true
+}
+
+func canaryBucket(key, appId string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(key + "/" + appId))
+	return int(hash.Sum32() % 100)
+}
@@ -0,0 +1,36 @@
+package backend
+
+import (
+	"strings"
+
+	"code.cloudfoundry.org/stager"
+)
+
+// Capabilities returns what the configured backends can stage. Features
+// depend on more than the backends and are left to the caller.
+func (c Config) Capabilities() stager.Capabilities {
+	lifecycles := map[string]map[string]string{}
+	for key, bundle := range c.Lifecycles {
+		lifecycle, stack := key, ""
+		if i := strings.Index(key, "/"); i >= 0 {
+			lifecycle, stack = key[:i], key[i+1:]
+		} else if key == DockerLifecycleName {
+			stack = c.DockerStagingStack
+		} else {
+			// only the docker lifecycle is looked up without a stack
+			continue
+		}
+
+		if lifecycles[lifecycle] == nil {
+			lifecycles[lifecycle] = map[string]string{}
+		}
+		lifecycles[lifecycle][stack] = bundle
+	}
+
+	return stager.Capabilities{
+		Lifecycles:         lifecycles,
+		DockerStagingStack: c.DockerStagingStack,
+		DockerImageCaching: c.DockerRegistryAddress != "" && c.ConsulCluster != "",
+		Features:           []string{},
+	}
+}
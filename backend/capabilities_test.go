@@ -0,0 +1,55 @@
+package backend_test
+
+import (
+	"code.cloudfoundry.org/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Capabilities", func() {
+	var config backend.Config
+
+	BeforeEach(func() {
+		config = backend.Config{
+			Lifecycles: map[string]string{
+				"buildpack/cflinuxfs2":    "buildpack_app_lifecycle/cflinuxfs2.tgz",
+				"buildpack/windows2012R2": "windows_app_lifecycle/windows.tgz",
+				"docker":                  "docker_app_lifecycle/docker.tgz",
+			},
+			DockerStagingStack: "cflinuxfs2",
+		}
+	})
+
+	It("maps each lifecycle to its stacks and bundles", func() {
+		capabilities := config.Capabilities()
+
+		Expect(capabilities.Lifecycles).To(Equal(map[string]map[string]string{
+			"buildpack": {
+				"cflinuxfs2":    "buildpack_app_lifecycle/cflinuxfs2.tgz",
+				"windows2012R2": "windows_app_lifecycle/windows.tgz",
+			},
+			"docker": {
+				"cflinuxfs2": "docker_app_lifecycle/docker.tgz",
+			},
+		}))
+		Expect(capabilities.DockerStagingStack).To(Equal("cflinuxfs2"))
+		Expect(capabilities.Supports("buildpack", "cflinuxfs2")).To(BeTrue())
+		Expect(capabilities.Supports("buildpack", "no-such-stack")).To(BeFalse())
+	})
+
+	It("skips lifecycles that need a stack but have none", func() {
+		config.Lifecycles["buildpack"] = "buildpack_app_lifecycle/any.tgz"
+
+		Expect(config.Capabilities().Lifecycles["buildpack"]).NotTo(HaveKey(""))
+	})
+
+	It("reports docker image caching only when a registry and consul are configured", func() {
+		Expect(config.Capabilities().DockerImageCaching).To(BeFalse())
+
+		config.DockerRegistryAddress = "docker-registry.service.cf.internal:8080"
+		Expect(config.Capabilities().DockerImageCaching).To(BeFalse())
+
+		config.ConsulCluster = "http://127.0.0.1:8500"
+		Expect(config.Capabilities().DockerImageCaching).To(BeTrue())
+	})
+})
@@ -0,0 +1,84 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/stager/diego_errors"
+)
+
+var ErrMissingChecksum = diego_errors.ErrMissingChecksum
+var ErrInvalidChecksum = diego_errors.ErrInvalidChecksum
+
+// Checksum is the expected digest of a cached dependency. The cell
+// verifies it before caching the download.
+type Checksum struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"value"`
+}
+
+var checksumAlgorithms = map[string]bool{
+	"md5":    true,
+	"sha1":   true,
+	"sha256": true,
+}
+
+// String formats the checksum as algorithm:value.
+func (c Checksum) String() string {
+	return c.Algorithm + ":" + c.Value
+}
+
+func (c Checksum) Validate() error {
+	if !checksumAlgorithms[c.Algorithm] {
+		return fmt.Errorf("unsupported checksum algorithm %q", c.Algorithm)
+	}
+	if c.Value == "" {
+		return fmt.Errorf("missing %s checksum value", c.Algorithm)
+	}
+	return nil
+}
+
+// parseBuildpackChecksums reads the checksums CC sends alongside the
+// buildpacks in the lifecycle data, keyed by buildpack key.
+// cc_messages.Buildpack has no field for them.
+func parseBuildpackChecksums(lifecycleData json.RawMessage) (map[string]Checksum, error) {
+	var data struct {
+		Buildpacks []struct {
+			Key      string    `json:"key"`
+			Checksum *Checksum `json:"checksum"`
+		} `json:"buildpacks"`
+	}
+	err := json.Unmarshal(lifecycleData, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	checksums := map[string]Checksum{}
+	for _, buildpack := range data.Buildpacks {
+		if buildpack.Checksum != nil {
+			checksums[buildpack.Key] = *buildpack.Checksum
+		}
+	}
+	return checksums, nil
+}
+
+// setChecksum sets the checksum of dependency, if it has one. In strict
+// mode a dependency without a checksum is an error.
+func (c Config) setChecksum(dependency *models.CachedDependency, checksum Checksum, ok bool) error {
+	if !ok {
+		if c.RequireChecksums {
+			return ErrMissingChecksum.WithDetail(dependency.From)
+		}
+		return nil
+	}
+
+	err := checksum.Validate()
+	if err != nil {
+		return ErrInvalidChecksum.WithDetail(fmt.Sprintf("%s: %s", dependency.From, err))
+	}
+
+	dependency.ChecksumAlgorithm = checksum.Algorithm
+	dependency.ChecksumValue = checksum.Value
+	return nil
+}
@@ -10,7 +10,6 @@ import (
 	"net/url"
 	"path"
 	"strings"
-	"time"
 
 	"code.cloudfoundry.org/bbs/models"
 	"code.cloudfoundry.org/lager"
@@ -26,10 +25,10 @@ const (
 	DockerBuilderOutputPath     = "/tmp/docker-result/result.json"
 )
 
-var ErrMissingDockerImageUrl = errors.New(diego_errors.MISSING_DOCKER_IMAGE_URL)
-var ErrMissingDockerRegistry = errors.New(diego_errors.MISSING_DOCKER_REGISTRY)
-var ErrMissingDockerCredentials = errors.New(diego_errors.MISSING_DOCKER_CREDENTIALS)
-var ErrInvalidDockerRegistryAddress = errors.New(diego_errors.INVALID_DOCKER_REGISTRY_ADDRESS)
+var ErrMissingDockerImageUrl = diego_errors.ErrMissingDockerImageUrl
+var ErrMissingDockerRegistry = diego_errors.ErrMissingDockerRegistry
+var ErrMissingDockerCredentials = diego_errors.ErrMissingDockerCredentials
+var ErrInvalidDockerRegistryAddress = diego_errors.ErrInvalidDockerRegistry
 
 type dockerBackend struct {
 	config Config
@@ -47,8 +46,8 @@ func NewDockerBackend(config Config, logger lager.Logger) Backend {
 	}
 }
 
-func (backend *dockerBackend) BuildRecipe(stagingGuid string, request cc_messages.StagingRequestFromCC) (*models.TaskDefinition, string, string, error) {
-	logger := backend.logger.Session("build-recipe", lager.Data{"app-id": request.AppId, "staging-guid": stagingGuid})
+func (backend *dockerBackend) BuildRecipe(requestId, stagingGuid string, request cc_messages.StagingRequestFromCC) (*models.TaskDefinition, string, string, error) {
+	logger := backend.logger.Session("build-recipe", lager.Data{"app-id": request.AppId, "staging-guid": stagingGuid, "request-id": requestId})
 	logger.Info("staging-request")
 
 	var lifecycleData cc_messages.DockerStagingData
@@ -62,19 +61,36 @@ func (backend *dockerBackend) BuildRecipe(stagingGuid string, request cc_message
 		return &models.TaskDefinition{}, "", "", err
 	}
 
-	compilerURL, err := backend.compilerDownloadURL()
+	err = backend.config.DockerImagePolicy.Evaluate(logger, lifecycleData.DockerImageUrl)
 	if err != nil {
 		return &models.TaskDefinition{}, "", "", err
 	}
 
-	cachedDependencies := []*models.CachedDependency{
-		&models.CachedDependency{
-			From:     compilerURL.String(),
-			To:       path.Dir(DockerBuilderExecutablePath),
-			CacheKey: "docker-lifecycle",
-		},
+	bundle, ok := backend.config.lifecycleBundle(DockerLifecycleName, request.AppId)
+	if !ok {
+		return &models.TaskDefinition{}, "", "", ErrNoCompilerDefined
+	}
+
+	compilerURL, err := backend.compilerDownloadURL(bundle.Path)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
+	builderDependency := &models.CachedDependency{
+		From: compilerURL.String(),
+		To:   path.Dir(DockerBuilderExecutablePath),
+	}
+	err = backend.config.setChecksum(builderDependency, bundle.Checksum, bundle.HasChecksum)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+	builderDependency.CacheKey, err = backend.config.lifecycleCacheKey(logger, "docker-lifecycle", builderDependency)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
 	}
 
+	cachedDependencies := []*models.CachedDependency{builderDependency}
+
 	runActionArguments := []string{
 		"-outputMetadataJSONFilename", DockerBuilderOutputPath,
 		"-dockerRef", lifecycleData.DockerImageUrl,
@@ -85,7 +101,8 @@ func (backend *dockerBackend) BuildRecipe(stagingGuid string, request cc_message
 		runActionArguments = append(runActionArguments, "-insecureDockerRegistries", insecureDockerRegistries)
 	}
 
-	fileDescriptorLimit := uint64(request.FileDescriptors)
+	resources := resolveStagingResources(logger, backend.config, DockerLifecycleName, backend.config.DockerStagingStack, request.MemoryMB, request.DiskMB, request.FileDescriptors)
+	fileDescriptorLimit := resources.FileDescriptors
 	runAs := "vcap"
 
 	actions := []models.ActionInterface{}
@@ -141,23 +158,35 @@ func (backend *dockerBackend) BuildRecipe(stagingGuid string, request cc_message
 		),
 	)
 
-	annotationJson, _ := json.Marshal(cc_messages.StagingTaskAnnotation{
-		Lifecycle:          DockerLifecycleName,
-		CompletionCallback: request.CompletionCallback,
-	})
+	timeout, _ := stagingTimeouts(backend.logger, backend.config, DockerLifecycleName, backend.config.DockerStagingStack, request)
+
+	annotation := StagingTaskAnnotation{
+		StagingTaskAnnotation: cc_messages.StagingTaskAnnotation{
+			Lifecycle:          DockerLifecycleName,
+			CompletionCallback: request.CompletionCallback,
+		},
+		AppId: request.AppId,
+	}
+	if bundle.HasCanary {
+		annotation.LifecycleBundle = bundle.Path
+		annotation.LifecycleBundleChecksum = bundle.checksum()
+		annotation.LifecycleCanary = bundle.Canary
+	}
+	annotationJson, _ := json.Marshal(annotation)
 
 	taskDefinition := &models.TaskDefinition{
 		RootFs:                        models.PreloadedRootFS(backend.config.DockerStagingStack),
 		ResultFile:                    DockerBuilderOutputPath,
 		Privileged:                    backend.config.PrivilegedContainers,
-		MemoryMb:                      int32(request.MemoryMB),
+		MemoryMb:                      resources.MemoryMB,
+		CpuWeight:                     resources.CpuWeight,
 		LogSource:                     TaskLogSource,
 		LogGuid:                       request.LogGuid,
 		EgressRules:                   request.EgressRules,
-		DiskMb:                        int32(request.DiskMB),
+		DiskMb:                        resources.DiskMB,
 		CompletionCallbackUrl:         backend.config.CallbackURL(stagingGuid),
 		Annotation:                    string(annotationJson),
-		Action:                        models.WrapAction(models.Timeout(models.Serial(actions...), dockerTimeout(request, backend.logger))),
+		Action:                        models.WrapAction(models.Timeout(models.Serial(actions...), timeout)),
 		CachedDependencies:            cachedDependencies,
 		LegacyDownloadUser:            "vcap",
 		TrustedSystemCertificatesPath: TrustedSystemCertificatesPath,
@@ -180,12 +209,7 @@ func (backend *dockerBackend) BuildStagingResponse(taskResponse *models.TaskCall
 	return response, nil
 }
 
-func (backend *dockerBackend) compilerDownloadURL() (*url.URL, error) {
-	lifecycleFilename := backend.config.Lifecycles["docker"]
-	if lifecycleFilename == "" {
-		return nil, ErrNoCompilerDefined
-	}
-
+func (backend *dockerBackend) compilerDownloadURL(lifecycleFilename string) (*url.URL, error) {
 	parsed, err := url.Parse(lifecycleFilename)
 	if err != nil {
 		return nil, errors.New("couldn't parse compiler URL")
@@ -227,19 +251,6 @@ func (backend *dockerBackend) validateRequest(stagingRequest cc_messages.Staging
 	return nil
 }
 
-func dockerTimeout(request cc_messages.StagingRequestFromCC, logger lager.Logger) time.Duration {
-	if request.Timeout > 0 {
-		return time.Duration(request.Timeout) * time.Second
-	} else {
-		logger.Info("overriding requested timeout", lager.Data{
-			"requested-timeout": request.Timeout,
-			"default-timeout":   DefaultStagingTimeout,
-			"app-id":            request.AppId,
-		})
-		return DefaultStagingTimeout
-	}
-}
-
 func getDockerRegistryServices(consulCluster string, backendLogger lager.Logger) ([]consulServiceInfo, error) {
 	logger := backendLogger.Session("docker-registry-consul-services")
 
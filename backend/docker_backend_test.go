@@ -112,49 +112,49 @@ var _ = Describe("DockerBackend", func() {
 		})
 
 		It("returns the task domain", func() {
-			_, _, domain, err := docker.BuildRecipe("staging-guid", stagingRequest)
+			_, _, domain, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(domain).To(Equal("config-task-domain"))
 		})
 
 		It("returns the task guid", func() {
-			_, guid, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+			_, guid, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(guid).To(Equal("staging-guid"))
 		})
 
 		It("sets the task LogGuid", func() {
-			taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+			taskDef, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(taskDef.LogGuid).To(Equal("log-guid"))
 		})
 
 		It("sets the task LogSource", func() {
-			taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+			taskDef, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(taskDef.LogSource).To(Equal(backend.TaskLogSource))
 		})
 
 		It("sets the task ResultFile", func() {
-			taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+			taskDef, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(taskDef.ResultFile).To(Equal("/tmp/docker-result/result.json"))
 		})
 
 		It("sets the task Privileged as false by default", func() {
-			taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+			taskDef, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(taskDef.Privileged).To(BeFalse())
 		})
 
 		It("sets the LegacyDownloadUser", func() {
-			taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+			taskDef, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(taskDef.LegacyDownloadUser).To(Equal("vcap"))
 		})
 
 		It("sets the task Annotation", func() {
-			taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+			taskDef, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 			Expect(err).NotTo(HaveOccurred())
 
 			var annotation cc_messages.StagingTaskAnnotation
@@ -168,7 +168,7 @@ var _ = Describe("DockerBackend", func() {
 		})
 
 		It("sets the task CachedDependencies", func() {
-			taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+			taskDef, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 			Expect(err).NotTo(HaveOccurred())
 
 			actions := actionsFromTaskDef(taskDef)
@@ -180,14 +180,14 @@ var _ = Describe("DockerBackend", func() {
 			dockerCachedDependency := models.CachedDependency{
 				From:     "http://file-server.com/v1/static/docker_lifecycle/docker_app_lifecycle.tgz",
 				To:       "/tmp/docker_app_lifecycle",
-				CacheKey: "docker-lifecycle",
+				CacheKey: urlCacheKey("docker-lifecycle", "http://file-server.com/v1/static/docker_lifecycle/docker_app_lifecycle.tgz"),
 			}
 
 			Expect(*cachedDependencies[0]).To(Equal(dockerCachedDependency))
 		})
 
 		It("sets the task RunAction", func() {
-			taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+			taskDef, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 			Expect(err).NotTo(HaveOccurred())
 
 			fileDescriptorLimit := uint64(512)
@@ -225,20 +225,20 @@ var _ = Describe("DockerBackend", func() {
 		})
 
 		It("sets the task MemoryMb", func() {
-			taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+			taskDef, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 			Expect(err).NotTo(HaveOccurred())
 
 			Expect(taskDef.MemoryMb).To(Equal(memoryMb))
 		})
 
 		It("sets the task DiskMb", func() {
-			taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+			taskDef, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(taskDef.DiskMb).To(Equal(diskMb))
 		})
 
 		It("sets the task EgressRules", func() {
-			taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+			taskDef, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 			Expect(err).NotTo(HaveOccurred())
 
 			egressRules := []*models.SecurityGroupRule{
@@ -253,21 +253,21 @@ var _ = Describe("DockerBackend", func() {
 		})
 
 		It("sets the task RootFS to the configured Docker staging stack", func() {
-			taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+			taskDef, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 			Expect(err).NotTo(HaveOccurred())
 
 			Expect(taskDef.RootFs).To(Equal(models.PreloadedRootFS("penguin")))
 		})
 
 		It("sets the task CompletionCallbackURL", func() {
-			taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+			taskDef, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 			Expect(err).NotTo(HaveOccurred())
 
 			Expect(taskDef.CompletionCallbackUrl).To(Equal(fmt.Sprintf("%s/v1/staging/%s/completed", "http://staging-url.com", "staging-guid")))
 		})
 
 		It("sets the task TrustedSystemCertificatesPath", func() {
-			taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+			taskDef, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 			Expect(err).NotTo(HaveOccurred())
 
 			Expect(taskDef.TrustedSystemCertificatesPath).To(Equal(backend.TrustedSystemCertificatesPath))
@@ -279,7 +279,7 @@ var _ = Describe("DockerBackend", func() {
 			})
 
 			It("returns an error", func() {
-				_, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+				_, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 				Expect(err).To(Equal(backend.ErrMissingAppId))
 			})
 		})
@@ -290,7 +290,7 @@ var _ = Describe("DockerBackend", func() {
 			})
 
 			It("returns an error", func() {
-				_, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+				_, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 				Expect(err).To(Equal(backend.ErrMissingDockerImageUrl))
 			})
 		})
@@ -302,7 +302,7 @@ var _ = Describe("DockerBackend", func() {
 			})
 
 			It("returns an error", func() {
-				_, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+				_, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 				Expect(err).To(Equal(backend.ErrMissingDockerCredentials))
 			})
 		})
@@ -314,7 +314,7 @@ var _ = Describe("DockerBackend", func() {
 			})
 
 			It("returns an error", func() {
-				_, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+				_, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 				Expect(err).To(Equal(backend.ErrMissingDockerCredentials))
 			})
 		})
@@ -326,18 +326,63 @@ var _ = Describe("DockerBackend", func() {
 			})
 
 			It("returns an error", func() {
-				_, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+				_, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 				Expect(err).To(Equal(backend.ErrMissingDockerCredentials))
 			})
 		})
 
+		Context("when the docker image violates the image policy", func() {
+			BeforeEach(func() {
+				config.DockerImagePolicy = backend.DockerImagePolicy{
+					DeniedRegistries: []string{"docker.io"},
+				}
+				docker = backend.NewDockerBackend(config, logger)
+			})
+
+			It("returns an error", func() {
+				_, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
+				Expect(err).To(MatchError(&backend.DockerImagePolicyViolationError{Reason: "registry docker.io is denied"}))
+			})
+		})
+
+		Context("when the docker lifecycle has a checksum", func() {
+			BeforeEach(func() {
+				config.LifecycleChecksums = map[string]backend.Checksum{
+					"docker": {Algorithm: "sha256", Value: "docker-lifecycle-sha"},
+				}
+				docker = backend.NewDockerBackend(config, logger)
+			})
+
+			It("verifies the lifecycle bundle against it", func() {
+				taskDef, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(taskDef.CachedDependencies).To(HaveLen(1))
+				Expect(taskDef.CachedDependencies[0].ChecksumAlgorithm).To(Equal("sha256"))
+				Expect(taskDef.CachedDependencies[0].ChecksumValue).To(Equal("docker-lifecycle-sha"))
+				Expect(taskDef.CachedDependencies[0].CacheKey).To(Equal("docker-lifecycle-sha256-docker-lifecycle-sha"))
+			})
+		})
+
+		Context("when checksums are required and the docker lifecycle has none", func() {
+			BeforeEach(func() {
+				config.RequireChecksums = true
+				docker = backend.NewDockerBackend(config, logger)
+			})
+
+			It("returns an error", func() {
+				_, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
+				Expect(err).To(MatchError("missing checksum for cached dependency: http://file-server.com/v1/static/docker_lifecycle/docker_app_lifecycle.tgz"))
+			})
+		})
+
 		Context("when the docker lifecycle is missing", func() {
 			BeforeEach(func() {
 				delete(config.Lifecycles, "docker")
 			})
 
 			It("returns an error", func() {
-				_, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+				_, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 				Expect(err).To(Equal(backend.ErrNoCompilerDefined))
 			})
 		})
@@ -348,7 +393,7 @@ var _ = Describe("DockerBackend", func() {
 			})
 
 			It("returns an error", func() {
-				_, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+				_, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 				Expect(err).To(Equal(backend.ErrNoCompilerDefined))
 			})
 		})
@@ -359,7 +404,7 @@ var _ = Describe("DockerBackend", func() {
 			})
 
 			It("passes the timeout along", func() {
-				taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+				taskDef, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 				Expect(err).NotTo(HaveOccurred())
 
 				timeoutAction := taskDef.Action.GetTimeoutAction()
@@ -374,7 +419,7 @@ var _ = Describe("DockerBackend", func() {
 			})
 
 			It("uses the default timeout", func() {
-				taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+				taskDef, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 				Expect(err).NotTo(HaveOccurred())
 
 				timeoutAction := taskDef.Action.GetTimeoutAction()
@@ -389,7 +434,7 @@ var _ = Describe("DockerBackend", func() {
 			})
 
 			It("uses the default timeout", func() {
-				taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+				taskDef, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 				Expect(err).NotTo(HaveOccurred())
 
 				timeoutAction := taskDef.Action.GetTimeoutAction()
@@ -0,0 +1,262 @@
+package backend
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+const (
+	dockerManifestV2MediaType    = "application/vnd.docker.distribution.manifest.v2+json"
+	dockerRegistryRequestTimeout = 10 * time.Second
+	dockerHubRegistryHost        = "registry-1.docker.io"
+	dockerHubAuthServerHost      = "auth.docker.io"
+	maxDockerRegistryRedirects   = 10
+)
+
+type registryImageMetadataFetcher struct {
+	insecureRegistries map[string]bool
+	authServers        map[string]bool
+	httpClient         *http.Client
+}
+
+// NewRegistryImageMetadataFetcher returns a DockerImageMetadataFetcher that
+// asks the image's registry. Registries are picked by users, so the fetcher
+// only follows them to the authorization servers in authServers, Docker
+// Hub's, or the registry itself, whether named in a bearer challenge or
+// redirected to.
+func NewRegistryImageMetadataFetcher(insecureRegistries, authServers []string, skipCertVerify bool) DockerImageMetadataFetcher {
+	insecure := make(map[string]bool, len(insecureRegistries))
+	for _, registry := range insecureRegistries {
+		insecure[registry] = true
+	}
+
+	trusted := map[string]bool{dockerHubAuthServerHost: true}
+	for _, host := range authServers {
+		trusted[host] = true
+	}
+
+	fetcher := &registryImageMetadataFetcher{
+		insecureRegistries: insecure,
+		authServers:        trusted,
+	}
+	fetcher.httpClient = &http.Client{
+		Timeout: dockerRegistryRequestTimeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: skipCertVerify,
+			},
+		},
+		CheckRedirect: fetcher.checkRedirect,
+	}
+
+	return fetcher
+}
+
+func (fetcher *registryImageMetadataFetcher) checkRedirect(request *http.Request, via []*http.Request) error {
+	if len(via) >= maxDockerRegistryRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxDockerRegistryRedirects)
+	}
+
+	if !fetcher.mayFollow(via[0].URL, request.URL) {
+		return fmt.Errorf("refusing to follow the registry to %s", request.URL.Host)
+	}
+	return nil
+}
+
+// mayFollow reports whether a request to registry may lead to target. Only
+// an insecure registry may lead to an authorization server over http.
+func (fetcher *registryImageMetadataFetcher) mayFollow(registry, target *url.URL) bool {
+	if target.Host == registry.Host {
+		return true
+	}
+	return fetcher.authServers[target.Host] && (target.Scheme == "https" || registry.Scheme == "http")
+}
+
+func (fetcher *registryImageMetadataFetcher) ImageCreatedAt(logger lager.Logger, image DockerImageReference) (time.Time, error) {
+	logger = logger.Session("fetch-image-metadata", lager.Data{"repository": image.Repository})
+
+	reference := image.Digest
+	if reference == "" {
+		reference = image.Tag
+	}
+
+	// Registries such as Docker Hub require a bearer token even for public
+	// images; the token obtained for the manifest also covers the blob.
+	token := ""
+
+	var manifest struct {
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+	}
+	err := fetcher.getJSON(image, fmt.Sprintf("/v2/%s/manifests/%s", image.Repository, reference), &token, &manifest)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if manifest.Config.Digest == "" {
+		return time.Time{}, fmt.Errorf("manifest for %s has no config blob", image.Repository)
+	}
+
+	var config struct {
+		Created time.Time `json:"created"`
+	}
+	err = fetcher.getJSON(image, fmt.Sprintf("/v2/%s/blobs/%s", image.Repository, manifest.Config.Digest), &token, &config)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	logger.Debug("fetched-image-metadata", lager.Data{"created": config.Created})
+	return config.Created, nil
+}
+
+func (fetcher *registryImageMetadataFetcher) getJSON(image DockerImageReference, path string, token *string, v interface{}) error {
+	host := image.Registry
+	if host == DefaultDockerRegistry {
+		host = dockerHubRegistryHost
+	}
+
+	scheme := "https"
+	if fetcher.insecureRegistries[image.Registry] {
+		scheme = "http"
+	}
+
+	response, err := fetcher.get(fmt.Sprintf("%s://%s%s", scheme, host, path), *token)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusUnauthorized && *token == "" {
+		*token, err = fetcher.fetchToken(response.Request.URL, response.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return err
+		}
+
+		response, err = fetcher.get(fmt.Sprintf("%s://%s%s", scheme, host, path), *token)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("registry responded with %d for %s", response.StatusCode, path)
+	}
+
+	return json.NewDecoder(response.Body).Decode(v)
+}
+
+func (fetcher *registryImageMetadataFetcher) get(url, token string) (*http.Response, error) {
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", dockerManifestV2MediaType)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return fetcher.httpClient.Do(request)
+}
+
+// fetchToken obtains an anonymous token from the authorization server named
+// in a registry's Bearer challenge, as described by the Docker registry
+// token authentication specification.
+func (fetcher *registryImageMetadataFetcher) fetchToken(registry *url.URL, challenge string) (string, error) {
+	params, ok := parseBearerChallenge(challenge)
+	if !ok || params["realm"] == "" {
+		return "", fmt.Errorf("registry responded with 401 without a bearer challenge")
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil {
+		return "", err
+	}
+	if !fetcher.mayFollow(registry, realm) {
+		return "", fmt.Errorf("registry named an untrusted authorization server %s", realm.Host)
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	response, err := fetcher.httpClient.Get(realm.String())
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("authorization server responded with %d", response.StatusCode)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&body)
+	if err != nil {
+		return "", err
+	}
+
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+	return "", fmt.Errorf("authorization server returned no token")
+}
+
+func parseBearerChallenge(challenge string) (map[string]string, bool) {
+	const prefix = "bearer "
+	if len(challenge) < len(prefix) || !strings.EqualFold(challenge[:len(prefix)], prefix) {
+		return nil, false
+	}
+
+	params := map[string]string{}
+	rest := strings.TrimSpace(challenge[len(prefix):])
+	for rest != "" {
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = strings.TrimSpace(rest[eq+1:])
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				return nil, false
+			}
+			value = rest[1 : end+1]
+			rest = rest[end+2:]
+		} else {
+			end := strings.Index(rest, ",")
+			if end < 0 {
+				end = len(rest)
+			}
+			value = strings.TrimSpace(rest[:end])
+			rest = rest[end:]
+		}
+
+		params[key] = value
+		rest = strings.TrimPrefix(strings.TrimSpace(rest), ",")
+		rest = strings.TrimSpace(rest)
+	}
+
+	return params, true
+}
@@ -0,0 +1,203 @@
+package backend_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("RegistryImageMetadataFetcher", func() {
+	var (
+		registry    *ghttp.Server
+		registryURL *url.URL
+		authServers []string
+		fetcher     backend.DockerImageMetadataFetcher
+		logger      *lagertest.TestLogger
+		image       backend.DockerImageReference
+		createdAt   time.Time
+	)
+
+	BeforeEach(func() {
+		registry = ghttp.NewServer()
+		logger = lagertest.NewTestLogger("test")
+		createdAt = time.Date(2016, time.January, 2, 3, 4, 5, 0, time.UTC)
+
+		var err error
+		registryURL, err = url.Parse(registry.URL())
+		Expect(err).NotTo(HaveOccurred())
+
+		authServers = []string{}
+		image = backend.DockerImageReference{
+			Registry:   registryURL.Host,
+			Repository: "library/busybox",
+			Tag:        "latest",
+		}
+	})
+
+	JustBeforeEach(func() {
+		fetcher = backend.NewRegistryImageMetadataFetcher([]string{registryURL.Host}, authServers, false)
+	})
+
+	AfterEach(func() {
+		registry.Close()
+	})
+
+	Context("when the registry serves the image anonymously", func() {
+		BeforeEach(func() {
+			registry.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/v2/library/busybox/manifests/latest"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+						"config": map[string]string{"digest": "sha256:config"},
+					}),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/v2/library/busybox/blobs/sha256:config"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"created": createdAt}),
+				),
+			)
+		})
+
+		It("returns the creation time from the image config", func() {
+			created, err := fetcher.ImageCreatedAt(logger, image)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created).To(BeTemporally("==", createdAt))
+		})
+	})
+
+	Context("when the registry requires a bearer token", func() {
+		var challenge http.Header
+
+		BeforeEach(func() {
+			challenge = http.Header{
+				"WWW-Authenticate": []string{fmt.Sprintf(
+					`Bearer realm="%s/token",service="registry.example.com",scope="repository:library/busybox:pull"`,
+					registry.URL(),
+				)},
+			}
+
+			registry.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/v2/library/busybox/manifests/latest"),
+					ghttp.RespondWith(http.StatusUnauthorized, nil, challenge),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/token", "scope=repository%3Alibrary%2Fbusybox%3Apull&service=registry.example.com"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]string{"token": "secret-token"}),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/v2/library/busybox/manifests/latest"),
+					ghttp.VerifyHeaderKV("Authorization", "Bearer secret-token"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+						"config": map[string]string{"digest": "sha256:config"},
+					}),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/v2/library/busybox/blobs/sha256:config"),
+					ghttp.VerifyHeaderKV("Authorization", "Bearer secret-token"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"created": createdAt}),
+				),
+			)
+		})
+
+		It("fetches a token and retries with it", func() {
+			created, err := fetcher.ImageCreatedAt(logger, image)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created).To(BeTemporally("==", createdAt))
+			Expect(registry.ReceivedRequests()).To(HaveLen(4))
+		})
+	})
+
+	Context("when the registry names another authorization server", func() {
+		var authServer *ghttp.Server
+
+		BeforeEach(func() {
+			authServer = ghttp.NewServer()
+			authServer.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/token"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]string{"token": "secret-token"}),
+				),
+			)
+
+			registry.AppendHandlers(
+				ghttp.RespondWith(http.StatusUnauthorized, nil, http.Header{
+					"WWW-Authenticate": []string{fmt.Sprintf(`Bearer realm="%s/token"`, authServer.URL())},
+				}),
+				ghttp.CombineHandlers(
+					ghttp.VerifyHeaderKV("Authorization", "Bearer secret-token"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+						"config": map[string]string{"digest": "sha256:config"},
+					}),
+				),
+				ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"created": createdAt}),
+			)
+		})
+
+		AfterEach(func() {
+			authServer.Close()
+		})
+
+		It("does not ask it for a token", func() {
+			_, err := fetcher.ImageCreatedAt(logger, image)
+			Expect(err).To(MatchError(ContainSubstring("untrusted authorization server")))
+			Expect(authServer.ReceivedRequests()).To(BeEmpty())
+		})
+
+		Context("when the authorization server is trusted", func() {
+			BeforeEach(func() {
+				authServerURL, err := url.Parse(authServer.URL())
+				Expect(err).NotTo(HaveOccurred())
+				authServers = []string{authServerURL.Host}
+			})
+
+			It("fetches a token from it", func() {
+				created, err := fetcher.ImageCreatedAt(logger, image)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(created).To(BeTemporally("==", createdAt))
+				Expect(authServer.ReceivedRequests()).To(HaveLen(1))
+			})
+		})
+	})
+
+	Context("when the registry redirects to another host", func() {
+		var otherServer *ghttp.Server
+
+		BeforeEach(func() {
+			otherServer = ghttp.NewServer()
+			registry.AppendHandlers(
+				ghttp.RespondWith(http.StatusFound, nil, http.Header{
+					"Location": []string{otherServer.URL() + "/internal"},
+				}),
+			)
+		})
+
+		AfterEach(func() {
+			otherServer.Close()
+		})
+
+		It("does not follow the redirect", func() {
+			_, err := fetcher.ImageCreatedAt(logger, image)
+			Expect(err).To(MatchError(ContainSubstring("refusing to follow the registry")))
+			Expect(otherServer.ReceivedRequests()).To(BeEmpty())
+		})
+	})
+
+	Context("when the registry rejects the request without a bearer challenge", func() {
+		BeforeEach(func() {
+			registry.AppendHandlers(ghttp.RespondWith(http.StatusUnauthorized, nil))
+		})
+
+		It("returns an error", func() {
+			_, err := fetcher.ImageCreatedAt(logger, image)
+			Expect(err).To(MatchError("registry responded with 401 without a bearer challenge"))
+		})
+	})
+})
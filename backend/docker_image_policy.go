@@ -0,0 +1,161 @@
+package backend
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/stager/diego_errors"
+)
+
+const DefaultDockerRegistry = "docker.io"
+
+type DockerImagePolicy struct {
+	AllowedRegistries    []string
+	DeniedRegistries     []string
+	AllowedRepositories  []string
+	RequireDigest        bool
+	MaxImageAge          time.Duration
+	ImageMetadataFetcher DockerImageMetadataFetcher
+}
+
+//go:generate counterfeiter -o fake_backend/fake_docker_image_metadata_fetcher.go . DockerImageMetadataFetcher
+type DockerImageMetadataFetcher interface {
+	ImageCreatedAt(logger lager.Logger, image DockerImageReference) (time.Time, error)
+}
+
+type DockerImagePolicyViolationError struct {
+	Reason string
+}
+
+func (e *DockerImagePolicyViolationError) Error() string {
+	return fmt.Sprintf("%s: %s", diego_errors.ErrDockerImageRejected.Message, e.Reason)
+}
+
+// TypedError shows the reason to users, so they can fix the image reference.
+func (e *DockerImagePolicyViolationError) TypedError() *diego_errors.Error {
+	return diego_errors.New(diego_errors.CodeDockerImageRejected, e.Error())
+}
+
+type DockerImageReference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+func ParseDockerImageReference(dockerImageUrl string) (DockerImageReference, error) {
+	ref := DockerImageReference{}
+
+	name := dockerImageUrl
+	if i := strings.Index(name, "://"); i >= 0 {
+		name = strings.TrimPrefix(name[i+3:], "/")
+	}
+
+	if i := strings.Index(name, "@"); i >= 0 {
+		ref.Digest = name[i+1:]
+		name = name[:i]
+	}
+
+	if i := strings.LastIndex(name, ":"); i >= 0 && !strings.Contains(name[i+1:], "/") {
+		ref.Tag = name[i+1:]
+		name = name[:i]
+	}
+
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		ref.Registry = parts[0]
+		name = parts[1]
+	} else {
+		ref.Registry = DefaultDockerRegistry
+		if len(parts) == 1 {
+			name = "library/" + name
+		}
+	}
+
+	if name == "" {
+		return DockerImageReference{}, fmt.Errorf("invalid docker image reference: '%s'", dockerImageUrl)
+	}
+	ref.Repository = name
+
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+
+	return ref, nil
+}
+
+func (policy DockerImagePolicy) Enabled() bool {
+	return len(policy.AllowedRegistries) > 0 ||
+		len(policy.DeniedRegistries) > 0 ||
+		len(policy.AllowedRepositories) > 0 ||
+		policy.RequireDigest ||
+		policy.MaxImageAge > 0
+}
+
+func (policy DockerImagePolicy) Evaluate(logger lager.Logger, dockerImageUrl string) error {
+	if !policy.Enabled() {
+		return nil
+	}
+
+	logger = logger.Session("docker-image-policy", lager.Data{"docker-image": dockerImageUrl})
+
+	ref, err := ParseDockerImageReference(dockerImageUrl)
+	if err != nil {
+		logger.Error("failed-to-parse-image-reference", err)
+		return &DockerImagePolicyViolationError{Reason: "invalid image reference"}
+	}
+
+	if matchesAny(policy.DeniedRegistries, ref.Registry) {
+		return policy.violation(logger, ref, fmt.Sprintf("registry %s is denied", ref.Registry))
+	}
+
+	if len(policy.AllowedRegistries) > 0 && !matchesAny(policy.AllowedRegistries, ref.Registry) {
+		return policy.violation(logger, ref, fmt.Sprintf("registry %s is not allowed", ref.Registry))
+	}
+
+	if len(policy.AllowedRepositories) > 0 && !matchesAny(policy.AllowedRepositories, ref.Repository) {
+		return policy.violation(logger, ref, fmt.Sprintf("repository %s is not allowed", ref.Repository))
+	}
+
+	if policy.RequireDigest && ref.Digest == "" {
+		return policy.violation(logger, ref, "image must be referenced by digest")
+	}
+
+	if policy.MaxImageAge > 0 && policy.ImageMetadataFetcher != nil {
+		createdAt, err := policy.ImageMetadataFetcher.ImageCreatedAt(logger, ref)
+		if err != nil {
+			logger.Error("image-metadata-unavailable", err)
+			return nil
+		}
+
+		age := time.Since(createdAt)
+		if age > policy.MaxImageAge {
+			return policy.violation(logger, ref, fmt.Sprintf("image is older than %s", policy.MaxImageAge))
+		}
+	}
+
+	return nil
+}
+
+func (policy DockerImagePolicy) violation(logger lager.Logger, ref DockerImageReference, reason string) error {
+	err := &DockerImagePolicyViolationError{Reason: reason}
+	logger.Error("image-rejected", err, lager.Data{
+		"registry":   ref.Registry,
+		"repository": ref.Repository,
+	})
+	return err
+}
+
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		matched, err := path.Match(pattern, value)
+		if err == nil && matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,199 @@
+package backend_test
+
+import (
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/backend/fake_backend"
+	"code.cloudfoundry.org/stager/diego_errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("DockerImagePolicy", func() {
+	Describe("ParseDockerImageReference", func() {
+		It("defaults the registry, repository namespace and tag", func() {
+			ref, err := backend.ParseDockerImageReference("busybox")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ref).To(Equal(backend.DockerImageReference{
+				Registry:   "docker.io",
+				Repository: "library/busybox",
+				Tag:        "latest",
+			}))
+		})
+
+		It("parses the docker:/// form sent by the Cloud Controller", func() {
+			ref, err := backend.ParseDockerImageReference("docker:///busybox")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ref).To(Equal(backend.DockerImageReference{
+				Registry:   "docker.io",
+				Repository: "library/busybox",
+				Tag:        "latest",
+			}))
+		})
+
+		It("parses a registry with a port, a tag and a digest", func() {
+			ref, err := backend.ParseDockerImageReference("docker://registry.example.com:5000/org/app:v1@sha256:abc")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ref).To(Equal(backend.DockerImageReference{
+				Registry:   "registry.example.com:5000",
+				Repository: "org/app",
+				Tag:        "v1",
+				Digest:     "sha256:abc",
+			}))
+		})
+
+		It("fails on an empty reference", func() {
+			_, err := backend.ParseDockerImageReference("")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Evaluate", func() {
+		var (
+			logger      *lagertest.TestLogger
+			policy      backend.DockerImagePolicy
+			image       string
+			evaluateErr error
+		)
+
+		BeforeEach(func() {
+			logger = lagertest.NewTestLogger("test")
+			policy = backend.DockerImagePolicy{}
+			image = "registry.example.com/org/app:v1"
+		})
+
+		JustBeforeEach(func() {
+			evaluateErr = policy.Evaluate(logger, image)
+		})
+
+		Context("when the policy is empty", func() {
+			BeforeEach(func() {
+				image = "not a :// valid image"
+			})
+
+			It("allows everything", func() {
+				Expect(evaluateErr).NotTo(HaveOccurred())
+			})
+		})
+
+		Context("when the registry is denied", func() {
+			BeforeEach(func() {
+				policy.DeniedRegistries = []string{"*.example.com"}
+			})
+
+			It("rejects the image", func() {
+				Expect(evaluateErr).To(HaveOccurred())
+				Expect(evaluateErr.Error()).To(HavePrefix(diego_errors.ErrDockerImageRejected.Message))
+				Expect(evaluateErr.Error()).To(ContainSubstring("registry registry.example.com is denied"))
+			})
+		})
+
+		Context("when allowed registries are configured", func() {
+			BeforeEach(func() {
+				policy.AllowedRegistries = []string{"registry.example.com"}
+			})
+
+			It("allows images from those registries", func() {
+				Expect(evaluateErr).NotTo(HaveOccurred())
+			})
+
+			Context("and the image comes from another registry", func() {
+				BeforeEach(func() {
+					image = "busybox"
+				})
+
+				It("rejects the image", func() {
+					Expect(evaluateErr).To(MatchError(&backend.DockerImagePolicyViolationError{Reason: "registry docker.io is not allowed"}))
+				})
+			})
+		})
+
+		Context("when allowed repositories are configured", func() {
+			BeforeEach(func() {
+				policy.AllowedRepositories = []string{"approved/*"}
+			})
+
+			It("rejects repositories that do not match", func() {
+				Expect(evaluateErr).To(MatchError(&backend.DockerImagePolicyViolationError{Reason: "repository org/app is not allowed"}))
+			})
+
+			Context("and the image is given as a docker:/// url", func() {
+				BeforeEach(func() {
+					policy.AllowedRepositories = []string{"library/*"}
+					image = "docker:///busybox"
+				})
+
+				It("matches the repository", func() {
+					Expect(evaluateErr).NotTo(HaveOccurred())
+				})
+			})
+		})
+
+		Context("when a digest is required", func() {
+			BeforeEach(func() {
+				policy.RequireDigest = true
+			})
+
+			It("rejects images referenced by tag", func() {
+				Expect(evaluateErr).To(MatchError(&backend.DockerImagePolicyViolationError{Reason: "image must be referenced by digest"}))
+			})
+
+			Context("and the image has a digest", func() {
+				BeforeEach(func() {
+					image = "registry.example.com/org/app@sha256:abc"
+				})
+
+				It("allows the image", func() {
+					Expect(evaluateErr).NotTo(HaveOccurred())
+				})
+			})
+		})
+
+		Context("when a maximum image age is configured", func() {
+			var fakeFetcher *fake_backend.FakeDockerImageMetadataFetcher
+
+			BeforeEach(func() {
+				fakeFetcher = &fake_backend.FakeDockerImageMetadataFetcher{}
+				policy.MaxImageAge = time.Hour
+				policy.ImageMetadataFetcher = fakeFetcher
+			})
+
+			Context("and the image is too old", func() {
+				BeforeEach(func() {
+					fakeFetcher.ImageCreatedAtReturns(time.Now().Add(-2*time.Hour), nil)
+				})
+
+				It("rejects the image", func() {
+					Expect(evaluateErr).To(MatchError(&backend.DockerImagePolicyViolationError{Reason: "image is older than 1h0m0s"}))
+					_, ref := fakeFetcher.ImageCreatedAtArgsForCall(0)
+					Expect(ref.Repository).To(Equal("org/app"))
+				})
+			})
+
+			Context("and the image is recent", func() {
+				BeforeEach(func() {
+					fakeFetcher.ImageCreatedAtReturns(time.Now(), nil)
+				})
+
+				It("allows the image", func() {
+					Expect(evaluateErr).NotTo(HaveOccurred())
+				})
+			})
+
+			Context("and the metadata is unavailable", func() {
+				BeforeEach(func() {
+					fakeFetcher.ImageCreatedAtReturns(time.Time{}, errors.New("unauthorized"))
+				})
+
+				It("allows the image and logs the error", func() {
+					Expect(evaluateErr).NotTo(HaveOccurred())
+					Expect(logger).To(gbytes.Say("image-metadata-unavailable.*unauthorized"))
+				})
+			})
+		})
+	})
+})
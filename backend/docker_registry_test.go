@@ -115,7 +115,7 @@ var _ = Describe("DockerBackend", func() {
 		var dockerCachedDependency = models.CachedDependency{
 			From:     "http://file-server.com/v1/static/docker_lifecycle/docker_app_lifecycle.tgz",
 			To:       "/tmp/docker_app_lifecycle",
-			CacheKey: "docker-lifecycle",
+			CacheKey: urlCacheKey("docker-lifecycle", "http://file-server.com/v1/static/docker_lifecycle/docker_app_lifecycle.tgz"),
 		}
 
 		fileDescriptorLimit := uint64(512)
@@ -151,7 +151,7 @@ var _ = Describe("DockerBackend", func() {
 			})
 
 			It("creates a cf-app-docker-staging Task with no additional egress rules", func() {
-				taskDef, _, _, err := dockerBackend.BuildRecipe("staging-guid", stagingRequest)
+				taskDef, _, _, err := dockerBackend.BuildRecipe("request-id", "staging-guid", stagingRequest)
 				Expect(err).NotTo(HaveOccurred())
 				Expect(taskDef.EgressRules).To(Equal(stagingRequest.EgressRules))
 			})
@@ -168,7 +168,7 @@ var _ = Describe("DockerBackend", func() {
 				})
 
 				It("returns an error", func() {
-					_, _, _, err := dockerBackend.BuildRecipe("staging-guid", stagingRequest)
+					_, _, _, err := dockerBackend.BuildRecipe("request-id", "staging-guid", stagingRequest)
 					Expect(err).To(Equal(backend.ErrInvalidDockerRegistryAddress))
 				})
 			})
@@ -179,21 +179,21 @@ var _ = Describe("DockerBackend", func() {
 				})
 
 				It("runs as unprivileged", func() {
-					taskDef, _, _, err := dockerBackend.BuildRecipe("staging-guid", stagingRequest)
+					taskDef, _, _, err := dockerBackend.BuildRecipe("request-id", "staging-guid", stagingRequest)
 					Expect(err).NotTo(HaveOccurred())
 
 					Expect(taskDef.Privileged).To(BeFalse())
 				})
 
 				It("has an Action", func() {
-					taskDef, _, _, err := dockerBackend.BuildRecipe("staging-guid", stagingRequest)
+					taskDef, _, _, err := dockerBackend.BuildRecipe("request-id", "staging-guid", stagingRequest)
 					Expect(err).NotTo(HaveOccurred())
 
 					Expect(taskDef.Action).NotTo(BeNil())
 				})
 
 				It("has expected EgressRules", func() {
-					taskDef, _, _, err := dockerBackend.BuildRecipe("staging-guid", stagingRequest)
+					taskDef, _, _, err := dockerBackend.BuildRecipe("request-id", "staging-guid", stagingRequest)
 					Expect(err).NotTo(HaveOccurred())
 
 					expectedEgressRules := []*models.SecurityGroupRule{}
@@ -213,7 +213,7 @@ var _ = Describe("DockerBackend", func() {
 				})
 
 				It("includes the expected Docker DownloadAction", func() {
-					taskDef, _, _, err := dockerBackend.BuildRecipe("staging-guid", stagingRequest)
+					taskDef, _, _, err := dockerBackend.BuildRecipe("request-id", "staging-guid", stagingRequest)
 					Expect(err).NotTo(HaveOccurred())
 
 					cachedDependencies := taskDef.CachedDependencies
@@ -222,7 +222,7 @@ var _ = Describe("DockerBackend", func() {
 				})
 
 				It("includes mounting of the cgroups", func() {
-					taskDef, _, _, err := dockerBackend.BuildRecipe("staging-guid", stagingRequest)
+					taskDef, _, _, err := dockerBackend.BuildRecipe("request-id", "staging-guid", stagingRequest)
 					Expect(err).NotTo(HaveOccurred())
 
 					actions := actionsFromTaskDef(taskDef)
@@ -231,7 +231,7 @@ var _ = Describe("DockerBackend", func() {
 				})
 
 				It("includes the expected Run action", func() {
-					taskDef, _, _, err := dockerBackend.BuildRecipe("staging-guid", stagingRequest)
+					taskDef, _, _, err := dockerBackend.BuildRecipe("request-id", "staging-guid", stagingRequest)
 					Expect(err).NotTo(HaveOccurred())
 
 					actions := actionsFromTaskDef(taskDef)
@@ -273,21 +273,21 @@ var _ = Describe("DockerBackend", func() {
 				})
 
 				It("runs as unprivileged", func() {
-					taskDef, _, _, err := dockerBackend.BuildRecipe("staging-guid", stagingRequest)
+					taskDef, _, _, err := dockerBackend.BuildRecipe("request-id", "staging-guid", stagingRequest)
 					Expect(err).NotTo(HaveOccurred())
 
 					Expect(taskDef.Privileged).To(BeFalse())
 				})
 
 				It("has an Action", func() {
-					taskDef, _, _, err := dockerBackend.BuildRecipe("staging-guid", stagingRequest)
+					taskDef, _, _, err := dockerBackend.BuildRecipe("request-id", "staging-guid", stagingRequest)
 					Expect(err).NotTo(HaveOccurred())
 
 					Expect(taskDef.Action).NotTo(BeNil())
 				})
 
 				It("has expected EgressRules", func() {
-					taskDef, _, _, err := dockerBackend.BuildRecipe("staging-guid", stagingRequest)
+					taskDef, _, _, err := dockerBackend.BuildRecipe("request-id", "staging-guid", stagingRequest)
 					Expect(err).NotTo(HaveOccurred())
 
 					expectedEgressRules := []*models.SecurityGroupRule{}
@@ -307,7 +307,7 @@ var _ = Describe("DockerBackend", func() {
 				})
 
 				It("includes the expected Docker DownloadAction", func() {
-					taskDef, _, _, err := dockerBackend.BuildRecipe("staging-guid", stagingRequest)
+					taskDef, _, _, err := dockerBackend.BuildRecipe("request-id", "staging-guid", stagingRequest)
 					Expect(err).NotTo(HaveOccurred())
 
 					cachedDependencies := taskDef.CachedDependencies
@@ -316,7 +316,7 @@ var _ = Describe("DockerBackend", func() {
 				})
 
 				It("includes mounting of the cgroups", func() {
-					taskDef, _, _, err := dockerBackend.BuildRecipe("staging-guid", stagingRequest)
+					taskDef, _, _, err := dockerBackend.BuildRecipe("request-id", "staging-guid", stagingRequest)
 					Expect(err).NotTo(HaveOccurred())
 
 					actions := actionsFromTaskDef(taskDef)
@@ -325,7 +325,7 @@ var _ = Describe("DockerBackend", func() {
 				})
 
 				It("includes the expected Run action", func() {
-					taskDef, _, _, err := dockerBackend.BuildRecipe("staging-guid", stagingRequest)
+					taskDef, _, _, err := dockerBackend.BuildRecipe("request-id", "staging-guid", stagingRequest)
 					Expect(err).NotTo(HaveOccurred())
 
 					actions := actionsFromTaskDef(taskDef)
@@ -376,21 +376,21 @@ var _ = Describe("DockerBackend", func() {
 				})
 
 				It("runs as unprivileged", func() {
-					taskDef, _, _, err := dockerBackend.BuildRecipe("staging-guid", stagingRequest)
+					taskDef, _, _, err := dockerBackend.BuildRecipe("request-id", "staging-guid", stagingRequest)
 					Expect(err).NotTo(HaveOccurred())
 
 					Expect(taskDef.Privileged).To(BeFalse())
 				})
 
 				It("has an Action", func() {
-					taskDef, _, _, err := dockerBackend.BuildRecipe("staging-guid", stagingRequest)
+					taskDef, _, _, err := dockerBackend.BuildRecipe("request-id", "staging-guid", stagingRequest)
 					Expect(err).NotTo(HaveOccurred())
 
 					Expect(taskDef.Action).NotTo(BeNil())
 				})
 
 				It("has expected EgressRules", func() {
-					taskDef, _, _, err := dockerBackend.BuildRecipe("staging-guid", stagingRequest)
+					taskDef, _, _, err := dockerBackend.BuildRecipe("request-id", "staging-guid", stagingRequest)
 					Expect(err).NotTo(HaveOccurred())
 
 					expectedEgressRules := []*models.SecurityGroupRule{}
@@ -410,7 +410,7 @@ var _ = Describe("DockerBackend", func() {
 				})
 
 				It("includes the expected Docker DownloadAction", func() {
-					taskDef, _, _, err := dockerBackend.BuildRecipe("staging-guid", stagingRequest)
+					taskDef, _, _, err := dockerBackend.BuildRecipe("request-id", "staging-guid", stagingRequest)
 					Expect(err).NotTo(HaveOccurred())
 
 					cachedDependencies := taskDef.CachedDependencies
@@ -419,7 +419,7 @@ var _ = Describe("DockerBackend", func() {
 				})
 
 				It("includes mounting of the cgroups", func() {
-					taskDef, _, _, err := dockerBackend.BuildRecipe("staging-guid", stagingRequest)
+					taskDef, _, _, err := dockerBackend.BuildRecipe("request-id", "staging-guid", stagingRequest)
 					Expect(err).NotTo(HaveOccurred())
 
 					actions := actionsFromTaskDef(taskDef)
@@ -428,7 +428,7 @@ var _ = Describe("DockerBackend", func() {
 				})
 
 				It("includes the expected Run action", func() {
-					taskDef, _, _, err := dockerBackend.BuildRecipe("staging-guid", stagingRequest)
+					taskDef, _, _, err := dockerBackend.BuildRecipe("request-id", "staging-guid", stagingRequest)
 					Expect(err).NotTo(HaveOccurred())
 
 					actions := actionsFromTaskDef(taskDef)
@@ -485,7 +485,7 @@ var _ = Describe("DockerBackend", func() {
 			})
 
 			It("errors", func() {
-				_, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+				_, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 				Expect(err).To(HaveOccurred())
 				Expect(err).To(Equal(backend.ErrMissingDockerRegistry))
 			})
@@ -497,7 +497,7 @@ var _ = Describe("DockerBackend", func() {
 			})
 
 			It("does not error", func() {
-				_, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+				_, _, _, err := docker.BuildRecipe("request-id", "staging-guid", stagingRequest)
 				Expect(err).NotTo(HaveOccurred())
 			})
 		})
@@ -10,9 +10,10 @@ import (
 )
 
 type FakeBackend struct {
-	BuildRecipeStub        func(stagingGuid string, request cc_messages.StagingRequestFromCC) (*models.TaskDefinition, string, string, error)
+	BuildRecipeStub        func(requestId, stagingGuid string, request cc_messages.StagingRequestFromCC) (*models.TaskDefinition, string, string, error)
 	buildRecipeMutex       sync.RWMutex
 	buildRecipeArgsForCall []struct {
+		requestId   string
 		stagingGuid string
 		request     cc_messages.StagingRequestFromCC
 	}
@@ -33,15 +34,16 @@ type FakeBackend struct {
 	}
 }
 
-func (fake *FakeBackend) BuildRecipe(stagingGuid string, request cc_messages.StagingRequestFromCC) (*models.TaskDefinition, string, string, error) {
+func (fake *FakeBackend) BuildRecipe(requestId string, stagingGuid string, request cc_messages.StagingRequestFromCC) (*models.TaskDefinition, string, string, error) {
 	fake.buildRecipeMutex.Lock()
 	fake.buildRecipeArgsForCall = append(fake.buildRecipeArgsForCall, struct {
+		requestId   string
 		stagingGuid string
 		request     cc_messages.StagingRequestFromCC
-	}{stagingGuid, request})
+	}{requestId, stagingGuid, request})
 	fake.buildRecipeMutex.Unlock()
 	if fake.BuildRecipeStub != nil {
-		return fake.BuildRecipeStub(stagingGuid, request)
+		return fake.BuildRecipeStub(requestId, stagingGuid, request)
 	} else {
 		return fake.buildRecipeReturns.result1, fake.buildRecipeReturns.result2, fake.buildRecipeReturns.result3, fake.buildRecipeReturns.result4
 	}
@@ -53,10 +55,10 @@ func (fake *FakeBackend) BuildRecipeCallCount() int {
 	return len(fake.buildRecipeArgsForCall)
 }
 
-func (fake *FakeBackend) BuildRecipeArgsForCall(i int) (string, cc_messages.StagingRequestFromCC) {
+func (fake *FakeBackend) BuildRecipeArgsForCall(i int) (string, string, cc_messages.StagingRequestFromCC) {
 	fake.buildRecipeMutex.RLock()
 	defer fake.buildRecipeMutex.RUnlock()
-	return fake.buildRecipeArgsForCall[i].stagingGuid, fake.buildRecipeArgsForCall[i].request
+	return fake.buildRecipeArgsForCall[i].requestId, fake.buildRecipeArgsForCall[i].stagingGuid, fake.buildRecipeArgsForCall[i].request
 }
 
 func (fake *FakeBackend) BuildRecipeReturns(result1 *models.TaskDefinition, result2 string, result3 string, result4 error) {
@@ -0,0 +1,58 @@
+// This file was generated by counterfeiter
+package fake_backend
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/stager/backend"
+)
+
+type FakeBundleVersionFetcher struct {
+	BundleVersionStub        func(logger lager.Logger, url string) (string, error)
+	bundleVersionMutex       sync.RWMutex
+	bundleVersionArgsForCall []struct {
+		logger lager.Logger
+		url    string
+	}
+	bundleVersionReturns struct {
+		result1 string
+		result2 error
+	}
+}
+
+func (fake *FakeBundleVersionFetcher) BundleVersion(logger lager.Logger, url string) (string, error) {
+	fake.bundleVersionMutex.Lock()
+	fake.bundleVersionArgsForCall = append(fake.bundleVersionArgsForCall, struct {
+		logger lager.Logger
+		url    string
+	}{logger, url})
+	fake.bundleVersionMutex.Unlock()
+	if fake.BundleVersionStub != nil {
+		return fake.BundleVersionStub(logger, url)
+	} else {
+		return fake.bundleVersionReturns.result1, fake.bundleVersionReturns.result2
+	}
+}
+
+func (fake *FakeBundleVersionFetcher) BundleVersionCallCount() int {
+	fake.bundleVersionMutex.RLock()
+	defer fake.bundleVersionMutex.RUnlock()
+	return len(fake.bundleVersionArgsForCall)
+}
+
+func (fake *FakeBundleVersionFetcher) BundleVersionArgsForCall(i int) (lager.Logger, string) {
+	fake.bundleVersionMutex.RLock()
+	defer fake.bundleVersionMutex.RUnlock()
+	return fake.bundleVersionArgsForCall[i].logger, fake.bundleVersionArgsForCall[i].url
+}
+
+func (fake *FakeBundleVersionFetcher) BundleVersionReturns(result1 string, result2 error) {
+	fake.BundleVersionStub = nil
+	fake.bundleVersionReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+var _ backend.BundleVersionFetcher = new(FakeBundleVersionFetcher)
@@ -0,0 +1,59 @@
+// This file was generated by counterfeiter
+package fake_backend
+
+import (
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/stager/backend"
+)
+
+type FakeDockerImageMetadataFetcher struct {
+	ImageCreatedAtStub        func(logger lager.Logger, image backend.DockerImageReference) (time.Time, error)
+	imageCreatedAtMutex       sync.RWMutex
+	imageCreatedAtArgsForCall []struct {
+		logger lager.Logger
+		image  backend.DockerImageReference
+	}
+	imageCreatedAtReturns struct {
+		result1 time.Time
+		result2 error
+	}
+}
+
+func (fake *FakeDockerImageMetadataFetcher) ImageCreatedAt(logger lager.Logger, image backend.DockerImageReference) (time.Time, error) {
+	fake.imageCreatedAtMutex.Lock()
+	fake.imageCreatedAtArgsForCall = append(fake.imageCreatedAtArgsForCall, struct {
+		logger lager.Logger
+		image  backend.DockerImageReference
+	}{logger, image})
+	fake.imageCreatedAtMutex.Unlock()
+	if fake.ImageCreatedAtStub != nil {
+		return fake.ImageCreatedAtStub(logger, image)
+	} else {
+		return fake.imageCreatedAtReturns.result1, fake.imageCreatedAtReturns.result2
+	}
+}
+
+func (fake *FakeDockerImageMetadataFetcher) ImageCreatedAtCallCount() int {
+	fake.imageCreatedAtMutex.RLock()
+	defer fake.imageCreatedAtMutex.RUnlock()
+	return len(fake.imageCreatedAtArgsForCall)
+}
+
+func (fake *FakeDockerImageMetadataFetcher) ImageCreatedAtArgsForCall(i int) (lager.Logger, backend.DockerImageReference) {
+	fake.imageCreatedAtMutex.RLock()
+	defer fake.imageCreatedAtMutex.RUnlock()
+	return fake.imageCreatedAtArgsForCall[i].logger, fake.imageCreatedAtArgsForCall[i].image
+}
+
+func (fake *FakeDockerImageMetadataFetcher) ImageCreatedAtReturns(result1 time.Time, result2 error) {
+	fake.ImageCreatedAtStub = nil
+	fake.imageCreatedAtReturns = struct {
+		result1 time.Time
+		result2 error
+	}{result1, result2}
+}
+
+var _ backend.DockerImageMetadataFetcher = new(FakeDockerImageMetadataFetcher)
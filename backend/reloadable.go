@@ -0,0 +1,142 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+)
+
+// Validate checks the parts of a Config that can be changed at runtime.
+func (c Config) Validate() error {
+	if c.DockerStagingStack == "" {
+		return errors.New("docker staging stack cannot be blank")
+	}
+
+	_, err := url.Parse(c.DockerRegistryAddress)
+	if err != nil {
+		return fmt.Errorf("invalid docker registry address: %s", err)
+	}
+
+	for key, bundle := range c.Lifecycles {
+		if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") {
+			return fmt.Errorf("invalid lifecycle %q", key)
+		}
+		if bundle == "" {
+			return fmt.Errorf("lifecycle %q has no bundle", key)
+		}
+	}
+
+	for key, canary := range c.LifecycleCanaries {
+		if _, ok := c.Lifecycles[key]; !ok {
+			return fmt.Errorf("canary for lifecycle %q has no primary bundle", key)
+		}
+		err := canary.Validate()
+		if err != nil {
+			return fmt.Errorf("canary for lifecycle %q: %s", key, err)
+		}
+	}
+
+	for key, checksum := range c.LifecycleChecksums {
+		err := checksum.Validate()
+		if err != nil {
+			return fmt.Errorf("lifecycle %q: %s", key, err)
+		}
+	}
+
+	// the bounds of a stack are only valid merged over its lifecycle's
+	for key := range c.StagingResources {
+		lifecycle, stack := key, ""
+		if i := strings.Index(key, "/"); i >= 0 {
+			lifecycle, stack = key[:i], key[i+1:]
+		}
+		err := c.stagingResources(lifecycle, stack).Validate()
+		if err != nil {
+			return fmt.Errorf("staging resources for %q: %s", key, err)
+		}
+	}
+
+	return nil
+}
+
+// ReloadableBackends hold the backends built from a Config that can be
+// replaced at runtime. Requests in flight when the config is replaced
+// finish on the backends they started on.
+type ReloadableBackends struct {
+	newBackends func(Config) map[string]Backend
+
+	lock     sync.RWMutex
+	config   Config
+	backends map[string]Backend
+}
+
+// NewReloadableBackends builds the initial backends from config.
+// newBackends must return a backend for the same lifecycles for every
+// config.
+func NewReloadableBackends(config Config, newBackends func(Config) map[string]Backend) *ReloadableBackends {
+	return &ReloadableBackends{
+		newBackends: newBackends,
+		config:      config,
+		backends:    newBackends(config),
+	}
+}
+
+func (r *ReloadableBackends) Config() Config {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.config
+}
+
+// Reload replaces the backends with ones built from config, unless config
+// is invalid.
+func (r *ReloadableBackends) Reload(config Config) error {
+	err := config.Validate()
+	if err != nil {
+		return err
+	}
+
+	backends := r.newBackends(config)
+
+	r.lock.Lock()
+	r.config = config
+	r.backends = backends
+	r.lock.Unlock()
+
+	return nil
+}
+
+// Backends returns a backend per lifecycle that always uses the backend
+// built from the current config.
+func (r *ReloadableBackends) Backends() map[string]Backend {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	backends := map[string]Backend{}
+	for lifecycle := range r.backends {
+		backends[lifecycle] = &reloadingBackend{backends: r, lifecycle: lifecycle}
+	}
+	return backends
+}
+
+func (r *ReloadableBackends) backend(lifecycle string) Backend {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.backends[lifecycle]
+}
+
+type reloadingBackend struct {
+	backends  *ReloadableBackends
+	lifecycle string
+}
+
+func (b *reloadingBackend) BuildRecipe(requestId, stagingGuid string, request cc_messages.StagingRequestFromCC) (*models.TaskDefinition, string, string, error) {
+	return b.backends.backend(b.lifecycle).BuildRecipe(requestId, stagingGuid, request)
+}
+
+func (b *reloadingBackend) BuildStagingResponse(response *models.TaskCallbackResponse) (cc_messages.StagingResponseForCC, error) {
+	return b.backends.backend(b.lifecycle).BuildStagingResponse(response)
+}
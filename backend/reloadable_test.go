@@ -0,0 +1,155 @@
+package backend_test
+
+import (
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/backend/fake_backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	Describe("Validate", func() {
+		var config backend.Config
+
+		BeforeEach(func() {
+			config = backend.Config{
+				Lifecycles:            map[string]string{"buildpack/cflinuxfs2": "buildpack_app_lifecycle.tgz", "docker": "docker_app_lifecycle.tgz"},
+				DockerStagingStack:    "cflinuxfs2",
+				DockerRegistryAddress: "docker-registry.service.cf.internal:8080",
+			}
+		})
+
+		It("accepts a valid config", func() {
+			Expect(config.Validate()).To(Succeed())
+		})
+
+		It("requires a docker staging stack", func() {
+			config.DockerStagingStack = ""
+			Expect(config.Validate()).To(MatchError("docker staging stack cannot be blank"))
+		})
+
+		It("rejects lifecycles without a bundle", func() {
+			config.Lifecycles["buildpack/windows2012R2"] = ""
+			Expect(config.Validate()).To(MatchError(`lifecycle "buildpack/windows2012R2" has no bundle`))
+		})
+
+		It("rejects malformed lifecycles", func() {
+			config.Lifecycles["buildpack/"] = "buildpack_app_lifecycle.tgz"
+			Expect(config.Validate()).To(MatchError(`invalid lifecycle "buildpack/"`))
+		})
+
+		It("rejects canaries without a primary bundle", func() {
+			config.LifecycleCanaries = map[string]backend.Canary{"buildpack/windows2012R2": {Bundle: "canary.tgz", Percent: 10}}
+			Expect(config.Validate()).To(MatchError(`canary for lifecycle "buildpack/windows2012R2" has no primary bundle`))
+		})
+
+		It("rejects canaries with a share outside of 0 to 100 percent", func() {
+			config.LifecycleCanaries = map[string]backend.Canary{"docker": {Bundle: "canary.tgz", Percent: 101}}
+			Expect(config.Validate()).To(MatchError(`canary for lifecycle "docker": percent 101 is outside of [0, 100]`))
+		})
+
+		It("rejects lifecycle checksums with an unsupported algorithm", func() {
+			config.LifecycleChecksums = map[string]backend.Checksum{"docker": {Algorithm: "crc32", Value: "abc"}}
+			Expect(config.Validate()).To(MatchError(`lifecycle "docker": unsupported checksum algorithm "crc32"`))
+		})
+
+		It("rejects staging resources of a stack that are invalid merged over the lifecycle's", func() {
+			config.StagingResources = map[string]backend.StagingResources{
+				"buildpack":            {MemoryMB: backend.ResourceBounds{Minimum: 1024}},
+				"buildpack/cflinuxfs2": {MemoryMB: backend.ResourceBounds{Maximum: 512}},
+			}
+			Expect(config.Validate()).To(MatchError(`staging resources for "buildpack/cflinuxfs2": memory_mb: minimum 1024 exceeds maximum 512`))
+		})
+
+		It("rejects a lifecycle's default outside of a stack's bounds", func() {
+			config.StagingResources = map[string]backend.StagingResources{
+				"buildpack":            {CpuWeight: backend.ResourceBounds{Default: 90}},
+				"buildpack/cflinuxfs2": {CpuWeight: backend.ResourceBounds{Maximum: 50}},
+			}
+			Expect(config.Validate()).To(MatchError(`staging resources for "buildpack/cflinuxfs2": cpu_weight: default 90 is outside of [0, 50]`))
+		})
+	})
+})
+
+var _ = Describe("ReloadableBackends", func() {
+	var (
+		initialConfig backend.Config
+		builtFrom     []backend.Config
+		fakeBackends  []*fake_backend.FakeBackend
+		reloadable    *backend.ReloadableBackends
+	)
+
+	BeforeEach(func() {
+		initialConfig = backend.Config{
+			Lifecycles:         map[string]string{"buildpack/cflinuxfs2": "old-lifecycle.tgz"},
+			DockerStagingStack: "cflinuxfs2",
+		}
+		builtFrom = nil
+		fakeBackends = nil
+
+		reloadable = backend.NewReloadableBackends(initialConfig, func(config backend.Config) map[string]backend.Backend {
+			fakeBackend := &fake_backend.FakeBackend{}
+			fakeBackend.BuildRecipeReturns(&models.TaskDefinition{LogSource: config.Lifecycles["buildpack/cflinuxfs2"]}, "", "", nil)
+
+			builtFrom = append(builtFrom, config)
+			fakeBackends = append(fakeBackends, fakeBackend)
+			return map[string]backend.Backend{"buildpack": fakeBackend}
+		})
+	})
+
+	buildRecipe := func(backends map[string]backend.Backend) string {
+		taskDef, _, _, err := backends["buildpack"].BuildRecipe("request-id", "staging-guid", cc_messages.StagingRequestFromCC{})
+		Expect(err).NotTo(HaveOccurred())
+		return taskDef.LogSource
+	}
+
+	It("builds the backends from the initial config", func() {
+		Expect(builtFrom).To(Equal([]backend.Config{initialConfig}))
+		Expect(reloadable.Config()).To(Equal(initialConfig))
+		Expect(buildRecipe(reloadable.Backends())).To(Equal("old-lifecycle.tgz"))
+	})
+
+	Context("when reloaded with a valid config", func() {
+		var newConfig backend.Config
+
+		BeforeEach(func() {
+			newConfig = backend.Config{
+				Lifecycles:         map[string]string{"buildpack/cflinuxfs2": "new-lifecycle.tgz"},
+				DockerStagingStack: "cflinuxfs2",
+			}
+		})
+
+		It("switches the existing backends to the new config", func() {
+			backends := reloadable.Backends()
+
+			Expect(reloadable.Reload(newConfig)).To(Succeed())
+
+			Expect(reloadable.Config()).To(Equal(newConfig))
+			Expect(buildRecipe(backends)).To(Equal("new-lifecycle.tgz"))
+			Expect(fakeBackends[0].BuildRecipeCallCount()).To(Equal(0))
+		})
+
+		It("delivers staging responses with the new backends", func() {
+			backends := reloadable.Backends()
+
+			Expect(reloadable.Reload(newConfig)).To(Succeed())
+
+			_, err := backends["buildpack"].BuildStagingResponse(&models.TaskCallbackResponse{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeBackends[1].BuildStagingResponseCallCount()).To(Equal(1))
+		})
+	})
+
+	Context("when reloaded with an invalid config", func() {
+		It("keeps the current config", func() {
+			err := reloadable.Reload(backend.Config{})
+			Expect(err).To(HaveOccurred())
+
+			Expect(builtFrom).To(HaveLen(1))
+			Expect(reloadable.Config()).To(Equal(initialConfig))
+			Expect(buildRecipe(reloadable.Backends())).To(Equal("old-lifecycle.tgz"))
+		})
+	})
+})
@@ -1,6 +1,9 @@
 package backend_test
 
 import (
+	"crypto/sha1"
+	"fmt"
+
 	"code.cloudfoundry.org/bbs/models"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -17,6 +20,11 @@ func actionsFromTaskDef(taskDef *models.TaskDefinition) []*models.Action {
 	return serialAction.Actions
 }
 
+// urlCacheKey is the cache key of a lifecycle bundle without a checksum.
+func urlCacheKey(name, identity string) string {
+	return fmt.Sprintf("%s-%x", name, sha1.Sum([]byte(identity)))
+}
+
 func TestBackend(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Backend Suite")
@@ -0,0 +1,149 @@
+package backend
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/lager"
+)
+
+const DefaultStagingFileDescriptors = uint64(1024)
+
+type ResourceBounds struct {
+	Default uint64 `json:"default"`
+	Minimum uint64 `json:"minimum"`
+	Maximum uint64 `json:"maximum"`
+}
+
+// StagingResources bounds the resources of staging tasks. Zero values leave
+// the corresponding bound unset.
+type StagingResources struct {
+	MemoryMB        ResourceBounds `json:"memory_mb"`
+	DiskMB          ResourceBounds `json:"disk_mb"`
+	FileDescriptors ResourceBounds `json:"file_descriptors"`
+	CpuWeight       ResourceBounds `json:"cpu_weight"`
+}
+
+type stagingTaskResources struct {
+	MemoryMB        int32
+	DiskMB          int32
+	FileDescriptors uint64
+	CpuWeight       uint32
+}
+
+func (b ResourceBounds) Validate() error {
+	if b.Maximum > 0 && b.Minimum > b.Maximum {
+		return fmt.Errorf("minimum %d exceeds maximum %d", b.Minimum, b.Maximum)
+	}
+
+	if b.Default > 0 && (b.Default < b.Minimum || (b.Maximum > 0 && b.Default > b.Maximum)) {
+		return fmt.Errorf("default %d is outside of [%d, %d]", b.Default, b.Minimum, b.Maximum)
+	}
+
+	return nil
+}
+
+func (r StagingResources) Validate() error {
+	bounds := map[string]ResourceBounds{
+		"memory_mb":        r.MemoryMB,
+		"disk_mb":          r.DiskMB,
+		"file_descriptors": r.FileDescriptors,
+		"cpu_weight":       r.CpuWeight,
+	}
+
+	for name, b := range bounds {
+		if err := b.Validate(); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+
+	if r.CpuWeight.Maximum > 100 || r.CpuWeight.Minimum > 100 || r.CpuWeight.Default > 100 {
+		return fmt.Errorf("cpu_weight: must not exceed 100")
+	}
+
+	return nil
+}
+
+// stagingResources merges the resource bounds for lifecycle/stack over the
+// bounds for the lifecycle alone, so a stack only needs to set the bounds it
+// changes.
+func (c Config) stagingResources(lifecycle, stack string) StagingResources {
+	resources := c.StagingResources[lifecycle]
+	if override, ok := c.StagingResources[lifecycle+"/"+stack]; ok {
+		resources = resources.merge(override)
+	}
+
+	return resources
+}
+
+func (r StagingResources) merge(override StagingResources) StagingResources {
+	return StagingResources{
+		MemoryMB:        r.MemoryMB.merge(override.MemoryMB),
+		DiskMB:          r.DiskMB.merge(override.DiskMB),
+		FileDescriptors: r.FileDescriptors.merge(override.FileDescriptors),
+		CpuWeight:       r.CpuWeight.merge(override.CpuWeight),
+	}
+}
+
+func (b ResourceBounds) merge(override ResourceBounds) ResourceBounds {
+	if override.Default > 0 {
+		b.Default = override.Default
+	}
+	if override.Minimum > 0 {
+		b.Minimum = override.Minimum
+	}
+	if override.Maximum > 0 {
+		b.Maximum = override.Maximum
+	}
+	return b
+}
+
+func (b ResourceBounds) apply(logger lager.Logger, resource string, requested uint64) uint64 {
+	value := requested
+	if value == 0 {
+		value = b.Default
+	}
+
+	if b.Minimum > 0 && value < b.Minimum {
+		value = b.Minimum
+	}
+
+	if b.Maximum > 0 && value > b.Maximum {
+		value = b.Maximum
+	}
+
+	if requested != 0 && value != requested {
+		logger.Info("clamped-staging-resource", lager.Data{
+			"resource":  resource,
+			"requested": requested,
+			"applied":   value,
+		})
+	}
+
+	return value
+}
+
+func resolveStagingResources(logger lager.Logger, config Config, lifecycle, stack string, memoryMB, diskMB, fileDescriptors int) stagingTaskResources {
+	bounds := config.stagingResources(lifecycle, stack)
+
+	if bounds.FileDescriptors.Default == 0 {
+		bounds.FileDescriptors.Default = DefaultStagingFileDescriptors
+	}
+
+	if bounds.CpuWeight.Default == 0 {
+		bounds.CpuWeight.Default = uint64(StagingTaskCpuWeight)
+	}
+
+	return stagingTaskResources{
+		MemoryMB:        int32(bounds.MemoryMB.apply(logger, "memory-mb", nonNegative(memoryMB))),
+		DiskMB:          int32(bounds.DiskMB.apply(logger, "disk-mb", nonNegative(diskMB))),
+		FileDescriptors: bounds.FileDescriptors.apply(logger, "file-descriptors", nonNegative(fileDescriptors)),
+		CpuWeight:       uint32(bounds.CpuWeight.apply(logger, "cpu-weight", 0)),
+	}
+}
+
+func nonNegative(value int) uint64 {
+	if value < 0 {
+		return 0
+	}
+	return uint64(value)
+}
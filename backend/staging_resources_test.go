@@ -0,0 +1,50 @@
+package backend_test
+
+import (
+	"code.cloudfoundry.org/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StagingResources", func() {
+	Describe("Validate", func() {
+		It("accepts empty bounds", func() {
+			Expect(backend.StagingResources{}.Validate()).To(Succeed())
+		})
+
+		It("accepts a default within the bounds", func() {
+			resources := backend.StagingResources{
+				MemoryMB: backend.ResourceBounds{Default: 1024, Minimum: 256, Maximum: 4096},
+			}
+			Expect(resources.Validate()).To(Succeed())
+		})
+
+		It("rejects a minimum above the maximum", func() {
+			resources := backend.StagingResources{
+				DiskMB: backend.ResourceBounds{Minimum: 4096, Maximum: 1024},
+			}
+			Expect(resources.Validate()).To(MatchError("disk_mb: minimum 4096 exceeds maximum 1024"))
+		})
+
+		It("rejects a default outside of the bounds", func() {
+			resources := backend.StagingResources{
+				FileDescriptors: backend.ResourceBounds{Default: 10, Minimum: 256},
+			}
+			Expect(resources.Validate()).To(MatchError("file_descriptors: default 10 is outside of [256, 0]"))
+		})
+
+		It("rejects a cpu weight above 100", func() {
+			resources := backend.StagingResources{
+				CpuWeight: backend.ResourceBounds{Default: 150},
+			}
+			Expect(resources.Validate()).To(MatchError("cpu_weight: must not exceed 100"))
+		})
+
+		It("rejects a cpu weight minimum above 100", func() {
+			resources := backend.StagingResources{
+				CpuWeight: backend.ResourceBounds{Minimum: 120},
+			}
+			Expect(resources.Validate()).To(MatchError("cpu_weight: must not exceed 100"))
+		})
+	})
+})
@@ -0,0 +1,132 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+)
+
+// TimeoutPolicy bounds how long a staging task may run. Zero values fall back
+// to DefaultStagingTimeout, no maximum, and the staging timeout respectively.
+type TimeoutPolicy struct {
+	Default time.Duration
+	Maximum time.Duration
+	Upload  time.Duration
+}
+
+type timeoutPolicyJSON struct {
+	Default string `json:"default"`
+	Maximum string `json:"maximum"`
+	Upload  string `json:"upload"`
+}
+
+func (p *TimeoutPolicy) UnmarshalJSON(data []byte) error {
+	var raw timeoutPolicyJSON
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	fields := []struct {
+		name  string
+		value string
+		dest  *time.Duration
+	}{
+		{"default", raw.Default, &p.Default},
+		{"maximum", raw.Maximum, &p.Maximum},
+		{"upload", raw.Upload, &p.Upload},
+	}
+
+	for _, field := range fields {
+		if field.value == "" {
+			continue
+		}
+
+		*field.dest, err = time.ParseDuration(field.value)
+		if err != nil {
+			return fmt.Errorf("%s: %s", field.name, err)
+		}
+	}
+
+	return nil
+}
+
+func (p TimeoutPolicy) Validate() error {
+	if p.Default < 0 || p.Maximum < 0 || p.Upload < 0 {
+		return fmt.Errorf("timeouts must not be negative")
+	}
+
+	if p.Maximum > 0 && p.Default > p.Maximum {
+		return fmt.Errorf("default %s exceeds maximum %s", p.Default, p.Maximum)
+	}
+
+	return nil
+}
+
+// timeoutPolicy merges the policy for lifecycle/stack over the policy for
+// the lifecycle alone.
+func (c Config) timeoutPolicy(lifecycle, stack string) TimeoutPolicy {
+	policy := c.StagingTimeouts[lifecycle]
+	if override, ok := c.StagingTimeouts[lifecycle+"/"+stack]; ok {
+		if override.Default > 0 {
+			policy.Default = override.Default
+		}
+		if override.Maximum > 0 {
+			policy.Maximum = override.Maximum
+		}
+		if override.Upload > 0 {
+			policy.Upload = override.Upload
+		}
+	}
+
+	return policy
+}
+
+// UploadTimeout returns the timeout CC should apply to uploads of the
+// artifacts of a staging of request, as it is given to its staging task.
+func (c Config) UploadTimeout(logger lager.Logger, lifecycle, stack string, request cc_messages.StagingRequestFromCC) time.Duration {
+	_, uploadTimeout := stagingTimeouts(logger, c, lifecycle, stack, request)
+	return uploadTimeout
+}
+
+// stagingTimeouts returns the timeout for the whole staging task and the
+// timeout CC should apply to uploads of its artifacts.
+func stagingTimeouts(logger lager.Logger, config Config, lifecycle, stack string, request cc_messages.StagingRequestFromCC) (time.Duration, time.Duration) {
+	policy := config.timeoutPolicy(lifecycle, stack)
+
+	defaultTimeout := policy.Default
+	if defaultTimeout == 0 {
+		defaultTimeout = DefaultStagingTimeout
+	}
+	if policy.Maximum > 0 && defaultTimeout > policy.Maximum {
+		defaultTimeout = policy.Maximum
+	}
+
+	timeout := defaultTimeout
+	if request.Timeout > 0 {
+		timeout = time.Duration(request.Timeout) * time.Second
+		if policy.Maximum > 0 && timeout > policy.Maximum {
+			logger.Info("capping-requested-timeout", lager.Data{
+				"requested-timeout": request.Timeout,
+				"maximum-timeout":   policy.Maximum,
+			})
+			timeout = policy.Maximum
+		}
+	} else {
+		logger.Info("overriding requested timeout", lager.Data{
+			"requested-timeout": request.Timeout,
+			"default-timeout":   defaultTimeout,
+			"app-id":            request.AppId,
+		})
+	}
+
+	uploadTimeout := policy.Upload
+	if uploadTimeout == 0 {
+		uploadTimeout = timeout
+	}
+
+	return timeout, uploadTimeout
+}
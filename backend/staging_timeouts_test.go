@@ -0,0 +1,44 @@
+package backend_test
+
+import (
+	"encoding/json"
+	"time"
+
+	"code.cloudfoundry.org/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TimeoutPolicy", func() {
+	Describe("UnmarshalJSON", func() {
+		It("parses durations", func() {
+			var policies map[string]backend.TimeoutPolicy
+			err := json.Unmarshal([]byte(`{"buildpack": {"default": "15m", "maximum": "30m", "upload": "5m"}, "docker": {"maximum": "5m"}}`), &policies)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(policies).To(Equal(map[string]backend.TimeoutPolicy{
+				"buildpack": {Default: 15 * time.Minute, Maximum: 30 * time.Minute, Upload: 5 * time.Minute},
+				"docker":    {Maximum: 5 * time.Minute},
+			}))
+		})
+
+		It("fails on invalid durations", func() {
+			var policy backend.TimeoutPolicy
+			err := json.Unmarshal([]byte(`{"default": "forever"}`), &policy)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("default: "))
+		})
+	})
+
+	Describe("Validate", func() {
+		It("rejects a default above the maximum", func() {
+			policy := backend.TimeoutPolicy{Default: time.Hour, Maximum: time.Minute}
+			Expect(policy.Validate()).To(MatchError("default 1h0m0s exceeds maximum 1m0s"))
+		})
+
+		It("rejects negative timeouts", func() {
+			policy := backend.TimeoutPolicy{Upload: -time.Second}
+			Expect(policy.Validate()).To(HaveOccurred())
+		})
+	})
+})
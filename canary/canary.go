@@ -0,0 +1,232 @@
+package canary
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/metric"
+)
+
+const (
+	DefaultFailureThreshold = 0.5
+	DefaultMinStagings      = 10
+	DefaultWindow           = 50
+
+	canaryRollbacksCounter = metric.Counter("LifecycleCanaryRollbacks")
+)
+
+// Key identifies a lifecycle bundle to a Tracker: by its path and, when it
+// has one, its checksum, so that a fixed bundle published at the path of a
+// rolled back one is a new canary.
+func Key(bundle, checksum string) string {
+	if checksum == "" {
+		return bundle
+	}
+	return bundle + "@" + checksum
+}
+
+// outcomes holds whether each of the most recent stagings on a bundle
+// failed, oldest overwritten first.
+type outcomes struct {
+	failed   []bool
+	next     int
+	failures int
+}
+
+func (o *outcomes) add(failed bool, window int) {
+	if len(o.failed) < window {
+		o.failed = append(o.failed, failed)
+	} else {
+		if o.failed[o.next] {
+			o.failures--
+		}
+		o.failed[o.next] = failed
+		o.next = (o.next + 1) % window
+	}
+	if failed {
+		o.failures++
+	}
+}
+
+func (o *outcomes) stagings() int {
+	if o == nil {
+		return 0
+	}
+	return len(o.failed)
+}
+
+func (o *outcomes) failureRate() float64 {
+	if o.stagings() == 0 {
+		return 0
+	}
+	return float64(o.failures) / float64(len(o.failed))
+}
+
+// Tracker keeps the outcomes of the last window stagings per lifecycle
+// bundle and rolls a canary bundle back once more than failureThreshold of
+// at least minStagings of them failed. Bundles are identified by Key.
+//
+// A rolled back canary stays rolled back until the stager restarts, or for
+// good if the Tracker has a state file. Publishing a fixed canary with a
+// different checksum or at a different path starts afresh; a canary
+// without a checksum fixed in place is reset by removing its entry from
+// the state file and restarting. A nil Tracker never rolls back.
+type Tracker struct {
+	failureThreshold float64
+	minStagings      int
+	window           int
+	statePath        string
+
+	lock       sync.Mutex
+	outcomes   map[string]*outcomes
+	rolledBack map[string]bool
+}
+
+// NewTracker returns a Tracker judging canaries on their last window
+// stagings. A window smaller than minStagings is widened to it.
+func NewTracker(failureThreshold float64, minStagings, window int) *Tracker {
+	if window < minStagings {
+		window = minStagings
+	}
+	if window < 1 {
+		window = 1
+	}
+
+	return &Tracker{
+		failureThreshold: failureThreshold,
+		minStagings:      minStagings,
+		window:           window,
+		outcomes:         map[string]*outcomes{},
+		rolledBack:       map[string]bool{},
+	}
+}
+
+// LoadTracker returns a Tracker that remembers the canaries it rolled back
+// in the file at statePath.
+func LoadTracker(failureThreshold float64, minStagings, window int, statePath string) (*Tracker, error) {
+	t := NewTracker(failureThreshold, minStagings, window)
+	t.statePath = statePath
+
+	payload, err := ioutil.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var s state
+	err = json.Unmarshal(payload, &s)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range s.RolledBack {
+		t.rolledBack[key] = true
+	}
+	return t, nil
+}
+
+type state struct {
+	RolledBack []string `json:"rolled_back"`
+}
+
+// RolledBack reports whether stagings should no longer use the canary
+// bundle with the given key.
+func (t *Tracker) RolledBack(key string) bool {
+	if t == nil {
+		return false
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.rolledBack[key]
+}
+
+// Record adds the outcome of a staging on the bundle with the given key.
+func (t *Tracker) Record(logger lager.Logger, key string, canary, failed bool) {
+	if t == nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	o, ok := t.outcomes[key]
+	if !ok {
+		o = &outcomes{}
+		t.outcomes[key] = o
+	}
+	o.add(failed, t.window)
+
+	if !canary || t.rolledBack[key] || o.stagings() < t.minStagings || o.failureRate() <= t.failureThreshold {
+		return
+	}
+
+	t.rolledBack[key] = true
+	canaryRollbacksCounter.Increment()
+
+	err := t.save()
+	if err != nil {
+		logger.Error("failed-to-save-rolled-back-canaries", err, lager.Data{"path": t.statePath})
+	}
+	logger.Info("canary-rolled-back", lager.Data{
+		"bundle":       key,
+		"stagings":     o.stagings(),
+		"failures":     o.failures,
+		"failure-rate": o.failureRate(),
+	})
+}
+
+// FailureRate returns the share of the recent stagings on the bundle with
+// the given key that failed.
+func (t *Tracker) FailureRate(key string) float64 {
+	if t == nil {
+		return 0
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.outcomes[key].failureRate()
+}
+
+// save writes the rolled back canaries to the state file, if there is one.
+// The caller holds the lock.
+func (t *Tracker) save() error {
+	if t.statePath == "" {
+		return nil
+	}
+
+	s := state{RolledBack: []string{}}
+	for key := range t.rolledBack {
+		s.RolledBack = append(s.RolledBack, key)
+	}
+	sort.Strings(s.RolledBack)
+
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(t.statePath), filepath.Base(t.statePath))
+	if err != nil {
+		return err
+	}
+
+	_, err = tmpFile.Write(payload)
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), t.statePath)
+}
@@ -0,0 +1,165 @@
+package canary_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/canary"
+	fake_metric_sender "github.com/cloudfoundry/dropsonde/metric_sender/fake"
+	"github.com/cloudfoundry/dropsonde/metrics"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Tracker", func() {
+	var (
+		fakeMetricSender *fake_metric_sender.FakeMetricSender
+		logger           *lagertest.TestLogger
+		tracker          *canary.Tracker
+	)
+
+	record := func(bundle string, isCanary bool, stagings, failures int) {
+		for i := 0; i < stagings; i++ {
+			tracker.Record(logger, bundle, isCanary, i < failures)
+		}
+	}
+
+	BeforeEach(func() {
+		fakeMetricSender = fake_metric_sender.NewFakeMetricSender()
+		metrics.Initialize(fakeMetricSender, nil)
+
+		logger = lagertest.NewTestLogger("test")
+		tracker = canary.NewTracker(0.5, 4, 8)
+	})
+
+	It("tracks the failure rate per bundle", func() {
+		record("canary.tgz", true, 4, 1)
+		record("primary.tgz", false, 2, 0)
+
+		Expect(tracker.FailureRate("canary.tgz")).To(Equal(0.25))
+		Expect(tracker.FailureRate("primary.tgz")).To(BeZero())
+		Expect(tracker.FailureRate("unknown.tgz")).To(BeZero())
+	})
+
+	It("keeps a canary whose failure rate stays at the threshold", func() {
+		record("canary.tgz", true, 4, 2)
+		Expect(tracker.RolledBack("canary.tgz")).To(BeFalse())
+	})
+
+	It("rolls a canary back once its failure rate crosses the threshold", func() {
+		record("canary.tgz", true, 4, 3)
+
+		Expect(tracker.RolledBack("canary.tgz")).To(BeTrue())
+		Expect(fakeMetricSender.GetCounter("LifecycleCanaryRollbacks")).To(BeEquivalentTo(1))
+		Expect(logger).To(gbytes.Say("canary-rolled-back"))
+	})
+
+	It("waits for enough stagings before rolling back", func() {
+		record("canary.tgz", true, 3, 3)
+		Expect(tracker.RolledBack("canary.tgz")).To(BeFalse())
+	})
+
+	It("rolls a canary back only once", func() {
+		record("canary.tgz", true, 8, 8)
+		Expect(fakeMetricSender.GetCounter("LifecycleCanaryRollbacks")).To(BeEquivalentTo(1))
+	})
+
+	It("judges the failure rate on the most recent stagings", func() {
+		record("canary.tgz", true, 8, 0)
+		record("canary.tgz", true, 5, 5)
+
+		Expect(tracker.FailureRate("canary.tgz")).To(Equal(0.625))
+		Expect(tracker.RolledBack("canary.tgz")).To(BeTrue())
+	})
+
+	It("forgets failures that left the window", func() {
+		record("primary.tgz", false, 8, 8)
+		record("primary.tgz", false, 8, 0)
+
+		Expect(tracker.FailureRate("primary.tgz")).To(BeZero())
+	})
+
+	Context("when the window is smaller than the minimum stagings", func() {
+		BeforeEach(func() {
+			tracker = canary.NewTracker(0.5, 4, 2)
+		})
+
+		It("judges the failure rate on the minimum stagings", func() {
+			record("canary.tgz", true, 4, 2)
+			Expect(tracker.FailureRate("canary.tgz")).To(Equal(0.5))
+		})
+	})
+
+	It("tells bundles at the same path with different checksums apart", func() {
+		broken := canary.Key("canary.tgz", "sha256:broken")
+		fixed := canary.Key("canary.tgz", "sha256:fixed")
+		record(broken, true, 4, 4)
+
+		Expect(tracker.RolledBack(broken)).To(BeTrue())
+		Expect(tracker.RolledBack(fixed)).To(BeFalse())
+		Expect(tracker.RolledBack(canary.Key("canary.tgz", ""))).To(BeFalse())
+	})
+
+	It("never rolls back a primary bundle", func() {
+		record("primary.tgz", false, 4, 4)
+		Expect(tracker.RolledBack("primary.tgz")).To(BeFalse())
+	})
+
+	Context("when the tracker has a state file", func() {
+		var (
+			stateDir  string
+			statePath string
+		)
+
+		BeforeEach(func() {
+			var err error
+			stateDir, err = ioutil.TempDir("", "canary")
+			Expect(err).NotTo(HaveOccurred())
+			statePath = filepath.Join(stateDir, "canaries.json")
+
+			tracker, err = canary.LoadTracker(0.5, 4, 8, statePath)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(stateDir)
+		})
+
+		It("keeps rolled back canaries rolled back across restarts", func() {
+			record("canary.tgz", true, 4, 3)
+			Expect(statePath).To(BeAnExistingFile())
+
+			restarted, err := canary.LoadTracker(0.5, 4, 8, statePath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(restarted.RolledBack("canary.tgz")).To(BeTrue())
+			Expect(restarted.RolledBack("other-canary.tgz")).To(BeFalse())
+		})
+
+		Context("when the state file is corrupt", func() {
+			BeforeEach(func() {
+				err := ioutil.WriteFile(statePath, []byte("{"), 0600)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("fails to load", func() {
+				_, err := canary.LoadTracker(0.5, 4, 8, statePath)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Context("when the tracker is nil", func() {
+		BeforeEach(func() {
+			tracker = nil
+		})
+
+		It("never rolls back", func() {
+			record("canary.tgz", true, 4, 4)
+			Expect(tracker.RolledBack("canary.tgz")).To(BeFalse())
+		})
+	})
+})
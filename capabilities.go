@@ -0,0 +1,30 @@
+package stager
+
+// Capabilities describe what a stager is configured to stage, so that
+// clients can reject unsupported requests before staging starts.
+type Capabilities struct {
+	// Lifecycles maps each lifecycle to the stacks it can stage on and the
+	// lifecycle bundle used for each.
+	Lifecycles         map[string]map[string]string `json:"lifecycles"`
+	DockerStagingStack string                       `json:"docker_staging_stack"`
+	DockerImageCaching bool                         `json:"docker_image_caching"`
+	Features           []string                     `json:"features"`
+}
+
+// Features a stager may report as enabled.
+const (
+	FeatureAdmission   = "admission"
+	FeatureSupersede   = "supersede"
+	FeatureResultCache = "result_cache"
+	FeatureQueueing    = "queueing"
+	FeatureRetries     = "retries"
+	FeatureTracing     = "tracing"
+	FeatureRequestIds  = "request_ids"
+	FeatureValidation  = "request_validation"
+)
+
+// Supports reports whether the stager can stage lifecycle on stack.
+func (c Capabilities) Supports(lifecycle, stack string) bool {
+	_, ok := c.Lifecycles[lifecycle][stack]
+	return ok
+}
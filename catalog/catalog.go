@@ -0,0 +1,208 @@
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry/gunk/urljoiner"
+	"github.com/tedsuo/ifrit"
+)
+
+const (
+	DefaultRefreshInterval = time.Minute
+	DefaultArch            = "amd64"
+)
+
+type Checksum struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"value"`
+}
+
+// Bundle is a lifecycle bundle available on the file server. Path is
+// relative to the file server's static directory, like the bundles given
+// with -lifecycle. Lifecycles that are not staged per stack, like docker,
+// leave Stack empty.
+type Bundle struct {
+	Lifecycle string   `json:"lifecycle"`
+	Stack     string   `json:"stack,omitempty"`
+	Arch      string   `json:"arch"`
+	Path      string   `json:"path"`
+	Checksum  Checksum `json:"checksum"`
+}
+
+func (b Bundle) Validate() error {
+	switch {
+	case b.Lifecycle == "":
+		return errors.New("missing lifecycle")
+	case b.Arch == "":
+		return errors.New("missing arch")
+	case b.Path == "":
+		return errors.New("missing path")
+	case b.Checksum.Algorithm == "" || b.Checksum.Value == "":
+		return errors.New("missing checksum")
+	}
+	return nil
+}
+
+// Key returns the key of the bundle in a lifecycle bundle mapping.
+func (b Bundle) Key() string {
+	if b.Stack == "" {
+		return b.Lifecycle
+	}
+	return b.Lifecycle + "/" + b.Stack
+}
+
+// Index lists the lifecycle bundles on the file server.
+type Index struct {
+	Bundles []Bundle `json:"bundles"`
+}
+
+func (i Index) Validate() error {
+	seen := map[string]bool{}
+	for n, bundle := range i.Bundles {
+		err := bundle.Validate()
+		if err != nil {
+			return fmt.Errorf("bundle %d: %s", n, err)
+		}
+
+		id := bundle.Key() + "@" + bundle.Arch
+		if seen[id] {
+			return fmt.Errorf("bundle %d: duplicate bundle for %s", n, id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// Lifecycles returns the bundles for arch in the form of the -lifecycle
+// mappings.
+func (i Index) Lifecycles(arch string) map[string]string {
+	lifecycles := map[string]string{}
+	for _, bundle := range i.Bundles {
+		if bundle.Arch == arch {
+			lifecycles[bundle.Key()] = bundle.Path
+		}
+	}
+	return lifecycles
+}
+
+// Checksums returns the checksums of the bundles for arch, keyed like
+// Lifecycles.
+func (i Index) Checksums(arch string) map[string]Checksum {
+	checksums := map[string]Checksum{}
+	for _, bundle := range i.Bundles {
+		if bundle.Arch == arch {
+			checksums[bundle.Key()] = bundle.Checksum
+		}
+	}
+	return checksums
+}
+
+// Fetch downloads and validates the index at indexPath on the file server.
+func Fetch(httpClient *http.Client, fileServerURL, indexPath string) (Index, error) {
+	var index Index
+
+	response, err := httpClient.Get(urljoiner.Join(fileServerURL, "/v1/static", indexPath))
+	if err != nil {
+		return index, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return index, fmt.Errorf("file server responded with %d", response.StatusCode)
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&index)
+	if err != nil {
+		return index, fmt.Errorf("invalid index: %s", err)
+	}
+
+	err = index.Validate()
+	if err != nil {
+		return index, fmt.Errorf("invalid index: %s", err)
+	}
+
+	return index, nil
+}
+
+// refresher periodically fetches the index and hands it to update whenever
+// it changes. A failed fetch keeps the last index in use.
+type refresher struct {
+	logger        lager.Logger
+	httpClient    *http.Client
+	fileServerURL string
+	indexPath     string
+	clock         clock.Clock
+	interval      time.Duration
+	update        func(Index) error
+
+	current *Index
+}
+
+func New(
+	logger lager.Logger,
+	httpClient *http.Client,
+	fileServerURL string,
+	indexPath string,
+	clock clock.Clock,
+	interval time.Duration,
+	update func(Index) error,
+) ifrit.Runner {
+	return &refresher{
+		logger:        logger.Session("lifecycle-catalog"),
+		httpClient:    httpClient,
+		fileServerURL: fileServerURL,
+		indexPath:     indexPath,
+		clock:         clock,
+		interval:      interval,
+		update:        update,
+	}
+}
+
+func (r *refresher) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	r.refresh()
+
+	ticker := r.clock.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	close(ready)
+
+	for {
+		select {
+		case <-ticker.C():
+			r.refresh()
+		case <-signals:
+			return nil
+		}
+	}
+}
+
+func (r *refresher) refresh() {
+	logger := r.logger.Session("refresh", lager.Data{"index-path": r.indexPath})
+
+	index, err := Fetch(r.httpClient, r.fileServerURL, r.indexPath)
+	if err != nil {
+		logger.Error("failed-to-fetch-index", err)
+		return
+	}
+
+	if r.current != nil && reflect.DeepEqual(*r.current, index) {
+		return
+	}
+
+	err = r.update(index)
+	if err != nil {
+		logger.Error("failed-to-apply-index", err)
+		return
+	}
+
+	r.current = &index
+	logger.Info("applied-index", lager.Data{"bundles": len(index.Bundles)})
+}
@@ -0,0 +1,169 @@
+package catalog_test
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/catalog"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const indexJSON = `{
+	"bundles": [
+		{"lifecycle": "buildpack", "stack": "cflinuxfs2", "arch": "amd64", "path": "buildpack_app_lifecycle/cflinuxfs2.tgz", "checksum": {"algorithm": "sha256", "value": "abc"}},
+		{"lifecycle": "buildpack", "stack": "cflinuxfs2", "arch": "arm64", "path": "buildpack_app_lifecycle/cflinuxfs2-arm64.tgz", "checksum": {"algorithm": "sha256", "value": "def"}},
+		{"lifecycle": "docker", "arch": "amd64", "path": "docker_app_lifecycle/docker.tgz", "checksum": {"algorithm": "sha256", "value": "ghi"}}
+	]
+}`
+
+var _ = Describe("Index", func() {
+	var index catalog.Index
+
+	BeforeEach(func() {
+		index = catalog.Index{Bundles: []catalog.Bundle{
+			{Lifecycle: "buildpack", Stack: "cflinuxfs2", Arch: "amd64", Path: "buildpack.tgz", Checksum: catalog.Checksum{Algorithm: "sha256", Value: "abc"}},
+			{Lifecycle: "docker", Arch: "amd64", Path: "docker.tgz", Checksum: catalog.Checksum{Algorithm: "sha256", Value: "def"}},
+		}}
+	})
+
+	It("maps the bundles of an arch like the -lifecycle flags", func() {
+		Expect(index.Lifecycles("amd64")).To(Equal(map[string]string{
+			"buildpack/cflinuxfs2": "buildpack.tgz",
+			"docker":               "docker.tgz",
+		}))
+		Expect(index.Lifecycles("arm64")).To(BeEmpty())
+	})
+
+	It("keys the checksums of an arch like its lifecycles", func() {
+		Expect(index.Checksums("amd64")).To(Equal(map[string]catalog.Checksum{
+			"buildpack/cflinuxfs2": {Algorithm: "sha256", Value: "abc"},
+			"docker":               {Algorithm: "sha256", Value: "def"},
+		}))
+		Expect(index.Checksums("arm64")).To(BeEmpty())
+	})
+
+	It("requires checksums", func() {
+		index.Bundles[1].Checksum = catalog.Checksum{}
+		Expect(index.Validate()).To(MatchError("bundle 1: missing checksum"))
+	})
+
+	It("rejects duplicate bundles", func() {
+		index.Bundles = append(index.Bundles, index.Bundles[0])
+		Expect(index.Validate()).To(MatchError("bundle 2: duplicate bundle for buildpack/cflinuxfs2@amd64"))
+	})
+})
+
+var _ = Describe("Refresher", func() {
+	const interval = time.Minute
+
+	var (
+		fileServer *ghttp.Server
+		fakeClock  *fakeclock.FakeClock
+
+		lock      sync.Mutex
+		updates   []catalog.Index
+		updateErr error
+
+		process ifrit.Process
+	)
+
+	receivedUpdates := func() []catalog.Index {
+		lock.Lock()
+		defer lock.Unlock()
+		return updates
+	}
+
+	BeforeEach(func() {
+		fileServer = ghttp.NewServer()
+		fileServer.RouteToHandler("GET", "/v1/static/lifecycles/index.json", ghttp.RespondWith(http.StatusOK, indexJSON))
+
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		updates = nil
+		updateErr = nil
+	})
+
+	JustBeforeEach(func() {
+		runner := catalog.New(lagertest.NewTestLogger("test"), http.DefaultClient, fileServer.URL(), "lifecycles/index.json", fakeClock, interval, func(index catalog.Index) error {
+			lock.Lock()
+			defer lock.Unlock()
+			updates = append(updates, index)
+			return updateErr
+		})
+		process = ifrit.Invoke(runner)
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive(BeNil()))
+		fileServer.Close()
+	})
+
+	It("applies the index before becoming ready", func() {
+		Expect(receivedUpdates()).To(HaveLen(1))
+		Expect(receivedUpdates()[0].Lifecycles("amd64")).To(Equal(map[string]string{
+			"buildpack/cflinuxfs2": "buildpack_app_lifecycle/cflinuxfs2.tgz",
+			"docker":               "docker_app_lifecycle/docker.tgz",
+		}))
+	})
+
+	It("does not reapply an unchanged index", func() {
+		fakeClock.WaitForWatcherAndIncrement(interval)
+		Eventually(fileServer.ReceivedRequests).Should(HaveLen(2))
+
+		Consistently(receivedUpdates).Should(HaveLen(1))
+	})
+
+	It("applies a changed index", func() {
+		fileServer.RouteToHandler("GET", "/v1/static/lifecycles/index.json", ghttp.RespondWith(http.StatusOK, `{
+			"bundles": [
+				{"lifecycle": "buildpack", "stack": "cflinuxfs3", "arch": "amd64", "path": "buildpack_app_lifecycle/cflinuxfs3.tgz", "checksum": {"algorithm": "sha256", "value": "jkl"}}
+			]
+		}`))
+		fakeClock.WaitForWatcherAndIncrement(interval)
+
+		Eventually(receivedUpdates).Should(HaveLen(2))
+		Expect(receivedUpdates()[1].Lifecycles("amd64")).To(Equal(map[string]string{
+			"buildpack/cflinuxfs3": "buildpack_app_lifecycle/cflinuxfs3.tgz",
+		}))
+	})
+
+	Context("when the file server fails", func() {
+		BeforeEach(func() {
+			fileServer.RouteToHandler("GET", "/v1/static/lifecycles/index.json", ghttp.RespondWith(http.StatusInternalServerError, nil))
+		})
+
+		It("starts without applying an index", func() {
+			Expect(receivedUpdates()).To(BeEmpty())
+		})
+	})
+
+	Context("when the index is invalid", func() {
+		BeforeEach(func() {
+			fileServer.RouteToHandler("GET", "/v1/static/lifecycles/index.json", ghttp.RespondWith(http.StatusOK, `{"bundles": [{"lifecycle": "buildpack"}]}`))
+		})
+
+		It("does not apply it", func() {
+			Expect(receivedUpdates()).To(BeEmpty())
+		})
+	})
+
+	Context("when applying the index fails", func() {
+		BeforeEach(func() {
+			updateErr = errors.New("invalid config")
+		})
+
+		It("retries on the next refresh", func() {
+			fakeClock.WaitForWatcherAndIncrement(interval)
+			Eventually(receivedUpdates).Should(HaveLen(2))
+		})
+	})
+})
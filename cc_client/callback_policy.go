@@ -0,0 +1,130 @@
+package cc_client
+
+import (
+	"net"
+	"net/url"
+	"path"
+	"strings"
+
+	"code.cloudfoundry.org/stager/diego_errors"
+)
+
+var ErrInvalidCompletionCallback = diego_errors.ErrInvalidCompletionCallback
+var ErrCompletionCallbackNotAllowed = diego_errors.ErrCompletionCallbackDenied
+
+var defaultCallbackSchemes = []string{"http", "https"}
+
+// CallbackPolicy restricts where staging completion callbacks may be sent.
+// The host of BaseURI is always allowed, on any port. Callbacks to the
+// origin of BaseURI, or to one of TrustedOrigins, receive the CC basic-auth
+// credentials; those to allowed hosts do not.
+type CallbackPolicy struct {
+	BaseURI        string
+	AllowedHosts   []string
+	TrustedOrigins []string
+	AllowedSchemes []string
+}
+
+func (p CallbackPolicy) Validate(completionCallback string) error {
+	if completionCallback == "" {
+		return nil
+	}
+
+	u, err := url.ParseRequestURI(completionCallback)
+	if err != nil || u.Host == "" {
+		return ErrInvalidCompletionCallback
+	}
+
+	schemes := p.AllowedSchemes
+	if len(schemes) == 0 {
+		schemes = defaultCallbackSchemes
+	}
+	if !contains(schemes, u.Scheme) {
+		return ErrCompletionCallbackNotAllowed
+	}
+
+	if p.Trusted(u) || matchesHost(p.AllowedHosts, u.Host) {
+		return nil
+	}
+
+	if base, err := url.Parse(p.BaseURI); err == nil && base.Hostname() != "" && base.Hostname() == hostname(u.Host) {
+		return nil
+	}
+
+	return ErrCompletionCallbackNotAllowed
+}
+
+// Trusted reports whether u has the scheme, host and port of BaseURI or of
+// one of TrustedOrigins. Nothing is trusted over http if BaseURI is https.
+func (p CallbackPolicy) Trusted(u *url.URL) bool {
+	base, err := url.Parse(p.BaseURI)
+	if err != nil {
+		return false
+	}
+	if base.Scheme == "https" && u.Scheme != "https" {
+		return false
+	}
+
+	o := origin(u)
+	if base.Host != "" && origin(base) == o {
+		return true
+	}
+
+	for _, trusted := range p.TrustedOrigins {
+		t, err := url.Parse(trusted)
+		if err == nil && t.Host != "" && origin(t) == o {
+			return true
+		}
+	}
+
+	return false
+}
+
+func origin(u *url.URL) string {
+	scheme := strings.ToLower(u.Scheme)
+
+	port := u.Port()
+	if port == "" {
+		switch scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		}
+	}
+
+	return scheme + "://" + net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+func hostname(hostPort string) string {
+	host, _, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return hostPort
+	}
+	return host
+}
+
+func matchesHost(patterns []string, hostPort string) bool {
+	host := hostname(hostPort)
+
+	for _, pattern := range patterns {
+		for _, candidate := range []string{hostPort, host} {
+			matched, err := path.Match(pattern, candidate)
+			if err == nil && matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
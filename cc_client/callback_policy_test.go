@@ -0,0 +1,98 @@
+package cc_client_test
+
+import (
+	"net/url"
+
+	"code.cloudfoundry.org/stager/cc_client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CallbackPolicy", func() {
+	var policy cc_client.CallbackPolicy
+
+	BeforeEach(func() {
+		policy = cc_client.CallbackPolicy{
+			BaseURI:        "https://cc.internal:9023",
+			AllowedHosts:   []string{"*.apps.internal"},
+			TrustedOrigins: []string{"https://api.system.internal"},
+		}
+	})
+
+	Describe("Validate", func() {
+		It("allows an empty callback", func() {
+			Expect(policy.Validate("")).To(Succeed())
+		})
+
+		It("allows the CC host", func() {
+			Expect(policy.Validate("https://cc.internal:9023/internal/v3/staging/guid/build_completed")).To(Succeed())
+		})
+
+		It("allows the CC host on another port", func() {
+			Expect(policy.Validate("https://cc.internal:9024/internal/v3/staging/guid/build_completed")).To(Succeed())
+		})
+
+		It("allows trusted origins and allowed hosts regardless of port", func() {
+			Expect(policy.Validate("https://api.system.internal/staging")).To(Succeed())
+			Expect(policy.Validate("http://callbacks.apps.internal:8080/staging")).To(Succeed())
+		})
+
+		It("rejects other hosts", func() {
+			Expect(policy.Validate("https://evil.example.com/staging")).To(Equal(cc_client.ErrCompletionCallbackNotAllowed))
+		})
+
+		It("rejects disallowed schemes", func() {
+			Expect(policy.Validate("ftp://cc.internal:9023/staging")).To(Equal(cc_client.ErrCompletionCallbackNotAllowed))
+		})
+
+		It("rejects relative or unparseable callbacks", func() {
+			Expect(policy.Validate("/internal/staging")).To(Equal(cc_client.ErrInvalidCompletionCallback))
+			Expect(policy.Validate("::not a url")).To(Equal(cc_client.ErrInvalidCompletionCallback))
+		})
+
+		Context("when schemes are configured", func() {
+			BeforeEach(func() {
+				policy.AllowedSchemes = []string{"https"}
+			})
+
+			It("only allows those schemes", func() {
+				Expect(policy.Validate("http://cc.internal:9023/staging")).To(Equal(cc_client.ErrCompletionCallbackNotAllowed))
+				Expect(policy.Validate("https://cc.internal:9023/staging")).To(Succeed())
+			})
+		})
+	})
+
+	Describe("Trusted", func() {
+		trusted := func(rawURL string) bool {
+			u, err := url.Parse(rawURL)
+			Expect(err).NotTo(HaveOccurred())
+			return policy.Trusted(u)
+		}
+
+		It("trusts the CC origin and trusted origins only", func() {
+			Expect(trusted("https://cc.internal:9023/staging")).To(BeTrue())
+			Expect(trusted("https://api.system.internal/staging")).To(BeTrue())
+			Expect(trusted("https://api.system.internal:443/staging")).To(BeTrue())
+			Expect(trusted("https://callbacks.apps.internal/staging")).To(BeFalse())
+		})
+
+		It("does not trust other ports of the CC host", func() {
+			Expect(trusted("https://cc.internal:9024/staging")).To(BeFalse())
+		})
+
+		It("does not trust http when CC is reached over https", func() {
+			Expect(trusted("http://cc.internal:9023/staging")).To(BeFalse())
+			Expect(trusted("http://api.system.internal/staging")).To(BeFalse())
+		})
+
+		Context("when another port of the CC host is a trusted origin", func() {
+			BeforeEach(func() {
+				policy.TrustedOrigins = append(policy.TrustedOrigins, "https://cc.internal:9024")
+			})
+
+			It("trusts it", func() {
+				Expect(trusted("https://cc.internal:9024/staging")).To(BeTrue())
+			})
+		})
+	})
+})
@@ -13,18 +13,35 @@ import (
 
 const (
 	stagingCompleteRequestTimeout = 5 * time.Second
+
+	// RequestIdHeader carries the id that correlates a staging across the
+	// logs of CC, the stager and the BBS.
+	RequestIdHeader = "X-Vcap-Request-Id"
 )
 
 //go:generate counterfeiter -o fakes/fake_cc_client.go . CcClient
 type CcClient interface {
-	StagingComplete(stagingGuid string, completionCallback string, payload []byte, logger lager.Logger) error
+	// StagingComplete posts the staging response to CC, sending headers along
+	// with the request.
+	StagingComplete(stagingGuid string, completionCallback string, payload []byte, headers http.Header, logger lager.Logger) error
+}
+
+// RequestHeaders returns the headers that tie a staging response to the CC
+// request it answers.
+func RequestHeaders(requestId string) http.Header {
+	headers := http.Header{}
+	if requestId != "" {
+		headers.Set(RequestIdHeader, requestId)
+	}
+	return headers
 }
 
 type ccClient struct {
-	baseURI    string
-	username   string
-	password   string
-	httpClient *http.Client
+	baseURI        string
+	username       string
+	password       string
+	callbackPolicy CallbackPolicy
+	httpClient     *http.Client
 }
 
 type BadResponseError struct {
@@ -35,7 +52,7 @@ func (b *BadResponseError) Error() string {
 	return fmt.Sprintf("Staging response POST failed with %d", b.StatusCode)
 }
 
-func NewCcClient(baseURI string, username string, password string, skipCertVerify bool) CcClient {
+func NewCcClient(baseURI string, username string, password string, skipCertVerify bool, callbackPolicy CallbackPolicy) CcClient {
 	httpClient := &http.Client{
 		Timeout: stagingCompleteRequestTimeout,
 		Transport: &http.Transport{
@@ -52,24 +69,43 @@ func NewCcClient(baseURI string, username string, password string, skipCertVerif
 		},
 	}
 
+	callbackPolicy.BaseURI = baseURI
+
 	return &ccClient{
-		baseURI:    baseURI,
-		username:   username,
-		password:   password,
-		httpClient: httpClient,
+		baseURI:        baseURI,
+		username:       username,
+		password:       password,
+		callbackPolicy: callbackPolicy,
+		httpClient:     httpClient,
 	}
 }
 
-func (cc *ccClient) StagingComplete(stagingGuid string, completionCallback string, payload []byte, logger lager.Logger) error {
+func (cc *ccClient) StagingComplete(stagingGuid string, completionCallback string, payload []byte, headers http.Header, logger lager.Logger) error {
 	logger = logger.Session("cc-client")
 	logger.Info("delivering-staging-response", lager.Data{"payload": string(payload)})
 
+	err := cc.callbackPolicy.Validate(completionCallback)
+	if err != nil {
+		logger.Error("completion-callback-rejected", err, lager.Data{"completion-callback": completionCallback})
+		return err
+	}
+
 	request, err := http.NewRequest("POST", cc.stagingCompleteURI(stagingGuid, completionCallback), bytes.NewReader(payload))
 	if err != nil {
 		return err
 	}
 
-	request.SetBasicAuth(cc.username, cc.password)
+	for key, values := range headers {
+		for _, value := range values {
+			request.Header.Add(key, value)
+		}
+	}
+
+	if cc.callbackPolicy.Trusted(request.URL) {
+		request.SetBasicAuth(cc.username, cc.password)
+	} else {
+		logger.Info("omitting-credentials-for-untrusted-host", lager.Data{"host": request.URL.Host})
+	}
 	request.Header.Set("content-type", "application/json")
 
 	response, err := cc.httpClient.Do(request)
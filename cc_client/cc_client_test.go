@@ -31,7 +31,7 @@ var _ = Describe("CC Client", func() {
 		logger = lager.NewLogger("fakelogger")
 		logger.RegisterSink(lager.NewWriterSink(GinkgoWriter, lager.DEBUG))
 
-		ccClient = cc_client.NewCcClient(fakeCC.URL(), "username", "password", true)
+		ccClient = cc_client.NewCcClient(fakeCC.URL(), "username", "password", true, cc_client.CallbackPolicy{})
 
 		stagingGuid = "the-staging-guid"
 		completionCallback = ""
@@ -53,7 +53,7 @@ var _ = Describe("CC Client", func() {
 				),
 			)
 
-			err := ccClient.StagingComplete(stagingGuid, completionCallback, []byte(`{}`), logger)
+			err := ccClient.StagingComplete(stagingGuid, completionCallback, []byte(`{}`), nil, logger)
 			Expect(err).NotTo(HaveOccurred())
 		})
 
@@ -78,10 +78,117 @@ var _ = Describe("CC Client", func() {
 			})
 
 			It("sends the request payload to the CC without modification", func() {
-				err := ccClient.StagingComplete(stagingGuid, completionCallback, expectedBody, logger)
+				err := ccClient.StagingComplete(stagingGuid, completionCallback, expectedBody, nil, logger)
 				Expect(err).NotTo(HaveOccurred())
 			})
 		})
+
+		Context("when headers are given", func() {
+			BeforeEach(func() {
+				fakeCC.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", fmt.Sprintf("/internal/staging/%s/completed", stagingGuid)),
+						ghttp.VerifyHeaderKV("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"),
+						ghttp.VerifyContentType("application/json"),
+						ghttp.RespondWith(200, `{}`),
+					),
+				)
+			})
+
+			It("sends them along with the request", func() {
+				headers := http.Header{}
+				headers.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+				err := ccClient.StagingComplete(stagingGuid, completionCallback, []byte(`{}`), headers, logger)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeCC.ReceivedRequests()).To(HaveLen(1))
+			})
+		})
+	})
+
+	Describe("RequestHeaders", func() {
+		It("carries the request id", func() {
+			headers := cc_client.RequestHeaders("the-request-id")
+			Expect(headers.Get("X-Vcap-Request-Id")).To(Equal("the-request-id"))
+		})
+
+		It("is empty without a request id", func() {
+			Expect(cc_client.RequestHeaders("")).To(BeEmpty())
+		})
+	})
+
+	Describe("Completion callback validation", func() {
+		var otherServer *ghttp.Server
+
+		BeforeEach(func() {
+			otherServer = ghttp.NewServer()
+		})
+
+		AfterEach(func() {
+			otherServer.Close()
+		})
+
+		Context("when the callback host is not allowed", func() {
+			It("refuses to deliver the response", func() {
+				completionCallback = fmt.Sprintf("%s/steal/credentials", otherServer.URL())
+
+				err := ccClient.StagingComplete(stagingGuid, completionCallback, []byte(`{}`), nil, logger)
+				Expect(err).To(Equal(cc_client.ErrCompletionCallbackNotAllowed))
+				Expect(otherServer.ReceivedRequests()).To(BeEmpty())
+			})
+		})
+
+		Context("when the callback host is allowed but not trusted", func() {
+			BeforeEach(func() {
+				otherHost, err := url.Parse(otherServer.URL())
+				Expect(err).NotTo(HaveOccurred())
+
+				ccClient = cc_client.NewCcClient(fakeCC.URL(), "username", "password", true, cc_client.CallbackPolicy{
+					AllowedHosts: []string{otherHost.Host},
+				})
+
+				otherServer.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/allowed/staging_complete"),
+						func(w http.ResponseWriter, req *http.Request) {
+							_, _, ok := req.BasicAuth()
+							Expect(ok).To(BeFalse())
+						},
+					),
+				)
+			})
+
+			It("delivers the response without credentials", func() {
+				completionCallback = fmt.Sprintf("%s/allowed/staging_complete", otherServer.URL())
+
+				err := ccClient.StagingComplete(stagingGuid, completionCallback, []byte(`{}`), nil, logger)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(otherServer.ReceivedRequests()).To(HaveLen(1))
+			})
+		})
+
+		Context("when the callback host is trusted", func() {
+			BeforeEach(func() {
+				ccClient = cc_client.NewCcClient(fakeCC.URL(), "username", "password", true, cc_client.CallbackPolicy{
+					TrustedOrigins: []string{otherServer.URL()},
+				})
+
+				otherServer.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/trusted/staging_complete"),
+						ghttp.VerifyBasicAuth("username", "password"),
+					),
+				)
+			})
+
+			It("delivers the response with credentials", func() {
+				completionCallback = fmt.Sprintf("%s/trusted/staging_complete", otherServer.URL())
+
+				err := ccClient.StagingComplete(stagingGuid, completionCallback, []byte(`{}`), nil, logger)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(otherServer.ReceivedRequests()).To(HaveLen(1))
+			})
+		})
 	})
 
 	Describe("TLS certificate validation", func() {
@@ -101,22 +208,22 @@ var _ = Describe("CC Client", func() {
 
 		Context("when certificate verfication is enabled", func() {
 			BeforeEach(func() {
-				ccClient = cc_client.NewCcClient(fakeCC.URL(), "username", "password", false)
+				ccClient = cc_client.NewCcClient(fakeCC.URL(), "username", "password", false, cc_client.CallbackPolicy{})
 			})
 
 			It("fails with a self-signed certificate", func() {
-				err := ccClient.StagingComplete(stagingGuid, completionCallback, []byte(`{}`), logger)
+				err := ccClient.StagingComplete(stagingGuid, completionCallback, []byte(`{}`), nil, logger)
 				Expect(err).To(HaveOccurred())
 			})
 		})
 
 		Context("when certificate verfication is disabled", func() {
 			BeforeEach(func() {
-				ccClient = cc_client.NewCcClient(fakeCC.URL(), "username", "password", true)
+				ccClient = cc_client.NewCcClient(fakeCC.URL(), "username", "password", true, cc_client.CallbackPolicy{})
 			})
 
 			It("Attempts to validate SSL certificates", func() {
-				err := ccClient.StagingComplete(stagingGuid, completionCallback, []byte(`{}`), logger)
+				err := ccClient.StagingComplete(stagingGuid, completionCallback, []byte(`{}`), nil, logger)
 				Expect(err).NotTo(HaveOccurred())
 			})
 		})
@@ -126,11 +233,11 @@ var _ = Describe("CC Client", func() {
 		Context("when the request couldn't be completed", func() {
 			BeforeEach(func() {
 				bogusURL := "http://0.0.0.0.0:80"
-				ccClient = cc_client.NewCcClient(bogusURL, "username", "password", true)
+				ccClient = cc_client.NewCcClient(bogusURL, "username", "password", true, cc_client.CallbackPolicy{})
 			})
 
 			It("percolates the error", func() {
-				err := ccClient.StagingComplete(stagingGuid, completionCallback, []byte(`{}`), logger)
+				err := ccClient.StagingComplete(stagingGuid, completionCallback, []byte(`{}`), nil, logger)
 				Expect(err).To(HaveOccurred())
 				Expect(err).To(BeAssignableToTypeOf(&url.Error{}))
 			})
@@ -147,7 +254,7 @@ var _ = Describe("CC Client", func() {
 			})
 
 			It("returns an error with the actual status code", func() {
-				err := ccClient.StagingComplete(stagingGuid, completionCallback, []byte(`{}`), logger)
+				err := ccClient.StagingComplete(stagingGuid, completionCallback, []byte(`{}`), nil, logger)
 				Expect(err).To(HaveOccurred())
 				Expect(err).To(BeAssignableToTypeOf(&cc_client.BadResponseError{}))
 				Expect(err.(*cc_client.BadResponseError).StatusCode).To(Equal(500))
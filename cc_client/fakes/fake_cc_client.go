@@ -2,6 +2,7 @@
 package fakes
 
 import (
+	"net/http"
 	"sync"
 
 	"code.cloudfoundry.org/lager"
@@ -9,12 +10,13 @@ import (
 )
 
 type FakeCcClient struct {
-	StagingCompleteStub        func(stagingGuid string, completionCallback string, payload []byte, logger lager.Logger) error
+	StagingCompleteStub        func(stagingGuid string, completionCallback string, payload []byte, headers http.Header, logger lager.Logger) error
 	stagingCompleteMutex       sync.RWMutex
 	stagingCompleteArgsForCall []struct {
 		stagingGuid        string
 		completionCallback string
 		payload            []byte
+		headers            http.Header
 		logger             lager.Logger
 	}
 	stagingCompleteReturns struct {
@@ -22,17 +24,18 @@ type FakeCcClient struct {
 	}
 }
 
-func (fake *FakeCcClient) StagingComplete(stagingGuid string, completionCallback string, payload []byte, logger lager.Logger) error {
+func (fake *FakeCcClient) StagingComplete(stagingGuid string, completionCallback string, payload []byte, headers http.Header, logger lager.Logger) error {
 	fake.stagingCompleteMutex.Lock()
 	fake.stagingCompleteArgsForCall = append(fake.stagingCompleteArgsForCall, struct {
 		stagingGuid        string
 		completionCallback string
 		payload            []byte
+		headers            http.Header
 		logger             lager.Logger
-	}{stagingGuid, completionCallback, payload, logger})
+	}{stagingGuid, completionCallback, payload, headers, logger})
 	fake.stagingCompleteMutex.Unlock()
 	if fake.StagingCompleteStub != nil {
-		return fake.StagingCompleteStub(stagingGuid, completionCallback, payload, logger)
+		return fake.StagingCompleteStub(stagingGuid, completionCallback, payload, headers, logger)
 	} else {
 		return fake.stagingCompleteReturns.result1
 	}
@@ -57,4 +60,10 @@ func (fake *FakeCcClient) StagingCompleteReturns(result1 error) {
 	}{result1}
 }
 
+func (fake *FakeCcClient) StagingCompleteHeadersForCall(i int) http.Header {
+	fake.stagingCompleteMutex.RLock()
+	defer fake.stagingCompleteMutex.RUnlock()
+	return fake.stagingCompleteArgsForCall[i].headers
+}
+
 var _ cc_client.CcClient = new(FakeCcClient)
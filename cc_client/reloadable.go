@@ -0,0 +1,34 @@
+package cc_client
+
+import (
+	"net/http"
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// ReloadableCcClient delivers staging responses with a CcClient that can be
+// replaced at runtime, e.g. to rotate credentials. Deliveries in flight
+// when the client is replaced finish with the client they started with.
+type ReloadableCcClient struct {
+	lock   sync.RWMutex
+	client CcClient
+}
+
+func NewReloadableCcClient(client CcClient) *ReloadableCcClient {
+	return &ReloadableCcClient{client: client}
+}
+
+func (c *ReloadableCcClient) Reload(client CcClient) {
+	c.lock.Lock()
+	c.client = client
+	c.lock.Unlock()
+}
+
+func (c *ReloadableCcClient) StagingComplete(stagingGuid string, completionCallback string, payload []byte, headers http.Header, logger lager.Logger) error {
+	c.lock.RLock()
+	client := c.client
+	c.lock.RUnlock()
+
+	return client.StagingComplete(stagingGuid, completionCallback, payload, headers, logger)
+}
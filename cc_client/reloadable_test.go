@@ -0,0 +1,67 @@
+package cc_client_test
+
+import (
+	"net/http"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/cc_client"
+	"code.cloudfoundry.org/stager/cc_client/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("ReloadableCcClient", func() {
+	var (
+		oldClient *fakes.FakeCcClient
+		newClient *fakes.FakeCcClient
+		ccClient  *cc_client.ReloadableCcClient
+		logger    *lagertest.TestLogger
+	)
+
+	BeforeEach(func() {
+		oldClient = &fakes.FakeCcClient{}
+		newClient = &fakes.FakeCcClient{}
+		ccClient = cc_client.NewReloadableCcClient(oldClient)
+		logger = lagertest.NewTestLogger("test")
+	})
+
+	It("delivers staging responses with the current client", func() {
+		headers := http.Header{cc_client.RequestIdHeader: {"the-request-id"}}
+
+		err := ccClient.StagingComplete("the-staging-guid", "https://cc.example.com/callback", []byte(`{}`), headers, logger)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(oldClient.StagingCompleteCallCount()).To(Equal(1))
+		guid, payload, _ := oldClient.StagingCompleteArgsForCall(0)
+		Expect(guid).To(Equal("the-staging-guid"))
+		Expect(payload).To(Equal([]byte(`{}`)))
+		Expect(oldClient.StagingCompleteHeadersForCall(0)).To(Equal(headers))
+	})
+
+	It("delivers with the new client once reloaded", func() {
+		ccClient.Reload(newClient)
+
+		err := ccClient.StagingComplete("the-staging-guid", "", []byte(`{}`), nil, logger)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(oldClient.StagingCompleteCallCount()).To(Equal(0))
+		Expect(newClient.StagingCompleteCallCount()).To(Equal(1))
+	})
+
+	It("sends the rotated credentials", func() {
+		fakeCC := ghttp.NewServer()
+		defer fakeCC.Close()
+
+		fakeCC.AppendHandlers(ghttp.CombineHandlers(
+			ghttp.VerifyRequest("POST", "/internal/staging/the-staging-guid/completed"),
+			ghttp.VerifyBasicAuth("new-username", "new-password"),
+		))
+
+		ccClient.Reload(cc_client.NewCcClient(fakeCC.URL(), "new-username", "new-password", true, cc_client.CallbackPolicy{}))
+
+		err := ccClient.StagingComplete("the-staging-guid", "", []byte(`{}`), nil, logger)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fakeCC.ReceivedRequests()).To(HaveLen(1))
+	})
+})
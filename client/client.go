@@ -0,0 +1,201 @@
+package client
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager"
+	"code.cloudfoundry.org/stager/cc_client"
+	"github.com/tedsuo/rata"
+)
+
+const DefaultTimeout = 30 * time.Second
+
+//go:generate counterfeiter -o fakes/fake_client.go . Client
+type Client interface {
+	// Stage asks the stager to stage an app. The staging result is posted to
+	// the request's completion callback, not returned.
+	Stage(logger lager.Logger, stagingGuid string, request cc_messages.StagingRequestFromCC) error
+	StopStaging(logger lager.Logger, stagingGuid string) error
+	// Capabilities returns the lifecycles, stacks and features the stager
+	// supports, so unsupported requests can be rejected before staging.
+	Capabilities(logger lager.Logger) (stager.Capabilities, error)
+}
+
+// Error is returned when the stager does not accept a request. StagingError
+// holds the error the stager reported, if the response had one.
+type Error struct {
+	StatusCode   int
+	StagingError *cc_messages.StagingError
+}
+
+func (e *Error) Error() string {
+	if e.StagingError == nil {
+		return fmt.Sprintf("stager responded with %d", e.StatusCode)
+	}
+	return fmt.Sprintf("stager responded with %d: %s: %s", e.StatusCode, e.StagingError.Id, e.StagingError.Message)
+}
+
+// IsNotFound reports whether the stager did not know the staging, or the
+// lifecycle a staging was requested for.
+func IsNotFound(err error) bool {
+	clientErr, ok := err.(*Error)
+	return ok && clientErr.StatusCode == http.StatusNotFound
+}
+
+// TLSConfig configures a client for a stager served over TLS. The
+// certificate and key are only needed if the stager requires client
+// certificates.
+type TLSConfig struct {
+	CACertFile     string
+	CertFile       string
+	KeyFile        string
+	SkipCertVerify bool
+}
+
+type client struct {
+	requestGenerator *rata.RequestGenerator
+	httpClient       *http.Client
+}
+
+func NewClient(stagerURL string) Client {
+	return newClient(stagerURL, &http.Client{Timeout: DefaultTimeout})
+}
+
+func NewSecureClient(stagerURL string, config TLSConfig) (Client, error) {
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return newClient(stagerURL, &http.Client{
+		Timeout:   DefaultTimeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}), nil
+}
+
+func newClient(stagerURL string, httpClient *http.Client) Client {
+	return &client{
+		requestGenerator: rata.NewRequestGenerator(stagerURL, stager.Routes),
+		httpClient:       httpClient,
+	}
+}
+
+func (c TLSConfig) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.SkipCertVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if c.CACertFile != "" {
+		caCert, err := ioutil.ReadFile(c.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+
+		caPool := x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("unable to load CA certificate")
+		}
+		tlsConfig.RootCAs = caPool
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+func (c *client) Stage(logger lager.Logger, stagingGuid string, request cc_messages.StagingRequestFromCC) error {
+	logger = logger.Session("stage", lager.Data{"staging-guid": stagingGuid})
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	return c.do(logger, stager.StageRoute, stagingGuid, body)
+}
+
+func (c *client) StopStaging(logger lager.Logger, stagingGuid string) error {
+	logger = logger.Session("stop-staging", lager.Data{"staging-guid": stagingGuid})
+
+	return c.do(logger, stager.StopStagingRoute, stagingGuid, nil)
+}
+
+func (c *client) Capabilities(logger lager.Logger) (stager.Capabilities, error) {
+	logger = logger.Session("capabilities")
+
+	var capabilities stager.Capabilities
+
+	request, err := c.requestGenerator.CreateRequest(stager.CapabilitiesRoute, nil, nil)
+	if err != nil {
+		return capabilities, err
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		logger.Error("request-failed", err)
+		return capabilities, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		clientErr := &Error{StatusCode: response.StatusCode}
+		logger.Error("request-rejected", clientErr)
+		return capabilities, clientErr
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&capabilities)
+	if err != nil {
+		logger.Error("decode-failed", err)
+		return capabilities, err
+	}
+
+	return capabilities, nil
+}
+
+func (c *client) do(logger lager.Logger, route, stagingGuid string, body []byte) error {
+	request, err := c.requestGenerator.CreateRequest(route, rata.Params{"staging_guid": stagingGuid}, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		logger.Error("request-failed", err)
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusAccepted {
+		return nil
+	}
+
+	clientErr := &Error{StatusCode: response.StatusCode}
+
+	var stagingResponse cc_messages.StagingResponseForCC
+	if json.NewDecoder(response.Body).Decode(&stagingResponse) == nil {
+		clientErr.StagingError = stagingResponse.Error
+	}
+
+	logger.Error("request-rejected", clientErr, lager.Data{"request-id": response.Header.Get(cc_client.RequestIdHeader)})
+	return clientErr
+}
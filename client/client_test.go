@@ -0,0 +1,185 @@
+package client_test
+
+import (
+	"net/http"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager"
+	"code.cloudfoundry.org/stager/client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		fakeStager   *ghttp.Server
+		logger       *lagertest.TestLogger
+		stagerClient client.Client
+	)
+
+	BeforeEach(func() {
+		fakeStager = ghttp.NewServer()
+		logger = lagertest.NewTestLogger("test")
+		stagerClient = client.NewClient(fakeStager.URL())
+	})
+
+	AfterEach(func() {
+		if fakeStager.HTTPTestServer != nil {
+			fakeStager.Close()
+		}
+	})
+
+	Describe("Stage", func() {
+		var (
+			request  cc_messages.StagingRequestFromCC
+			stageErr error
+		)
+
+		BeforeEach(func() {
+			request = cc_messages.StagingRequestFromCC{
+				AppId:     "the-app-id",
+				Lifecycle: "buildpack",
+			}
+		})
+
+		JustBeforeEach(func() {
+			stageErr = stagerClient.Stage(logger, "the-staging-guid", request)
+		})
+
+		Context("when the stager accepts the request", func() {
+			BeforeEach(func() {
+				fakeStager.AppendHandlers(ghttp.CombineHandlers(
+					ghttp.VerifyRequest("PUT", "/v1/staging/the-staging-guid"),
+					ghttp.VerifyContentType("application/json"),
+					ghttp.VerifyJSONRepresenting(request),
+					ghttp.RespondWith(http.StatusAccepted, nil),
+				))
+			})
+
+			It("succeeds", func() {
+				Expect(stageErr).NotTo(HaveOccurred())
+				Expect(fakeStager.ReceivedRequests()).To(HaveLen(1))
+			})
+		})
+
+		Context("when the stager rejects the request", func() {
+			BeforeEach(func() {
+				fakeStager.AppendHandlers(ghttp.RespondWith(
+					http.StatusInternalServerError,
+					`{"error":{"id":"StagingError","message":"missing app id"}}`,
+				))
+			})
+
+			It("returns the staging error", func() {
+				Expect(stageErr).To(Equal(&client.Error{
+					StatusCode: http.StatusInternalServerError,
+					StagingError: &cc_messages.StagingError{
+						Id:      cc_messages.STAGING_ERROR,
+						Message: "missing app id",
+					},
+				}))
+				Expect(stageErr).To(MatchError("stager responded with 500: StagingError: missing app id"))
+			})
+		})
+
+		Context("when the stager has no backend for the lifecycle", func() {
+			BeforeEach(func() {
+				fakeStager.AppendHandlers(ghttp.RespondWith(http.StatusNotFound, nil))
+			})
+
+			It("returns a not found error", func() {
+				Expect(client.IsNotFound(stageErr)).To(BeTrue())
+				Expect(stageErr).To(MatchError("stager responded with 404"))
+			})
+		})
+
+		Context("when the stager is unreachable", func() {
+			BeforeEach(func() {
+				fakeStager.Close()
+			})
+
+			It("returns the error", func() {
+				Expect(stageErr).To(HaveOccurred())
+				Expect(client.IsNotFound(stageErr)).To(BeFalse())
+			})
+		})
+	})
+
+	Describe("StopStaging", func() {
+		It("deletes the staging", func() {
+			fakeStager.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("DELETE", "/v1/staging/the-staging-guid"),
+				ghttp.RespondWith(http.StatusAccepted, nil),
+			))
+
+			Expect(stagerClient.StopStaging(logger, "the-staging-guid")).To(Succeed())
+		})
+
+		It("returns a not found error for unknown stagings", func() {
+			fakeStager.AppendHandlers(ghttp.RespondWith(http.StatusNotFound, nil))
+
+			err := stagerClient.StopStaging(logger, "the-staging-guid")
+			Expect(client.IsNotFound(err)).To(BeTrue())
+		})
+	})
+
+	Describe("Capabilities", func() {
+		It("returns the stager's capabilities", func() {
+			capabilities := stager.Capabilities{
+				Lifecycles:         map[string]map[string]string{"buildpack": {"cflinuxfs2": "buildpack_app_lifecycle.tgz"}},
+				DockerStagingStack: "cflinuxfs2",
+				Features:           []string{stager.FeatureRequestIds},
+			}
+
+			fakeStager.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/v1/capabilities"),
+				ghttp.RespondWithJSONEncoded(http.StatusOK, capabilities),
+			))
+
+			Expect(stagerClient.Capabilities(logger)).To(Equal(capabilities))
+		})
+
+		It("returns an error when the stager does not respond with its capabilities", func() {
+			fakeStager.AppendHandlers(ghttp.RespondWith(http.StatusNotFound, nil))
+
+			_, err := stagerClient.Capabilities(logger)
+			Expect(client.IsNotFound(err)).To(BeTrue())
+		})
+	})
+
+	Describe("NewSecureClient", func() {
+		var tlsStager *ghttp.Server
+
+		BeforeEach(func() {
+			tlsStager = ghttp.NewTLSServer()
+			tlsStager.AppendHandlers(ghttp.RespondWith(http.StatusAccepted, nil))
+		})
+
+		AfterEach(func() {
+			tlsStager.Close()
+		})
+
+		It("verifies the stager's certificate", func() {
+			secureClient, err := client.NewSecureClient(tlsStager.URL(), client.TLSConfig{})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = secureClient.StopStaging(logger, "the-staging-guid")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("certificate"))
+		})
+
+		It("can skip certificate verification", func() {
+			secureClient, err := client.NewSecureClient(tlsStager.URL(), client.TLSConfig{SkipCertVerify: true})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(secureClient.StopStaging(logger, "the-staging-guid")).To(Succeed())
+		})
+
+		It("fails for unreadable certificate files", func() {
+			_, err := client.NewSecureClient(tlsStager.URL(), client.TLSConfig{CACertFile: "/does/not/exist"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
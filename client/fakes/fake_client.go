@@ -0,0 +1,144 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager"
+	"code.cloudfoundry.org/stager/client"
+)
+
+type FakeClient struct {
+	StageStub        func(logger lager.Logger, stagingGuid string, request cc_messages.StagingRequestFromCC) error
+	stageMutex       sync.RWMutex
+	stageArgsForCall []struct {
+		logger      lager.Logger
+		stagingGuid string
+		request     cc_messages.StagingRequestFromCC
+	}
+	stageReturns struct {
+		result1 error
+	}
+	StopStagingStub        func(logger lager.Logger, stagingGuid string) error
+	stopStagingMutex       sync.RWMutex
+	stopStagingArgsForCall []struct {
+		logger      lager.Logger
+		stagingGuid string
+	}
+	stopStagingReturns struct {
+		result1 error
+	}
+	CapabilitiesStub        func(logger lager.Logger) (stager.Capabilities, error)
+	capabilitiesMutex       sync.RWMutex
+	capabilitiesArgsForCall []struct {
+		logger lager.Logger
+	}
+	capabilitiesReturns struct {
+		result1 stager.Capabilities
+		result2 error
+	}
+}
+
+func (fake *FakeClient) Stage(logger lager.Logger, stagingGuid string, request cc_messages.StagingRequestFromCC) error {
+	fake.stageMutex.Lock()
+	fake.stageArgsForCall = append(fake.stageArgsForCall, struct {
+		logger      lager.Logger
+		stagingGuid string
+		request     cc_messages.StagingRequestFromCC
+	}{logger, stagingGuid, request})
+	fake.stageMutex.Unlock()
+	if fake.StageStub != nil {
+		return fake.StageStub(logger, stagingGuid, request)
+	} else {
+		return fake.stageReturns.result1
+	}
+}
+
+func (fake *FakeClient) StageCallCount() int {
+	fake.stageMutex.RLock()
+	defer fake.stageMutex.RUnlock()
+	return len(fake.stageArgsForCall)
+}
+
+func (fake *FakeClient) StageArgsForCall(i int) (lager.Logger, string, cc_messages.StagingRequestFromCC) {
+	fake.stageMutex.RLock()
+	defer fake.stageMutex.RUnlock()
+	return fake.stageArgsForCall[i].logger, fake.stageArgsForCall[i].stagingGuid, fake.stageArgsForCall[i].request
+}
+
+func (fake *FakeClient) StageReturns(result1 error) {
+	fake.StageStub = nil
+	fake.stageReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeClient) StopStaging(logger lager.Logger, stagingGuid string) error {
+	fake.stopStagingMutex.Lock()
+	fake.stopStagingArgsForCall = append(fake.stopStagingArgsForCall, struct {
+		logger      lager.Logger
+		stagingGuid string
+	}{logger, stagingGuid})
+	fake.stopStagingMutex.Unlock()
+	if fake.StopStagingStub != nil {
+		return fake.StopStagingStub(logger, stagingGuid)
+	} else {
+		return fake.stopStagingReturns.result1
+	}
+}
+
+func (fake *FakeClient) StopStagingCallCount() int {
+	fake.stopStagingMutex.RLock()
+	defer fake.stopStagingMutex.RUnlock()
+	return len(fake.stopStagingArgsForCall)
+}
+
+func (fake *FakeClient) StopStagingArgsForCall(i int) (lager.Logger, string) {
+	fake.stopStagingMutex.RLock()
+	defer fake.stopStagingMutex.RUnlock()
+	return fake.stopStagingArgsForCall[i].logger, fake.stopStagingArgsForCall[i].stagingGuid
+}
+
+func (fake *FakeClient) StopStagingReturns(result1 error) {
+	fake.StopStagingStub = nil
+	fake.stopStagingReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeClient) Capabilities(logger lager.Logger) (stager.Capabilities, error) {
+	fake.capabilitiesMutex.Lock()
+	fake.capabilitiesArgsForCall = append(fake.capabilitiesArgsForCall, struct {
+		logger lager.Logger
+	}{logger})
+	fake.capabilitiesMutex.Unlock()
+	if fake.CapabilitiesStub != nil {
+		return fake.CapabilitiesStub(logger)
+	} else {
+		return fake.capabilitiesReturns.result1, fake.capabilitiesReturns.result2
+	}
+}
+
+func (fake *FakeClient) CapabilitiesCallCount() int {
+	fake.capabilitiesMutex.RLock()
+	defer fake.capabilitiesMutex.RUnlock()
+	return len(fake.capabilitiesArgsForCall)
+}
+
+func (fake *FakeClient) CapabilitiesArgsForCall(i int) lager.Logger {
+	fake.capabilitiesMutex.RLock()
+	defer fake.capabilitiesMutex.RUnlock()
+	return fake.capabilitiesArgsForCall[i].logger
+}
+
+func (fake *FakeClient) CapabilitiesReturns(result1 stager.Capabilities, result2 error) {
+	fake.CapabilitiesStub = nil
+	fake.capabilitiesReturns = struct {
+		result1 stager.Capabilities
+		result2 error
+	}{result1, result2}
+}
+
+var _ client.Client = new(FakeClient)
@@ -1,12 +1,20 @@
 package main
 
 import (
+	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/cloudfoundry/dropsonde"
 	"github.com/hashicorp/consul/api"
@@ -24,9 +32,20 @@ import (
 	"code.cloudfoundry.org/locket"
 	"code.cloudfoundry.org/runtimeschema/cc_messages"
 	"code.cloudfoundry.org/runtimeschema/cc_messages/flags"
+	"code.cloudfoundry.org/stager"
+	"code.cloudfoundry.org/stager/admission"
 	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/canary"
+	"code.cloudfoundry.org/stager/catalog"
 	"code.cloudfoundry.org/stager/cc_client"
+	"code.cloudfoundry.org/stager/diego_errors"
 	"code.cloudfoundry.org/stager/handlers"
+	"code.cloudfoundry.org/stager/reconciler"
+	"code.cloudfoundry.org/stager/reload"
+	"code.cloudfoundry.org/stager/resultcache"
+	"code.cloudfoundry.org/stager/scheduler"
+	"code.cloudfoundry.org/stager/sweeper"
+	"code.cloudfoundry.org/stager/tracing"
 	"code.cloudfoundry.org/stager/vars"
 )
 
@@ -144,7 +163,254 @@ var bbsMaxIdleConnsPerHost = flag.Int(
 	"Controls the maximum number of idle (keep-alive) connctions per host. If zero, golang's default will be used",
 )
 
+var requireDockerImageDigest = flag.Bool(
+	"requireDockerImageDigest",
+	false,
+	"Only stage docker images that are referenced by digest",
+)
+
+var maxDockerImageAge = flag.Duration(
+	"maxDockerImageAge",
+	0,
+	"Reject docker images older than this age when the registry exposes image metadata. If zero, image age is not checked",
+)
+
+var stagingAdmissionURL = flag.String(
+	"stagingAdmissionURL",
+	"",
+	"URL of an admission webhook that reviews staging requests before they are built. If empty, no admission review is performed",
+)
+
+var stagingAdmissionTimeout = flag.Duration(
+	"stagingAdmissionTimeout",
+	admission.DefaultTimeout,
+	"Timeout for calls to the staging admission webhook",
+)
+
+var stagingAdmissionFailOpen = flag.Bool(
+	"stagingAdmissionFailOpen",
+	false,
+	"Admit staging requests when the staging admission webhook cannot be reached",
+)
+
+var stagingResourcesFile = flag.String(
+	"stagingResourcesFile",
+	"",
+	"Path to a JSON file of staging resource defaults, minimums and maximums keyed by lifecycle[/stack]",
+)
+
+var stagingTimeoutsFile = flag.String(
+	"stagingTimeoutsFile",
+	"",
+	"Path to a JSON file of default, maximum and upload staging timeouts (e.g. \"15m\") keyed by lifecycle[/stack]",
+)
+
+var reconcileInterval = flag.Duration(
+	"reconcileInterval",
+	0,
+	"Interval at which completed staging tasks whose callback was missed are delivered to CC. If zero, missed callbacks are not reconciled",
+)
+
+var reconcileGracePeriod = flag.Duration(
+	"reconcileGracePeriod",
+	reconciler.DefaultGracePeriod,
+	"How long a staging task must have been completed before its callback is considered missed",
+)
+
+var orphanSweepInterval = flag.Duration(
+	"orphanSweepInterval",
+	0,
+	"Interval at which orphaned staging tasks are cancelled or deleted. If zero, orphaned staging tasks are not swept",
+)
+
+var orphanTimeoutMargin = flag.Duration(
+	"orphanTimeoutMargin",
+	sweeper.DefaultTimeoutMargin,
+	"How long past its recipe timeout a pending or running staging task is considered orphaned",
+)
+
+var orphanCompletedExpiry = flag.Duration(
+	"orphanCompletedExpiry",
+	sweeper.DefaultCompletedExpiry,
+	"How long a completed staging task may wait for its callback to be delivered before it is considered orphaned",
+)
+
+var stagingMaxAttempts = flag.Int(
+	"stagingMaxAttempts",
+	1,
+	"Maximum number of attempts for staging tasks that fail for infrastructure reasons (insufficient resources, no compatible cell, cell communication errors)",
+)
+
+var stagingRetryBackoff = flag.Duration(
+	"stagingRetryBackoff",
+	handlers.DefaultRetryBackoff,
+	"Delay before the first retry of a staging task; doubled for each further attempt",
+)
+
+var stagingMaxRetryBackoff = flag.Duration(
+	"stagingMaxRetryBackoff",
+	0,
+	"Upper bound for the delay between staging attempts. If zero, the delay is unbounded",
+)
+
+var supersedeStagings = flag.Bool(
+	"supersedeStagings",
+	false,
+	"Cancel in-flight staging tasks of an app when a newer staging request for the same app is received",
+)
+
+var supersedeResyncInterval = flag.Duration(
+	"supersedeResyncInterval",
+	handlers.DefaultSupersedeResyncInterval,
+	"Minimum interval between scans of the staging task domain for staging tasks desired by other stager instances that newer stagings may supersede",
+)
+
+var stagingResultCacheDir = flag.String(
+	"stagingResultCacheDir",
+	"",
+	"Directory in which to index the results of successful stagings for reuse by identical staging requests (disabled if empty)",
+)
+
+var stagingResultCacheDropletURL = flag.String(
+	"stagingResultCacheDropletURL",
+	"",
+	"URL from which the droplet of a previous staging can be downloaded, with ':staging_guid' standing in for its staging guid (required to enable the staging result cache)",
+)
+
+var stagingResultCacheTTL = flag.Duration(
+	"stagingResultCacheTTL",
+	resultcache.DefaultTTL,
+	"Time after which the result of a successful staging is no longer reused, since CC may have deleted its droplet",
+)
+
+var stagingConcurrency = flag.Int(
+	"stagingConcurrency",
+	0,
+	"Maximum number of staging tasks this stager instance keeps desired at once; further requests are queued and released fairly across tenants. The budget is not shared between stager instances. If zero, staging tasks are desired immediately",
+)
+
+var stagingSchedulerStateFile = flag.String(
+	"stagingSchedulerStateFile",
+	"",
+	"File in which the staging scheduler's state is persisted across restarts, with the queued staging tasks in a '.queue' directory next to it (required when stagingConcurrency is set)",
+)
+
+var stagingTenantPolicyFile = flag.String(
+	"stagingTenantPolicyFile",
+	"",
+	"Path to a JSON file configuring how staging requests are mapped to tenants (env_var, app_id_prefix_length) and the tenants' weights",
+)
+
+var stagingSchedulerReconcileInterval = flag.Duration(
+	"stagingSchedulerReconcileInterval",
+	scheduler.DefaultReconcileInterval,
+	"Interval at which the scheduler releases the budget of queued staging tasks whose completion it missed",
+)
+
+var failureReasonRulesFile = flag.String(
+	"failureReasonRulesFile",
+	"",
+	"Path to a JSON file with rules mapping staging failure reasons to the error ids and messages reported to CC, tried before the built-in rules",
+)
+
+var traceOTLPEndpoint = flag.String(
+	"traceOTLPEndpoint",
+	"",
+	"Base URL of an OTLP/HTTP collector to export staging traces to (e.g. http://localhost:4318)",
+)
+
+var traceFile = flag.String(
+	"traceFile",
+	"",
+	"Path to a file to write staging traces to as OTLP/JSON lines ('stdout' writes to standard output)",
+)
+
+var traceFlushInterval = flag.Duration(
+	"traceFlushInterval",
+	tracing.DefaultFlushInterval,
+	"Interval at which recorded staging spans are exported",
+)
+
+var reloadableConfigFile = flag.String(
+	"reloadableConfigFile",
+	"",
+	"Path to a JSON file overriding the lifecycles, docker registry settings and CC credentials given as flags; reloaded on SIGHUP",
+)
+
+var lifecycleCatalogPath = flag.String(
+	"lifecycleCatalogPath",
+	"",
+	"Path on the file server of an index of the available lifecycle bundles, used for lifecycles and stacks not given with -lifecycle (disabled if empty)",
+)
+
+var lifecycleCatalogArch = flag.String(
+	"lifecycleCatalogArch",
+	catalog.DefaultArch,
+	"Architecture whose bundles are used from the lifecycle catalog",
+)
+
+var lifecycleCatalogRefreshInterval = flag.Duration(
+	"lifecycleCatalogRefreshInterval",
+	catalog.DefaultRefreshInterval,
+	"Interval at which the lifecycle catalog is fetched from the file server",
+)
+
+var lifecycleChecksumsFile = flag.String(
+	"lifecycleChecksumsFile",
+	"",
+	"Path to a JSON file mapping lifecycle[/stack] to the checksum of its bundle, as {\"algorithm\": \"sha256\", \"value\": \"...\"}",
+)
+
+var requireChecksums = flag.Bool(
+	"requireChecksums",
+	false,
+	"Reject stagings whose lifecycle bundle or buildpacks have no checksum to verify their download against",
+)
+
+var lifecycleBundleVersionTTL = flag.Duration(
+	"lifecycleBundleVersionTTL",
+	0,
+	"How long the file server's ETag of a lifecycle bundle without a checksum is trusted before it is refreshed in the background; the ETag keys the bundle's cache on the cells (disabled if 0)",
+)
+
+var lifecycleCanariesFile = flag.String(
+	"lifecycleCanariesFile",
+	"",
+	"Path to a JSON file mapping lifecycle[/stack] to a canary bundle staged for a share of apps, as {\"bundle\": \"...\", \"percent\": 10}",
+)
+
+var lifecycleCanaryFailureThreshold = flag.Float64(
+	"lifecycleCanaryFailureThreshold",
+	canary.DefaultFailureThreshold,
+	"Share of failed stagings on a canary lifecycle bundle above which all stagings fall back to the primary bundle",
+)
+
+var lifecycleCanaryMinStagings = flag.Int(
+	"lifecycleCanaryMinStagings",
+	canary.DefaultMinStagings,
+	"Number of stagings on a canary lifecycle bundle before its failure rate can roll it back",
+)
+
+var lifecycleCanaryWindow = flag.Int(
+	"lifecycleCanaryWindow",
+	canary.DefaultWindow,
+	"Number of most recent stagings on a canary lifecycle bundle its failure rate is judged on (at least lifecycleCanaryMinStagings)",
+)
+
+var lifecycleCanaryStateFile = flag.String(
+	"lifecycleCanaryStateFile",
+	"",
+	"Path to a file where rolled back canary lifecycle bundles are remembered across restarts (forgotten on restart if empty); a canary without a checksum fixed in place is reset by removing its entry",
+)
+
 var insecureDockerRegistries = make(vars.StringList)
+var dockerRegistryAuthServers = make(vars.StringList)
+var allowedDockerRegistries = make(vars.StringList)
+var deniedDockerRegistries = make(vars.StringList)
+var allowedDockerRepositories = make(vars.StringList)
+var allowedCallbackHosts = make(vars.StringList)
+var trustedCallbackOrigins = make(vars.StringList)
+var allowedCallbackSchemes = make(vars.StringList)
 
 const (
 	dropsondeOrigin = "stager"
@@ -160,6 +426,48 @@ func main() {
 		"Docker registry to allow connecting to even if not secure. (Can be specified multiple times to allow insecure connection to multiple repositories)",
 	)
 
+	flag.Var(
+		&dockerRegistryAuthServers,
+		"dockerRegistryAuthServer",
+		"Host of a docker registry authorization server, besides Docker Hub's, that image metadata may be fetched with. (Can be specified multiple times)",
+	)
+
+	flag.Var(
+		&allowedDockerRegistries,
+		"allowedDockerRegistry",
+		"Docker registry (glob pattern) that docker images may be staged from. (Can be specified multiple times; if unset, all registries are allowed)",
+	)
+
+	flag.Var(
+		&deniedDockerRegistries,
+		"deniedDockerRegistry",
+		"Docker registry (glob pattern) that docker images may never be staged from. (Can be specified multiple times)",
+	)
+
+	flag.Var(
+		&allowedDockerRepositories,
+		"allowedDockerRepository",
+		"Docker repository (glob pattern, e.g. myorg/*) that docker images may be staged from. (Can be specified multiple times; if unset, all repositories are allowed)",
+	)
+
+	flag.Var(
+		&allowedCallbackHosts,
+		"allowedCallbackHost",
+		"Host (glob pattern) that staging completion callbacks may be sent to without CC credentials. (Can be specified multiple times; the ccBaseURL host is always allowed)",
+	)
+
+	flag.Var(
+		&trustedCallbackOrigins,
+		"trustedCallbackOrigin",
+		"Origin (scheme://host[:port]) that staging completion callbacks may be sent to with CC credentials. (Can be specified multiple times; the ccBaseURL origin is always trusted, and nothing over http if it is https)",
+	)
+
+	flag.Var(
+		&allowedCallbackSchemes,
+		"allowedCallbackScheme",
+		"URL scheme allowed for staging completion callbacks. (Can be specified multiple times; defaults to http and https)",
+	)
+
 	lifecycles := flags.LifecycleMap{}
 	flag.Var(&lifecycles, "lifecycle", "app lifecycle binary bundle mapping (lifecycle[/stack]:bundle-filepath-in-fileserver)")
 	flag.Parse()
@@ -167,13 +475,62 @@ func main() {
 	logger, reconfigurableSink := cflager.New("stager")
 	initializeDropsonde(logger)
 
-	ccClient := cc_client.NewCcClient(*ccBaseURL, *ccUsername, *ccPassword, *skipCertVerify)
+	callbackPolicy := cc_client.CallbackPolicy{
+		BaseURI:        *ccBaseURL,
+		AllowedHosts:   allowedCallbackHosts.Values(),
+		TrustedOrigins: trustedCallbackOrigins.Values(),
+		AllowedSchemes: allowedCallbackSchemes.Values(),
+	}
 
-	backends := initializeBackends(logger, lifecycles)
+	canaryTracker := initializeCanaryTracker(logger)
+	classifier := initializeClassifier(logger)
+
+	sources := &configSources{
+		base:      initializeBackendConfig(logger, lifecycles, canaryTracker, classifier),
+		overrides: loadReloadableConfig(logger),
+	}
 
-	handler := handlers.New(logger, ccClient, initializeBBSClient(logger), backends, clock.NewClock())
+	ccClient := cc_client.NewReloadableCcClient(sources.overrides.ccClient(callbackPolicy))
+
+	reloadableBackends := initializeBackends(logger, sources.config())
+	sources.backends = reloadableBackends
+	backends := reloadableBackends.Backends()
+
+	bbsClient := initializeBBSClient(logger)
+
+	retryPolicy := handlers.RetryPolicy{
+		MaxAttempts: *stagingMaxAttempts,
+		Backoff:     *stagingRetryBackoff,
+		MaxBackoff:  *stagingMaxRetryBackoff,
+	}
 
 	clock := clock.NewClock()
+
+	stagingScheduler := initializeScheduler(logger, bbsClient, ccClient, classifier, clock)
+
+	tracer := initializeTracer(logger, clock)
+
+	capabilities := func() stager.Capabilities {
+		return reloadableBackends.Config().Capabilities()
+	}
+	resultCache := initializeResultCache(logger, reloadableBackends.Config, clock)
+
+	superseder := initializeSuperseder(bbsClient, ccClient, stagingScheduler, clock)
+
+	handlerOptions := handlers.Options{
+		AdmissionClient: initializeAdmissionClient(logger),
+		CallbackPolicy:  callbackPolicy,
+		RetryPolicy:     retryPolicy,
+		Classifier:      classifier,
+		Superseder:      superseder,
+		ResultCache:     resultCache,
+		Scheduler:       stagingScheduler,
+		Tracer:          tracer,
+		Canaries:        canaryTracker,
+	}
+
+	handler := handlers.New(logger, ccClient, bbsClient, backends, capabilities, clock, handlerOptions)
+
 	consulClient, err := consuladapter.NewClientFromUrl(*consulCluster)
 	if err != nil {
 		logger.Fatal("new-client-failed", err)
@@ -195,6 +552,61 @@ func main() {
 		{"registration-runner", registrationRunner},
 	}
 
+	if stagingScheduler != nil {
+		members = append(grouper.Members{
+			{"scheduler", stagingScheduler},
+		}, members...)
+	}
+
+	if tracer != nil {
+		members = append(grouper.Members{
+			{"tracer", tracer},
+		}, members...)
+	}
+
+	if *lifecycleCatalogPath != "" {
+		members = append(grouper.Members{
+			{"lifecycle-catalog", initializeLifecycleCatalog(logger, sources, clock)},
+		}, members...)
+	}
+
+	if *reloadableConfigFile != "" {
+		hangups := make(chan os.Signal, 1)
+		signal.Notify(hangups, syscall.SIGHUP)
+
+		members = append(members, grouper.Member{
+			"reloader", reload.New(logger, hangups, func(logger lager.Logger) error {
+				return sources.reload(logger, ccClient, callbackPolicy)
+			}),
+		})
+	}
+
+	if *reconcileInterval > 0 {
+		members = append(members, grouper.Member{
+			"reconciler", reconciler.New(
+				logger,
+				bbsClient,
+				handlers.NewStagingCompletionHandler(logger, ccClient, bbsClient, backends, clock, handlerOptions),
+				clock,
+				cc_messages.StagingTaskDomain,
+				*reconcileInterval,
+				*reconcileGracePeriod,
+			),
+		})
+	}
+
+	if resultCache != nil {
+		members = append(members, grouper.Member{
+			"reuse-resumer", handlers.NewReuseResumer(logger, ccClient, bbsClient, backends, handlerOptions),
+		})
+	}
+
+	if *orphanSweepInterval > 0 {
+		members = append(members, grouper.Member{
+			"sweeper", sweeper.New(logger, bbsClient, ccClient, clock, cc_messages.StagingTaskDomain, *orphanSweepInterval, *orphanTimeoutMargin, *orphanCompletedExpiry),
+		})
+	}
+
 	if dbgAddr := debugserver.DebugAddress(flag.CommandLine); dbgAddr != "" {
 		members = append(grouper.Members{
 			{"debug-server", debugserver.Runner(dbgAddr, reconfigurableSink)},
@@ -225,7 +637,7 @@ func initializeDropsonde(logger lager.Logger) {
 	}
 }
 
-func initializeBackends(logger lager.Logger, lifecycles flags.LifecycleMap) map[string]backend.Backend {
+func initializeBackendConfig(logger lager.Logger, lifecycles flags.LifecycleMap, canaryTracker *canary.Tracker, classifier *diego_errors.Classifier) backend.Config {
 	_, err := url.Parse(*stagingTaskCallbackURL)
 	if err != nil {
 		logger.Fatal("Invalid staging task callback url", err)
@@ -243,25 +655,403 @@ func initializeBackends(logger lager.Logger, lifecycles flags.LifecycleMap) map[
 		logger.Fatal("Error parsing Docker Registry address", err)
 	}
 
-	config := backend.Config{
+	dockerImagePolicy := backend.DockerImagePolicy{
+		AllowedRegistries:   allowedDockerRegistries.Values(),
+		DeniedRegistries:    deniedDockerRegistries.Values(),
+		AllowedRepositories: allowedDockerRepositories.Values(),
+		RequireDigest:       *requireDockerImageDigest,
+		MaxImageAge:         *maxDockerImageAge,
+	}
+	if dockerImagePolicy.MaxImageAge > 0 {
+		dockerImagePolicy.ImageMetadataFetcher = backend.NewRegistryImageMetadataFetcher(insecureDockerRegistries.Values(), dockerRegistryAuthServers.Values(), *skipCertVerify)
+	}
+
+	var bundleVersionFetcher backend.BundleVersionFetcher
+	if *lifecycleBundleVersionTTL > 0 {
+		bundleVersionFetcher = backend.NewETagBundleVersionFetcher(logger, *skipCertVerify, clock.NewClock(), *lifecycleBundleVersionTTL)
+	}
+
+	return backend.Config{
 		TaskDomain:               cc_messages.StagingTaskDomain,
 		StagerURL:                *stagingTaskCallbackURL,
 		FileServerURL:            *fileServerURL,
 		CCUploaderURL:            *ccUploaderURL,
 		Lifecycles:               lifecycles,
+		LifecycleChecksums:       initializeLifecycleChecksums(logger),
+		RequireChecksums:         *requireChecksums,
+		BundleVersionFetcher:     bundleVersionFetcher,
+		LifecycleCanaries:        initializeLifecycleCanaries(logger),
+		CanaryTracker:            canaryTracker,
 		DockerRegistryAddress:    *dockerRegistryAddress,
 		InsecureDockerRegistries: insecureDockerRegistries.Values(),
 		ConsulCluster:            *consulCluster,
 		SkipCertVerify:           *skipCertVerify,
 		PrivilegedContainers:     *privilegedContainers,
-		Sanitizer:                backend.SanitizeErrorMessage,
+		Sanitizer:                backend.NewSanitizer(classifier),
+		Classifier:               classifier,
 		DockerStagingStack:       *dockerStagingStack,
+		DockerImagePolicy:        dockerImagePolicy,
+		StagingResources:         initializeStagingResources(logger),
+		StagingTimeouts:          initializeStagingTimeouts(logger),
+	}
+}
+
+func initializeLifecycleChecksums(logger lager.Logger) map[string]backend.Checksum {
+	checksums := map[string]backend.Checksum{}
+	if *lifecycleChecksumsFile == "" {
+		return checksums
+	}
+
+	readJSONFile(logger, *lifecycleChecksumsFile, &checksums)
+	return checksums
+}
+
+func initializeLifecycleCanaries(logger lager.Logger) map[string]backend.Canary {
+	canaries := map[string]backend.Canary{}
+	if *lifecycleCanariesFile == "" {
+		return canaries
+	}
+
+	readJSONFile(logger, *lifecycleCanariesFile, &canaries)
+	return canaries
+}
+
+func initializeBackends(logger lager.Logger, config backend.Config) *backend.ReloadableBackends {
+	err := config.Validate()
+	if err != nil {
+		logger.Fatal("invalid-backend-config", err)
+	}
+
+	return backend.NewReloadableBackends(config, func(config backend.Config) map[string]backend.Backend {
+		return map[string]backend.Backend{
+			"buildpack": backend.NewTraditionalBackend(config, logger),
+			"docker":    backend.NewDockerBackend(config, logger),
+		}
+	})
+}
+
+// reloadableConfig overrides the flags that can be changed without a
+// restart. Fields left out of the file keep their flag value.
+type reloadableConfig struct {
+	Lifecycles               map[string]string           `json:"lifecycles"`
+	LifecycleChecksums       map[string]backend.Checksum `json:"lifecycle_checksums"`
+	LifecycleCanaries        map[string]backend.Canary   `json:"lifecycle_canaries"`
+	InsecureDockerRegistries []string                    `json:"insecure_docker_registries"`
+	DockerRegistryAddress    *string                     `json:"docker_registry_address"`
+	DockerStagingStack       *string                     `json:"docker_staging_stack"`
+	CCUsername               *string                     `json:"cc_username"`
+	CCPassword               *string                     `json:"cc_password"`
+}
+
+func loadReloadableConfig(logger lager.Logger) reloadableConfig {
+	var config reloadableConfig
+	if *reloadableConfigFile != "" {
+		readJSONFile(logger, *reloadableConfigFile, &config)
+	}
+	return config
+}
+
+func (c reloadableConfig) apply(config backend.Config) backend.Config {
+	if c.Lifecycles != nil {
+		config.Lifecycles = c.Lifecycles
+	}
+	if c.LifecycleChecksums != nil {
+		config.LifecycleChecksums = c.LifecycleChecksums
 	}
+	if c.LifecycleCanaries != nil {
+		config.LifecycleCanaries = c.LifecycleCanaries
+	}
+	if c.InsecureDockerRegistries != nil {
+		config.InsecureDockerRegistries = c.InsecureDockerRegistries
+		if config.DockerImagePolicy.ImageMetadataFetcher != nil {
+			config.DockerImagePolicy.ImageMetadataFetcher = backend.NewRegistryImageMetadataFetcher(c.InsecureDockerRegistries, dockerRegistryAuthServers.Values(), config.SkipCertVerify)
+		}
+	}
+	if c.DockerRegistryAddress != nil {
+		config.DockerRegistryAddress = *c.DockerRegistryAddress
+	}
+	if c.DockerStagingStack != nil {
+		config.DockerStagingStack = *c.DockerStagingStack
+	}
+	return config
+}
+
+func (c reloadableConfig) ccClient(callbackPolicy cc_client.CallbackPolicy) cc_client.CcClient {
+	username, password := *ccUsername, *ccPassword
+	if c.CCUsername != nil {
+		username = *c.CCUsername
+	}
+	if c.CCPassword != nil {
+		password = *c.CCPassword
+	}
+
+	return cc_client.NewCcClient(*ccBaseURL, username, password, *skipCertVerify, callbackPolicy)
+}
+
+// configSources combine the flags, the reloadable config file and the
+// lifecycle catalog into the config of the backends. Lifecycles given with
+// flags or in the file take precedence over the catalog's. The catalog's
+// checksum of a bundle is only used while its bundle is.
+type configSources struct {
+	lock             sync.Mutex
+	base             backend.Config
+	overrides        reloadableConfig
+	catalog          map[string]string
+	catalogChecksums map[string]catalog.Checksum
+	backends         *backend.ReloadableBackends
+}
+
+func (s *configSources) config() backend.Config {
+	config := s.overrides.apply(s.base)
+
+	lifecycles := map[string]string{}
+	checksums := map[string]backend.Checksum{}
+	for key, bundle := range s.catalog {
+		lifecycles[key] = bundle
+		if checksum, ok := s.catalogChecksums[key]; ok {
+			checksums[key] = backend.Checksum(checksum)
+		}
+	}
+	for key, bundle := range config.Lifecycles {
+		if lifecycles[key] != bundle {
+			delete(checksums, key)
+		}
+		lifecycles[key] = bundle
+	}
+	for key, checksum := range config.LifecycleChecksums {
+		checksums[key] = checksum
+	}
+	config.Lifecycles = lifecycles
+	config.LifecycleChecksums = checksums
+
+	return config
+}
 
-	return map[string]backend.Backend{
-		"buildpack": backend.NewTraditionalBackend(config, logger),
-		"docker":    backend.NewDockerBackend(config, logger),
+// reload applies the reloadable config file on top of the flags. Nothing
+// is swapped unless the whole file is valid.
+func (s *configSources) reload(logger lager.Logger, ccClient *cc_client.ReloadableCcClient, callbackPolicy cc_client.CallbackPolicy) error {
+	var overrides reloadableConfig
+	err := loadJSONFile(*reloadableConfigFile, &overrides)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	previous := s.overrides
+	s.overrides = overrides
+	err = s.backends.Reload(s.config())
+	if err != nil {
+		s.overrides = previous
+		return err
 	}
+	ccClient.Reload(overrides.ccClient(callbackPolicy))
+
+	logger.Info("reloaded", lager.Data{"lifecycles": s.backends.Config().Lifecycles})
+	return nil
+}
+
+func (s *configSources) updateCatalog(index catalog.Index) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	previous, previousChecksums := s.catalog, s.catalogChecksums
+	s.catalog = index.Lifecycles(*lifecycleCatalogArch)
+	s.catalogChecksums = index.Checksums(*lifecycleCatalogArch)
+	err := s.backends.Reload(s.config())
+	if err != nil {
+		s.catalog, s.catalogChecksums = previous, previousChecksums
+		return err
+	}
+
+	return nil
+}
+
+func initializeLifecycleCatalog(logger lager.Logger, sources *configSources, clock clock.Clock) ifrit.Runner {
+	if *fileServerURL == "" {
+		logger.Fatal("Invalid lifecycle catalog", errors.New("fileServerURL cannot be blank when lifecycleCatalogPath is set"))
+	}
+
+	httpClient := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: *skipCertVerify},
+		},
+	}
+
+	return catalog.New(logger, httpClient, *fileServerURL, *lifecycleCatalogPath, clock, *lifecycleCatalogRefreshInterval, sources.updateCatalog)
+}
+
+func initializeStagingResources(logger lager.Logger) map[string]backend.StagingResources {
+	stagingResources := map[string]backend.StagingResources{}
+	if *stagingResourcesFile == "" {
+		return stagingResources
+	}
+
+	readJSONFile(logger, *stagingResourcesFile, &stagingResources)
+
+	for key, resources := range stagingResources {
+		err := resources.Validate()
+		if err != nil {
+			logger.Fatal("invalid-staging-resources", err, lager.Data{"lifecycle": key})
+		}
+	}
+
+	return stagingResources
+}
+
+func initializeStagingTimeouts(logger lager.Logger) map[string]backend.TimeoutPolicy {
+	stagingTimeouts := map[string]backend.TimeoutPolicy{}
+	if *stagingTimeoutsFile == "" {
+		return stagingTimeouts
+	}
+
+	readJSONFile(logger, *stagingTimeoutsFile, &stagingTimeouts)
+
+	for key, policy := range stagingTimeouts {
+		err := policy.Validate()
+		if err != nil {
+			logger.Fatal("invalid-staging-timeouts", err, lager.Data{"lifecycle": key})
+		}
+	}
+
+	return stagingTimeouts
+}
+
+func initializeCanaryTracker(logger lager.Logger) *canary.Tracker {
+	if *lifecycleCanaryStateFile == "" {
+		return canary.NewTracker(*lifecycleCanaryFailureThreshold, *lifecycleCanaryMinStagings, *lifecycleCanaryWindow)
+	}
+
+	tracker, err := canary.LoadTracker(*lifecycleCanaryFailureThreshold, *lifecycleCanaryMinStagings, *lifecycleCanaryWindow, *lifecycleCanaryStateFile)
+	if err != nil {
+		logger.Fatal("failed-to-load-canary-state", err, lager.Data{"path": *lifecycleCanaryStateFile})
+	}
+	return tracker
+}
+
+func initializeClassifier(logger lager.Logger) *diego_errors.Classifier {
+	rules := []diego_errors.Rule{}
+	if *failureReasonRulesFile != "" {
+		readJSONFile(logger, *failureReasonRulesFile, &rules)
+	}
+
+	classifier, err := diego_errors.NewClassifier(append(rules, diego_errors.DefaultRules...))
+	if err != nil {
+		logger.Fatal("invalid-failure-reason-rules", err, lager.Data{"path": *failureReasonRulesFile})
+	}
+
+	if *failureReasonRulesFile != "" {
+		logger.Info("loaded-failure-reason-rules", lager.Data{"count": len(rules)})
+	}
+	return classifier
+}
+
+func readJSONFile(logger lager.Logger, path string, v interface{}) {
+	err := loadJSONFile(path, v)
+	if err != nil {
+		logger.Fatal("failed-to-load-config-file", err, lager.Data{"path": path})
+	}
+}
+
+func loadJSONFile(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func initializeAdmissionClient(logger lager.Logger) admission.Client {
+	if *stagingAdmissionURL == "" {
+		return nil
+	}
+
+	_, err := url.ParseRequestURI(*stagingAdmissionURL)
+	if err != nil {
+		logger.Fatal("Invalid staging admission url", err)
+	}
+
+	return admission.NewClient(*stagingAdmissionURL, *stagingAdmissionTimeout, *stagingAdmissionFailOpen, nil)
+}
+
+func initializeResultCache(logger lager.Logger, config func() backend.Config, clock clock.Clock) resultcache.Cache {
+	if *stagingResultCacheDir == "" {
+		return nil
+	}
+
+	if *stagingResultCacheDropletURL == "" {
+		logger.Fatal("Invalid staging result cache droplet url", errors.New("stagingResultCacheDropletURL cannot be blank when stagingResultCacheDir is set"))
+	}
+
+	store, err := resultcache.NewFileStore(*stagingResultCacheDir)
+	if err != nil {
+		logger.Fatal("failed-to-initialize-staging-result-cache", err)
+	}
+
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: *skipCertVerify},
+		},
+	}
+
+	return resultcache.New(store, config, *ccUploaderURL, *stagingResultCacheDropletURL, httpClient, *stagingResultCacheTTL, clock)
+}
+
+func initializeScheduler(logger lager.Logger, bbsClient bbs.Client, ccClient cc_client.CcClient, classifier *diego_errors.Classifier, clock clock.Clock) scheduler.Runner {
+	if *stagingConcurrency <= 0 {
+		return nil
+	}
+
+	if *stagingSchedulerStateFile == "" {
+		logger.Fatal("Invalid staging scheduler state file", errors.New("stagingSchedulerStateFile cannot be blank when stagingConcurrency is set"))
+	}
+
+	tenants := scheduler.TenantPolicy{}
+	if *stagingTenantPolicyFile != "" {
+		readJSONFile(logger, *stagingTenantPolicyFile, &tenants)
+	}
+
+	stagingScheduler, err := scheduler.New(logger, bbsClient, ccClient, clock, tenants, classifier, *stagingConcurrency, *stagingSchedulerReconcileInterval, *stagingSchedulerStateFile)
+	if err != nil {
+		logger.Fatal("failed-to-initialize-scheduler", err)
+	}
+
+	return stagingScheduler
+}
+
+func initializeSuperseder(bbsClient bbs.Client, ccClient cc_client.CcClient, stagingScheduler scheduler.Scheduler, clock clock.Clock) *handlers.Superseder {
+	if !*supersedeStagings {
+		return nil
+	}
+
+	return handlers.NewSuperseder(bbsClient, ccClient, stagingScheduler, clock, cc_messages.StagingTaskDomain, *supersedeResyncInterval)
+}
+
+func initializeTracer(logger lager.Logger, clock clock.Clock) *tracing.Tracer {
+	var exporter tracing.Exporter
+	switch {
+	case *traceOTLPEndpoint != "":
+		_, err := url.ParseRequestURI(*traceOTLPEndpoint)
+		if err != nil {
+			logger.Fatal("Invalid trace OTLP endpoint", err)
+		}
+		exporter = tracing.NewOTLPExporter(*traceOTLPEndpoint, nil, nil)
+	case *traceFile == "stdout":
+		exporter = tracing.NewWriterExporter(os.Stdout)
+	case *traceFile != "":
+		file, err := os.OpenFile(*traceFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			logger.Fatal("failed-to-open-trace-file", err)
+		}
+		exporter = tracing.NewWriterExporter(file)
+	default:
+		return nil
+	}
+
+	return tracing.NewTracer(logger, dropsondeOrigin, exporter, clock, *traceFlushInterval)
 }
 
 func initializeBBSClient(logger lager.Logger) bbs.Client {
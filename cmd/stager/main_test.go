@@ -5,9 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
+	"syscall"
 
 	"code.cloudfoundry.org/bbs/models"
 	"code.cloudfoundry.org/bbs/models/test/model_helpers"
@@ -16,6 +19,7 @@ import (
 	"code.cloudfoundry.org/stager"
 	"code.cloudfoundry.org/stager/cmd/stager/testrunner"
 	"code.cloudfoundry.org/stager/diego_errors"
+	"github.com/cloudfoundry/sonde-go/events"
 	"github.com/gogo/protobuf/proto"
 	"github.com/hashicorp/consul/api"
 	. "github.com/onsi/ginkgo"
@@ -313,7 +317,7 @@ var _ = Describe("Stager", func() {
 							"lifecycle": "buildpack"
 						}`,
 						Failed:        true,
-						FailureReason: diego_errors.INSUFFICIENT_RESOURCES_MESSAGE,
+						FailureReason: diego_errors.ErrInsufficientResources.Message,
 					})
 
 					fakeCC.AppendHandlers(
@@ -542,6 +546,205 @@ var _ = Describe("Stager", func() {
 		})
 	})
 
+	Context("when started with a reloadable config file", func() {
+		var configFile string
+
+		getCapabilities := func() stager.Capabilities {
+			req, err := requestGenerator.CreateRequest(stager.CapabilitiesRoute, nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			resp, err := httpClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			var capabilities stager.Capabilities
+			Expect(json.NewDecoder(resp.Body).Decode(&capabilities)).To(Succeed())
+			return capabilities
+		}
+
+		BeforeEach(func() {
+			file, err := ioutil.TempFile("", "reloadable-config")
+			Expect(err).NotTo(HaveOccurred())
+			configFile = file.Name()
+
+			_, err = file.WriteString(`{"lifecycles": {"buildpack/cflinuxfs2": "cflinuxfs2/lifecycle.zip"}}`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(file.Close()).To(Succeed())
+
+			runner.Start(
+				"-lifecycle", "buildpack/linux:lifecycle.zip",
+				"-reloadableConfigFile", configFile,
+			)
+			Eventually(runner.Session()).Should(gbytes.Say("Listening for staging requests!"))
+		})
+
+		AfterEach(func() {
+			os.Remove(configFile)
+		})
+
+		It("overrides the flags with the file", func() {
+			Expect(getCapabilities().Lifecycles).To(Equal(map[string]map[string]string{
+				"buildpack": {"cflinuxfs2": "cflinuxfs2/lifecycle.zip"},
+			}))
+		})
+
+		It("reloads the file on SIGHUP", func() {
+			err := ioutil.WriteFile(configFile, []byte(`{"lifecycles": {"buildpack/cflinuxfs3": "cflinuxfs3/lifecycle.zip"}}`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+
+			runner.Session().Signal(syscall.SIGHUP)
+			Eventually(runner.Session()).Should(gbytes.Say("reload.succeeded"))
+
+			Expect(getCapabilities().Lifecycles).To(Equal(map[string]map[string]string{
+				"buildpack": {"cflinuxfs3": "cflinuxfs3/lifecycle.zip"},
+			}))
+			Consistently(runner.Session()).ShouldNot(gexec.Exit())
+		})
+
+		It("keeps the current config when the file is invalid", func() {
+			err := ioutil.WriteFile(configFile, []byte(`{"lifecycles": {"buildpack/cflinuxfs3": ""}}`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+
+			runner.Session().Signal(syscall.SIGHUP)
+			Eventually(runner.Session()).Should(gbytes.Say("reload.failed"))
+
+			Expect(getCapabilities().Lifecycles).To(Equal(map[string]map[string]string{
+				"buildpack": {"cflinuxfs2": "cflinuxfs2/lifecycle.zip"},
+			}))
+		})
+	})
+
+	Context("when started with a lifecycle catalog", func() {
+		var fileServer *ghttp.Server
+
+		BeforeEach(func() {
+			fileServer = ghttp.NewServer()
+			fileServer.RouteToHandler("GET", "/v1/static/lifecycles/index.json", ghttp.RespondWith(http.StatusOK, `{
+				"bundles": [
+					{"lifecycle": "buildpack", "stack": "cflinuxfs2", "arch": "amd64", "path": "catalog/cflinuxfs2.tgz", "checksum": {"algorithm": "sha256", "value": "abc"}},
+					{"lifecycle": "buildpack", "stack": "linux", "arch": "amd64", "path": "catalog/linux.tgz", "checksum": {"algorithm": "sha256", "value": "def"}}
+				]
+			}`))
+
+			runner.Start(
+				"-lifecycle", "buildpack/linux:lifecycle.zip",
+				"-fileServerURL", fileServer.URL(),
+				"-lifecycleCatalogPath", "lifecycles/index.json",
+			)
+			Eventually(runner.Session()).Should(gbytes.Say("Listening for staging requests!"))
+		})
+
+		AfterEach(func() {
+			fileServer.Close()
+		})
+
+		It("serves the catalog's stacks alongside the -lifecycle flags, which take precedence", func() {
+			req, err := requestGenerator.CreateRequest(stager.CapabilitiesRoute, nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			resp, err := httpClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+
+			var capabilities stager.Capabilities
+			Expect(json.NewDecoder(resp.Body).Decode(&capabilities)).To(Succeed())
+			Expect(capabilities.Lifecycles).To(Equal(map[string]map[string]string{
+				"buildpack": {
+					"cflinuxfs2": "catalog/cflinuxfs2.tgz",
+					"linux":      "lifecycle.zip",
+				},
+			}))
+		})
+	})
+
+	Context("when started with a metron agent", func() {
+		var (
+			fakeMetron *net.UDPConn
+			envelopes  chan *events.Envelope
+		)
+
+		BeforeEach(func() {
+			var err error
+			fakeMetron, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+			Expect(err).NotTo(HaveOccurred())
+
+			envelopes = make(chan *events.Envelope, 100)
+			go func(conn *net.UDPConn, envelopes chan<- *events.Envelope) {
+				defer GinkgoRecover()
+
+				buffer := make([]byte, 65535)
+				for {
+					n, err := conn.Read(buffer)
+					if err != nil {
+						return
+					}
+
+					envelope := &events.Envelope{}
+					Expect(proto.Unmarshal(buffer[:n], envelope)).To(Succeed())
+					envelopes <- envelope
+				}
+			}(fakeMetron, envelopes)
+
+			metronPort := fakeMetron.LocalAddr().(*net.UDPAddr).Port
+			runner.Start(
+				"-lifecycle", "buildpack/linux:lifecycle.zip",
+				"-dropsondePort", strconv.Itoa(metronPort),
+			)
+			Eventually(runner.Session()).Should(gbytes.Say("Listening for staging requests!"))
+		})
+
+		AfterEach(func() {
+			fakeMetron.Close()
+		})
+
+		Context("when a staging request is rejected", func() {
+			It("explains the rejection in the app's logs", func() {
+				req, err := requestGenerator.CreateRequest(stager.StageRoute, rata.Params{"staging_guid": "my-task-guid"}, strings.NewReader(`{
+					"app_id":"my-app-guid",
+					"log_guid":"my-log-guid",
+					"file_descriptors":3,
+					"memory_mb" : 1024,
+					"disk_mb" : 128,
+					"environment" : [],
+					"lifecycle": "buildpack",
+					"lifecycle_data": {
+					  "buildpacks" : [],
+						"stack":"no-such-stack",
+					  "app_bits_download_uri":"http://example.com/app_bits"
+					}
+				}`))
+				Expect(err).NotTo(HaveOccurred())
+				req.Header.Set("Content-Type", "application/json")
+
+				resp, err := httpClient.Do(req)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+
+				var logMessage *events.LogMessage
+				Eventually(func() *events.LogMessage {
+					for {
+						select {
+						case envelope := <-envelopes:
+							if envelope.GetEventType() == events.Envelope_LogMessage {
+								logMessage = envelope.GetLogMessage()
+								return logMessage
+							}
+						default:
+							return nil
+						}
+					}
+				}).ShouldNot(BeNil())
+
+				Expect(logMessage.GetAppId()).To(Equal("my-log-guid"))
+				Expect(logMessage.GetSourceType()).To(Equal("STG"))
+				Expect(logMessage.GetMessageType()).To(Equal(events.LogMessage_ERR))
+				Expect(string(logMessage.GetMessage())).To(Equal("Staging failed: no compiler defined for requested stack"))
+				Expect(fakeBBS.ReceivedRequests()).To(BeEmpty())
+			})
+		})
+	})
+
 	Describe("-listenAddress arg", func() {
 		Context("when started with an invalid -listenAddress arg with no :", func() {
 			BeforeEach(func() {
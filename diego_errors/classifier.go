@@ -0,0 +1,160 @@
+package diego_errors
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/buildpackapplifecycle"
+)
+
+// Rule maps Diego failure reasons onto a Code. A rule matches a reason when
+// all of its non-empty matchers do. Message is shown to users in place of
+// the reason, which is kept as the error's detail, unless PassThrough is set
+// and the reason itself is shown.
+type Rule struct {
+	Exact    string `json:"exact,omitempty"`
+	Prefix   string `json:"prefix,omitempty"`
+	Suffix   string `json:"suffix,omitempty"`
+	Contains string `json:"contains,omitempty"`
+	Pattern  string `json:"pattern,omitempty"`
+
+	Code        Code   `json:"id"`
+	Message     string `json:"message,omitempty"`
+	PassThrough bool   `json:"pass_through,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+func (r Rule) Validate() error {
+	if r.Exact == "" && r.Prefix == "" && r.Suffix == "" && r.Contains == "" && r.Pattern == "" {
+		return errors.New("rule has no matcher")
+	}
+
+	if r.Pattern != "" {
+		_, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %s", err)
+		}
+	}
+
+	if !knownCodes[r.Code] {
+		return fmt.Errorf("unknown id %q", r.Code)
+	}
+
+	if r.PassThrough == (r.Message != "") {
+		return errors.New("rule must have either a message or pass the reason through")
+	}
+
+	return nil
+}
+
+func (r Rule) matches(reason string) bool {
+	return (r.Exact == "" || reason == r.Exact) &&
+		strings.HasPrefix(reason, r.Prefix) &&
+		strings.HasSuffix(reason, r.Suffix) &&
+		strings.Contains(reason, r.Contains) &&
+		(r.pattern == nil || r.pattern.MatchString(reason))
+}
+
+// DefaultRules classify the failure reasons reported by Diego and the
+// lifecycles. The download and upload rules match the executor's messages
+// for the artifact names the backends give their actions.
+var DefaultRules = []Rule{
+	{Contains: "out of memory", Code: CodeOutOfMemory, Message: ErrOutOfMemory.Message},
+	{Prefix: "Exceeded ", Suffix: " timeout", Code: CodeStagingTimedOut, Message: ErrStagingTimedOut.Message},
+	{Suffix: strconv.Itoa(buildpackapplifecycle.DETECT_FAIL_CODE), Code: CodeBuildpackDetectFailed, Message: ErrStagingFailed.Message},
+	{Suffix: strconv.Itoa(buildpackapplifecycle.COMPILE_FAIL_CODE), Code: CodeBuildpackCompileFailed, Message: ErrStagingFailed.Message},
+	{Suffix: strconv.Itoa(buildpackapplifecycle.RELEASE_FAIL_CODE), Code: CodeBuildpackReleaseFailed, Message: ErrStagingFailed.Message},
+	{Pattern: `^Failed to download (payload for )?app package\b`, Code: CodeAppBitsDownloadFailed, Message: ErrAppBitsDownloadFailed.Message},
+	{Pattern: `^Failed to upload (payload for )?droplet\b`, Code: CodeDropletUploadFailed, Message: ErrDropletUploadFailed.Message},
+	{Prefix: ErrInsufficientResources.Message, Code: CodeInsufficientResources, PassThrough: true},
+	{Prefix: ErrNoCompatibleCell.Message, Code: CodeNoCompatibleCell, PassThrough: true},
+	{Exact: ErrCellCommunicationError.Message, Code: CodeCellCommunicationError, PassThrough: true},
+	{Exact: ErrTaskCancelled.Message, Code: CodeTaskCancelled, PassThrough: true},
+}
+
+// Classifier turns failure reasons into typed errors using the first rule
+// that matches. Unmatched reasons become an ErrUnrecognizedFailure, keeping
+// the reason as the detail. A nil Classifier uses the DefaultRules.
+type Classifier struct {
+	rules []Rule
+}
+
+func NewClassifier(rules []Rule) (*Classifier, error) {
+	compiled := make([]Rule, len(rules))
+	for i, rule := range rules {
+		err := rule.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %s", i, err)
+		}
+
+		if rule.Pattern != "" {
+			rule.pattern = regexp.MustCompile(rule.Pattern)
+		}
+		compiled[i] = rule
+	}
+
+	return &Classifier{rules: compiled}, nil
+}
+
+func (c *Classifier) Classify(reason string) *Error {
+	classified, ok := c.match(reason)
+	if !ok {
+		return ErrUnrecognizedFailure.WithDetail(reason)
+	}
+	return classified
+}
+
+// FromError returns the classified form of err, a failure of the stager
+// itself rather than of a staging task. Errors that are neither an *Error
+// nor a TypedError are classified by their message; those no rule matches
+// become an ErrStagingFailed, since there are no staging logs to point to.
+func (c *Classifier) FromError(err error) *Error {
+	switch err := err.(type) {
+	case *Error:
+		return err
+	case TypedError:
+		return err.TypedError()
+	default:
+		classified, ok := c.match(err.Error())
+		if !ok {
+			return ErrStagingFailed.WithDetail(err.Error())
+		}
+		return classified
+	}
+}
+
+func (c *Classifier) match(reason string) (*Error, bool) {
+	if c == nil {
+		c = defaultClassifier
+	}
+
+	for _, rule := range c.rules {
+		if !rule.matches(reason) {
+			continue
+		}
+
+		if rule.PassThrough {
+			return New(rule.Code, reason), true
+		}
+		return New(rule.Code, rule.Message).WithDetail(reason), true
+	}
+
+	return nil, false
+}
+
+var defaultClassifier = func() *Classifier {
+	classifier, err := NewClassifier(DefaultRules)
+	if err != nil {
+		panic("invalid default rules: " + err.Error())
+	}
+	return classifier
+}()
+
+// Classify classifies reason using the DefaultRules.
+func Classify(reason string) *Error {
+	return defaultClassifier.Classify(reason)
+}
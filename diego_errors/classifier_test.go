@@ -0,0 +1,136 @@
+package diego_errors_test
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/stager/diego_errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Classifier", func() {
+	var classifier *diego_errors.Classifier
+
+	BeforeEach(func() {
+		var err error
+		classifier, err = diego_errors.NewClassifier([]diego_errors.Rule{
+			{Prefix: "disk quota", Suffix: "exceeded", Code: diego_errors.CodeInsufficientResources, Message: "out of disk"},
+			{Pattern: `proxy .* refused`, Code: diego_errors.CodeStagingError, Message: "the staging proxy refused the connection"},
+			{Contains: "cell", Code: diego_errors.CodeNoCompatibleCell, PassThrough: true},
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("uses the message of the first matching rule and keeps the reason as detail", func() {
+		err := classifier.Classify("disk quota of cell exceeded")
+		Expect(err.Code).To(Equal(diego_errors.CodeInsufficientResources))
+		Expect(err.Message).To(Equal("out of disk"))
+		Expect(err.Detail).To(Equal("disk quota of cell exceeded"))
+	})
+
+	It("requires all matchers of a rule to match", func() {
+		err := classifier.Classify("disk quota of cell almost reached")
+		Expect(err.Code).To(Equal(diego_errors.CodeNoCompatibleCell))
+	})
+
+	It("matches patterns", func() {
+		err := classifier.Classify("dial tcp: proxy 10.0.0.1:8080 refused the connection")
+		Expect(err.Message).To(Equal("the staging proxy refused the connection"))
+	})
+
+	It("passes the reason through when asked to", func() {
+		err := classifier.Classify("no cell")
+		Expect(err.Message).To(Equal("no cell"))
+		Expect(err.Detail).To(BeEmpty())
+	})
+
+	It("falls back to a generic staging error", func() {
+		err := classifier.Classify("something else")
+		Expect(err.Code).To(Equal(diego_errors.CodeStagingError))
+		Expect(err.Message).To(Equal(diego_errors.ErrUnrecognizedFailure.Message))
+		Expect(err.Detail).To(Equal("something else"))
+	})
+
+	Describe("FromError", func() {
+		It("classifies errors of the stager by their message with the classifier's rules", func() {
+			err := classifier.FromError(errors.New("disk quota of cell exceeded"))
+			Expect(err.Code).To(Equal(diego_errors.CodeInsufficientResources))
+			Expect(err.Message).To(Equal("out of disk"))
+		})
+
+		It("falls back to a generic staging error that does not point to staging logs", func() {
+			err := classifier.FromError(errors.New("something else"))
+			Expect(err.Message).To(Equal(diego_errors.ErrStagingFailed.Message))
+			Expect(err.Detail).To(Equal("something else"))
+		})
+	})
+
+	Describe("validation", func() {
+		It("rejects rules without matchers", func() {
+			_, err := diego_errors.NewClassifier([]diego_errors.Rule{{Code: diego_errors.CodeOutOfMemory, Message: "oom"}})
+			Expect(err).To(MatchError("rule 0: rule has no matcher"))
+		})
+
+		It("rejects invalid patterns", func() {
+			_, err := diego_errors.NewClassifier([]diego_errors.Rule{{Pattern: "(", Code: diego_errors.CodeStagingError, Message: "m"}})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects unknown ids", func() {
+			_, err := diego_errors.NewClassifier([]diego_errors.Rule{{Contains: "x", Code: "Bogus", Message: "m"}})
+			Expect(err).To(MatchError(`rule 0: unknown id "Bogus"`))
+		})
+
+		It("requires either a message or pass through", func() {
+			_, err := diego_errors.NewClassifier([]diego_errors.Rule{{Contains: "x", Code: diego_errors.CodeStagingError}})
+			Expect(err).To(HaveOccurred())
+
+			_, err = diego_errors.NewClassifier([]diego_errors.Rule{{Contains: "x", Code: diego_errors.CodeStagingError, Message: "m", PassThrough: true}})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("the default rules", func() {
+		It("recognises failed buildpack compiles by their exit status", func() {
+			err := diego_errors.Classify("Exited with status 223")
+			Expect(err.Code).To(Equal(diego_errors.CodeBuildpackCompileFailed))
+			Expect(err.Message).To(Equal("staging failed"))
+		})
+
+		It("prefers out of memory over the exit status", func() {
+			Expect(diego_errors.Classify("Exited with status 137 (out of memory)").Code).To(Equal(diego_errors.CodeOutOfMemory))
+		})
+
+		It("recognises timeouts", func() {
+			Expect(diego_errors.Classify("Exceeded 15m0s timeout").Code).To(Equal(diego_errors.CodeStagingTimedOut))
+		})
+
+		It("recognises app bits download failures", func() {
+			Expect(diego_errors.Classify("Failed to download app package").Code).To(Equal(diego_errors.CodeAppBitsDownloadFailed))
+		})
+
+		It("recognises droplet upload failures", func() {
+			Expect(diego_errors.Classify("Failed to upload droplet").Code).To(Equal(diego_errors.CodeDropletUploadFailed))
+		})
+
+		It("recognises the executor's upload failures", func() {
+			Expect(diego_errors.Classify("Failed to upload payload for droplet").Code).To(Equal(diego_errors.CodeDropletUploadFailed))
+		})
+
+		It("does not take any mention of the artifacts for their transfer failing", func() {
+			Expect(diego_errors.Classify("Exited with status 1: no such file or directory: /tmp/droplet").Code).To(Equal(diego_errors.ErrUnrecognizedFailure.Code))
+			Expect(diego_errors.Classify("buildpack rejected the app package layout").Code).To(Equal(diego_errors.ErrUnrecognizedFailure.Code))
+		})
+
+		It("recognises insufficient resources", func() {
+			err := diego_errors.Classify("insufficient resources: memory")
+			Expect(err.Code).To(Equal(diego_errors.CodeInsufficientResources))
+			Expect(err.Message).To(Equal("insufficient resources: memory"))
+		})
+
+		It("recognises cancelled tasks", func() {
+			Expect(diego_errors.Classify("task was cancelled").Code).To(Equal(diego_errors.CodeTaskCancelled))
+		})
+	})
+})
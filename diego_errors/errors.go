@@ -1,15 +1,153 @@
 package diego_errors
 
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+)
+
+// Code classifies why a staging failed. Codes CC knows about share their
+// value with the cc_messages error ids.
+type Code string
+
 const (
-	INSUFFICIENT_RESOURCES_MESSAGE        = "insufficient resources"
-	MISSING_APP_BITS_DOWNLOAD_URI_MESSAGE = "missing app bits download uri"
-	MISSING_APP_ID_MESSAGE                = "missing app id"
-	MISSING_LIFECYCLE_DATA_MESSAGE        = "missing lifecycle data"
-	NO_COMPILER_DEFINED_MESSAGE           = "no compiler defined for requested stack"
-	CELL_MISMATCH_MESSAGE                 = "found no compatible cell"
-	CELL_COMMUNICATION_ERROR              = "unable to communicate to compatible cells"
-	MISSING_DOCKER_IMAGE_URL              = "missing docker image download url"
-	MISSING_DOCKER_REGISTRY               = "missing docker registry"
-	MISSING_DOCKER_CREDENTIALS            = "missing docker credentials"
-	INVALID_DOCKER_REGISTRY_ADDRESS       = "invalid docker registry address"
+	CodeStagingError           Code = cc_messages.STAGING_ERROR
+	CodeInsufficientResources  Code = cc_messages.INSUFFICIENT_RESOURCES
+	CodeNoCompatibleCell       Code = cc_messages.NO_COMPATIBLE_CELL
+	CodeCellCommunicationError Code = cc_messages.CELL_COMMUNICATION_ERROR
+	CodeBuildpackDetectFailed  Code = cc_messages.BUILDPACK_DETECT_FAILED
+	CodeBuildpackCompileFailed Code = cc_messages.BUILDPACK_COMPILE_FAILED
+	CodeBuildpackReleaseFailed Code = cc_messages.BUILDPACK_RELEASE_FAILED
+
+	CodeInvalidStagingRequest     Code = "InvalidStagingRequest"
+	CodeDockerImageRejected       Code = "DockerImageRejected"
+	CodeStagingRequestDenied      Code = "StagingRequestDenied"
+	CodeAdmissionUnavailable      Code = "StagingAdmissionUnavailable"
+	CodeMalformedStagingResult    Code = "MalformedStagingResult"
+	CodeInvalidCompletionCallback Code = "InvalidCompletionCallback"
+	CodeStagingSuperseded         Code = "StagingSuperseded"
+	CodeTaskCancelled             Code = "TaskCancelled"
+	CodeAppBitsDownloadFailed     Code = "AppBitsDownloadFailed"
+	CodeDropletUploadFailed       Code = "DropletUploadFailed"
+	CodeStagingTimedOut           Code = "StagingTimedOut"
+	CodeOutOfMemory               Code = "OutOfMemory"
+)
+
+var knownCodes = map[Code]bool{
+	CodeStagingError:              true,
+	CodeInsufficientResources:     true,
+	CodeNoCompatibleCell:          true,
+	CodeCellCommunicationError:    true,
+	CodeBuildpackDetectFailed:     true,
+	CodeBuildpackCompileFailed:    true,
+	CodeBuildpackReleaseFailed:    true,
+	CodeInvalidStagingRequest:     true,
+	CodeDockerImageRejected:       true,
+	CodeStagingRequestDenied:      true,
+	CodeAdmissionUnavailable:      true,
+	CodeMalformedStagingResult:    true,
+	CodeInvalidCompletionCallback: true,
+	CodeStagingSuperseded:         true,
+	CodeTaskCancelled:             true,
+	CodeAppBitsDownloadFailed:     true,
+	CodeDropletUploadFailed:       true,
+	CodeStagingTimedOut:           true,
+	CodeOutOfMemory:               true,
+}
+
+// ccErrorIds are the codes CC has a dedicated error for; all others are
+// reported to CC as a StagingError.
+var ccErrorIds = map[Code]bool{
+	CodeInsufficientResources:  true,
+	CodeNoCompatibleCell:       true,
+	CodeCellCommunicationError: true,
+	CodeBuildpackDetectFailed:  true,
+	CodeBuildpackCompileFailed: true,
+	CodeBuildpackReleaseFailed: true,
+}
+
+// Error is a classified staging failure. Message is safe to show to users;
+// Detail is for operators only and never sent to CC.
+type Error struct {
+	Code    Code
+	Message string
+	Detail  string
+}
+
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.Detail == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Message, e.Detail)
+}
+
+// WithDetail returns a copy of the error carrying the given internal detail.
+func (e *Error) WithDetail(detail string) *Error {
+	err := *e
+	err.Detail = detail
+	return &err
+}
+
+// StagingError returns the error as reported to CC.
+func (e *Error) StagingError() *cc_messages.StagingError {
+	id := cc_messages.STAGING_ERROR
+	if ccErrorIds[e.Code] {
+		id = string(e.Code)
+	}
+
+	return &cc_messages.StagingError{
+		Id:      id,
+		Message: e.Message,
+	}
+}
+
+// TypedError is implemented by errors that carry more context than an
+// *Error but know how they are classified.
+type TypedError interface {
+	error
+	TypedError() *Error
+}
+
+// FromError returns the classified form of err using the DefaultRules.
+func FromError(err error) *Error {
+	return defaultClassifier.FromError(err)
+}
+
+var (
+	ErrInsufficientResources  = New(CodeInsufficientResources, "insufficient resources")
+	ErrNoCompatibleCell       = New(CodeNoCompatibleCell, "found no compatible cell")
+	ErrCellCommunicationError = New(CodeCellCommunicationError, "unable to communicate to compatible cells")
+	ErrStagingFailed          = New(CodeStagingError, "staging failed")
+	ErrUnrecognizedFailure    = New(CodeStagingError, "staging failed for an unrecognized reason, see the staging logs for details")
+
+	ErrMissingAppBitsDownloadUri = New(CodeInvalidStagingRequest, "missing app bits download uri")
+	ErrMissingAppId              = New(CodeInvalidStagingRequest, "missing app id")
+	ErrMissingLifecycleData      = New(CodeInvalidStagingRequest, "missing lifecycle data")
+	ErrNoCompilerDefined         = New(CodeInvalidStagingRequest, "no compiler defined for requested stack")
+	ErrMissingDockerImageUrl     = New(CodeInvalidStagingRequest, "missing docker image download url")
+	ErrMissingDockerRegistry     = New(CodeInvalidStagingRequest, "missing docker registry")
+	ErrMissingDockerCredentials  = New(CodeInvalidStagingRequest, "missing docker credentials")
+	ErrInvalidDockerRegistry     = New(CodeInvalidStagingRequest, "invalid docker registry address")
+	ErrMissingChecksum           = New(CodeInvalidStagingRequest, "missing checksum for cached dependency")
+	ErrInvalidChecksum           = New(CodeInvalidStagingRequest, "invalid checksum for cached dependency")
+
+	ErrDockerImageRejected       = New(CodeDockerImageRejected, "docker image rejected by policy")
+	ErrStagingRequestDenied      = New(CodeStagingRequestDenied, "staging request denied")
+	ErrInvalidAdmittedRequest    = New(CodeStagingRequestDenied, "staging request denied: admission webhook returned an invalid request")
+	ErrAdmissionUnavailable      = New(CodeAdmissionUnavailable, "staging admission check unavailable")
+	ErrMalformedStagingResult    = New(CodeMalformedStagingResult, "malformed staging result")
+	ErrInvalidCompletionCallback = New(CodeInvalidCompletionCallback, "invalid completion callback")
+	ErrCompletionCallbackDenied  = New(CodeInvalidCompletionCallback, "completion callback host not allowed")
+	ErrStagingSuperseded         = New(CodeStagingSuperseded, "staging superseded by a newer staging of the same app")
+	ErrTaskCancelled             = New(CodeTaskCancelled, "task was cancelled")
+	ErrAppBitsDownloadFailed     = New(CodeAppBitsDownloadFailed, "failed to download app bits")
+	ErrDropletUploadFailed       = New(CodeDropletUploadFailed, "failed to upload droplet")
+	ErrStagingTimedOut           = New(CodeStagingTimedOut, "staging timed out")
+	ErrOutOfMemory               = New(CodeOutOfMemory, "staging ran out of memory")
+	ErrStagingResultExpired      = New(CodeStagingError, "staging result expired before it could be delivered")
+	ErrBundleVersionUnavailable  = New(CodeStagingError, "lifecycle bundle version unavailable")
 )
@@ -0,0 +1,81 @@
+package diego_errors_test
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/diego_errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type reasonedError struct {
+	reason string
+}
+
+func (e *reasonedError) Error() string {
+	return "rejected: " + e.reason
+}
+
+func (e *reasonedError) TypedError() *diego_errors.Error {
+	return diego_errors.New(diego_errors.CodeStagingRequestDenied, e.Error())
+}
+
+var _ = Describe("Error", func() {
+	Describe("StagingError", func() {
+		It("uses the code as the id of errors CC knows about", func() {
+			stagingErr := diego_errors.ErrNoCompatibleCell.StagingError()
+			Expect(stagingErr).To(Equal(&cc_messages.StagingError{
+				Id:      cc_messages.NO_COMPATIBLE_CELL,
+				Message: "found no compatible cell",
+			}))
+		})
+
+		It("reports other errors as a StagingError", func() {
+			stagingErr := diego_errors.ErrOutOfMemory.StagingError()
+			Expect(stagingErr).To(Equal(&cc_messages.StagingError{
+				Id:      cc_messages.STAGING_ERROR,
+				Message: "staging ran out of memory",
+			}))
+		})
+
+		It("does not send the detail to CC", func() {
+			stagingErr := diego_errors.ErrDropletUploadFailed.WithDetail("Post https://internal-host/droplet: EOF").StagingError()
+			Expect(stagingErr.Message).To(Equal("failed to upload droplet"))
+		})
+	})
+
+	Describe("WithDetail", func() {
+		It("leaves the original error untouched", func() {
+			err := diego_errors.ErrStagingFailed.WithDetail("boom")
+			Expect(err.Error()).To(Equal("staging failed: boom"))
+			Expect(diego_errors.ErrStagingFailed.Error()).To(Equal("staging failed"))
+		})
+	})
+
+	Describe("FromError", func() {
+		It("returns typed errors as they are", func() {
+			Expect(diego_errors.FromError(diego_errors.ErrMissingAppId)).To(Equal(diego_errors.ErrMissingAppId))
+		})
+
+		It("asks errors that know their classification", func() {
+			err := diego_errors.FromError(&reasonedError{reason: "quota"})
+			Expect(err.Code).To(Equal(diego_errors.CodeStagingRequestDenied))
+			Expect(err.Message).To(Equal("rejected: quota"))
+		})
+
+		It("classifies other errors by their message", func() {
+			err := diego_errors.FromError(errors.New("found no compatible cell with placement tags: [a]"))
+			Expect(err.Code).To(Equal(diego_errors.CodeNoCompatibleCell))
+			Expect(err.Message).To(Equal("found no compatible cell with placement tags: [a]"))
+		})
+
+		It("does not point to staging logs for errors it does not recognize", func() {
+			err := diego_errors.FromError(errors.New("connection refused"))
+			Expect(err.Code).To(Equal(diego_errors.CodeStagingError))
+			Expect(err.Message).To(Equal(diego_errors.ErrStagingFailed.Message))
+			Expect(err.Detail).To(Equal("connection refused"))
+		})
+	})
+})
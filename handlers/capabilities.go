@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"code.cloudfoundry.org/stager"
+)
+
+// NewCapabilitiesHandler serves what the stager can currently stage, as
+// returned by capabilities.
+func NewCapabilitiesHandler(capabilities func() stager.Capabilities) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		responseJson, err := json.Marshal(capabilities())
+		if err != nil {
+			resp.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		resp.Header().Set("Content-Type", "application/json")
+		resp.Write(responseJson)
+	})
+}
@@ -0,0 +1,105 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"code.cloudfoundry.org/bbs/fake_bbs"
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager"
+	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/cc_client/fakes"
+	"code.cloudfoundry.org/stager/handlers"
+	"code.cloudfoundry.org/stager/resultcache"
+	fake_resultcache "code.cloudfoundry.org/stager/resultcache/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Capabilities", func() {
+	var (
+		capabilities     stager.Capabilities
+		retryPolicy      handlers.RetryPolicy
+		superseder       *handlers.Superseder
+		resultCache      resultcache.Cache
+		handler          http.Handler
+		responseRecorder *httptest.ResponseRecorder
+		response         stager.Capabilities
+	)
+
+	getCapabilities := func() {
+		req, err := http.NewRequest("GET", "/v1/capabilities", nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		responseRecorder = httptest.NewRecorder()
+		handler.ServeHTTP(responseRecorder, req)
+
+		response = stager.Capabilities{}
+		err = json.Unmarshal(responseRecorder.Body.Bytes(), &response)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		capabilities = stager.Capabilities{
+			Lifecycles: map[string]map[string]string{
+				"buildpack": {"cflinuxfs2": "buildpack_app_lifecycle/buildpack_app_lifecycle.tgz"},
+				"docker":    {"cflinuxfs2": "docker_app_lifecycle/docker_app_lifecycle.tgz"},
+			},
+			DockerStagingStack: "cflinuxfs2",
+			DockerImageCaching: true,
+		}
+		retryPolicy = handlers.RetryPolicy{}
+		superseder = nil
+		resultCache = nil
+	})
+
+	JustBeforeEach(func() {
+		handler = handlers.New(lagertest.NewTestLogger("test"), &fakes.FakeCcClient{}, &fake_bbs.FakeClient{}, map[string]backend.Backend{}, func() stager.Capabilities { return capabilities }, fakeclock.NewFakeClock(time.Now()), handlers.Options{RetryPolicy: retryPolicy, Superseder: superseder, ResultCache: resultCache})
+
+		getCapabilities()
+	})
+
+	It("serves the configured lifecycles and stacks", func() {
+		Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+		Expect(responseRecorder.Header().Get("Content-Type")).To(Equal("application/json"))
+
+		Expect(response.Lifecycles).To(Equal(capabilities.Lifecycles))
+		Expect(response.DockerStagingStack).To(Equal("cflinuxfs2"))
+		Expect(response.DockerImageCaching).To(BeTrue())
+	})
+
+	It("reports only the features that are always on", func() {
+		Expect(response.Features).To(ConsistOf(stager.FeatureRequestIds, stager.FeatureValidation))
+	})
+
+	Context("when the capabilities change", func() {
+		It("serves the current capabilities", func() {
+			capabilities.Lifecycles = map[string]map[string]string{"buildpack": {"cflinuxfs3": "buildpack_app_lifecycle/cflinuxfs3.tgz"}}
+
+			getCapabilities()
+			Expect(response.Lifecycles).To(Equal(capabilities.Lifecycles))
+		})
+	})
+
+	Context("when optional features are enabled", func() {
+		BeforeEach(func() {
+			retryPolicy = handlers.RetryPolicy{MaxAttempts: 3}
+			superseder = handlers.NewSuperseder(&fake_bbs.FakeClient{}, &fakes.FakeCcClient{}, nil, fakeclock.NewFakeClock(time.Now()), "staging-domain", time.Minute)
+			resultCache = &fake_resultcache.FakeCache{}
+		})
+
+		It("reports them", func() {
+			Expect(response.Features).To(ConsistOf(
+				stager.FeatureRequestIds,
+				stager.FeatureValidation,
+				stager.FeatureRetries,
+				stager.FeatureSupersede,
+				stager.FeatureResultCache,
+			))
+		})
+	})
+})
@@ -7,20 +7,68 @@ import (
 	"code.cloudfoundry.org/clock"
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/stager"
+	"code.cloudfoundry.org/stager/admission"
 	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/canary"
 	"code.cloudfoundry.org/stager/cc_client"
+	"code.cloudfoundry.org/stager/diego_errors"
+	"code.cloudfoundry.org/stager/resultcache"
+	"code.cloudfoundry.org/stager/scheduler"
+	"code.cloudfoundry.org/stager/tracing"
 	"github.com/tedsuo/rata"
 )
 
-func New(logger lager.Logger, ccClient cc_client.CcClient, bbsClient bbs.Client, backends map[string]backend.Backend, clock clock.Clock) http.Handler {
+// Options are the optional collaborators of the staging handlers. Each
+// feature is disabled while its collaborator is left unset.
+type Options struct {
+	AdmissionClient admission.Client
+	CallbackPolicy  cc_client.CallbackPolicy
+	RetryPolicy     RetryPolicy
+	Classifier      *diego_errors.Classifier
+	Superseder      *Superseder
+	ResultCache     resultcache.Cache
+	Scheduler       scheduler.Scheduler
+	Tracer          *tracing.Tracer
+	Canaries        *canary.Tracker
+}
+
+func New(logger lager.Logger, ccClient cc_client.CcClient, bbsClient bbs.Client, backends map[string]backend.Backend, capabilities func() stager.Capabilities, clock clock.Clock, options Options) http.Handler {
+
+	stagingHandler := NewStagingHandler(logger, backends, bbsClient, ccClient, options)
+	stagingCompletedHandler := NewStagingCompletionHandler(logger, ccClient, bbsClient, backends, clock, options)
 
-	stagingHandler := NewStagingHandler(logger, backends, bbsClient)
-	stagingCompletedHandler := NewStagingCompletionHandler(logger, ccClient, backends, clock)
+	features := []string{stager.FeatureRequestIds, stager.FeatureValidation}
+	if options.AdmissionClient != nil {
+		features = append(features, stager.FeatureAdmission)
+	}
+	if options.Superseder != nil {
+		features = append(features, stager.FeatureSupersede)
+	}
+	if options.ResultCache != nil {
+		features = append(features, stager.FeatureResultCache)
+	}
+	if options.Scheduler != nil {
+		features = append(features, stager.FeatureQueueing)
+	}
+	if options.RetryPolicy.MaxAttempts > 1 {
+		features = append(features, stager.FeatureRetries)
+	}
+	if options.Tracer != nil {
+		features = append(features, stager.FeatureTracing)
+	}
+
+	currentCapabilities := func() stager.Capabilities {
+		current := capabilities()
+		current.Features = features
+		return current
+	}
 
 	actions := rata.Handlers{
 		stager.StageRoute:            http.HandlerFunc(stagingHandler.Stage),
 		stager.StopStagingRoute:      http.HandlerFunc(stagingHandler.StopStaging),
 		stager.StagingCompletedRoute: http.HandlerFunc(stagingCompletedHandler.StagingComplete),
+		stager.OpenAPIRoute:          http.HandlerFunc(OpenAPI),
+		stager.CapabilitiesRoute:     NewCapabilitiesHandler(currentCapabilities),
 	}
 
 	handler, err := rata.NewRouter(stager.Routes, actions)
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/diego_errors"
+	"code.cloudfoundry.org/stager/openapi"
+)
+
+// OpenAPI serves the stager's API description.
+func OpenAPI(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Write([]byte(openapi.Document))
+}
+
+// writeValidationError rejects a request that does not match the API
+// description, telling the caller which field is wrong.
+func writeValidationError(resp http.ResponseWriter, description string, err error) {
+	response := cc_messages.StagingResponseForCC{
+		Error: diego_errors.New(diego_errors.CodeInvalidStagingRequest, description+": "+err.Error()).StagingError(),
+	}
+	responseJson, _ := json.Marshal(response)
+
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(http.StatusBadRequest)
+	resp.Write(responseJson)
+}
@@ -0,0 +1,26 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/stager/handlers"
+	"code.cloudfoundry.org/stager/openapi"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OpenAPI", func() {
+	It("serves the API description", func() {
+		req, err := http.NewRequest("GET", "/v1/openapi.json", nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		responseRecorder := httptest.NewRecorder()
+		handlers.OpenAPI(responseRecorder, req)
+
+		Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+		Expect(responseRecorder.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(responseRecorder.Body.String()).To(MatchJSON(openapi.Document))
+	})
+})
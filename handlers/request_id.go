@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+
+	"code.cloudfoundry.org/stager/cc_client"
+	"code.cloudfoundry.org/stager/tracing"
+)
+
+// requestIdFor returns the id CC tagged the request with, or a new one so
+// that stagings requested without one can still be followed in the logs.
+func requestIdFor(req *http.Request) string {
+	if requestId := req.Header.Get(cc_client.RequestIdHeader); requestId != "" {
+		return requestId
+	}
+
+	return newRequestId()
+}
+
+// newRequestId returns a random (version 4) UUID, the format CC uses for its
+// request ids.
+func newRequestId() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// ccHeaders returns the headers for a CC callback about a staging, carrying
+// both its request id and its trace context.
+func ccHeaders(requestId string, trace tracing.SpanContext) http.Header {
+	headers := cc_client.RequestHeaders(requestId)
+	tracing.Inject(trace, headers)
+	return headers
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"os"
+
+	"code.cloudfoundry.org/bbs"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/cc_client"
+	"github.com/tedsuo/ifrit"
+)
+
+// reuseResumer stages from scratch, once at startup, the reuses of cached
+// staging results that a previous run of the stager was interrupted in.
+type reuseResumer struct {
+	logger  lager.Logger
+	handler *stagingHandler
+}
+
+func NewReuseResumer(logger lager.Logger, ccClient cc_client.CcClient, bbsClient bbs.Client, backends map[string]backend.Backend, options Options) ifrit.Runner {
+	return &reuseResumer{
+		logger:  logger.Session("reuse-resumer"),
+		handler: NewStagingHandler(logger, backends, bbsClient, ccClient, options).(*stagingHandler),
+	}
+}
+
+func (r *reuseResumer) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	close(ready)
+
+	if r.handler.resultCache != nil {
+		r.handler.resumeReuses(r.logger)
+	}
+
+	<-signals
+	return nil
+}
@@ -0,0 +1,82 @@
+package handlers_test
+
+import (
+	"errors"
+	"os"
+
+	"code.cloudfoundry.org/bbs/fake_bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/cc_client/fakes"
+	"code.cloudfoundry.org/stager/handlers"
+	"code.cloudfoundry.org/stager/resultcache"
+	fake_resultcache "code.cloudfoundry.org/stager/resultcache/fakes"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ReuseResumer", func() {
+	var (
+		fakeBBSClient   *fake_bbs.FakeClient
+		fakeCCClient    *fakes.FakeCcClient
+		fakeResultCache *fake_resultcache.FakeCache
+
+		process ifrit.Process
+	)
+
+	BeforeEach(func() {
+		fakeBBSClient = &fake_bbs.FakeClient{}
+		fakeCCClient = &fakes.FakeCcClient{}
+		fakeResultCache = &fake_resultcache.FakeCache{}
+
+		fakeResultCache.PendingReturns([]resultcache.PendingReuse{{
+			StagingGuid:    "interrupted-staging-guid",
+			RequestId:      "the-request-id",
+			Request:        cc_messages.StagingRequestFromCC{AppId: "the-app-id", CompletionCallback: "https://cc.example.com/callback"},
+			TaskGuid:       "interrupted-staging-guid",
+			Domain:         "staging-domain",
+			TaskDefinition: &models.TaskDefinition{MemoryMb: 1024},
+		}})
+	})
+
+	JustBeforeEach(func() {
+		runner := handlers.NewReuseResumer(lagertest.NewTestLogger("test"), fakeCCClient, fakeBBSClient, map[string]backend.Backend{}, handlers.Options{ResultCache: fakeResultCache})
+		process = ifrit.Invoke(runner)
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive(BeNil()))
+	})
+
+	It("stages the interrupted reuses from scratch", func() {
+		Eventually(fakeBBSClient.DesireTaskCallCount).Should(Equal(1))
+
+		_, guid, domain, taskDef := fakeBBSClient.DesireTaskArgsForCall(0)
+		Expect(guid).To(Equal("interrupted-staging-guid"))
+		Expect(domain).To(Equal("staging-domain"))
+		Expect(taskDef.MemoryMb).To(BeEquivalentTo(1024))
+	})
+
+	It("forgets the reuses once their tasks are desired", func() {
+		Eventually(fakeResultCache.FinishCallCount).Should(Equal(1))
+		_, stagingGuid := fakeResultCache.FinishArgsForCall(0)
+		Expect(stagingGuid).To(Equal("interrupted-staging-guid"))
+	})
+
+	Context("when the task cannot be desired", func() {
+		BeforeEach(func() {
+			fakeBBSClient.DesireTaskReturns(errors.New("bbs down"))
+		})
+
+		It("reports the failure to CC", func() {
+			Eventually(fakeCCClient.StagingCompleteCallCount).Should(Equal(1))
+			guid, _, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+			Expect(guid).To(Equal("interrupted-staging-guid"))
+		})
+	})
+})
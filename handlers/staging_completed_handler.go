@@ -2,16 +2,26 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"sync"
 	"time"
 
+	"code.cloudfoundry.org/bbs"
 	"code.cloudfoundry.org/bbs/models"
 	"code.cloudfoundry.org/clock"
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/runtimeschema/cc_messages"
 	"code.cloudfoundry.org/runtimeschema/metric"
 	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/canary"
 	"code.cloudfoundry.org/stager/cc_client"
+	"code.cloudfoundry.org/stager/diego_errors"
+	"code.cloudfoundry.org/stager/openapi"
+	"code.cloudfoundry.org/stager/resultcache"
+	"code.cloudfoundry.org/stager/scheduler"
+	"code.cloudfoundry.org/stager/tracing"
 )
 
 const (
@@ -24,98 +34,233 @@ const (
 
 type CompletionHandler interface {
 	StagingComplete(resp http.ResponseWriter, req *http.Request)
+
+	// CompleteTask delivers the result of a completed staging task whose
+	// callback never reached the stager. claim is called once the staging
+	// response has been built, before anything is delivered.
+	CompleteTask(logger lager.Logger, task *models.TaskCallbackResponse, claim func() error) error
 }
 
 type completionHandler struct {
-	ccClient cc_client.CcClient
-	backends map[string]backend.Backend
-	logger   lager.Logger
-	clock    clock.Clock
+	ccClient    cc_client.CcClient
+	bbsClient   bbs.Client
+	backends    map[string]backend.Backend
+	retryPolicy RetryPolicy
+	classifier  *diego_errors.Classifier
+	superseder  *Superseder
+	resultCache resultcache.Cache
+	scheduler   scheduler.Scheduler
+	tracer      *tracing.Tracer
+	canaries    *canary.Tracker
+	logger      lager.Logger
+	clock       clock.Clock
+
+	retryLock      sync.Mutex
+	pendingRetries map[string]time.Time
 }
 
-func NewStagingCompletionHandler(logger lager.Logger, ccClient cc_client.CcClient, backends map[string]backend.Backend, clock clock.Clock) CompletionHandler {
+func NewStagingCompletionHandler(logger lager.Logger, ccClient cc_client.CcClient, bbsClient bbs.Client, backends map[string]backend.Backend, clock clock.Clock, options Options) CompletionHandler {
 	return &completionHandler{
-		ccClient: ccClient,
-		backends: backends,
-		logger:   logger.Session("completion-handler"),
-		clock:    clock,
+		ccClient:    ccClient,
+		bbsClient:   bbsClient,
+		backends:    backends,
+		retryPolicy: options.RetryPolicy,
+		classifier:  options.Classifier,
+		superseder:  options.Superseder,
+		resultCache: options.ResultCache,
+		scheduler:   options.Scheduler,
+		tracer:      options.Tracer,
+		canaries:    options.Canaries,
+		logger:      logger.Session("completion-handler"),
+		clock:       clock,
+
+		pendingRetries: map[string]time.Time{},
 	}
 }
 
 func (handler *completionHandler) StagingComplete(res http.ResponseWriter, req *http.Request) {
 	taskGuid := req.FormValue(":staging_guid")
-	logger := handler.logger.Session("task-complete-callback-received", lager.Data{
-		"guid": taskGuid,
-	})
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		handler.logger.Error("read-body-failed", err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = openapi.ValidateTaskCallback(body)
+	if err != nil {
+		handler.logger.Error("invalid-task-callback", err, lager.Data{"guid": taskGuid})
+		writeValidationError(res, "invalid task callback", err)
+		return
+	}
 
 	task := &models.TaskCallbackResponse{}
-	err := json.NewDecoder(req.Body).Decode(task)
+	err = json.Unmarshal(body, task)
 	if err != nil {
 		handler.logger.Error("parsing-incoming-task-failed", err)
 		res.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
+	annotation, _ := backend.ParseStagingTaskAnnotation(task.Annotation)
+	logger := handler.logger.Session("task-complete-callback-received", lager.Data{
+		"guid":       taskGuid,
+		"request-id": annotation.RequestId,
+	})
+
 	if taskGuid != task.TaskGuid {
 		logger.Error("task-guid-mismatch", err, lager.Data{"body-task-guid": task.TaskGuid})
 		res.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	var annotation cc_messages.StagingTaskAnnotation
-	err = json.Unmarshal([]byte(task.Annotation), &annotation)
+	status, _ := handler.complete(logger, task, nil)
+	res.WriteHeader(status)
+}
+
+func (handler *completionHandler) CompleteTask(logger lager.Logger, task *models.TaskCallbackResponse, claim func() error) error {
+	_, err := handler.complete(logger, task, claim)
+	return err
+}
+
+// complete delivers the result of a staging task to CC, retrying the
+// staging instead if the retry policy allows. It returns the status the
+// completion callback is answered with.
+func (handler *completionHandler) complete(logger lager.Logger, task *models.TaskCallbackResponse, claim func() error) (int, error) {
+	taskGuid := task.TaskGuid
+
+	// deferred so that a retry can take over the budget of the task first
+	if handler.scheduler != nil {
+		defer handler.scheduler.Completed(logger, taskGuid)
+	}
+
+	annotation, err := backend.ParseStagingTaskAnnotation(task.Annotation)
 	if err != nil {
-		res.WriteHeader(http.StatusBadRequest)
 		logger.Error("parsing-annotation-failed", err)
-		return
+		return http.StatusBadRequest, err
 	}
 
+	// a malformed trace context only loses the trace, not the staging result
+	parent, _ := tracing.ParseTraceparent(annotation.Traceparent)
+	span := handler.tracer.Start(parent, "staging-complete", tracing.SpanKindServer)
+	defer span.End()
+	span.SetAttribute("task_guid", taskGuid)
+
 	backend := handler.backends[annotation.Lifecycle]
 	if backend == nil {
-		res.WriteHeader(http.StatusNotFound)
+		err = fmt.Errorf("no backend for lifecycle '%s'", annotation.Lifecycle)
 		logger.Error("get-staging-response-failed-backend-not-found", err)
-		return
+		return http.StatusNotFound, err
 	}
 
 	response, err := backend.BuildStagingResponse(task)
 	if err != nil {
-		res.WriteHeader(http.StatusBadRequest)
 		logger.Error("get-staging-response-failed", err)
-		return
+		return http.StatusBadRequest, err
+	}
+
+	// classified with the operator's rules, like the error reported to CC
+	var failure *diego_errors.Error
+	if task.Failed {
+		failure = handler.classifier.Classify(task.FailureReason)
+	}
+
+	// without a scheduler to persist it, a retry that is not due yet is kept
+	// by leaving the failed task in BBS to deliver its callback again
+	retrying := handler.retryPolicy.shouldRetry(annotation, failure)
+	if retrying && handler.scheduler == nil && !handler.retryDue(logger, taskGuid, annotation) {
+		return http.StatusServiceUnavailable, errRetryBackingOff
+	}
+
+	if claim != nil {
+		err = claim()
+		if err != nil {
+			return http.StatusConflict, err
+		}
+	}
+
+	superseded := handler.superseder.Completed(taskGuid)
+	if superseded && task.Failed && task.FailureReason == diego_errors.ErrTaskCancelled.Message {
+		response.Error = diego_errors.ErrStagingSuperseded.StagingError()
 	}
 
 	responseJson, err := json.Marshal(response)
 	if err != nil {
-		res.WriteHeader(http.StatusBadRequest)
 		logger.Error("get-staging-response-failed", err)
-		return
+		return http.StatusBadRequest, err
 	}
 
-	if responseJson == nil {
-		res.WriteHeader(http.StatusNotFound)
-		res.Write([]byte("Unknown task domain"))
-		return
+	if retrying {
+		err = handler.retry(logger, taskGuid, annotation)
+		if err == nil {
+			return http.StatusOK, nil
+		}
+		logger.Error("staging-retry-failed", err)
 	}
 
 	logger.Info("posting-staging-complete", lager.Data{
 		"payload": responseJson,
 	})
 
-	err = handler.ccClient.StagingComplete(taskGuid, annotation.CompletionCallback, responseJson, logger)
+	ccSpan := handler.tracer.Start(span.Context(), "cc-staging-complete", tracing.SpanKindClient)
+	headers := ccHeaders(annotation.RequestId, ccSpan.Context())
+
+	err = handler.ccClient.StagingComplete(annotation.StagingGuidFor(taskGuid), annotation.CompletionCallback, responseJson, headers, logger)
+	ccSpan.SetError(err)
+	ccSpan.End()
 	if err != nil {
+		span.SetError(err)
 		logger.Error("cc-staging-complete-failed", err)
 		if responseErr, ok := err.(*cc_client.BadResponseError); ok {
-			res.WriteHeader(responseErr.StatusCode)
-		} else {
-			res.WriteHeader(http.StatusServiceUnavailable)
+			return responseErr.StatusCode, err
 		}
-		return
+		return http.StatusServiceUnavailable, err
 	}
 
 	handler.reportMetrics(task)
 
+	// only the attempt reported to CC counts, so that retries and
+	// redelivered callbacks do not
+	if failed, telling := lifecycleBundleFailed(failure, response); telling && annotation.LifecycleBundle != "" {
+		bundle := canary.Key(annotation.LifecycleBundle, annotation.LifecycleBundleChecksum)
+		handler.canaries.Record(logger, bundle, annotation.LifecycleCanary, failed)
+	}
+
+	// results are cached by the primary lifecycle bundle, so those of
+	// canaries are not
+	if handler.resultCache != nil && annotation.CacheKey != "" && !annotation.LifecycleCanary && !task.Failed && response.Error == nil {
+		handler.resultCache.Record(logger, annotation.CacheKey, annotation.StagingGuidFor(taskGuid), responseJson)
+	}
+
 	logger.Info("posted-staging-complete")
-	res.WriteHeader(http.StatusOK)
+	return http.StatusOK, nil
+}
+
+// appFailures are the failures of the app or its buildpacks rather than of
+// the lifecycle that staged it.
+var appFailures = map[diego_errors.Code]bool{
+	diego_errors.CodeBuildpackDetectFailed:  true,
+	diego_errors.CodeBuildpackCompileFailed: true,
+	diego_errors.CodeBuildpackReleaseFailed: true,
+	diego_errors.CodeOutOfMemory:            true,
+	diego_errors.CodeStagingTimedOut:        true,
+	diego_errors.CodeAppBitsDownloadFailed:  true,
+}
+
+// lifecycleBundleFailed reports whether a staging failed in a way the
+// lifecycle bundle may be to blame for, and whether its outcome tells
+// anything about the bundle at all. Tasks that were never placed, were
+// cancelled, or failed because of the app do not.
+func lifecycleBundleFailed(failure *diego_errors.Error, response cc_messages.StagingResponseForCC) (bool, bool) {
+	if failure == nil {
+		return response.Error != nil, true
+	}
+
+	if infrastructureFailures[failure.Code] || appFailures[failure.Code] || failure.Code == diego_errors.CodeTaskCancelled {
+		return false, false
+	}
+	return true, true
 }
 
 func (handler *completionHandler) reportMetrics(task *models.TaskCallbackResponse) {
@@ -11,15 +11,21 @@ import (
 	"strings"
 	"time"
 
+	"code.cloudfoundry.org/bbs/fake_bbs"
 	"code.cloudfoundry.org/bbs/models"
 	"code.cloudfoundry.org/clock/fakeclock"
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/runtimeschema/cc_messages"
 	"code.cloudfoundry.org/stager/backend"
 	"code.cloudfoundry.org/stager/backend/fake_backend"
+	"code.cloudfoundry.org/stager/canary"
 	"code.cloudfoundry.org/stager/cc_client"
 	"code.cloudfoundry.org/stager/cc_client/fakes"
+	"code.cloudfoundry.org/stager/diego_errors"
 	"code.cloudfoundry.org/stager/handlers"
+	fake_resultcache "code.cloudfoundry.org/stager/resultcache/fakes"
+	fake_scheduler "code.cloudfoundry.org/stager/scheduler/fakes"
+	"code.cloudfoundry.org/stager/tracing"
 	"github.com/cloudfoundry/dropsonde/metric_sender/fake"
 	"github.com/cloudfoundry/dropsonde/metrics"
 
@@ -32,6 +38,7 @@ var _ = Describe("StagingCompletedHandler", func() {
 		logger lager.Logger
 
 		fakeCCClient        *fakes.FakeCcClient
+		fakeBBSClient       *fake_bbs.FakeClient
 		fakeBackend         *fake_backend.FakeBackend
 		backendResponse     cc_messages.StagingResponseForCC
 		backendError        error
@@ -52,13 +59,14 @@ var _ = Describe("StagingCompletedHandler", func() {
 		metrics.Initialize(metricSender, nil)
 
 		fakeCCClient = &fakes.FakeCcClient{}
+		fakeBBSClient = &fake_bbs.FakeClient{}
 		fakeBackend = &fake_backend.FakeBackend{}
 		backendError = nil
 
 		fakeClock = fakeclock.NewFakeClock(time.Now())
 
 		responseRecorder = httptest.NewRecorder()
-		handler = handlers.NewStagingCompletionHandler(logger, fakeCCClient, map[string]backend.Backend{"fake": fakeBackend}, fakeClock)
+		handler = handlers.NewStagingCompletionHandler(logger, fakeCCClient, fakeBBSClient, map[string]backend.Backend{"fake": fakeBackend}, fakeClock, handlers.Options{})
 	})
 
 	JustBeforeEach(func() {
@@ -255,10 +263,21 @@ var _ = Describe("StagingCompletedHandler", func() {
 	})
 
 	Context("when a staging task fails", func() {
-		var backendResponseJson []byte
+		var (
+			backendResponseJson []byte
+			failureReason       string
+			taskResponse        *models.TaskCallbackResponse
+		)
+
+		redeliver := func() *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			handler.StagingComplete(recorder, postTask(taskResponse))
+			return recorder
+		}
 
 		BeforeEach(func() {
 			backendResponse = cc_messages.StagingResponseForCC{}
+			failureReason = "because I said so"
 
 			var err error
 			backendResponseJson, err = json.Marshal(backendResponse)
@@ -269,11 +288,11 @@ var _ = Describe("StagingCompletedHandler", func() {
 			createdAt := fakeClock.Now().UnixNano()
 			fakeClock.Increment(stagingDurationNano)
 
-			taskResponse := &models.TaskCallbackResponse{
+			taskResponse = &models.TaskCallbackResponse{
 				TaskGuid:      "the-task-guid",
 				CreatedAt:     createdAt,
 				Failed:        true,
-				FailureReason: "because I said so",
+				FailureReason: failureReason,
 				Result:        `{}`,
 				Annotation: `{
 					"lifecycle": "fake",
@@ -308,6 +327,548 @@ var _ = Describe("StagingCompletedHandler", func() {
 			}))
 
 		})
+
+		Context("when retries are enabled", func() {
+			BeforeEach(func() {
+				handler = handlers.NewStagingCompletionHandler(logger, fakeCCClient, fakeBBSClient, map[string]backend.Backend{"fake": fakeBackend}, fakeClock, handlers.Options{RetryPolicy: handlers.RetryPolicy{MaxAttempts: 2, Backoff: time.Minute}})
+
+				fakeBBSClient.TaskByGuidReturns(&models.Task{
+					TaskGuid: "the-task-guid",
+					Domain:   "staging-domain",
+					TaskDefinition: &models.TaskDefinition{
+						MemoryMb:              1024,
+						CompletionCallbackUrl: "http://stager.example.com/v1/staging/the-task-guid/completed",
+					},
+				}, nil)
+			})
+
+			Context("and the failure is not caused by the infrastructure", func() {
+				BeforeEach(func() {
+					backendResponse = cc_messages.StagingResponseForCC{
+						Error: &cc_messages.StagingError{Id: cc_messages.STAGING_ERROR, Message: "staging failed"},
+					}
+				})
+
+				It("reports the failure to CC right away", func() {
+					Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(1))
+					Expect(fakeBBSClient.TaskByGuidCallCount()).To(Equal(0))
+				})
+
+				Context("but the operator classifies it as an infrastructure failure", func() {
+					BeforeEach(func() {
+						classifier, err := diego_errors.NewClassifier(append([]diego_errors.Rule{
+							{Exact: "because I said so", Code: diego_errors.CodeCellCommunicationError},
+						}, diego_errors.DefaultRules...))
+						Expect(err).NotTo(HaveOccurred())
+
+						handler = handlers.NewStagingCompletionHandler(logger, fakeCCClient, fakeBBSClient, map[string]backend.Backend{"fake": fakeBackend}, fakeClock, handlers.Options{RetryPolicy: handlers.RetryPolicy{MaxAttempts: 2, Backoff: time.Minute}, Classifier: classifier})
+					})
+
+					It("retries the staging", func() {
+						Expect(responseRecorder.Code).To(Equal(http.StatusServiceUnavailable))
+						Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(0))
+
+						fakeClock.WaitForWatcherAndIncrement(time.Minute)
+						Eventually(fakeBBSClient.DesireTaskCallCount).Should(Equal(1))
+					})
+				})
+			})
+
+			Context("and the failure is caused by the infrastructure", func() {
+				BeforeEach(func() {
+					failureReason = "insufficient resources"
+					backendResponse = cc_messages.StagingResponseForCC{
+						Error: &cc_messages.StagingError{Id: cc_messages.INSUFFICIENT_RESOURCES, Message: "insufficient resources"},
+					}
+				})
+
+				It("does not report the failure to CC", func() {
+					Consistently(fakeCCClient.StagingCompleteCallCount).Should(Equal(0))
+				})
+
+				It("refuses the callback while backing off, so BBS keeps the failed task", func() {
+					Expect(responseRecorder.Code).To(Equal(http.StatusServiceUnavailable))
+					Expect(fakeBBSClient.DesireTaskCallCount()).To(Equal(0))
+
+					Expect(redeliver().Code).To(Equal(http.StatusServiceUnavailable))
+					Expect(fakeBBSClient.DesireTaskCallCount()).To(Equal(0))
+				})
+
+				It("re-desires the original task under a derived guid once the backoff has passed", func() {
+					fakeClock.WaitForWatcherAndIncrement(time.Minute)
+					Eventually(fakeBBSClient.DesireTaskCallCount).Should(Equal(1))
+					Expect(fakeBBSClient.TaskByGuidCallCount()).To(Equal(1))
+
+					_, guid, domain, taskDef := fakeBBSClient.DesireTaskArgsForCall(0)
+					Expect(guid).To(Equal("the-task-guid-attempt-2"))
+					Expect(domain).To(Equal("staging-domain"))
+					Expect(taskDef.MemoryMb).To(Equal(int32(1024)))
+					Expect(taskDef.CompletionCallbackUrl).To(Equal("http://stager.example.com/v1/staging/the-task-guid-attempt-2/completed"))
+
+					annotation, err := backend.ParseStagingTaskAnnotation(taskDef.Annotation)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(annotation.Lifecycle).To(Equal("fake"))
+					Expect(annotation.StagingGuid).To(Equal("the-task-guid"))
+					Expect(annotation.Attempt).To(Equal(2))
+				})
+
+				It("answers the callback delivered again once the retry is desired", func() {
+					fakeBBSClient.DesireTaskReturns(models.ErrResourceExists)
+					fakeClock.WaitForWatcherAndIncrement(time.Minute)
+					Eventually(fakeBBSClient.DesireTaskCallCount).Should(Equal(1))
+
+					Expect(redeliver().Code).To(Equal(http.StatusOK))
+					Expect(fakeBBSClient.DesireTaskCallCount()).To(Equal(2))
+					Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(0))
+					retries := func() uint64 { return metricSender.GetCounter("StagingRequestsRetried") }
+					Eventually(retries).Should(BeEquivalentTo(1))
+					Consistently(retries).Should(BeEquivalentTo(1))
+				})
+
+				Context("when retries are not backed off", func() {
+					BeforeEach(func() {
+						handler = handlers.NewStagingCompletionHandler(logger, fakeCCClient, fakeBBSClient, map[string]backend.Backend{"fake": fakeBackend}, fakeClock, handlers.Options{RetryPolicy: handlers.RetryPolicy{MaxAttempts: 2}})
+					})
+
+					It("re-desires the task before answering the callback", func() {
+						Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+						Expect(fakeBBSClient.DesireTaskCallCount()).To(Equal(1))
+					})
+
+					Context("when a redelivered callback already desired the retry", func() {
+						BeforeEach(func() {
+							fakeBBSClient.DesireTaskReturns(models.ErrResourceExists)
+						})
+
+						It("answers the callback without reporting the failure to CC", func() {
+							Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+							Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(0))
+						})
+					})
+				})
+
+				Context("when the task cannot be re-desired", func() {
+					BeforeEach(func() {
+						fakeBBSClient.DesireTaskReturns(errors.New("bbs down"))
+					})
+
+					It("reports the original failure to CC once the retry is due", func() {
+						fakeClock.WaitForWatcherAndIncrement(time.Minute)
+						Eventually(fakeBBSClient.DesireTaskCallCount).Should(Equal(1))
+
+						Expect(redeliver().Code).To(Equal(http.StatusOK))
+						Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(1))
+
+						guid, payload, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+						Expect(guid).To(Equal("the-task-guid"))
+						Expect(payload).To(MatchJSON(`{"error":{"id":"InsufficientResources","message":"insufficient resources"}}`))
+					})
+				})
+
+				Context("when a scheduler is configured", func() {
+					var fakeScheduler *fake_scheduler.FakeScheduler
+
+					BeforeEach(func() {
+						fakeScheduler = &fake_scheduler.FakeScheduler{}
+						handler = handlers.NewStagingCompletionHandler(logger, fakeCCClient, fakeBBSClient, map[string]backend.Backend{"fake": fakeBackend}, fakeClock, handlers.Options{RetryPolicy: handlers.RetryPolicy{MaxAttempts: 2, Backoff: time.Minute}, Scheduler: fakeScheduler})
+					})
+
+					It("queues the retry with the scheduler, backed off and accounted to the failed attempt's tenant", func() {
+						Expect(fakeBBSClient.DesireTaskCallCount()).To(Equal(0))
+						Expect(fakeScheduler.ResubmitCallCount()).To(Equal(1))
+
+						_, previousTaskGuid, job := fakeScheduler.ResubmitArgsForCall(0)
+						Expect(previousTaskGuid).To(Equal("the-task-guid"))
+						Expect(job.TaskGuid).To(Equal("the-task-guid-attempt-2"))
+						Expect(job.StagingGuid).To(Equal("the-task-guid"))
+						Expect(job.Domain).To(Equal("staging-domain"))
+						Expect(job.NotBefore).To(Equal(fakeClock.Now().Add(time.Minute).UnixNano()))
+					})
+
+					Context("when the retry was already queued for an earlier delivery", func() {
+						BeforeEach(func() {
+							fakeScheduler.ResubmitReturns(models.ErrResourceExists)
+						})
+
+						It("answers the callback without reporting the failure to CC", func() {
+							Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+							Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(0))
+						})
+					})
+
+					Context("when the scheduler cannot queue the retry", func() {
+						BeforeEach(func() {
+							fakeScheduler.ResubmitReturns(errors.New("disk full"))
+						})
+
+						It("reports the original failure to CC and returns the budget", func() {
+							Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(1))
+							Expect(fakeScheduler.CompletedCallCount()).To(Equal(1))
+						})
+					})
+				})
+			})
+		})
+	})
+
+	Context("when the last attempt of a retried staging task completes", func() {
+		JustBeforeEach(func() {
+			handler = handlers.NewStagingCompletionHandler(logger, fakeCCClient, fakeBBSClient, map[string]backend.Backend{"fake": fakeBackend}, fakeClock, handlers.Options{RetryPolicy: handlers.RetryPolicy{MaxAttempts: 2, Backoff: time.Minute}})
+
+			taskResponse := &models.TaskCallbackResponse{
+				TaskGuid:      "the-task-guid-attempt-2",
+				Failed:        true,
+				FailureReason: "insufficient resources",
+				Annotation:    `{"lifecycle": "fake", "staging_guid": "the-task-guid", "attempt": 2}`,
+			}
+
+			handler.StagingComplete(responseRecorder, postTask(taskResponse))
+		})
+
+		BeforeEach(func() {
+			backendResponse = cc_messages.StagingResponseForCC{
+				Error: &cc_messages.StagingError{Id: cc_messages.INSUFFICIENT_RESOURCES, Message: "insufficient resources"},
+			}
+		})
+
+		It("reports the outcome to CC under the original staging guid", func() {
+			Expect(fakeBBSClient.TaskByGuidCallCount()).To(Equal(0))
+			Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(1))
+			guid, _, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+			Expect(guid).To(Equal("the-task-guid"))
+		})
+	})
+
+	Context("when superseding stagings is enabled and a cancelled task completes", func() {
+		var (
+			taskResponse *models.TaskCallbackResponse
+			superseder   *handlers.Superseder
+		)
+
+		BeforeEach(func() {
+			superseder = handlers.NewSuperseder(fakeBBSClient, fakeCCClient, nil, fakeClock, "staging-domain", time.Minute)
+			handler = handlers.NewStagingCompletionHandler(logger, fakeCCClient, fakeBBSClient, map[string]backend.Backend{"fake": fakeBackend}, fakeClock, handlers.Options{Superseder: superseder})
+
+			taskResponse = &models.TaskCallbackResponse{
+				TaskGuid:      "the-task-guid",
+				Failed:        true,
+				FailureReason: "task was cancelled",
+				Annotation:    `{"lifecycle": "fake", "app_id": "the-app"}`,
+				CreatedAt:     100,
+			}
+
+			backendResponse = cc_messages.StagingResponseForCC{
+				Error: &cc_messages.StagingError{Id: cc_messages.STAGING_ERROR, Message: "staging failed"},
+			}
+
+			superseder.Track("the-app", "the-task-guid")
+		})
+
+		JustBeforeEach(func() {
+			handler.StagingComplete(responseRecorder, postTask(taskResponse))
+		})
+
+		Context("when the stager cancelled it for a newer staging of the same app", func() {
+			BeforeEach(func() {
+				superseder.Supersede(logger, "the-app", "newer-task-guid")
+				_, cancelledGuid := fakeBBSClient.CancelTaskArgsForCall(0)
+				Expect(cancelledGuid).To(Equal("the-task-guid"))
+			})
+
+			It("reports the staging as superseded", func() {
+				Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(1))
+				_, payload, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+				Expect(payload).To(MatchJSON(`{"error":{"id":"StagingError","message":"staging superseded by a newer staging of the same app"}}`))
+			})
+
+			It("forgets the task", func() {
+				Expect(superseder.Completed("the-task-guid")).To(BeFalse())
+			})
+
+			Context("when the task failed for another reason", func() {
+				BeforeEach(func() {
+					taskResponse.FailureReason = "out of memory"
+				})
+
+				It("reports the original failure", func() {
+					_, payload, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+					Expect(payload).To(MatchJSON(`{"error":{"id":"StagingError","message":"staging failed"}}`))
+				})
+			})
+		})
+
+		Context("when the task was cancelled by the user", func() {
+			BeforeEach(func() {
+				superseder.Track("the-app", "newer-task-guid")
+			})
+
+			It("reports the original failure, even if a newer staging of the same app exists", func() {
+				Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(1))
+				_, payload, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+				Expect(payload).To(MatchJSON(`{"error":{"id":"StagingError","message":"staging failed"}}`))
+			})
+		})
+	})
+
+	Context("when a scheduler is configured", func() {
+		var fakeScheduler *fake_scheduler.FakeScheduler
+
+		BeforeEach(func() {
+			fakeScheduler = &fake_scheduler.FakeScheduler{}
+			handler = handlers.NewStagingCompletionHandler(logger, fakeCCClient, fakeBBSClient, map[string]backend.Backend{"fake": fakeBackend}, fakeClock, handlers.Options{Scheduler: fakeScheduler})
+			backendResponse = cc_messages.StagingResponseForCC{}
+		})
+
+		JustBeforeEach(func() {
+			handler.StagingComplete(responseRecorder, postTask(&models.TaskCallbackResponse{
+				TaskGuid:   "the-task-guid",
+				Annotation: `{"lifecycle": "fake"}`,
+			}))
+		})
+
+		It("returns the task's share of the concurrency budget", func() {
+			Expect(fakeScheduler.CompletedCallCount()).To(Equal(1))
+			_, taskGuid := fakeScheduler.CompletedArgsForCall(0)
+			Expect(taskGuid).To(Equal("the-task-guid"))
+		})
+	})
+
+	Context("when the task annotation carries a trace context", func() {
+		BeforeEach(func() {
+			backendResponse = cc_messages.StagingResponseForCC{}
+		})
+
+		JustBeforeEach(func() {
+			handler.StagingComplete(responseRecorder, postTask(&models.TaskCallbackResponse{
+				TaskGuid:   "the-task-guid",
+				Annotation: `{"lifecycle": "fake", "traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}`,
+			}))
+		})
+
+		It("continues the trace on the callback to CC", func() {
+			Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(1))
+
+			trace := tracing.Extract(fakeCCClient.StagingCompleteHeadersForCall(0))
+			Expect(trace.TraceID.String()).To(Equal("0af7651916cd43dd8448eb211c80319c"))
+			Expect(trace.SpanID.String()).NotTo(Equal("b7ad6b7169203331"))
+		})
+	})
+
+	Context("when the task annotation carries a request id", func() {
+		BeforeEach(func() {
+			backendResponse = cc_messages.StagingResponseForCC{}
+		})
+
+		JustBeforeEach(func() {
+			handler.StagingComplete(responseRecorder, postTask(&models.TaskCallbackResponse{
+				TaskGuid:   "the-task-guid",
+				Annotation: `{"lifecycle": "fake", "request_id": "the-request-id"}`,
+			}))
+		})
+
+		It("sends the request id on the callback to CC", func() {
+			Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(1))
+			Expect(fakeCCClient.StagingCompleteHeadersForCall(0).Get("X-Vcap-Request-Id")).To(Equal("the-request-id"))
+		})
+	})
+
+	Context("when a result cache is configured", func() {
+		var (
+			fakeResultCache *fake_resultcache.FakeCache
+			taskResponse    *models.TaskCallbackResponse
+		)
+
+		BeforeEach(func() {
+			fakeResultCache = &fake_resultcache.FakeCache{}
+			handler = handlers.NewStagingCompletionHandler(logger, fakeCCClient, fakeBBSClient, map[string]backend.Backend{"fake": fakeBackend}, fakeClock, handlers.Options{ResultCache: fakeResultCache})
+
+			taskResponse = &models.TaskCallbackResponse{
+				TaskGuid:   "the-task-guid",
+				Annotation: `{"lifecycle": "fake", "cache_key": "the-cache-key"}`,
+				Result:     `{}`,
+			}
+
+			backendResponse = cc_messages.StagingResponseForCC{}
+		})
+
+		JustBeforeEach(func() {
+			handler.StagingComplete(responseRecorder, postTask(taskResponse))
+		})
+
+		It("records the result reported to CC", func() {
+			Expect(fakeResultCache.RecordCallCount()).To(Equal(1))
+
+			_, key, stagingGuid, response := fakeResultCache.RecordArgsForCall(0)
+			Expect(key).To(Equal("the-cache-key"))
+			Expect(stagingGuid).To(Equal("the-task-guid"))
+
+			_, payload, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+			Expect(response).To(Equal(payload))
+		})
+
+		Context("when the staging failed", func() {
+			BeforeEach(func() {
+				taskResponse.Failed = true
+				taskResponse.FailureReason = "out of memory"
+				backendResponse = cc_messages.StagingResponseForCC{
+					Error: &cc_messages.StagingError{Id: cc_messages.STAGING_ERROR, Message: "staging failed"},
+				}
+			})
+
+			It("does not record the result", func() {
+				Expect(fakeResultCache.RecordCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when reporting to CC fails", func() {
+			BeforeEach(func() {
+				fakeCCClient.StagingCompleteReturns(errors.New("boom"))
+			})
+
+			It("does not record the result", func() {
+				Expect(fakeResultCache.RecordCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the staging ran on a canary lifecycle bundle", func() {
+			BeforeEach(func() {
+				taskResponse.Annotation = `{"lifecycle": "fake", "cache_key": "the-cache-key", "lifecycle_bundle": "canary.tgz", "lifecycle_canary": true}`
+			})
+
+			It("does not record the result", func() {
+				Expect(fakeResultCache.RecordCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the task has no cache key", func() {
+			BeforeEach(func() {
+				taskResponse.Annotation = `{"lifecycle": "fake"}`
+			})
+
+			It("does not record the result", func() {
+				Expect(fakeResultCache.RecordCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Context("when the staging ran with a lifecycle that has a canary", func() {
+		var (
+			tracker      *canary.Tracker
+			taskResponse *models.TaskCallbackResponse
+		)
+
+		BeforeEach(func() {
+			tracker = canary.NewTracker(0.5, 1, 1)
+			handler = handlers.NewStagingCompletionHandler(logger, fakeCCClient, fakeBBSClient, map[string]backend.Backend{"fake": fakeBackend}, fakeClock, handlers.Options{Canaries: tracker})
+
+			taskResponse = &models.TaskCallbackResponse{
+				TaskGuid:   "the-task-guid",
+				Annotation: `{"lifecycle": "fake", "lifecycle_bundle": "canary.tgz", "lifecycle_canary": true}`,
+				Result:     `{}`,
+			}
+
+			backendResponse = cc_messages.StagingResponseForCC{}
+		})
+
+		JustBeforeEach(func() {
+			handler.StagingComplete(responseRecorder, postTask(taskResponse))
+		})
+
+		It("records the outcome against the bundle", func() {
+			Expect(tracker.FailureRate("canary.tgz")).To(BeZero())
+			Expect(tracker.RolledBack("canary.tgz")).To(BeFalse())
+		})
+
+		Context("when the staging failed", func() {
+			BeforeEach(func() {
+				taskResponse.Failed = true
+				taskResponse.FailureReason = "staging failed"
+				backendResponse = cc_messages.StagingResponseForCC{
+					Error: &cc_messages.StagingError{Id: cc_messages.STAGING_ERROR, Message: "staging failed"},
+				}
+			})
+
+			It("rolls the canary back once it fails too often", func() {
+				Expect(tracker.FailureRate("canary.tgz")).To(Equal(1.0))
+				Expect(tracker.RolledBack("canary.tgz")).To(BeTrue())
+			})
+
+			Context("when the bundle has a checksum", func() {
+				BeforeEach(func() {
+					taskResponse.Annotation = `{"lifecycle": "fake", "lifecycle_bundle": "canary.tgz", "lifecycle_bundle_checksum": "sha256:abc", "lifecycle_canary": true}`
+				})
+
+				It("rolls back only the bundle with that checksum", func() {
+					Expect(tracker.RolledBack(canary.Key("canary.tgz", "sha256:abc"))).To(BeTrue())
+					Expect(tracker.RolledBack(canary.Key("canary.tgz", "sha256:def"))).To(BeFalse())
+				})
+			})
+		})
+
+		Context("when the task could not be placed", func() {
+			BeforeEach(func() {
+				taskResponse.Failed = true
+				taskResponse.FailureReason = "insufficient resources"
+				backendResponse = cc_messages.StagingResponseForCC{
+					Error: &cc_messages.StagingError{Id: cc_messages.INSUFFICIENT_RESOURCES, Message: "insufficient resources"},
+				}
+			})
+
+			It("does not blame the bundle", func() {
+				Expect(tracker.RolledBack("canary.tgz")).To(BeFalse())
+			})
+		})
+
+		Context("when the staging failed because of the app", func() {
+			BeforeEach(func() {
+				taskResponse.Failed = true
+				taskResponse.FailureReason = "Exited with status 137 (out of memory)"
+				backendResponse = cc_messages.StagingResponseForCC{
+					Error: &cc_messages.StagingError{Id: cc_messages.STAGING_ERROR, Message: "staging ran out of memory"},
+				}
+			})
+
+			It("does not count the staging against the bundle", func() {
+				Expect(tracker.FailureRate("canary.tgz")).To(BeZero())
+				Expect(tracker.RolledBack("canary.tgz")).To(BeFalse())
+			})
+		})
+
+		Context("when the result cannot be reported to CC", func() {
+			BeforeEach(func() {
+				taskResponse.Failed = true
+				taskResponse.FailureReason = "staging failed"
+				backendResponse = cc_messages.StagingResponseForCC{
+					Error: &cc_messages.StagingError{Id: cc_messages.STAGING_ERROR, Message: "staging failed"},
+				}
+				fakeCCClient.StagingCompleteReturns(errors.New("cc down"))
+			})
+
+			It("leaves counting the staging to the redelivered callback", func() {
+				Expect(tracker.RolledBack("canary.tgz")).To(BeFalse())
+			})
+		})
+
+		Context("when the operator classifies the failure as an infrastructure failure", func() {
+			BeforeEach(func() {
+				classifier, err := diego_errors.NewClassifier(append([]diego_errors.Rule{
+					{Prefix: "cell vanished", Code: diego_errors.CodeCellCommunicationError},
+				}, diego_errors.DefaultRules...))
+				Expect(err).NotTo(HaveOccurred())
+
+				handler = handlers.NewStagingCompletionHandler(logger, fakeCCClient, fakeBBSClient, map[string]backend.Backend{"fake": fakeBackend}, fakeClock, handlers.Options{Classifier: classifier, Canaries: tracker})
+
+				taskResponse.Failed = true
+				taskResponse.FailureReason = "cell vanished mid-staging"
+				backendResponse = cc_messages.StagingResponseForCC{
+					Error: &cc_messages.StagingError{Id: cc_messages.CELL_COMMUNICATION_ERROR, Message: "cell vanished mid-staging"},
+				}
+			})
+
+			It("does not blame the bundle", func() {
+				Expect(tracker.RolledBack("canary.tgz")).To(BeFalse())
+			})
+		})
 	})
 
 	Context("when a non-staging task is reported", func() {
@@ -327,6 +888,26 @@ var _ = Describe("StagingCompletedHandler", func() {
 		})
 	})
 
+	Context("when the task does not match the API description", func() {
+		JustBeforeEach(func() {
+			request, err := http.NewRequest("POST", "/v1/staging/the-task-guid/completed", strings.NewReader(`{"task_guid":"the-task-guid","failed":"yes"}`))
+			Expect(err).NotTo(HaveOccurred())
+
+			request.Form = url.Values{":staging_guid": {"the-task-guid"}}
+
+			handler.StagingComplete(responseRecorder, request)
+		})
+
+		It("does not build a staging response", func() {
+			Expect(fakeBackend.BuildStagingResponseCallCount()).To(Equal(0))
+		})
+
+		It("responds with a 400 naming the invalid field", func() {
+			Expect(responseRecorder.Code).To(Equal(400))
+			Expect(responseRecorder.Body.String()).To(ContainSubstring("invalid task callback: failed: must be a boolean"))
+		})
+	})
+
 	Context("when invalid JSON is posted instead of a task", func() {
 		JustBeforeEach(func() {
 			request, err := http.NewRequest("POST", "/v1/staging/an-invalid-guid/completed", strings.NewReader("{"))
@@ -10,7 +10,14 @@ import (
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/runtimeschema/cc_messages"
 	"code.cloudfoundry.org/runtimeschema/metric"
+	"code.cloudfoundry.org/stager/admission"
 	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/cc_client"
+	"code.cloudfoundry.org/stager/diego_errors"
+	"code.cloudfoundry.org/stager/openapi"
+	"code.cloudfoundry.org/stager/resultcache"
+	"code.cloudfoundry.org/stager/scheduler"
+	"code.cloudfoundry.org/stager/tracing"
 )
 
 const (
@@ -24,28 +31,52 @@ type StagingHandler interface {
 }
 
 type stagingHandler struct {
-	logger      lager.Logger
-	backends    map[string]backend.Backend
-	diegoClient bbs.Client
+	logger           lager.Logger
+	backends         map[string]backend.Backend
+	diegoClient      bbs.Client
+	ccClient         cc_client.CcClient
+	admissionClient  admission.Client
+	callbackPolicy   cc_client.CallbackPolicy
+	superseder       *Superseder
+	resultCache      resultcache.Cache
+	stagingScheduler scheduler.Scheduler
+	tracer           *tracing.Tracer
+	classifier       *diego_errors.Classifier
 }
 
 func NewStagingHandler(
 	logger lager.Logger,
 	backends map[string]backend.Backend,
 	bbsClient bbs.Client,
+	ccClient cc_client.CcClient,
+	options Options,
 ) StagingHandler {
 	logger = logger.Session("staging-handler")
 
 	return &stagingHandler{
-		logger:      logger,
-		backends:    backends,
-		diegoClient: bbsClient,
+		logger:           logger,
+		backends:         backends,
+		diegoClient:      bbsClient,
+		ccClient:         ccClient,
+		admissionClient:  options.AdmissionClient,
+		callbackPolicy:   options.CallbackPolicy,
+		superseder:       options.Superseder,
+		resultCache:      options.ResultCache,
+		stagingScheduler: options.Scheduler,
+		tracer:           options.Tracer,
+		classifier:       options.Classifier,
 	}
 }
 
 func (handler *stagingHandler) Stage(resp http.ResponseWriter, req *http.Request) {
 	stagingGuid := req.FormValue(":staging_guid")
-	logger := handler.logger.Session("staging-request", lager.Data{"staging-guid": stagingGuid})
+	requestId := requestIdFor(req)
+	logger := handler.logger.Session("staging-request", lager.Data{"staging-guid": stagingGuid, "request-id": requestId})
+	resp.Header().Set(cc_client.RequestIdHeader, requestId)
+
+	span := handler.tracer.Start(tracing.Extract(req.Header), "stage", tracing.SpanKindServer)
+	defer span.End()
+	span.SetAttribute("staging_guid", stagingGuid)
 
 	requestBody, err := ioutil.ReadAll(req.Body)
 	if err != nil {
@@ -54,6 +85,13 @@ func (handler *stagingHandler) Stage(resp http.ResponseWriter, req *http.Request
 		return
 	}
 
+	err = openapi.ValidateStagingRequest(requestBody)
+	if err != nil {
+		logger.Error("invalid-staging-request", err)
+		writeValidationError(resp, "invalid staging request", err)
+		return
+	}
+
 	var stagingRequest cc_messages.StagingRequestFromCC
 	err = json.Unmarshal(requestBody, &stagingRequest)
 	if err != nil {
@@ -62,13 +100,35 @@ func (handler *stagingHandler) Stage(resp http.ResponseWriter, req *http.Request
 		return
 	}
 
+	span.SetAttribute("app_id", stagingRequest.AppId)
+	span.SetAttribute("lifecycle", stagingRequest.Lifecycle)
+
+	if handler.admissionClient != nil {
+		admittedRequest, err := handler.admissionClient.Admit(logger, stagingGuid, stagingRequest)
+		if err != nil {
+			logger.Error("staging-request-not-admitted", err)
+			backend.EmitStagingRejection(logger, handler.classifier, stagingRequest.LogGuid, err)
+			handler.doErrorResponse(resp, err)
+			return
+		}
+		stagingRequest = admittedRequest
+	}
+
+	err = handler.callbackPolicy.Validate(stagingRequest.CompletionCallback)
+	if err != nil {
+		logger.Error("invalid-completion-callback", err, lager.Data{"completion-callback": stagingRequest.CompletionCallback})
+		backend.EmitStagingRejection(logger, handler.classifier, stagingRequest.LogGuid, err)
+		handler.doErrorResponse(resp, err)
+		return
+	}
+
 	envNames := []string{}
 	for _, envVar := range stagingRequest.Environment {
 		envNames = append(envNames, envVar.Name)
 	}
 	logger.Info("environment", lager.Data{"keys": envNames})
 
-	backend, ok := handler.backends[stagingRequest.Lifecycle]
+	stagingBackend, ok := handler.backends[stagingRequest.Lifecycle]
 	if !ok {
 		logger.Error("backend-not-found", err, lager.Data{"backend": stagingRequest.Lifecycle})
 		resp.WriteHeader(http.StatusNotFound)
@@ -77,35 +137,189 @@ func (handler *stagingHandler) Stage(resp http.ResponseWriter, req *http.Request
 
 	StagingStartRequestsReceivedCounter.Increment()
 
-	taskDef, guid, domain, err := backend.BuildRecipe(stagingGuid, stagingRequest)
+	taskDef, guid, domain, err := stagingBackend.BuildRecipe(requestId, stagingGuid, stagingRequest)
 	if err != nil {
 		logger.Error("recipe-building-failed", err, lager.Data{"staging-request": stagingRequest})
-		handler.doErrorResponse(resp, err.Error())
+		span.SetError(err)
+		backend.EmitStagingRejection(logger, handler.classifier, stagingRequest.LogGuid, err)
+		handler.doErrorResponse(resp, err)
 		return
 	}
 
+	// the completion handler continues the request's logs and trace from the
+	// annotation
+	err = updateAnnotation(taskDef, func(annotation *backend.StagingTaskAnnotation) {
+		annotation.RequestId = requestId
+		if span.Context().IsValid() {
+			annotation.Traceparent = span.Context().Traceparent()
+		}
+	})
+	if err != nil {
+		logger.Error("failed-to-record-request-context", err)
+	}
+
+	reuse := resultcache.PendingReuse{
+		StagingGuid:    stagingGuid,
+		RequestId:      requestId,
+		Request:        stagingRequest,
+		TaskGuid:       guid,
+		Domain:         domain,
+		TaskDefinition: taskDef,
+	}
+
+	// a reuse that cannot be journalled could be lost to a restart, so the
+	// request is staged from scratch instead
+	entry, cached := handler.lookupStagingResult(logger, stagingRequest, taskDef)
+	if cached && handler.resultCache.Begin(logger, reuse) == nil {
+		go handler.reuseStagingResult(logger, span.Context(), entry, reuse)
+	} else {
+		err = handler.desireTask(logger, requestId, span.Context(), stagingGuid, stagingRequest, guid, domain, taskDef)
+		if err != nil {
+			logger.Error("staging-failed", err, lager.Data{"staging-request": stagingRequest})
+			span.SetError(err)
+			backend.EmitStagingRejection(logger, handler.classifier, stagingRequest.LogGuid, err)
+			handler.doErrorResponse(resp, err)
+			return
+		}
+	}
+
+	handler.superseder.Supersede(logger, stagingRequest.AppId, guid)
+
+	resp.WriteHeader(http.StatusAccepted)
+}
+
+// desireTask desires the staging task on the BBS, or queues it with the
+// scheduler if one is configured.
+func (handler *stagingHandler) desireTask(logger lager.Logger, requestId string, trace tracing.SpanContext, stagingGuid string, request cc_messages.StagingRequestFromCC, guid, domain string, taskDef *models.TaskDefinition) error {
+	if handler.stagingScheduler != nil {
+		logger.Info("queueing-task", lager.Data{"task_guid": guid})
+
+		return handler.stagingScheduler.Submit(logger, request, scheduler.Job{
+			TaskGuid:           guid,
+			Domain:             domain,
+			StagingGuid:        stagingGuid,
+			CompletionCallback: request.CompletionCallback,
+			RequestId:          requestId,
+			TaskDefinition:     taskDef,
+		})
+	}
+
 	logger.Info("desiring-task", lager.Data{
 		"task_guid":    guid,
 		"callback_url": taskDef.CompletionCallbackUrl,
 	})
 
-	err = handler.diegoClient.DesireTask(logger, guid, domain, taskDef)
+	span := handler.tracer.Start(trace, "desire-task", tracing.SpanKindClient)
+	defer span.End()
+	span.SetAttribute("task_guid", guid)
+
+	err := handler.diegoClient.DesireTask(logger, guid, domain, taskDef)
 	if models.ErrResourceExists.Equal(err) {
-		err = nil
+		return nil
+	}
+	span.SetError(err)
+	return err
+}
+
+// lookupStagingResult records the cache key of the request in the task
+// annotation, so that a successful result can be cached on completion, and
+// returns the cached result of an earlier identical staging if there is one.
+func (handler *stagingHandler) lookupStagingResult(logger lager.Logger, request cc_messages.StagingRequestFromCC, taskDef *models.TaskDefinition) (resultcache.Entry, bool) {
+	if handler.resultCache == nil {
+		return resultcache.Entry{}, false
+	}
+
+	key, ok := handler.resultCache.Key(request)
+	if !ok {
+		return resultcache.Entry{}, false
 	}
 
+	err := updateAnnotation(taskDef, func(annotation *backend.StagingTaskAnnotation) {
+		annotation.CacheKey = key
+	})
+	if err != nil {
+		logger.Error("failed-to-record-cache-key", err)
+		return resultcache.Entry{}, false
+	}
+
+	return handler.resultCache.Lookup(logger, key)
+}
+
+// reuseStagingResult copies the droplet of a cached staging and reports its
+// result to CC, falling back to staging from scratch if either fails. The
+// journalled reuse is forgotten once CC or the staging task has taken over.
+func (handler *stagingHandler) reuseStagingResult(logger lager.Logger, trace tracing.SpanContext, entry resultcache.Entry, reuse resultcache.PendingReuse) {
+	logger = logger.Session("reuse-staging-result", lager.Data{"cached-staging-guid": entry.StagingGuid})
+	defer handler.resultCache.Finish(logger, reuse.StagingGuid)
+
+	err := handler.resultCache.Reuse(logger, entry, reuse.Request)
 	if err != nil {
-		logger.Error("staging-failed", err, lager.Data{"staging-request": stagingRequest})
-		handler.doErrorResponse(resp, err.Error())
+		logger.Error("failed-to-reuse-staging-result", err)
+		handler.stageFromScratch(logger, trace, reuse)
 		return
 	}
 
-	resp.WriteHeader(http.StatusAccepted)
+	err = handler.ccClient.StagingComplete(reuse.StagingGuid, reuse.Request.CompletionCallback, entry.Response, ccHeaders(reuse.RequestId, trace), logger)
+	if err != nil {
+		logger.Error("cc-staging-complete-failed", err)
+		handler.stageFromScratch(logger, trace, reuse)
+		return
+	}
+
+	logger.Info("posted-cached-staging-complete")
 }
 
-func (handler *stagingHandler) doErrorResponse(resp http.ResponseWriter, message string) {
+// resumeReuses stages from scratch the reuses an earlier run of the stager
+// did not see through, since whether CC got their results is unknown.
+func (handler *stagingHandler) resumeReuses(logger lager.Logger) {
+	for _, reuse := range handler.resultCache.Pending(logger) {
+		reuseLogger := logger.Session("resume-reuse", lager.Data{"staging-guid": reuse.StagingGuid, "request-id": reuse.RequestId})
+		handler.stageFromScratch(reuseLogger, tracing.SpanContext{}, reuse)
+		handler.resultCache.Finish(reuseLogger, reuse.StagingGuid)
+	}
+}
+
+// stageFromScratch desires the staging task of a reuse that could not be
+// completed, or reports to CC why it could not be desired either.
+func (handler *stagingHandler) stageFromScratch(logger lager.Logger, trace tracing.SpanContext, reuse resultcache.PendingReuse) {
+	err := handler.desireTask(logger, reuse.RequestId, trace, reuse.StagingGuid, reuse.Request, reuse.TaskGuid, reuse.Domain, reuse.TaskDefinition)
+	if err == nil {
+		return
+	}
+
+	logger.Error("staging-failed", err)
+	backend.EmitStagingRejection(logger, handler.classifier, reuse.Request.LogGuid, err)
+	response := cc_messages.StagingResponseForCC{
+		Error: backend.SanitizeError(handler.classifier, err),
+	}
+	responseJson, _ := json.Marshal(response)
+
+	err = handler.ccClient.StagingComplete(reuse.StagingGuid, reuse.Request.CompletionCallback, responseJson, ccHeaders(reuse.RequestId, trace), logger)
+	if err != nil {
+		logger.Error("cc-staging-complete-failed", err)
+	}
+}
+
+func updateAnnotation(taskDef *models.TaskDefinition, update func(*backend.StagingTaskAnnotation)) error {
+	annotation, err := backend.ParseStagingTaskAnnotation(taskDef.Annotation)
+	if err != nil {
+		return err
+	}
+
+	update(&annotation)
+
+	annotationJson, err := json.Marshal(annotation)
+	if err != nil {
+		return err
+	}
+
+	taskDef.Annotation = string(annotationJson)
+	return nil
+}
+
+func (handler *stagingHandler) doErrorResponse(resp http.ResponseWriter, err error) {
 	response := cc_messages.StagingResponseForCC{
-		Error: backend.SanitizeErrorMessage(message),
+		Error: backend.SanitizeError(handler.classifier, err),
 	}
 	responseJson, _ := json.Marshal(response)
 
@@ -115,9 +329,19 @@ func (handler *stagingHandler) doErrorResponse(resp http.ResponseWriter, message
 
 func (handler *stagingHandler) StopStaging(resp http.ResponseWriter, req *http.Request) {
 	taskGuid := req.FormValue(":staging_guid")
-	logger := handler.logger.Session("stop-staging-request", lager.Data{"staging-guid": taskGuid})
+	logger := handler.logger.Session("stop-staging-request", lager.Data{"staging-guid": taskGuid, "request-id": requestIdFor(req)})
+
+	if handler.stagingScheduler != nil {
+		if job, ok := handler.stagingScheduler.Remove(logger, taskGuid); ok {
+			resp.WriteHeader(http.StatusAccepted)
+			StagingStopRequestsReceivedCounter.Increment()
 
-	task, err := handler.diegoClient.TaskByGuid(logger, taskGuid)
+			handler.reportQueuedStagingCancelled(logger, job)
+			return
+		}
+	}
+
+	task, err := handler.currentAttempt(logger, taskGuid)
 	if err != nil {
 		if models.ErrResourceNotFound.Equal(err) {
 			resp.WriteHeader(http.StatusNotFound)
@@ -128,9 +352,9 @@ func (handler *stagingHandler) StopStaging(resp http.ResponseWriter, req *http.R
 		resp.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	taskGuid = task.TaskGuid
 
-	var annotation cc_messages.StagingTaskAnnotation
-	err = json.Unmarshal([]byte(task.Annotation), &annotation)
+	_, err = backend.ParseStagingTaskAnnotation(task.Annotation)
 	if err != nil {
 		logger.Error("failed-to-unmarshal-task-annotation", err)
 		resp.WriteHeader(http.StatusInternalServerError)
@@ -147,3 +371,62 @@ func (handler *stagingHandler) StopStaging(resp http.ResponseWriter, req *http.R
 		logger.Error("stop-staging-failed", err)
 	}
 }
+
+// currentAttempt finds the task of a staging that is still in flight. Once
+// the first attempt has finished, a retry runs under a derived task guid and
+// is only found by its annotation.
+func (handler *stagingHandler) currentAttempt(logger lager.Logger, stagingGuid string) (*models.Task, error) {
+	task, err := handler.diegoClient.TaskByGuid(logger, stagingGuid)
+	if err != nil && !models.ErrResourceNotFound.Equal(err) {
+		return nil, err
+	}
+	if err == nil && inFlight(task) {
+		return task, nil
+	}
+
+	tasks, scanErr := handler.diegoClient.TasksByDomain(logger, cc_messages.StagingTaskDomain)
+	if scanErr != nil {
+		if err == nil {
+			logger.Error("failed-to-fetch-retried-tasks", scanErr)
+			return task, nil
+		}
+		return nil, scanErr
+	}
+
+	var current *models.Task
+	currentAttempt := 0
+	for _, candidate := range tasks {
+		if candidate.TaskDefinition == nil || !inFlight(candidate) {
+			continue
+		}
+
+		annotation, parseErr := backend.ParseStagingTaskAnnotation(candidate.Annotation)
+		if parseErr != nil || annotation.StagingGuid != stagingGuid {
+			continue
+		}
+
+		if annotation.AttemptNumber() > currentAttempt {
+			current = candidate
+			currentAttempt = annotation.AttemptNumber()
+		}
+	}
+
+	if current != nil {
+		return current, nil
+	}
+	return task, err
+}
+
+// reportQueuedStagingCancelled tells CC about a staging that was stopped
+// before its task was desired, since no task callback will do so.
+func (handler *stagingHandler) reportQueuedStagingCancelled(logger lager.Logger, job scheduler.Job) {
+	response := cc_messages.StagingResponseForCC{
+		Error: diego_errors.ErrTaskCancelled.StagingError(),
+	}
+	responseJson, _ := json.Marshal(response)
+
+	err := handler.ccClient.StagingComplete(job.StagingGuid, job.CompletionCallback, responseJson, cc_client.RequestHeaders(job.RequestId), logger)
+	if err != nil {
+		logger.Error("cc-staging-complete-failed", err)
+	}
+}
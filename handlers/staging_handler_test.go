@@ -7,15 +7,29 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"time"
 
 	"code.cloudfoundry.org/bbs/fake_bbs"
 	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock/fakeclock"
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/lager/lagertest"
 	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/admission"
+	fake_admission "code.cloudfoundry.org/stager/admission/fakes"
 	"code.cloudfoundry.org/stager/backend"
 	"code.cloudfoundry.org/stager/backend/fake_backend"
+	"code.cloudfoundry.org/stager/cc_client"
+	fake_cc_client "code.cloudfoundry.org/stager/cc_client/fakes"
+	"code.cloudfoundry.org/stager/diego_errors"
 	"code.cloudfoundry.org/stager/handlers"
+	"code.cloudfoundry.org/stager/resultcache"
+	fake_resultcache "code.cloudfoundry.org/stager/resultcache/fakes"
+	"code.cloudfoundry.org/stager/scheduler"
+	fake_scheduler "code.cloudfoundry.org/stager/scheduler/fakes"
+	"code.cloudfoundry.org/stager/tracing"
+	fake_log_sender "github.com/cloudfoundry/dropsonde/log_sender/fake"
+	"github.com/cloudfoundry/dropsonde/logs"
 	fake_metric_sender "github.com/cloudfoundry/dropsonde/metric_sender/fake"
 	"github.com/cloudfoundry/dropsonde/metrics"
 
@@ -28,9 +42,11 @@ var _ = Describe("StagingHandler", func() {
 
 	var (
 		fakeMetricSender *fake_metric_sender.FakeMetricSender
+		fakeLogSender    *fake_log_sender.FakeLogSender
 
 		logger          lager.Logger
 		fakeDiegoClient *fake_bbs.FakeClient
+		fakeCCClient    *fake_cc_client.FakeCcClient
 		fakeBackend     *fake_backend.FakeBackend
 
 		responseRecorder *httptest.ResponseRecorder
@@ -43,24 +59,34 @@ var _ = Describe("StagingHandler", func() {
 		fakeMetricSender = fake_metric_sender.NewFakeMetricSender()
 		metrics.Initialize(fakeMetricSender, nil)
 
+		fakeLogSender = fake_log_sender.NewFakeLogSender()
+		logs.Initialize(fakeLogSender)
+
 		fakeBackend = &fake_backend.FakeBackend{}
 		fakeBackend.BuildRecipeReturns(&models.TaskDefinition{}, "", "", nil)
 
 		fakeDiegoClient = &fake_bbs.FakeClient{}
+		fakeCCClient = &fake_cc_client.FakeCcClient{}
 
 		responseRecorder = httptest.NewRecorder()
-		handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeDiegoClient)
+		handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeDiegoClient, fakeCCClient, handlers.Options{CallbackPolicy: cc_client.CallbackPolicy{BaseURI: "https://cc.example.com"}})
 	})
 
 	Describe("Stage", func() {
 		var (
 			stagingRequestJson []byte
+			requestHeader      http.Header
 		)
 
+		BeforeEach(func() {
+			requestHeader = http.Header{}
+		})
+
 		JustBeforeEach(func() {
 			req, err := http.NewRequest("PUT", "/v1/staging/a-staging-guid", bytes.NewReader(stagingRequestJson))
 			Expect(err).NotTo(HaveOccurred())
 
+			req.Header = requestHeader
 			req.Form = url.Values{":staging_guid": {"a-staging-guid"}}
 
 			handler.Stage(responseRecorder, req)
@@ -72,6 +98,7 @@ var _ = Describe("StagingHandler", func() {
 			BeforeEach(func() {
 				stagingRequest = cc_messages.StagingRequestFromCC{
 					AppId:     "myapp",
+					LogGuid:   "the-log-guid",
 					Lifecycle: "fake-backend",
 				}
 
@@ -91,14 +118,106 @@ var _ = Describe("StagingHandler", func() {
 			It("builds a staging recipe", func() {
 				Expect(fakeBackend.BuildRecipeCallCount()).To(Equal(1))
 
-				guid, request := fakeBackend.BuildRecipeArgsForCall(0)
+				_, guid, request := fakeBackend.BuildRecipeArgsForCall(0)
 				Expect(guid).To(Equal("a-staging-guid"))
 				Expect(request).To(Equal(stagingRequest))
 			})
 
+			Context("when the completion callback is not allowed", func() {
+				BeforeEach(func() {
+					stagingRequest.CompletionCallback = "https://evil.example.com/steal"
+
+					var err error
+					stagingRequestJson, err = json.Marshal(stagingRequest)
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				It("does not desire a task", func() {
+					Expect(fakeBackend.BuildRecipeCallCount()).To(Equal(0))
+					Expect(fakeDiegoClient.DesireTaskCallCount()).To(Equal(0))
+				})
+
+				It("returns a staging error", func() {
+					Expect(responseRecorder.Code).To(Equal(http.StatusInternalServerError))
+
+					var response cc_messages.StagingResponseForCC
+					err := json.NewDecoder(responseRecorder.Body).Decode(&response)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(response.Error).To(Equal(&cc_messages.StagingError{
+						Id:      cc_messages.STAGING_ERROR,
+						Message: cc_client.ErrCompletionCallbackNotAllowed.Error(),
+					}))
+				})
+
+				It("tells the user why staging failed in the app's logs", func() {
+					appLogs := fakeLogSender.GetLogs()
+					Expect(appLogs).To(HaveLen(1))
+					Expect(appLogs[0].AppId).To(Equal("the-log-guid"))
+					Expect(appLogs[0].Message).To(Equal("Staging failed: " + cc_client.ErrCompletionCallbackNotAllowed.Error()))
+				})
+			})
+
+			Context("when an admission client is configured", func() {
+				var fakeAdmissionClient *fake_admission.FakeClient
+
+				BeforeEach(func() {
+					fakeAdmissionClient = &fake_admission.FakeClient{}
+					fakeAdmissionClient.AdmitStub = func(_ lager.Logger, _ string, request cc_messages.StagingRequestFromCC) (cc_messages.StagingRequestFromCC, error) {
+						request.MemoryMB = 512
+						return request, nil
+					}
+
+					handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeDiegoClient, fakeCCClient, handlers.Options{AdmissionClient: fakeAdmissionClient})
+				})
+
+				It("reviews the staging request", func() {
+					Expect(fakeAdmissionClient.AdmitCallCount()).To(Equal(1))
+					_, guid, request := fakeAdmissionClient.AdmitArgsForCall(0)
+					Expect(guid).To(Equal("a-staging-guid"))
+					Expect(request).To(Equal(stagingRequest))
+				})
+
+				It("builds the recipe from the admitted request", func() {
+					Expect(fakeBackend.BuildRecipeCallCount()).To(Equal(1))
+					_, _, request := fakeBackend.BuildRecipeArgsForCall(0)
+					Expect(request.MemoryMB).To(Equal(512))
+				})
+
+				Context("when the request is denied", func() {
+					BeforeEach(func() {
+						fakeAdmissionClient.AdmitReturns(cc_messages.StagingRequestFromCC{}, &admission.DeniedError{Message: "no docker in prod"})
+					})
+
+					It("does not build a recipe", func() {
+						Expect(fakeBackend.BuildRecipeCallCount()).To(Equal(0))
+						Expect(fakeDiegoClient.DesireTaskCallCount()).To(Equal(0))
+					})
+
+					It("tells the user about the denial in the app's logs", func() {
+						appLogs := fakeLogSender.GetLogs()
+						Expect(appLogs).To(HaveLen(1))
+						Expect(appLogs[0].AppId).To(Equal("the-log-guid"))
+						Expect(appLogs[0].Message).To(Equal("Staging failed: staging request denied: no docker in prod"))
+					})
+
+					It("returns the denial to the cloud controller", func() {
+						Expect(responseRecorder.Code).To(Equal(http.StatusInternalServerError))
+
+						var response cc_messages.StagingResponseForCC
+						err := json.NewDecoder(responseRecorder.Body).Decode(&response)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(response.Error).To(Equal(&cc_messages.StagingError{
+							Id:      cc_messages.STAGING_ERROR,
+							Message: "staging request denied: no docker in prod",
+						}))
+					})
+				})
+			})
+
 			Context("when the recipe was built successfully", func() {
-				var fakeTaskDef = &models.TaskDefinition{Annotation: "test annotation"}
+				var fakeTaskDef *models.TaskDefinition
 				BeforeEach(func() {
+					fakeTaskDef = &models.TaskDefinition{Annotation: "test annotation"}
 					fakeBackend.BuildRecipeReturns(fakeTaskDef, "a-guid", "a-domain", nil)
 				})
 
@@ -108,6 +227,364 @@ var _ = Describe("StagingHandler", func() {
 					Expect(resultingTaskDef).To(Equal(fakeTaskDef))
 				})
 
+				It("does not look for stagings to supersede", func() {
+					Expect(fakeDiegoClient.TasksByDomainCallCount()).To(Equal(0))
+				})
+
+				Context("when a scheduler is configured", func() {
+					var fakeScheduler *fake_scheduler.FakeScheduler
+
+					BeforeEach(func() {
+						fakeScheduler = &fake_scheduler.FakeScheduler{}
+						handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeDiegoClient, fakeCCClient, handlers.Options{Scheduler: fakeScheduler})
+						requestHeader.Set("X-Vcap-Request-Id", "the-request-id")
+					})
+
+					It("queues the task instead of desiring it", func() {
+						Expect(fakeDiegoClient.DesireTaskCallCount()).To(Equal(0))
+						Expect(fakeScheduler.SubmitCallCount()).To(Equal(1))
+
+						_, request, job := fakeScheduler.SubmitArgsForCall(0)
+						Expect(request).To(Equal(stagingRequest))
+						Expect(job).To(Equal(scheduler.Job{
+							TaskGuid:           "a-guid",
+							Domain:             "a-domain",
+							StagingGuid:        "a-staging-guid",
+							CompletionCallback: stagingRequest.CompletionCallback,
+							RequestId:          "the-request-id",
+							TaskDefinition:     fakeTaskDef,
+						}))
+					})
+
+					It("returns an Accepted response", func() {
+						Expect(responseRecorder.Code).To(Equal(http.StatusAccepted))
+					})
+
+					Context("when queueing the task fails", func() {
+						BeforeEach(func() {
+							fakeScheduler.SubmitReturns(errors.New("disk full"))
+						})
+
+						It("returns an internal service error status code", func() {
+							Expect(responseRecorder.Code).To(Equal(http.StatusInternalServerError))
+						})
+
+						It("tells the user in the app's logs", func() {
+							appLogs := fakeLogSender.GetLogs()
+							Expect(appLogs).To(HaveLen(1))
+							Expect(appLogs[0].Message).To(Equal("Staging failed: the staging task could not be started"))
+						})
+					})
+				})
+
+				Context("when a result cache is configured", func() {
+					var fakeResultCache *fake_resultcache.FakeCache
+
+					BeforeEach(func() {
+						fakeTaskDef.Annotation = `{"lifecycle":"fake-backend"}`
+
+						fakeResultCache = &fake_resultcache.FakeCache{}
+						fakeResultCache.KeyReturns("the-cache-key", true)
+
+						handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeDiegoClient, fakeCCClient, handlers.Options{ResultCache: fakeResultCache})
+					})
+
+					It("records the cache key in the task annotation", func() {
+						Expect(fakeResultCache.LookupCallCount()).To(Equal(1))
+						_, key := fakeResultCache.LookupArgsForCall(0)
+						Expect(key).To(Equal("the-cache-key"))
+
+						_, _, _, taskDef := fakeDiegoClient.DesireTaskArgsForCall(0)
+						annotation, err := backend.ParseStagingTaskAnnotation(taskDef.Annotation)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(annotation.CacheKey).To(Equal("the-cache-key"))
+						Expect(annotation.Lifecycle).To(Equal("fake-backend"))
+					})
+
+					Context("when the request cannot be cached", func() {
+						BeforeEach(func() {
+							fakeResultCache.KeyReturns("", false)
+						})
+
+						It("desires the task without looking up a result", func() {
+							Expect(fakeResultCache.LookupCallCount()).To(Equal(0))
+							Expect(fakeDiegoClient.DesireTaskCallCount()).To(Equal(1))
+						})
+					})
+
+					Context("when a cached result exists", func() {
+						BeforeEach(func() {
+							fakeResultCache.LookupReturns(resultcache.Entry{
+								StagingGuid: "cached-staging-guid",
+								Response:    []byte(`{"result":{"cached":true}}`),
+							}, true)
+						})
+
+						It("does not desire a task", func() {
+							Consistently(fakeDiegoClient.DesireTaskCallCount).Should(Equal(0))
+						})
+
+						It("returns an Accepted response", func() {
+							Expect(responseRecorder.Code).To(Equal(http.StatusAccepted))
+						})
+
+						It("copies the cached droplet and reports the cached result to CC", func() {
+							Eventually(fakeCCClient.StagingCompleteCallCount).Should(Equal(1))
+
+							_, entry, _ := fakeResultCache.ReuseArgsForCall(0)
+							Expect(entry.StagingGuid).To(Equal("cached-staging-guid"))
+
+							guid, payload, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+							Expect(guid).To(Equal("a-staging-guid"))
+							Expect(payload).To(MatchJSON(`{"result":{"cached":true}}`))
+						})
+
+						It("journals the reuse until CC has the result", func() {
+							Expect(fakeResultCache.BeginCallCount()).To(Equal(1))
+							_, reuse := fakeResultCache.BeginArgsForCall(0)
+							Expect(reuse.StagingGuid).To(Equal("a-staging-guid"))
+							Expect(reuse.TaskDefinition).NotTo(BeNil())
+
+							Eventually(fakeResultCache.FinishCallCount).Should(Equal(1))
+							_, stagingGuid := fakeResultCache.FinishArgsForCall(0)
+							Expect(stagingGuid).To(Equal("a-staging-guid"))
+						})
+
+						Context("when the reuse cannot be journalled", func() {
+							BeforeEach(func() {
+								fakeResultCache.BeginReturns(errors.New("disk full"))
+							})
+
+							It("stages from scratch before answering", func() {
+								Expect(fakeDiegoClient.DesireTaskCallCount()).To(Equal(1))
+								Consistently(fakeResultCache.ReuseCallCount).Should(Equal(0))
+							})
+						})
+
+						Context("when the cached result cannot be reported to CC", func() {
+							BeforeEach(func() {
+								fakeCCClient.StagingCompleteReturns(errors.New("cc down"))
+							})
+
+							It("stages from scratch", func() {
+								Eventually(fakeDiegoClient.DesireTaskCallCount).Should(Equal(1))
+								Eventually(fakeResultCache.FinishCallCount).Should(Equal(1))
+							})
+						})
+
+						Context("when the cached droplet cannot be copied", func() {
+							BeforeEach(func() {
+								fakeResultCache.ReuseReturns(errors.New("droplet is gone"))
+							})
+
+							It("stages from scratch", func() {
+								Eventually(fakeDiegoClient.DesireTaskCallCount).Should(Equal(1))
+								Consistently(fakeCCClient.StagingCompleteCallCount).Should(Equal(0))
+							})
+
+							Context("when desiring the task fails", func() {
+								BeforeEach(func() {
+									fakeDiegoClient.DesireTaskReturns(errors.New("boom"))
+								})
+
+								It("reports the failure to CC", func() {
+									Eventually(fakeCCClient.StagingCompleteCallCount).Should(Equal(1))
+
+									_, payload, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+									Expect(payload).To(MatchJSON(`{"error":{"id":"StagingError","message":"staging failed"}}`))
+								})
+							})
+						})
+					})
+				})
+
+				Context("when the request carries a trace context", func() {
+					BeforeEach(func() {
+						fakeTaskDef.Annotation = `{"lifecycle":"fake-backend"}`
+						requestHeader.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+					})
+
+					It("records the continued trace in the task annotation", func() {
+						_, _, _, taskDef := fakeDiegoClient.DesireTaskArgsForCall(0)
+						annotation, err := backend.ParseStagingTaskAnnotation(taskDef.Annotation)
+						Expect(err).NotTo(HaveOccurred())
+
+						trace, err := tracing.ParseTraceparent(annotation.Traceparent)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(trace.TraceID.String()).To(Equal("0af7651916cd43dd8448eb211c80319c"))
+						Expect(trace.SpanID.String()).NotTo(Equal("b7ad6b7169203331"))
+					})
+				})
+
+				Context("when the request carries no trace context", func() {
+					BeforeEach(func() {
+						fakeTaskDef.Annotation = `{"lifecycle":"fake-backend"}`
+					})
+
+					It("does not record a trace when tracing is disabled", func() {
+						_, _, _, taskDef := fakeDiegoClient.DesireTaskArgsForCall(0)
+						annotation, err := backend.ParseStagingTaskAnnotation(taskDef.Annotation)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(annotation.Traceparent).To(BeEmpty())
+					})
+				})
+
+				Context("when the request carries a request id", func() {
+					BeforeEach(func() {
+						fakeTaskDef.Annotation = `{"lifecycle":"fake-backend"}`
+						requestHeader.Set("X-Vcap-Request-Id", "the-request-id")
+					})
+
+					It("records the request id in the task annotation", func() {
+						_, _, _, taskDef := fakeDiegoClient.DesireTaskArgsForCall(0)
+						annotation, err := backend.ParseStagingTaskAnnotation(taskDef.Annotation)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(annotation.RequestId).To(Equal("the-request-id"))
+					})
+
+					It("returns the request id", func() {
+						Expect(responseRecorder.Header().Get("X-Vcap-Request-Id")).To(Equal("the-request-id"))
+					})
+
+					It("passes the request id to the backend", func() {
+						requestId, _, _ := fakeBackend.BuildRecipeArgsForCall(0)
+						Expect(requestId).To(Equal("the-request-id"))
+					})
+
+					It("logs the request id", func() {
+						Expect(logger).To(gbytes.Say(`"request-id":"the-request-id"`))
+					})
+				})
+
+				Context("when the request carries no request id", func() {
+					BeforeEach(func() {
+						fakeTaskDef.Annotation = `{"lifecycle":"fake-backend"}`
+					})
+
+					It("records a generated request id in the task annotation", func() {
+						_, _, _, taskDef := fakeDiegoClient.DesireTaskArgsForCall(0)
+						annotation, err := backend.ParseStagingTaskAnnotation(taskDef.Annotation)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(annotation.RequestId).To(MatchRegexp(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`))
+						Expect(responseRecorder.Header().Get("X-Vcap-Request-Id")).To(Equal(annotation.RequestId))
+					})
+				})
+
+				Context("when superseding stagings is enabled", func() {
+					var superseder *handlers.Superseder
+
+					BeforeEach(func() {
+						superseder = handlers.NewSuperseder(fakeDiegoClient, fakeCCClient, nil, fakeclock.NewFakeClock(time.Now()), "staging-domain", time.Minute)
+						handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeDiegoClient, fakeCCClient, handlers.Options{Superseder: superseder})
+
+						fakeDiegoClient.TasksByDomainReturns([]*models.Task{
+							{TaskGuid: "a-guid", State: models.Task_Pending, TaskDefinition: &models.TaskDefinition{Annotation: `{"lifecycle":"fake-backend","app_id":"myapp"}`}},
+							{TaskGuid: "older-pending-guid", State: models.Task_Pending, TaskDefinition: &models.TaskDefinition{Annotation: `{"lifecycle":"fake-backend","app_id":"myapp"}`}},
+							{TaskGuid: "older-running-guid", State: models.Task_Running, TaskDefinition: &models.TaskDefinition{Annotation: `{"lifecycle":"fake-backend","app_id":"myapp"}`}},
+							{TaskGuid: "older-completed-guid", State: models.Task_Completed, TaskDefinition: &models.TaskDefinition{Annotation: `{"lifecycle":"fake-backend","app_id":"myapp"}`}},
+							{TaskGuid: "other-app-guid", State: models.Task_Running, TaskDefinition: &models.TaskDefinition{Annotation: `{"lifecycle":"fake-backend","app_id":"other-app"}`}},
+						}, nil)
+					})
+
+					It("looks up the tasks in the staging domain", func() {
+						Expect(fakeDiegoClient.TasksByDomainCallCount()).To(Equal(1))
+						_, domain := fakeDiegoClient.TasksByDomainArgsForCall(0)
+						Expect(domain).To(Equal("staging-domain"))
+					})
+
+					It("cancels the in-flight stagings of the same app", func() {
+						Expect(fakeDiegoClient.CancelTaskCallCount()).To(Equal(2))
+						_, firstGuid := fakeDiegoClient.CancelTaskArgsForCall(0)
+						_, secondGuid := fakeDiegoClient.CancelTaskArgsForCall(1)
+						Expect([]string{firstGuid, secondGuid}).To(ConsistOf("older-pending-guid", "older-running-guid"))
+					})
+
+					It("increments the superseded counter", func() {
+						Expect(fakeMetricSender.GetCounter("StagingRequestsSuperseded")).To(Equal(uint64(2)))
+					})
+
+					It("returns an Accepted response", func() {
+						Expect(responseRecorder.Code).To(Equal(http.StatusAccepted))
+					})
+
+					It("remembers the tasks it cancelled", func() {
+						Expect(superseder.Completed("older-pending-guid")).To(BeTrue())
+						Expect(superseder.Completed("a-guid")).To(BeFalse())
+					})
+
+					Context("when the app is staged again before the next resync", func() {
+						JustBeforeEach(func() {
+							superseder.Supersede(logger, "myapp", "newest-guid")
+						})
+
+						It("supersedes the tasks it indexed without scanning the domain again", func() {
+							Expect(fakeDiegoClient.TasksByDomainCallCount()).To(Equal(1))
+							Expect(fakeDiegoClient.CancelTaskCallCount()).To(Equal(3))
+							_, guid := fakeDiegoClient.CancelTaskArgsForCall(2)
+							Expect(guid).To(Equal("a-guid"))
+						})
+					})
+
+					Context("when cancelling a superseded task fails", func() {
+						BeforeEach(func() {
+							fakeDiegoClient.CancelTaskReturns(errors.New("boom"))
+						})
+
+						It("logs the failure and still accepts the request", func() {
+							Expect(logger).To(gbytes.Say("failed-to-cancel-superseded-task"))
+							Expect(responseRecorder.Code).To(Equal(http.StatusAccepted))
+						})
+
+						It("does not remember the task as cancelled", func() {
+							Expect(superseder.Completed("older-pending-guid")).To(BeFalse())
+						})
+					})
+
+					Context("when listing the tasks fails", func() {
+						BeforeEach(func() {
+							fakeDiegoClient.TasksByDomainReturns(nil, errors.New("boom"))
+						})
+
+						It("still accepts the request", func() {
+							Expect(fakeDiegoClient.CancelTaskCallCount()).To(Equal(0))
+							Expect(responseRecorder.Code).To(Equal(http.StatusAccepted))
+						})
+					})
+
+					Context("when a scheduler is configured", func() {
+						var fakeScheduler *fake_scheduler.FakeScheduler
+
+						BeforeEach(func() {
+							fakeScheduler = &fake_scheduler.FakeScheduler{}
+							fakeScheduler.RemoveAppReturns([]scheduler.Job{{
+								TaskGuid:           "older-queued-guid",
+								StagingGuid:        "older-queued-guid",
+								CompletionCallback: "https://cc.example.com/callback",
+								RequestId:          "older-request-id",
+								AppId:              "myapp",
+							}})
+
+							superseder = handlers.NewSuperseder(fakeDiegoClient, fakeCCClient, fakeScheduler, fakeclock.NewFakeClock(time.Now()), "staging-domain", time.Minute)
+							handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeDiegoClient, fakeCCClient, handlers.Options{Superseder: superseder, Scheduler: fakeScheduler})
+						})
+
+						It("takes the queued stagings of the app off the queue", func() {
+							Expect(fakeScheduler.RemoveAppCallCount()).To(Equal(1))
+							_, appId, exceptTaskGuid := fakeScheduler.RemoveAppArgsForCall(0)
+							Expect(appId).To(Equal("myapp"))
+							Expect(exceptTaskGuid).To(Equal("a-guid"))
+						})
+
+						It("reports the queued stagings to CC as superseded", func() {
+							Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(1))
+							guid, payload, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+							Expect(guid).To(Equal("older-queued-guid"))
+							Expect(payload).To(MatchJSON(`{"error":{"id":"StagingError","message":"staging superseded by a newer staging of the same app"}}`))
+							Expect(fakeCCClient.StagingCompleteHeadersForCall(0).Get("X-Vcap-Request-Id")).To(Equal("older-request-id"))
+						})
+					})
+				})
+
 				Context("when the task has already been created", func() {
 					BeforeEach(func() {
 						fakeDiegoClient.DesireTaskReturns(models.NewError(models.Error_ResourceExists, "ok, this task already exists"))
@@ -139,7 +616,7 @@ var _ = Describe("StagingHandler", func() {
 
 						BeforeEach(func() {
 							responseForCC = cc_messages.StagingResponseForCC{
-								Error: backend.SanitizeErrorMessage(desireError.Error()),
+								Error: &cc_messages.StagingError{Id: cc_messages.STAGING_ERROR, Message: "staging failed"},
 							}
 						})
 
@@ -152,6 +629,23 @@ var _ = Describe("StagingHandler", func() {
 							Expect(response).To(Equal(responseForCC))
 						})
 					})
+
+					Context("when the operator classifies the failure", func() {
+						BeforeEach(func() {
+							classifier, err := diego_errors.NewClassifier([]diego_errors.Rule{
+								{Contains: "task create error", Code: diego_errors.CodeInsufficientResources, Message: "bbs is out of capacity"},
+							})
+							Expect(err).NotTo(HaveOccurred())
+
+							handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeDiegoClient, fakeCCClient, handlers.Options{Classifier: classifier})
+						})
+
+						It("reports the failure as the operator classified it", func() {
+							var response cc_messages.StagingResponseForCC
+							Expect(json.NewDecoder(responseRecorder.Body).Decode(&response)).To(Succeed())
+							Expect(response.Error).To(Equal(&cc_messages.StagingError{Id: cc_messages.INSUFFICIENT_RESOURCES, Message: "bbs is out of capacity"}))
+						})
+					})
 				})
 			})
 
@@ -167,6 +661,27 @@ var _ = Describe("StagingHandler", func() {
 					Expect(logger).To(gbytes.Say("recipe-building-failed"))
 				})
 
+				It("tells the user why staging failed in the app's logs", func() {
+					appLogs := fakeLogSender.GetLogs()
+					Expect(appLogs).To(HaveLen(1))
+					Expect(appLogs[0].AppId).To(Equal("the-log-guid"))
+					Expect(appLogs[0].SourceType).To(Equal("STG"))
+					Expect(appLogs[0].MessageType).To(Equal("ERR"))
+					Expect(appLogs[0].Message).To(Equal("Staging failed: the staging task could not be started"))
+				})
+
+				Context("when the failure is the stager's own", func() {
+					BeforeEach(func() {
+						fakeBackend.BuildRecipeReturns(&models.TaskDefinition{}, "", "", backend.ErrNoCompilerDefined)
+					})
+
+					It("explains it in the app's logs", func() {
+						appLogs := fakeLogSender.GetLogs()
+						Expect(appLogs).To(HaveLen(1))
+						Expect(appLogs[0].Message).To(Equal("Staging failed: no compiler defined for requested stack"))
+					})
+				})
+
 				It("returns an internal service error status code", func() {
 					Expect(responseRecorder.Code).To(Equal(http.StatusInternalServerError))
 				})
@@ -176,7 +691,7 @@ var _ = Describe("StagingHandler", func() {
 
 					BeforeEach(func() {
 						responseForCC = cc_messages.StagingResponseForCC{
-							Error: backend.SanitizeErrorMessage(buildRecipeError.Error()),
+							Error: &cc_messages.StagingError{Id: cc_messages.STAGING_ERROR, Message: "staging failed"},
 						}
 					})
 
@@ -229,6 +744,28 @@ var _ = Describe("StagingHandler", func() {
 					Expect(responseRecorder.Code).To(Equal(http.StatusBadRequest))
 				})
 			})
+
+			Context("when the staging request does not match the API description", func() {
+				BeforeEach(func() {
+					stagingRequestJson = []byte(`{"app_id":"myapp","lifecycle":"fake-backend","memory_mb":"lots"}`)
+				})
+
+				It("does not build a recipe", func() {
+					Expect(fakeBackend.BuildRecipeCallCount()).To(Equal(0))
+				})
+
+				It("returns a BadRequest error naming the invalid field", func() {
+					Expect(responseRecorder.Code).To(Equal(http.StatusBadRequest))
+
+					var response cc_messages.StagingResponseForCC
+					err := json.Unmarshal(responseRecorder.Body.Bytes(), &response)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(response.Error).To(Equal(&cc_messages.StagingError{
+						Id:      cc_messages.STAGING_ERROR,
+						Message: "invalid staging request: memory_mb: must be a number",
+					}))
+				})
+			})
 		})
 	})
 
@@ -251,6 +788,51 @@ var _ = Describe("StagingHandler", func() {
 			handler.StopStaging(responseRecorder, req)
 		})
 
+		Context("when a scheduler is configured", func() {
+			var fakeScheduler *fake_scheduler.FakeScheduler
+
+			BeforeEach(func() {
+				fakeScheduler = &fake_scheduler.FakeScheduler{}
+				handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeDiegoClient, fakeCCClient, handlers.Options{Scheduler: fakeScheduler})
+			})
+
+			Context("when the staging task is still queued", func() {
+				BeforeEach(func() {
+					fakeScheduler.RemoveReturns(scheduler.Job{
+						TaskGuid:           "a-staging-guid",
+						StagingGuid:        "a-staging-guid",
+						CompletionCallback: "https://cc.example.com/callback",
+					}, true)
+				})
+
+				It("takes it off the queue without touching the BBS", func() {
+					Expect(fakeScheduler.RemoveCallCount()).To(Equal(1))
+					_, taskGuid := fakeScheduler.RemoveArgsForCall(0)
+					Expect(taskGuid).To(Equal("a-staging-guid"))
+
+					Expect(fakeDiegoClient.TaskByGuidCallCount()).To(Equal(0))
+					Expect(fakeDiegoClient.CancelTaskCallCount()).To(Equal(0))
+				})
+
+				It("reports the cancellation to CC", func() {
+					Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(1))
+					guid, payload, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+					Expect(guid).To(Equal("a-staging-guid"))
+					Expect(payload).To(MatchJSON(`{"error":{"id":"StagingError","message":"task was cancelled"}}`))
+				})
+
+				It("returns an Accepted response", func() {
+					Expect(responseRecorder.Code).To(Equal(http.StatusAccepted))
+				})
+			})
+
+			Context("when the staging task is not queued", func() {
+				It("cancels the Diego task", func() {
+					Expect(fakeDiegoClient.CancelTaskCallCount()).To(Equal(1))
+				})
+			})
+		})
+
 		Context("when receiving a stop staging request", func() {
 			It("retrieves the current staging task by guid", func() {
 				Expect(fakeDiegoClient.TaskByGuidCallCount()).To(Equal(1))
@@ -268,6 +850,33 @@ var _ = Describe("StagingHandler", func() {
 				})
 			})
 
+			Context("when the staging has been retried", func() {
+				BeforeEach(func() {
+					fakeDiegoClient.TaskByGuidReturns(&models.Task{}, models.ErrResourceNotFound)
+					fakeDiegoClient.TasksByDomainReturns([]*models.Task{
+						{TaskGuid: "a-staging-guid-attempt-2", State: models.Task_Completed, TaskDefinition: &models.TaskDefinition{Annotation: `{"lifecycle":"fake-backend","staging_guid":"a-staging-guid","attempt":2}`}},
+						{TaskGuid: "a-staging-guid-attempt-3", State: models.Task_Running, TaskDefinition: &models.TaskDefinition{Annotation: `{"lifecycle":"fake-backend","staging_guid":"a-staging-guid","attempt":3}`}},
+						{TaskGuid: "other-guid-attempt-4", State: models.Task_Running, TaskDefinition: &models.TaskDefinition{Annotation: `{"lifecycle":"fake-backend","staging_guid":"other-guid","attempt":4}`}},
+					}, nil)
+				})
+
+				It("looks up the attempts in the staging domain", func() {
+					Expect(fakeDiegoClient.TasksByDomainCallCount()).To(Equal(1))
+					_, domain := fakeDiegoClient.TasksByDomainArgsForCall(0)
+					Expect(domain).To(Equal(cc_messages.StagingTaskDomain))
+				})
+
+				It("cancels the attempt that is in flight", func() {
+					Expect(fakeDiegoClient.CancelTaskCallCount()).To(Equal(1))
+					_, task := fakeDiegoClient.CancelTaskArgsForCall(0)
+					Expect(task).To(Equal("a-staging-guid-attempt-3"))
+				})
+
+				It("returns an Accepted response", func() {
+					Expect(responseRecorder.Code).To(Equal(http.StatusAccepted))
+				})
+			})
+
 			Context("when retrieving the current task fails", func() {
 				BeforeEach(func() {
 					fakeDiegoClient.TaskByGuidReturns(&models.Task{}, errors.New("boom"))
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/metric"
+	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/diego_errors"
+	"code.cloudfoundry.org/stager/scheduler"
+)
+
+const (
+	DefaultRetryBackoff = 10 * time.Second
+
+	stagingRetriesCounter = metric.Counter("StagingRequestsRetried")
+)
+
+var errRetryBackingOff = errors.New("staging retry is backing off")
+
+// infrastructureFailures are the failures of staging tasks that say
+// nothing about the app or the lifecycle, and are worth retrying.
+var infrastructureFailures = map[diego_errors.Code]bool{
+	diego_errors.CodeInsufficientResources:  true,
+	diego_errors.CodeNoCompatibleCell:       true,
+	diego_errors.CodeCellCommunicationError: true,
+}
+
+// RetryPolicy controls how often staging tasks that failed for
+// infrastructure reasons are re-desired before the failure is reported to
+// CC. MaxAttempts includes the original attempt. Each retry waits Backoff,
+// doubled per attempt up to MaxBackoff.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+func (p RetryPolicy) shouldRetry(annotation backend.StagingTaskAnnotation, failure *diego_errors.Error) bool {
+	if failure == nil || !infrastructureFailures[failure.Code] {
+		return false
+	}
+
+	return annotation.AttemptNumber() < p.MaxAttempts
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := p.Backoff
+	for i := 1; i < attempt; i++ {
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+
+	return backoff
+}
+
+func retryTaskGuid(stagingGuid string, attempt int) string {
+	return fmt.Sprintf("%s-attempt-%d", stagingGuid, attempt)
+}
+
+// retry desires the task definition of a failed attempt again under a
+// derived guid before the callback of the failed attempt is answered, so a
+// restart cannot lose the staging. With a scheduler the new attempt is
+// queued, persisted and backed off under the scheduler's budget; without
+// one it is desired once retryDue has seen the backoff pass. A new attempt
+// that already exists was desired by an earlier delivery of the same
+// callback. If the new attempt cannot be desired, the caller delivers the
+// original failure to CC instead.
+func (handler *completionHandler) retry(logger lager.Logger, taskGuid string, annotation backend.StagingTaskAnnotation) error {
+	original, err := handler.bbsClient.TaskByGuid(logger, taskGuid)
+	if err != nil {
+		return err
+	}
+
+	stagingGuid := annotation.StagingGuidFor(taskGuid)
+	attempt := annotation.AttemptNumber() + 1
+	newTaskGuid := retryTaskGuid(stagingGuid, attempt)
+
+	annotation.StagingGuid = stagingGuid
+	annotation.Attempt = attempt
+	annotationJson, err := json.Marshal(annotation)
+	if err != nil {
+		return err
+	}
+
+	taskDefinition := *original.TaskDefinition
+	taskDefinition.Annotation = string(annotationJson)
+	taskDefinition.CompletionCallbackUrl = strings.Replace(taskDefinition.CompletionCallbackUrl, "/"+taskGuid+"/", "/"+newTaskGuid+"/", 1)
+
+	if handler.scheduler != nil {
+		backoff := handler.retryPolicy.backoff(attempt - 1)
+		logger.Info("queueing-staging-retry", lager.Data{
+			"staging-guid":  stagingGuid,
+			"new-task-guid": newTaskGuid,
+			"attempt":       attempt,
+			"backoff":       backoff.String(),
+		})
+
+		err = handler.scheduler.Resubmit(logger, taskGuid, scheduler.Job{
+			TaskGuid:           newTaskGuid,
+			Domain:             original.Domain,
+			StagingGuid:        stagingGuid,
+			CompletionCallback: annotation.CompletionCallback,
+			RequestId:          annotation.RequestId,
+			TaskDefinition:     &taskDefinition,
+			AppId:              annotation.AppId,
+			NotBefore:          handler.clock.Now().Add(backoff).UnixNano(),
+		})
+	} else {
+		logger.Info("retrying-staging", lager.Data{
+			"staging-guid":  stagingGuid,
+			"new-task-guid": newTaskGuid,
+			"attempt":       attempt,
+		})
+
+		err = handler.bbsClient.DesireTask(logger, newTaskGuid, original.Domain, &taskDefinition)
+	}
+	if models.ErrResourceExists.Equal(err) {
+		logger.Info("staging-retry-already-desired", lager.Data{"new-task-guid": newTaskGuid})
+		handler.superseder.Track(annotation.AppId, newTaskGuid)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	handler.superseder.Track(annotation.AppId, newTaskGuid)
+	stagingRetriesCounter.Increment()
+	return nil
+}
+
+// retryDue reports whether the backoff before retrying a failed attempt has
+// passed, for stagers without a scheduler. The first delivery of the
+// failed attempt's callback starts the backoff and a timer that desires the
+// retry when it passes; until then the callback is refused, so BBS keeps the
+// failed task and delivers it again, even to a restarted stager.
+func (handler *completionHandler) retryDue(logger lager.Logger, taskGuid string, annotation backend.StagingTaskAnnotation) bool {
+	backoff := handler.retryPolicy.backoff(annotation.AttemptNumber())
+	if backoff <= 0 {
+		return true
+	}
+
+	handler.retryLock.Lock()
+	defer handler.retryLock.Unlock()
+
+	due, pending := handler.pendingRetries[taskGuid]
+	if !pending {
+		logger.Info("backing-off-staging-retry", lager.Data{"backoff": backoff.String()})
+		handler.pendingRetries[taskGuid] = handler.clock.Now().Add(backoff)
+		go handler.retryAfter(logger, backoff, taskGuid, annotation)
+		return false
+	}
+
+	if handler.clock.Now().Before(due) {
+		return false
+	}
+
+	delete(handler.pendingRetries, taskGuid)
+	return true
+}
+
+func (handler *completionHandler) retryAfter(logger lager.Logger, backoff time.Duration, taskGuid string, annotation backend.StagingTaskAnnotation) {
+	<-handler.clock.After(backoff)
+
+	err := handler.retry(logger, taskGuid, annotation)
+	if err != nil {
+		logger.Error("failed-to-retry-staging", err)
+	}
+}
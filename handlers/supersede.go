@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/runtimeschema/metric"
+	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/cc_client"
+	"code.cloudfoundry.org/stager/diego_errors"
+	"code.cloudfoundry.org/stager/scheduler"
+)
+
+const (
+	DefaultSupersedeResyncInterval = 30 * time.Second
+
+	stagingSupersededCounter = metric.Counter("StagingRequestsSuperseded")
+)
+
+type supersedableTask struct {
+	appId   string
+	indexed time.Time
+}
+
+// Superseder cancels the in-flight stagings of an app when a newer staging
+// of the same app is requested. It indexes the staging tasks it desires by
+// app, and picks up tasks desired by other stager instances by rescanning
+// the task domain at most once per resyncInterval. It remembers the tasks
+// it cancelled, so that only those are reported to CC as superseded. A nil
+// Superseder supersedes nothing.
+type Superseder struct {
+	bbsClient      bbs.Client
+	ccClient       cc_client.CcClient
+	scheduler      scheduler.Scheduler
+	clock          clock.Clock
+	domain         string
+	resyncInterval time.Duration
+
+	lock       sync.Mutex
+	tasks      map[string]supersedableTask
+	cancelled  map[string]bool
+	lastResync time.Time
+}
+
+func NewSuperseder(bbsClient bbs.Client, ccClient cc_client.CcClient, stagingScheduler scheduler.Scheduler, clock clock.Clock, domain string, resyncInterval time.Duration) *Superseder {
+	return &Superseder{
+		bbsClient:      bbsClient,
+		ccClient:       ccClient,
+		scheduler:      stagingScheduler,
+		clock:          clock,
+		domain:         domain,
+		resyncInterval: resyncInterval,
+		tasks:          map[string]supersedableTask{},
+		cancelled:      map[string]bool{},
+	}
+}
+
+// Supersede cancels the stagings of appId other than currentTaskGuid, which
+// was just desired or queued. Queued stagings are taken off the scheduler's
+// queue and reported to CC right away, since no task callback will do so.
+func (s *Superseder) Supersede(logger lager.Logger, appId, currentTaskGuid string) {
+	if s == nil {
+		return
+	}
+
+	logger = logger.Session("supersede-stagings", lager.Data{"app-id": appId})
+
+	s.resync(logger)
+
+	removedJobs := []scheduler.Job{}
+	if s.scheduler != nil {
+		removedJobs = s.scheduler.RemoveApp(logger, appId, currentTaskGuid)
+	}
+
+	s.Track(appId, currentTaskGuid)
+
+	s.lock.Lock()
+	for _, job := range removedJobs {
+		delete(s.tasks, job.TaskGuid)
+	}
+
+	taskGuids := []string{}
+	for taskGuid, task := range s.tasks {
+		if task.appId == appId && taskGuid != currentTaskGuid {
+			taskGuids = append(taskGuids, taskGuid)
+			delete(s.tasks, taskGuid)
+		}
+	}
+	s.lock.Unlock()
+
+	for _, job := range removedJobs {
+		s.reportQueuedStagingSuperseded(logger, job)
+		stagingSupersededCounter.Increment()
+		logger.Info("removed-superseded-queued-task", lager.Data{"task-guid": job.TaskGuid})
+	}
+
+	for _, taskGuid := range taskGuids {
+		s.lock.Lock()
+		s.cancelled[taskGuid] = true
+		s.lock.Unlock()
+
+		err := s.bbsClient.CancelTask(logger, taskGuid)
+		if err != nil {
+			s.lock.Lock()
+			delete(s.cancelled, taskGuid)
+			s.lock.Unlock()
+
+			logger.Error("failed-to-cancel-superseded-task", err, lager.Data{"task-guid": taskGuid})
+			continue
+		}
+
+		stagingSupersededCounter.Increment()
+		logger.Info("cancelled-superseded-task", lager.Data{"task-guid": taskGuid})
+	}
+}
+
+// Track indexes a staging task of appId that was desired or queued without
+// superseding others, such as a retried attempt.
+func (s *Superseder) Track(appId, taskGuid string) {
+	if s == nil || appId == "" {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.tasks[taskGuid] = supersedableTask{appId: appId, indexed: s.clock.Now()}
+}
+
+// Completed forgets a finished staging task and reports whether it was
+// cancelled because a newer staging superseded it.
+func (s *Superseder) Completed(taskGuid string) bool {
+	if s == nil {
+		return false
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.tasks, taskGuid)
+
+	cancelled := s.cancelled[taskGuid]
+	delete(s.cancelled, taskGuid)
+	return cancelled
+}
+
+// resync replaces the tasks indexed before the last scan of the task domain
+// with the in-flight tasks found by a new one, unless the last scan is more
+// recent than resyncInterval.
+func (s *Superseder) resync(logger lager.Logger) {
+	s.lock.Lock()
+	scanStarted := s.clock.Now()
+	if !s.lastResync.IsZero() && scanStarted.Sub(s.lastResync) < s.resyncInterval {
+		s.lock.Unlock()
+		return
+	}
+	s.lastResync = scanStarted
+	s.lock.Unlock()
+
+	tasks, err := s.bbsClient.TasksByDomain(logger, s.domain)
+	if err != nil {
+		logger.Error("failed-to-fetch-tasks", err)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for taskGuid, task := range s.tasks {
+		if task.indexed.Before(scanStarted) {
+			delete(s.tasks, taskGuid)
+		}
+	}
+
+	existing := map[string]bool{}
+	for _, task := range tasks {
+		existing[task.TaskGuid] = true
+
+		if task.TaskDefinition == nil || !inFlight(task) {
+			continue
+		}
+
+		annotation, err := backend.ParseStagingTaskAnnotation(task.Annotation)
+		if err != nil || annotation.AppId == "" {
+			continue
+		}
+
+		if _, ok := s.tasks[task.TaskGuid]; !ok {
+			s.tasks[task.TaskGuid] = supersedableTask{appId: annotation.AppId, indexed: scanStarted}
+		}
+	}
+
+	// tasks whose callback went to another stager instance are gone
+	for taskGuid := range s.cancelled {
+		if !existing[taskGuid] {
+			delete(s.cancelled, taskGuid)
+		}
+	}
+}
+
+func (s *Superseder) reportQueuedStagingSuperseded(logger lager.Logger, job scheduler.Job) {
+	response := cc_messages.StagingResponseForCC{
+		Error: diego_errors.ErrStagingSuperseded.StagingError(),
+	}
+	responseJson, _ := json.Marshal(response)
+
+	err := s.ccClient.StagingComplete(job.StagingGuid, job.CompletionCallback, responseJson, cc_client.RequestHeaders(job.RequestId), logger)
+	if err != nil {
+		logger.Error("cc-staging-complete-failed", err, lager.Data{"task-guid": job.TaskGuid})
+	}
+}
+
+func inFlight(task *models.Task) bool {
+	return task.State == models.Task_Pending || task.State == models.Task_Running
+}
@@ -0,0 +1,293 @@
+package openapi
+
+// Document is the OpenAPI description of the stager's API. The handlers
+// validate inbound requests against its schemas, so a change to the
+// payloads the stager accepts starts here.
+const Document = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "Stager",
+    "description": "Stages apps on Diego on behalf of Cloud Controller.",
+    "version": "1"
+  },
+  "paths": {
+    "/v1/staging/{staging_guid}": {
+      "parameters": [
+        {"$ref": "#/components/parameters/StagingGuid"}
+      ],
+      "put": {
+        "operationId": "Stage",
+        "summary": "Stage an app. The result is posted to the request's completion callback.",
+        "parameters": [
+          {"$ref": "#/components/parameters/RequestId"}
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {"$ref": "#/components/schemas/StagingRequestFromCC"}
+            }
+          }
+        },
+        "responses": {
+          "202": {"description": "The staging was accepted."},
+          "400": {"$ref": "#/components/responses/StagingError"},
+          "404": {"description": "No backend is registered for the requested lifecycle."},
+          "500": {"$ref": "#/components/responses/StagingError"}
+        }
+      },
+      "delete": {
+        "operationId": "StopStaging",
+        "summary": "Stop a running or queued staging.",
+        "responses": {
+          "202": {"description": "The staging is being stopped."},
+          "404": {"description": "The stager does not know the staging."},
+          "500": {"description": "The staging could not be stopped."}
+        }
+      }
+    },
+    "/v1/staging/{staging_guid}/completed": {
+      "parameters": [
+        {"$ref": "#/components/parameters/StagingGuid"}
+      ],
+      "post": {
+        "operationId": "StagingCompleted",
+        "summary": "Task completion callback from Diego.",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {"$ref": "#/components/schemas/TaskCallbackResponse"}
+            }
+          }
+        },
+        "responses": {
+          "200": {"description": "The staging result was reported to Cloud Controller."},
+          "400": {"$ref": "#/components/responses/StagingError"},
+          "404": {"description": "No backend is registered for the task's lifecycle."},
+          "503": {"description": "Cloud Controller could not be reached."}
+        }
+      }
+    },
+    "/v1/capabilities": {
+      "get": {
+        "operationId": "Capabilities",
+        "summary": "The lifecycles, stacks and features this stager supports.",
+        "responses": {
+          "200": {
+            "description": "The stager's capabilities.",
+            "content": {
+              "application/json": {
+                "schema": {"$ref": "#/components/schemas/Capabilities"}
+              }
+            }
+          }
+        }
+      }
+    },
+    "/v1/openapi.json": {
+      "get": {
+        "operationId": "OpenAPI",
+        "summary": "This document.",
+        "responses": {
+          "200": {"description": "The OpenAPI description of the stager."}
+        }
+      }
+    }
+  },
+  "components": {
+    "parameters": {
+      "StagingGuid": {
+        "name": "staging_guid",
+        "in": "path",
+        "required": true,
+        "schema": {"type": "string"}
+      },
+      "RequestId": {
+        "name": "X-Vcap-Request-Id",
+        "in": "header",
+        "description": "Correlates the staging across logs and the completion callback. Generated if absent.",
+        "schema": {"type": "string"}
+      }
+    },
+    "responses": {
+      "StagingError": {
+        "description": "The request was rejected.",
+        "content": {
+          "application/json": {
+            "schema": {"$ref": "#/components/schemas/StagingResponseForCC"}
+          }
+        }
+      }
+    },
+    "schemas": {
+      "StagingRequestFromCC": {
+        "type": "object",
+        "required": ["app_id", "lifecycle"],
+        "properties": {
+          "app_id": {"type": "string"},
+          "log_guid": {"type": "string"},
+          "file_descriptors": {"type": "integer", "minimum": 0},
+          "memory_mb": {"type": "integer", "minimum": 0},
+          "disk_mb": {"type": "integer", "minimum": 0},
+          "timeout": {"type": "integer", "minimum": 0},
+          "environment": {
+            "type": "array",
+            "nullable": true,
+            "items": {"$ref": "#/components/schemas/EnvironmentVariable"}
+          },
+          "egress_rules": {
+            "type": "array",
+            "nullable": true,
+            "items": {"type": "object"}
+          },
+          "lifecycle": {
+            "type": "string",
+            "description": "Selects the backend, and the schema of lifecycle_data."
+          },
+          "lifecycle_data": {
+            "nullable": true,
+            "description": "Required for the lifecycles x-discriminator maps, and left to the backend for the others.",
+            "oneOf": [
+              {"$ref": "#/components/schemas/BuildpackStagingData"},
+              {"$ref": "#/components/schemas/DockerStagingData"}
+            ],
+            "x-discriminator": {
+              "propertyName": "lifecycle",
+              "mapping": {
+                "buildpack": "#/components/schemas/BuildpackStagingData",
+                "docker": "#/components/schemas/DockerStagingData"
+              }
+            }
+          },
+          "completion_callback": {"type": "string"}
+        }
+      },
+      "EnvironmentVariable": {
+        "type": "object",
+        "required": ["name"],
+        "properties": {
+          "name": {"type": "string"},
+          "value": {"type": "string"}
+        }
+      },
+      "BuildpackStagingData": {
+        "type": "object",
+        "required": ["app_bits_download_uri", "stack"],
+        "properties": {
+          "app_bits_download_uri": {"type": "string"},
+          "build_artifacts_cache_download_uri": {"type": "string"},
+          "build_artifacts_cache_upload_uri": {"type": "string"},
+          "buildpacks": {
+            "type": "array",
+            "nullable": true,
+            "items": {"$ref": "#/components/schemas/Buildpack"}
+          },
+          "droplet_upload_uri": {"type": "string"},
+          "stack": {"type": "string"}
+        }
+      },
+      "Buildpack": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "key": {"type": "string"},
+          "url": {"type": "string"},
+          "skip_detect": {"type": "boolean"},
+          "checksum": {"$ref": "#/components/schemas/Checksum"}
+        }
+      },
+      "Checksum": {
+        "type": "object",
+        "description": "Verified by the cell before the download is cached.",
+        "required": ["algorithm", "value"],
+        "properties": {
+          "algorithm": {"type": "string", "enum": ["md5", "sha1", "sha256"]},
+          "value": {"type": "string"}
+        }
+      },
+      "DockerStagingData": {
+        "type": "object",
+        "required": ["docker_image"],
+        "properties": {
+          "docker_image": {"type": "string"},
+          "docker_login_server": {"type": "string"},
+          "docker_user": {"type": "string"},
+          "docker_password": {"type": "string"},
+          "docker_email": {"type": "string"}
+        }
+      },
+      "StagingResponseForCC": {
+        "type": "object",
+        "properties": {
+          "error": {"$ref": "#/components/schemas/StagingError"},
+          "result": {"type": "object"}
+        }
+      },
+      "StagingError": {
+        "type": "object",
+        "required": ["id", "message"],
+        "properties": {
+          "id": {
+            "type": "string",
+            "enum": [
+              "StagingError",
+              "InsufficientResources",
+              "NoCompatibleCell",
+              "CellCommunicationError",
+              "NoAppDetectedError",
+              "BuildpackCompileFailed",
+              "BuildpackReleaseFailed"
+            ]
+          },
+          "message": {"type": "string"}
+        }
+      },
+      "Capabilities": {
+        "type": "object",
+        "required": ["lifecycles", "docker_staging_stack", "docker_image_caching", "features"],
+        "properties": {
+          "lifecycles": {
+            "type": "object",
+            "description": "Maps each lifecycle to the stacks it can stage on and the lifecycle bundle used for each.",
+            "additionalProperties": {
+              "type": "object",
+              "additionalProperties": {"type": "string"}
+            }
+          },
+          "docker_staging_stack": {"type": "string"},
+          "docker_image_caching": {"type": "boolean"},
+          "features": {
+            "type": "array",
+            "items": {
+              "type": "string",
+              "enum": [
+                "admission",
+                "supersede",
+                "result_cache",
+                "queueing",
+                "retries",
+                "tracing",
+                "request_ids",
+                "request_validation"
+              ]
+            }
+          }
+        }
+      },
+      "TaskCallbackResponse": {
+        "type": "object",
+        "required": ["task_guid"],
+        "properties": {
+          "task_guid": {"type": "string"},
+          "failed": {"type": "boolean"},
+          "failure_reason": {"type": "string"},
+          "result": {"type": "string"},
+          "annotation": {"type": "string"},
+          "created_at": {"type": "integer"}
+        }
+      }
+    }
+  }
+}
+`
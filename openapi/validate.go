@@ -0,0 +1,275 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// ValidationError describes the first part of a payload that does not
+// match its schema. Field is the dotted path to the offending value, empty
+// for the payload itself.
+type ValidationError struct {
+	Field   string
+	Problem string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Field == "" {
+		return e.Problem
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Problem)
+}
+
+// schema is the subset of the OpenAPI schema object the validator
+// understands. Keywords it does not know, such as allOf, are ignored.
+type schema struct {
+	Ref           string             `json:"$ref"`
+	Type          string             `json:"type"`
+	Nullable      bool               `json:"nullable"`
+	Required      []string           `json:"required"`
+	Properties    map[string]*schema `json:"properties"`
+	Items         *schema            `json:"items"`
+	Enum          []string           `json:"enum"`
+	Minimum       *float64           `json:"minimum"`
+	OneOf         []*schema          `json:"oneOf"`
+	Discriminator *discriminator     `json:"x-discriminator"`
+}
+
+// discriminator selects the schema of a oneOf property by another property
+// of the enclosing object, since lifecycle_data carries nothing that tells
+// its schemas apart. Values the mapping does not name are not validated.
+type discriminator struct {
+	PropertyName string            `json:"propertyName"`
+	Mapping      map[string]string `json:"mapping"`
+}
+
+const schemaRefPrefix = "#/components/schemas/"
+
+var schemas = func() map[string]*schema {
+	var document struct {
+		Components struct {
+			Schemas map[string]*schema `json:"schemas"`
+		} `json:"components"`
+	}
+
+	err := json.Unmarshal([]byte(Document), &document)
+	if err != nil {
+		panic("invalid openapi document: " + err.Error())
+	}
+
+	for name, s := range document.Components.Schemas {
+		err = s.checkOneOf(name)
+		if err != nil {
+			panic("invalid openapi document: " + err.Error())
+		}
+	}
+
+	return document.Components.Schemas
+}()
+
+// checkOneOf makes sure the validator can tell which schema of a oneOf
+// applies, which it only can by a discriminator mapping to those schemas.
+func (s *schema) checkOneOf(field string) error {
+	if len(s.OneOf) > 0 {
+		if s.Discriminator == nil {
+			return fmt.Errorf("%s: oneOf without x-discriminator", field)
+		}
+
+		for value, ref := range s.Discriminator.Mapping {
+			if !oneOfRefs(s.OneOf, ref) {
+				return fmt.Errorf("%s: x-discriminator maps %s to %s, which is not one of its schemas", field, value, ref)
+			}
+		}
+	}
+
+	for name, property := range s.Properties {
+		err := property.checkOneOf(join(field, name))
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.Items != nil {
+		return s.Items.checkOneOf(field + "[]")
+	}
+
+	return nil
+}
+
+func oneOfRefs(oneOf []*schema, ref string) bool {
+	for _, s := range oneOf {
+		if s.Ref == ref {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks payload against the named schema of the document.
+func Validate(schemaName string, payload []byte) error {
+	s, ok := schemas[schemaName]
+	if !ok {
+		return fmt.Errorf("unknown schema %q", schemaName)
+	}
+
+	var value interface{}
+	err := json.Unmarshal(payload, &value)
+	if err != nil {
+		return &ValidationError{Problem: "invalid JSON: " + err.Error()}
+	}
+
+	return s.validate("", value)
+}
+
+// ValidateStagingRequest checks a staging request, including its
+// lifecycle_data against the schema of the requested lifecycle.
+func ValidateStagingRequest(payload []byte) error {
+	return Validate("StagingRequestFromCC", payload)
+}
+
+// ValidateTaskCallback checks a task completion callback from Diego.
+func ValidateTaskCallback(payload []byte) error {
+	return Validate("TaskCallbackResponse", payload)
+}
+
+func (s *schema) validate(field string, value interface{}) error {
+	if s.Ref != "" {
+		resolved, ok := schemas[strings.TrimPrefix(s.Ref, schemaRefPrefix)]
+		if !ok {
+			return fmt.Errorf("unresolvable reference %q", s.Ref)
+		}
+		return resolved.validate(field, value)
+	}
+
+	if value == nil {
+		if s.Nullable || s.Type == "" {
+			return nil
+		}
+		return &ValidationError{Field: field, Problem: "must not be null"}
+	}
+
+	switch s.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return &ValidationError{Field: field, Problem: "must be an object"}
+		}
+		return s.validateObject(field, object)
+
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return &ValidationError{Field: field, Problem: "must be an array"}
+		}
+		if s.Items == nil {
+			return nil
+		}
+		for i, item := range array {
+			err := s.Items.validate(fmt.Sprintf("%s[%d]", field, i), item)
+			if err != nil {
+				return err
+			}
+		}
+
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return &ValidationError{Field: field, Problem: "must be a string"}
+		}
+		if len(s.Enum) > 0 && !contains(s.Enum, str) {
+			return &ValidationError{Field: field, Problem: fmt.Sprintf("must be one of %s", strings.Join(s.Enum, ", "))}
+		}
+
+	case "integer", "number":
+		number, ok := value.(float64)
+		if !ok {
+			return &ValidationError{Field: field, Problem: "must be a number"}
+		}
+		if s.Type == "integer" && number != math.Trunc(number) {
+			return &ValidationError{Field: field, Problem: "must be an integer"}
+		}
+		if s.Minimum != nil && number < *s.Minimum {
+			return &ValidationError{Field: field, Problem: fmt.Sprintf("must be at least %v", *s.Minimum)}
+		}
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return &ValidationError{Field: field, Problem: "must be a boolean"}
+		}
+	}
+
+	return nil
+}
+
+func (s *schema) validateObject(field string, object map[string]interface{}) error {
+	for _, name := range s.Required {
+		if _, ok := object[name]; !ok {
+			return &ValidationError{Field: join(field, name), Problem: "is required"}
+		}
+	}
+
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		property := s.Properties[name]
+		value, ok := object[name]
+
+		if property.Discriminator != nil {
+			err := property.validateDiscriminated(join(field, name), object, value)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !ok {
+			continue
+		}
+
+		err := property.validate(join(field, name), value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateDiscriminated validates the value of a oneOf property against the
+// schema the discriminating property of object selects.
+func (s *schema) validateDiscriminated(field string, object map[string]interface{}, value interface{}) error {
+	selector, _ := object[s.Discriminator.PropertyName].(string)
+	ref, ok := s.Discriminator.Mapping[selector]
+	if !ok {
+		return nil
+	}
+
+	if value == nil {
+		return &ValidationError{Field: field, Problem: fmt.Sprintf("is required for %s %s", s.Discriminator.PropertyName, selector)}
+	}
+
+	return (&schema{Ref: ref}).validate(field, value)
+}
+
+func join(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
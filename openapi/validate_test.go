@@ -0,0 +1,272 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"strings"
+
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager"
+	"code.cloudfoundry.org/stager/openapi"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Document", func() {
+	var document struct {
+		Paths      map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]struct {
+					Enum  []string `json:"enum"`
+					OneOf []struct {
+						Ref string `json:"$ref"`
+					} `json:"oneOf"`
+					Discriminator struct {
+						PropertyName string            `json:"propertyName"`
+						Mapping      map[string]string `json:"mapping"`
+					} `json:"x-discriminator"`
+				} `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+
+	BeforeEach(func() {
+		err := json.Unmarshal([]byte(openapi.Document), &document)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("describes every route", func() {
+		for _, route := range stager.Routes {
+			path := strings.Replace(route.Path, ":staging_guid", "{staging_guid}", 1)
+			Expect(document.Paths).To(HaveKey(path))
+			Expect(document.Paths[path]).To(HaveKey(strings.ToLower(route.Method)))
+		}
+	})
+
+	It("lists the error ids CC knows", func() {
+		ids := document.Components.Schemas["StagingError"].Properties["id"].Enum
+		Expect(ids).To(ConsistOf(
+			cc_messages.STAGING_ERROR,
+			cc_messages.INSUFFICIENT_RESOURCES,
+			cc_messages.NO_COMPATIBLE_CELL,
+			cc_messages.CELL_COMMUNICATION_ERROR,
+			cc_messages.BUILDPACK_DETECT_FAILED,
+			cc_messages.BUILDPACK_COMPILE_FAILED,
+			cc_messages.BUILDPACK_RELEASE_FAILED,
+		))
+	})
+
+	It("tells the lifecycle data schemas apart by lifecycle", func() {
+		lifecycleData := document.Components.Schemas["StagingRequestFromCC"].Properties["lifecycle_data"]
+		Expect(lifecycleData.Discriminator.PropertyName).To(Equal("lifecycle"))
+
+		refs := []string{}
+		for _, s := range lifecycleData.OneOf {
+			refs = append(refs, s.Ref)
+		}
+		Expect(refs).To(ConsistOf(
+			lifecycleData.Discriminator.Mapping["buildpack"],
+			lifecycleData.Discriminator.Mapping["docker"],
+		))
+	})
+})
+
+var _ = Describe("ValidateStagingRequest", func() {
+	var request map[string]interface{}
+
+	BeforeEach(func() {
+		request = map[string]interface{}{
+			"app_id":      "my-app",
+			"memory_mb":   1024,
+			"environment": nil,
+			"lifecycle":   "buildpack",
+			"lifecycle_data": map[string]interface{}{
+				"app_bits_download_uri": "http://example.com/app_bits",
+				"stack":                 "linux",
+				"buildpacks": []interface{}{
+					map[string]interface{}{"name": "ruby", "key": "ruby-key", "url": "http://example.com/ruby", "skip_detect": true},
+				},
+			},
+		}
+	})
+
+	validate := func() error {
+		payload, err := json.Marshal(request)
+		Expect(err).NotTo(HaveOccurred())
+		return openapi.ValidateStagingRequest(payload)
+	}
+
+	It("accepts a valid request", func() {
+		Expect(validate()).To(Succeed())
+	})
+
+	It("accepts a request generated from cc_messages", func() {
+		payload, err := json.Marshal(cc_messages.StagingRequestFromCC{AppId: "my-app", Lifecycle: "some-lifecycle"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(openapi.ValidateStagingRequest(payload)).To(Succeed())
+	})
+
+	It("rejects invalid JSON", func() {
+		err := openapi.ValidateStagingRequest([]byte("{"))
+		Expect(err).To(BeAssignableToTypeOf(&openapi.ValidationError{}))
+	})
+
+	It("rejects a payload that is not an object", func() {
+		err := openapi.ValidateStagingRequest([]byte(`"my-app"`))
+		Expect(err).To(MatchError("must be an object"))
+	})
+
+	It("rejects a request missing a required field", func() {
+		delete(request, "app_id")
+		Expect(validate()).To(MatchError("app_id: is required"))
+	})
+
+	It("rejects a field of the wrong type", func() {
+		request["memory_mb"] = "lots"
+		Expect(validate()).To(MatchError("memory_mb: must be a number"))
+	})
+
+	It("rejects a fractional integer", func() {
+		request["disk_mb"] = 1.5
+		Expect(validate()).To(MatchError("disk_mb: must be an integer"))
+	})
+
+	It("rejects a negative size", func() {
+		request["file_descriptors"] = -1
+		Expect(validate()).To(MatchError("file_descriptors: must be at least 0"))
+	})
+
+	It("rejects a null string", func() {
+		request["lifecycle"] = nil
+		Expect(validate()).To(MatchError("lifecycle: must not be null"))
+	})
+
+	It("reports the path to invalid array items", func() {
+		request["environment"] = []interface{}{
+			map[string]interface{}{"name": "FOO", "value": "bar"},
+			map[string]interface{}{"value": "baz"},
+		}
+		Expect(validate()).To(MatchError("environment[1].name: is required"))
+	})
+
+	It("ignores fields it does not know", func() {
+		request["some_new_field"] = 42
+		Expect(validate()).To(Succeed())
+	})
+
+	Context("when the lifecycle is buildpack", func() {
+		It("validates the lifecycle data as buildpack staging data", func() {
+			request["lifecycle_data"].(map[string]interface{})["stack"] = 42
+			Expect(validate()).To(MatchError("lifecycle_data.stack: must be a string"))
+		})
+
+		It("validates the buildpacks", func() {
+			request["lifecycle_data"].(map[string]interface{})["buildpacks"] = []interface{}{
+				map[string]interface{}{"name": "ruby", "skip_detect": "yes"},
+			}
+			Expect(validate()).To(MatchError("lifecycle_data.buildpacks[0].skip_detect: must be a boolean"))
+		})
+
+		It("validates the buildpack checksums", func() {
+			request["lifecycle_data"].(map[string]interface{})["buildpacks"] = []interface{}{
+				map[string]interface{}{"name": "ruby", "checksum": map[string]interface{}{"algorithm": "crc32", "value": "abc"}},
+			}
+			Expect(validate()).To(MatchError(HavePrefix("lifecycle_data.buildpacks[0].checksum.algorithm: must be one of")))
+		})
+
+		It("requires lifecycle data", func() {
+			delete(request, "lifecycle_data")
+			Expect(validate()).To(MatchError("lifecycle_data: is required for lifecycle buildpack"))
+		})
+	})
+
+	Context("when the lifecycle is docker", func() {
+		BeforeEach(func() {
+			request["lifecycle"] = "docker"
+			request["lifecycle_data"] = map[string]interface{}{"docker_image": "busybox"}
+		})
+
+		It("accepts docker staging data", func() {
+			Expect(validate()).To(Succeed())
+		})
+
+		It("validates the lifecycle data as docker staging data", func() {
+			request["lifecycle_data"] = map[string]interface{}{"stack": "linux"}
+			Expect(validate()).To(MatchError("lifecycle_data.docker_image: is required"))
+		})
+	})
+
+	Context("when the lifecycle has no lifecycle data schema", func() {
+		BeforeEach(func() {
+			request["lifecycle"] = "windows-buildpack"
+			request["lifecycle_data"] = map[string]interface{}{"anything": true}
+		})
+
+		It("leaves the lifecycle data to the backend", func() {
+			Expect(validate()).To(Succeed())
+		})
+	})
+})
+
+var _ = Describe("Validate", func() {
+	It("accepts the stager's capabilities", func() {
+		payload, err := json.Marshal(stager.Capabilities{
+			Lifecycles: map[string]map[string]string{"buildpack": {"cflinuxfs2": "buildpack_app_lifecycle.tgz"}},
+			Features: []string{
+				stager.FeatureAdmission,
+				stager.FeatureSupersede,
+				stager.FeatureResultCache,
+				stager.FeatureQueueing,
+				stager.FeatureRetries,
+				stager.FeatureTracing,
+				stager.FeatureRequestIds,
+				stager.FeatureValidation,
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(openapi.Validate("Capabilities", payload)).To(Succeed())
+	})
+
+	It("rejects unknown features", func() {
+		payload := []byte(`{"lifecycles":{},"docker_staging_stack":"","docker_image_caching":false,"features":["teleportation"]}`)
+		Expect(openapi.Validate("Capabilities", payload)).To(MatchError(HavePrefix("features[0]: must be one of")))
+	})
+
+	Describe("a staging request", func() {
+		It("validates buildpack lifecycle data as buildpack staging data", func() {
+			payload := []byte(`{"app_id":"my-app","lifecycle":"buildpack","lifecycle_data":{"app_bits_download_uri":"http://example.com/app_bits"}}`)
+			Expect(openapi.Validate("StagingRequestFromCC", payload)).To(MatchError("lifecycle_data.stack: is required"))
+		})
+
+		It("validates docker lifecycle data as docker staging data", func() {
+			payload := []byte(`{"app_id":"my-app","lifecycle":"docker","lifecycle_data":{"app_bits_download_uri":"http://example.com/app_bits","stack":"linux"}}`)
+			Expect(openapi.Validate("StagingRequestFromCC", payload)).To(MatchError("lifecycle_data.docker_image: is required"))
+		})
+
+		It("requires lifecycle data that is null for a mapped lifecycle", func() {
+			payload := []byte(`{"app_id":"my-app","lifecycle":"docker","lifecycle_data":null}`)
+			Expect(openapi.Validate("StagingRequestFromCC", payload)).To(MatchError("lifecycle_data: is required for lifecycle docker"))
+		})
+	})
+
+	It("fails for unknown schemas", func() {
+		Expect(openapi.Validate("NoSuchSchema", []byte(`{}`))).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("ValidateTaskCallback", func() {
+	It("accepts a task callback", func() {
+		Expect(openapi.ValidateTaskCallback([]byte(`{"task_guid":"a-guid","failed":true,"failure_reason":"oops","created_at":1}`))).To(Succeed())
+	})
+
+	It("requires the task guid", func() {
+		Expect(openapi.ValidateTaskCallback([]byte(`{"failed":true}`))).To(MatchError("task_guid: is required"))
+	})
+
+	It("rejects a field of the wrong type", func() {
+		Expect(openapi.ValidateTaskCallback([]byte(`{"task_guid":"a-guid","failed":"yes"}`))).To(MatchError("failed: must be a boolean"))
+	})
+})
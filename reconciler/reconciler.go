@@ -0,0 +1,135 @@
+package reconciler
+
+import (
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/metric"
+	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/handlers"
+	"github.com/tedsuo/ifrit"
+)
+
+const (
+	DefaultGracePeriod = 2 * time.Minute
+
+	stagingCallbacksReconciledCounter = metric.Counter("StagingCallbacksReconciled")
+)
+
+// reconciler delivers the results of staging tasks whose completion callback
+// never reached the stager, e.g. because the stager was down at the time.
+// Results are delivered by the completion handler, as if the callback had
+// arrived.
+type reconciler struct {
+	logger      lager.Logger
+	bbsClient   bbs.Client
+	completion  handlers.CompletionHandler
+	clock       clock.Clock
+	taskDomain  string
+	interval    time.Duration
+	gracePeriod time.Duration
+}
+
+func New(
+	logger lager.Logger,
+	bbsClient bbs.Client,
+	completion handlers.CompletionHandler,
+	clock clock.Clock,
+	taskDomain string,
+	interval time.Duration,
+	gracePeriod time.Duration,
+) ifrit.Runner {
+	return &reconciler{
+		logger:      logger.Session("reconciler"),
+		bbsClient:   bbsClient,
+		completion:  completion,
+		clock:       clock,
+		taskDomain:  taskDomain,
+		interval:    interval,
+		gracePeriod: gracePeriod,
+	}
+}
+
+func (r *reconciler) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	ticker := r.clock.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	close(ready)
+
+	for {
+		select {
+		case <-ticker.C():
+			r.reconcile()
+		case <-signals:
+			return nil
+		}
+	}
+}
+
+func (r *reconciler) reconcile() {
+	logger := r.logger.Session("reconcile")
+
+	tasks, err := r.bbsClient.TasksByDomain(logger, r.taskDomain)
+	if err != nil {
+		logger.Error("failed-to-fetch-tasks", err)
+		return
+	}
+
+	now := r.clock.Now()
+	for _, task := range tasks {
+		if task.State != models.Task_Completed {
+			continue
+		}
+
+		if now.Sub(time.Unix(0, task.UpdatedAt)) < r.gracePeriod {
+			continue
+		}
+
+		r.deliver(logger, task)
+	}
+}
+
+func (r *reconciler) deliver(logger lager.Logger, task *models.Task) {
+	annotation, _ := backend.ParseStagingTaskAnnotation(task.Annotation)
+	logger = logger.Session("deliver", lager.Data{"task-guid": task.TaskGuid, "request-id": annotation.RequestId})
+
+	// Claim the task so that BBS and other stagers stop delivering it, but
+	// only once its result is known to be deliverable.
+	claimed := false
+	claim := func() error {
+		err := r.bbsClient.ResolvingTask(logger, task.TaskGuid)
+		if err != nil {
+			logger.Info("task-already-claimed", lager.Data{"error": err.Error()})
+			return err
+		}
+		claimed = true
+		return nil
+	}
+
+	err := r.completion.CompleteTask(logger, &models.TaskCallbackResponse{
+		TaskGuid:      task.TaskGuid,
+		Failed:        task.Failed,
+		FailureReason: task.FailureReason,
+		Result:        task.Result,
+		Annotation:    task.Annotation,
+		CreatedAt:     task.CreatedAt,
+	}, claim)
+	if err != nil {
+		if claimed {
+			logger.Error("deliver-failed", err)
+		}
+		return
+	}
+
+	err = r.bbsClient.DeleteTask(logger, task.TaskGuid)
+	if err != nil {
+		logger.Error("delete-task-failed", err)
+	}
+
+	stagingCallbacksReconciledCounter.Increment()
+	logger.Info("reconciled-staging-complete")
+}
@@ -0,0 +1,196 @@
+package reconciler_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/bbs/fake_bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/backend/fake_backend"
+	"code.cloudfoundry.org/stager/cc_client/fakes"
+	"code.cloudfoundry.org/stager/handlers"
+	"code.cloudfoundry.org/stager/reconciler"
+	fake_metric_sender "github.com/cloudfoundry/dropsonde/metric_sender/fake"
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reconciler", func() {
+	const (
+		interval    = 30 * time.Second
+		gracePeriod = time.Minute
+	)
+
+	var (
+		fakeMetricSender *fake_metric_sender.FakeMetricSender
+		fakeBBS          *fake_bbs.FakeClient
+		fakeCC           *fakes.FakeCcClient
+		fakeBackend      *fake_backend.FakeBackend
+		fakeClock        *fakeclock.FakeClock
+
+		tasks   []*models.Task
+		process ifrit.Process
+	)
+
+	newAttempt := func(guid, stagingGuid string, state models.Task_State, updatedAt time.Time) *models.Task {
+		annotation, err := json.Marshal(backend.StagingTaskAnnotation{
+			StagingTaskAnnotation: cc_messages.StagingTaskAnnotation{
+				Lifecycle:          "fake-backend",
+				CompletionCallback: "https://cc.example.com/" + guid,
+			},
+			StagingGuid: stagingGuid,
+			RequestId:   "request-" + guid,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		return &models.Task{
+			TaskGuid:  guid,
+			State:     state,
+			UpdatedAt: updatedAt.UnixNano(),
+			Result:    `{"result":true}`,
+			TaskDefinition: &models.TaskDefinition{
+				Annotation: string(annotation),
+			},
+		}
+	}
+
+	newTask := func(guid string, state models.Task_State, updatedAt time.Time) *models.Task {
+		return newAttempt(guid, "", state, updatedAt)
+	}
+
+	BeforeEach(func() {
+		fakeMetricSender = fake_metric_sender.NewFakeMetricSender()
+		metrics.Initialize(fakeMetricSender, nil)
+
+		fakeBBS = &fake_bbs.FakeClient{}
+		fakeCC = &fakes.FakeCcClient{}
+		fakeBackend = &fake_backend.FakeBackend{}
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+
+		fakeBackend.BuildStagingResponseReturns(cc_messages.StagingResponseForCC{}, nil)
+
+		tasks = []*models.Task{
+			newTask("missed", models.Task_Completed, fakeClock.Now().Add(-2*gracePeriod)),
+			newTask("just-completed", models.Task_Completed, fakeClock.Now()),
+			newTask("running", models.Task_Running, fakeClock.Now().Add(-2*gracePeriod)),
+		}
+	})
+
+	JustBeforeEach(func() {
+		fakeBBS.TasksByDomainReturns(tasks, nil)
+
+		logger := lagertest.NewTestLogger("test")
+		completion := handlers.NewStagingCompletionHandler(logger, fakeCC, fakeBBS, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeClock, handlers.Options{})
+
+		runner := reconciler.New(
+			logger,
+			fakeBBS,
+			completion,
+			fakeClock,
+			"staging-domain",
+			interval,
+			gracePeriod,
+		)
+		process = ifrit.Invoke(runner)
+
+		fakeClock.WaitForWatcherAndIncrement(interval)
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive(BeNil()))
+	})
+
+	It("lists the tasks in the staging domain", func() {
+		Eventually(fakeBBS.TasksByDomainCallCount).Should(Equal(1))
+		_, domain := fakeBBS.TasksByDomainArgsForCall(0)
+		Expect(domain).To(Equal("staging-domain"))
+	})
+
+	It("delivers only tasks that completed longer than the grace period ago", func() {
+		Eventually(fakeCC.StagingCompleteCallCount).Should(Equal(1))
+		Consistently(fakeCC.StagingCompleteCallCount).Should(Equal(1))
+
+		guid, payload, _ := fakeCC.StagingCompleteArgsForCall(0)
+		Expect(guid).To(Equal("missed"))
+		Expect(payload).To(MatchJSON(`{}`))
+		Expect(fakeCC.StagingCompleteHeadersForCall(0).Get("X-Vcap-Request-Id")).To(Equal("request-missed"))
+
+		Expect(fakeBackend.BuildStagingResponseCallCount()).To(Equal(1))
+		Expect(fakeBackend.BuildStagingResponseArgsForCall(0).Result).To(Equal(`{"result":true}`))
+	})
+
+	It("claims the task before delivering it and deletes it afterwards", func() {
+		Eventually(fakeBBS.DeleteTaskCallCount).Should(Equal(1))
+
+		Expect(fakeBBS.ResolvingTaskCallCount()).To(Equal(1))
+		_, guid := fakeBBS.ResolvingTaskArgsForCall(0)
+		Expect(guid).To(Equal("missed"))
+
+		_, guid = fakeBBS.DeleteTaskArgsForCall(0)
+		Expect(guid).To(Equal("missed"))
+	})
+
+	It("emits a metric for reconciled callbacks", func() {
+		Eventually(func() uint64 {
+			return fakeMetricSender.GetCounter("StagingCallbacksReconciled")
+		}).Should(Equal(uint64(1)))
+	})
+
+	Context("when the task is a retried attempt", func() {
+		BeforeEach(func() {
+			tasks = []*models.Task{
+				newAttempt("staging-guid-attempt-2", "staging-guid", models.Task_Completed, fakeClock.Now().Add(-2*gracePeriod)),
+			}
+		})
+
+		It("reports the result under the staging guid", func() {
+			Eventually(fakeCC.StagingCompleteCallCount).Should(Equal(1))
+			guid, _, _ := fakeCC.StagingCompleteArgsForCall(0)
+			Expect(guid).To(Equal("staging-guid"))
+		})
+	})
+
+	Context("when the staging response cannot be built", func() {
+		BeforeEach(func() {
+			fakeBackend.BuildStagingResponseReturns(cc_messages.StagingResponseForCC{}, errors.New("bad result"))
+		})
+
+		It("leaves the task for BBS rather than claiming it", func() {
+			Eventually(fakeBackend.BuildStagingResponseCallCount).Should(Equal(1))
+			Consistently(fakeBBS.ResolvingTaskCallCount).Should(Equal(0))
+			Expect(fakeCC.StagingCompleteCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when another stager has already claimed the task", func() {
+		BeforeEach(func() {
+			fakeBBS.ResolvingTaskReturns(errors.New("invalid state transition"))
+		})
+
+		It("does not deliver the task", func() {
+			Eventually(fakeBBS.ResolvingTaskCallCount).Should(Equal(1))
+			Consistently(fakeCC.StagingCompleteCallCount).Should(Equal(0))
+		})
+	})
+
+	Context("when delivery to CC fails", func() {
+		BeforeEach(func() {
+			fakeCC.StagingCompleteReturns(errors.New("cc down"))
+		})
+
+		It("does not delete the task", func() {
+			Eventually(fakeCC.StagingCompleteCallCount).Should(Equal(1))
+			Consistently(fakeBBS.DeleteTaskCallCount).Should(Equal(0))
+		})
+	})
+})
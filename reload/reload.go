@@ -0,0 +1,62 @@
+package reload
+
+import (
+	"os"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/metric"
+	"github.com/tedsuo/ifrit"
+)
+
+const (
+	configReloadsSucceededCounter = metric.Counter("ConfigReloadsSucceeded")
+	configReloadsFailedCounter    = metric.Counter("ConfigReloadsFailed")
+)
+
+// Func loads, validates and applies new configuration. It must leave the
+// running configuration untouched when it fails.
+type Func func(logger lager.Logger) error
+
+// reloader applies new configuration whenever it is triggered, typically by
+// a SIGHUP.
+type reloader struct {
+	logger  lager.Logger
+	trigger <-chan os.Signal
+	reload  Func
+}
+
+func New(logger lager.Logger, trigger <-chan os.Signal, reload Func) ifrit.Runner {
+	return &reloader{
+		logger:  logger.Session("reloader"),
+		trigger: trigger,
+		reload:  reload,
+	}
+}
+
+func (r *reloader) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	close(ready)
+
+	for {
+		select {
+		case <-r.trigger:
+			r.reloadConfig()
+		case <-signals:
+			return nil
+		}
+	}
+}
+
+func (r *reloader) reloadConfig() {
+	logger := r.logger.Session("reload")
+	logger.Info("starting")
+
+	err := r.reload(logger)
+	if err != nil {
+		configReloadsFailedCounter.Increment()
+		logger.Error("failed", err)
+		return
+	}
+
+	configReloadsSucceededCounter.Increment()
+	logger.Info("succeeded")
+}
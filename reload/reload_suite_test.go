@@ -0,0 +1,13 @@
+package reload_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Reload Suite")
+}
@@ -0,0 +1,89 @@
+package reload_test
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/reload"
+	fake_metric_sender "github.com/cloudfoundry/dropsonde/metric_sender/fake"
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reloader", func() {
+	var (
+		fakeMetricSender *fake_metric_sender.FakeMetricSender
+
+		trigger   chan os.Signal
+		reloads   chan struct{}
+		reloadErr error
+		process   ifrit.Process
+	)
+
+	BeforeEach(func() {
+		fakeMetricSender = fake_metric_sender.NewFakeMetricSender()
+		metrics.Initialize(fakeMetricSender, nil)
+
+		trigger = make(chan os.Signal)
+		reloads = make(chan struct{}, 10)
+		reloadErr = nil
+	})
+
+	JustBeforeEach(func() {
+		reloadErr := reloadErr
+		runner := reload.New(lagertest.NewTestLogger("test"), trigger, func(logger lager.Logger) error {
+			reloads <- struct{}{}
+			return reloadErr
+		})
+		process = ifrit.Invoke(runner)
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive(BeNil()))
+	})
+
+	It("does not reload until triggered", func() {
+		Consistently(reloads).ShouldNot(Receive())
+	})
+
+	It("reloads each time it is triggered", func() {
+		trigger <- syscall.SIGHUP
+		Eventually(reloads).Should(Receive())
+
+		trigger <- syscall.SIGHUP
+		Eventually(reloads).Should(Receive())
+	})
+
+	It("counts successful reloads", func() {
+		trigger <- syscall.SIGHUP
+
+		Eventually(func() uint64 {
+			return fakeMetricSender.GetCounter("ConfigReloadsSucceeded")
+		}).Should(Equal(uint64(1)))
+		Expect(fakeMetricSender.GetCounter("ConfigReloadsFailed")).To(BeZero())
+	})
+
+	Context("when reloading fails", func() {
+		BeforeEach(func() {
+			reloadErr = errors.New("invalid config")
+		})
+
+		It("counts the failure and keeps running", func() {
+			trigger <- syscall.SIGHUP
+
+			Eventually(func() uint64 {
+				return fakeMetricSender.GetCounter("ConfigReloadsFailed")
+			}).Should(Equal(uint64(1)))
+			Expect(fakeMetricSender.GetCounter("ConfigReloadsSucceeded")).To(BeZero())
+
+			Consistently(process.Wait()).ShouldNot(Receive())
+		})
+	})
+})
@@ -0,0 +1,296 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/resultcache"
+)
+
+type FakeCache struct {
+	KeyStub        func(request cc_messages.StagingRequestFromCC) (string, bool)
+	keyMutex       sync.RWMutex
+	keyArgsForCall []struct {
+		request cc_messages.StagingRequestFromCC
+	}
+	keyReturns struct {
+		result1 string
+		result2 bool
+	}
+	LookupStub        func(logger lager.Logger, key string) (resultcache.Entry, bool)
+	lookupMutex       sync.RWMutex
+	lookupArgsForCall []struct {
+		logger lager.Logger
+		key    string
+	}
+	lookupReturns struct {
+		result1 resultcache.Entry
+		result2 bool
+	}
+	RecordStub        func(logger lager.Logger, key, stagingGuid string, response []byte)
+	recordMutex       sync.RWMutex
+	recordArgsForCall []struct {
+		logger      lager.Logger
+		key         string
+		stagingGuid string
+		response    []byte
+	}
+	ReuseStub        func(logger lager.Logger, entry resultcache.Entry, request cc_messages.StagingRequestFromCC) error
+	reuseMutex       sync.RWMutex
+	reuseArgsForCall []struct {
+		logger  lager.Logger
+		entry   resultcache.Entry
+		request cc_messages.StagingRequestFromCC
+	}
+	reuseReturns struct {
+		result1 error
+	}
+	BeginStub        func(logger lager.Logger, reuse resultcache.PendingReuse) error
+	beginMutex       sync.RWMutex
+	beginArgsForCall []struct {
+		logger lager.Logger
+		reuse  resultcache.PendingReuse
+	}
+	beginReturns struct {
+		result1 error
+	}
+	FinishStub        func(logger lager.Logger, stagingGuid string)
+	finishMutex       sync.RWMutex
+	finishArgsForCall []struct {
+		logger      lager.Logger
+		stagingGuid string
+	}
+	PendingStub        func(logger lager.Logger) []resultcache.PendingReuse
+	pendingMutex       sync.RWMutex
+	pendingArgsForCall []struct {
+		logger lager.Logger
+	}
+	pendingReturns struct {
+		result1 []resultcache.PendingReuse
+	}
+}
+
+func (fake *FakeCache) Key(request cc_messages.StagingRequestFromCC) (string, bool) {
+	fake.keyMutex.Lock()
+	fake.keyArgsForCall = append(fake.keyArgsForCall, struct {
+		request cc_messages.StagingRequestFromCC
+	}{request})
+	fake.keyMutex.Unlock()
+	if fake.KeyStub != nil {
+		return fake.KeyStub(request)
+	} else {
+		return fake.keyReturns.result1, fake.keyReturns.result2
+	}
+}
+
+func (fake *FakeCache) KeyCallCount() int {
+	fake.keyMutex.RLock()
+	defer fake.keyMutex.RUnlock()
+	return len(fake.keyArgsForCall)
+}
+
+func (fake *FakeCache) KeyArgsForCall(i int) cc_messages.StagingRequestFromCC {
+	fake.keyMutex.RLock()
+	defer fake.keyMutex.RUnlock()
+	return fake.keyArgsForCall[i].request
+}
+
+func (fake *FakeCache) KeyReturns(result1 string, result2 bool) {
+	fake.KeyStub = nil
+	fake.keyReturns = struct {
+		result1 string
+		result2 bool
+	}{result1, result2}
+}
+
+func (fake *FakeCache) Lookup(logger lager.Logger, key string) (resultcache.Entry, bool) {
+	fake.lookupMutex.Lock()
+	fake.lookupArgsForCall = append(fake.lookupArgsForCall, struct {
+		logger lager.Logger
+		key    string
+	}{logger, key})
+	fake.lookupMutex.Unlock()
+	if fake.LookupStub != nil {
+		return fake.LookupStub(logger, key)
+	} else {
+		return fake.lookupReturns.result1, fake.lookupReturns.result2
+	}
+}
+
+func (fake *FakeCache) LookupCallCount() int {
+	fake.lookupMutex.RLock()
+	defer fake.lookupMutex.RUnlock()
+	return len(fake.lookupArgsForCall)
+}
+
+func (fake *FakeCache) LookupArgsForCall(i int) (lager.Logger, string) {
+	fake.lookupMutex.RLock()
+	defer fake.lookupMutex.RUnlock()
+	return fake.lookupArgsForCall[i].logger, fake.lookupArgsForCall[i].key
+}
+
+func (fake *FakeCache) LookupReturns(result1 resultcache.Entry, result2 bool) {
+	fake.LookupStub = nil
+	fake.lookupReturns = struct {
+		result1 resultcache.Entry
+		result2 bool
+	}{result1, result2}
+}
+
+func (fake *FakeCache) Record(logger lager.Logger, key string, stagingGuid string, response []byte) {
+	var responseCopy []byte
+	if response != nil {
+		responseCopy = make([]byte, len(response))
+		copy(responseCopy, response)
+	}
+	fake.recordMutex.Lock()
+	fake.recordArgsForCall = append(fake.recordArgsForCall, struct {
+		logger      lager.Logger
+		key         string
+		stagingGuid string
+		response    []byte
+	}{logger, key, stagingGuid, responseCopy})
+	fake.recordMutex.Unlock()
+	if fake.RecordStub != nil {
+		fake.RecordStub(logger, key, stagingGuid, response)
+	}
+}
+
+func (fake *FakeCache) RecordCallCount() int {
+	fake.recordMutex.RLock()
+	defer fake.recordMutex.RUnlock()
+	return len(fake.recordArgsForCall)
+}
+
+func (fake *FakeCache) RecordArgsForCall(i int) (lager.Logger, string, string, []byte) {
+	fake.recordMutex.RLock()
+	defer fake.recordMutex.RUnlock()
+	return fake.recordArgsForCall[i].logger, fake.recordArgsForCall[i].key, fake.recordArgsForCall[i].stagingGuid, fake.recordArgsForCall[i].response
+}
+
+func (fake *FakeCache) Reuse(logger lager.Logger, entry resultcache.Entry, request cc_messages.StagingRequestFromCC) error {
+	fake.reuseMutex.Lock()
+	fake.reuseArgsForCall = append(fake.reuseArgsForCall, struct {
+		logger  lager.Logger
+		entry   resultcache.Entry
+		request cc_messages.StagingRequestFromCC
+	}{logger, entry, request})
+	fake.reuseMutex.Unlock()
+	if fake.ReuseStub != nil {
+		return fake.ReuseStub(logger, entry, request)
+	} else {
+		return fake.reuseReturns.result1
+	}
+}
+
+func (fake *FakeCache) ReuseCallCount() int {
+	fake.reuseMutex.RLock()
+	defer fake.reuseMutex.RUnlock()
+	return len(fake.reuseArgsForCall)
+}
+
+func (fake *FakeCache) ReuseArgsForCall(i int) (lager.Logger, resultcache.Entry, cc_messages.StagingRequestFromCC) {
+	fake.reuseMutex.RLock()
+	defer fake.reuseMutex.RUnlock()
+	return fake.reuseArgsForCall[i].logger, fake.reuseArgsForCall[i].entry, fake.reuseArgsForCall[i].request
+}
+
+func (fake *FakeCache) ReuseReturns(result1 error) {
+	fake.ReuseStub = nil
+	fake.reuseReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeCache) Begin(logger lager.Logger, reuse resultcache.PendingReuse) error {
+	fake.beginMutex.Lock()
+	fake.beginArgsForCall = append(fake.beginArgsForCall, struct {
+		logger lager.Logger
+		reuse  resultcache.PendingReuse
+	}{logger, reuse})
+	fake.beginMutex.Unlock()
+	if fake.BeginStub != nil {
+		return fake.BeginStub(logger, reuse)
+	} else {
+		return fake.beginReturns.result1
+	}
+}
+
+func (fake *FakeCache) BeginCallCount() int {
+	fake.beginMutex.RLock()
+	defer fake.beginMutex.RUnlock()
+	return len(fake.beginArgsForCall)
+}
+
+func (fake *FakeCache) BeginArgsForCall(i int) (lager.Logger, resultcache.PendingReuse) {
+	fake.beginMutex.RLock()
+	defer fake.beginMutex.RUnlock()
+	return fake.beginArgsForCall[i].logger, fake.beginArgsForCall[i].reuse
+}
+
+func (fake *FakeCache) BeginReturns(result1 error) {
+	fake.BeginStub = nil
+	fake.beginReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeCache) Finish(logger lager.Logger, stagingGuid string) {
+	fake.finishMutex.Lock()
+	fake.finishArgsForCall = append(fake.finishArgsForCall, struct {
+		logger      lager.Logger
+		stagingGuid string
+	}{logger, stagingGuid})
+	fake.finishMutex.Unlock()
+	if fake.FinishStub != nil {
+		fake.FinishStub(logger, stagingGuid)
+	}
+}
+
+func (fake *FakeCache) FinishCallCount() int {
+	fake.finishMutex.RLock()
+	defer fake.finishMutex.RUnlock()
+	return len(fake.finishArgsForCall)
+}
+
+func (fake *FakeCache) FinishArgsForCall(i int) (lager.Logger, string) {
+	fake.finishMutex.RLock()
+	defer fake.finishMutex.RUnlock()
+	return fake.finishArgsForCall[i].logger, fake.finishArgsForCall[i].stagingGuid
+}
+
+func (fake *FakeCache) Pending(logger lager.Logger) []resultcache.PendingReuse {
+	fake.pendingMutex.Lock()
+	fake.pendingArgsForCall = append(fake.pendingArgsForCall, struct {
+		logger lager.Logger
+	}{logger})
+	fake.pendingMutex.Unlock()
+	if fake.PendingStub != nil {
+		return fake.PendingStub(logger)
+	} else {
+		return fake.pendingReturns.result1
+	}
+}
+
+func (fake *FakeCache) PendingCallCount() int {
+	fake.pendingMutex.RLock()
+	defer fake.pendingMutex.RUnlock()
+	return len(fake.pendingArgsForCall)
+}
+
+func (fake *FakeCache) PendingArgsForCall(i int) lager.Logger {
+	fake.pendingMutex.RLock()
+	defer fake.pendingMutex.RUnlock()
+	return fake.pendingArgsForCall[i].logger
+}
+
+func (fake *FakeCache) PendingReturns(result1 []resultcache.PendingReuse) {
+	fake.PendingStub = nil
+	fake.pendingReturns = struct {
+		result1 []resultcache.PendingReuse
+	}{result1}
+}
+
+var _ resultcache.Cache = new(FakeCache)
@@ -0,0 +1,319 @@
+package resultcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/runtimeschema/metric"
+	"code.cloudfoundry.org/stager/backend"
+)
+
+const (
+	StagingGuidPlaceholder = ":staging_guid"
+	DefaultTTL             = 24 * time.Hour
+
+	stagingResultCacheHitsCounter   = metric.Counter("StagingResultCacheHits")
+	stagingResultCacheMissesCounter = metric.Counter("StagingResultCacheMisses")
+)
+
+//go:generate counterfeiter -o fakes/fake_cache.go . Cache
+type Cache interface {
+	// Key returns the fingerprint of the inputs of a staging request, or false
+	// if its result cannot be reused.
+	Key(request cc_messages.StagingRequestFromCC) (string, bool)
+	Lookup(logger lager.Logger, key string) (Entry, bool)
+	Record(logger lager.Logger, key, stagingGuid string, response []byte)
+	// Reuse copies the droplet of a cached staging to the droplet upload
+	// location of request.
+	Reuse(logger lager.Logger, entry Entry, request cc_messages.StagingRequestFromCC) error
+
+	// Begin journals a reuse before its droplet is copied and Finish forgets
+	// it once its outcome is reported, so that Pending returns the reuses a
+	// restart interrupted.
+	Begin(logger lager.Logger, reuse PendingReuse) error
+	Finish(logger lager.Logger, stagingGuid string)
+	Pending(logger lager.Logger) []PendingReuse
+}
+
+type checksum struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// cacheInputs holds the lifecycle data fields the cache needs that
+// cc_messages.BuildpackStagingData does not carry.
+type cacheInputs struct {
+	AppBitsChecksum checksum `json:"app_bits_checksum"`
+}
+
+type cache struct {
+	store         Store
+	config        func() backend.Config
+	ccUploaderURL string
+	dropletURL    string
+	httpClient    *http.Client
+	ttl           time.Duration
+	clock         clock.Clock
+
+	pruneLock sync.Mutex
+	lastPrune time.Time
+}
+
+// New returns a Cache indexing staging results in store. config returns the
+// current backend configuration, for the lifecycle bundle mapping and the
+// staging timeouts. dropletURL is where the droplet of
+// a previous staging can be downloaded from, with StagingGuidPlaceholder
+// standing in for its staging guid. Entries are not reused once they are
+// older than ttl, since CC may have deleted their droplets by then, and are
+// pruned from the store at most once per ttl.
+func New(store Store, config func() backend.Config, ccUploaderURL, dropletURL string, httpClient *http.Client, ttl time.Duration, clock clock.Clock) Cache {
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+
+	return &cache{
+		store:         store,
+		config:        config,
+		ccUploaderURL: ccUploaderURL,
+		dropletURL:    dropletURL,
+		httpClient:    httpClient,
+		ttl:           ttl,
+		clock:         clock,
+	}
+}
+
+func (c *cache) Key(request cc_messages.StagingRequestFromCC) (string, bool) {
+	if request.Lifecycle != backend.TraditionalLifecycleName || request.LifecycleData == nil {
+		return "", false
+	}
+
+	var lifecycleData cc_messages.BuildpackStagingData
+	err := json.Unmarshal(*request.LifecycleData, &lifecycleData)
+	if err != nil {
+		return "", false
+	}
+
+	var inputs cacheInputs
+	err = json.Unmarshal(*request.LifecycleData, &inputs)
+	if err != nil || inputs.AppBitsChecksum.Value == "" {
+		return "", false
+	}
+
+	lifecycleBundle, ok := c.config().Lifecycles[request.Lifecycle+"/"+lifecycleData.Stack]
+	if !ok {
+		return "", false
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "lifecycle:%s\n", request.Lifecycle)
+	fmt.Fprintf(hash, "stack:%s\n", lifecycleData.Stack)
+	fmt.Fprintf(hash, "lifecycle-bundle:%s\n", lifecycleBundle)
+	fmt.Fprintf(hash, "app-bits:%s:%s\n", inputs.AppBitsChecksum.Type, inputs.AppBitsChecksum.Value)
+
+	for _, buildpack := range lifecycleData.Buildpacks {
+		// custom buildpacks are fetched from arbitrary URLs whose contents
+		// may change without their key changing
+		if buildpack.Name == cc_messages.CUSTOM_BUILDPACK || buildpack.Key == "" {
+			return "", false
+		}
+		fmt.Fprintf(hash, "buildpack:%s:%t\n", buildpack.Key, buildpack.SkipDetect)
+	}
+
+	env := []string{}
+	for _, envVar := range request.Environment {
+		env = append(env, envVar.Name+"="+envVar.Value)
+	}
+	sort.Strings(env)
+	for _, envVar := range env {
+		fmt.Fprintf(hash, "env:%s\n", envVar)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), true
+}
+
+func (c *cache) Lookup(logger lager.Logger, key string) (Entry, bool) {
+	logger = logger.Session("staging-result-cache-lookup", lager.Data{"key": key})
+
+	entry, found, err := c.store.Get(key)
+	if err != nil {
+		logger.Error("failed-to-read-entry", err)
+		found = false
+	}
+
+	if found && c.clock.Now().Sub(entry.RecordedAt) > c.ttl {
+		logger.Info("expired", lager.Data{"recorded-at": entry.RecordedAt})
+		err := c.store.Delete(key)
+		if err != nil {
+			logger.Error("failed-to-delete-entry", err)
+		}
+		found = false
+	}
+
+	if !found {
+		stagingResultCacheMissesCounter.Increment()
+		return Entry{}, false
+	}
+
+	stagingResultCacheHitsCounter.Increment()
+	logger.Info("hit", lager.Data{"cached-staging-guid": entry.StagingGuid})
+	return entry, true
+}
+
+func (c *cache) Record(logger lager.Logger, key, stagingGuid string, response []byte) {
+	logger = logger.Session("staging-result-cache-record", lager.Data{"key": key, "staging-guid": stagingGuid})
+
+	err := c.store.Put(key, Entry{
+		StagingGuid: stagingGuid,
+		Response:    json.RawMessage(response),
+		RecordedAt:  c.clock.Now(),
+	})
+	if err != nil {
+		logger.Error("failed-to-write-entry", err)
+		return
+	}
+
+	logger.Info("recorded")
+
+	c.prune(logger)
+}
+
+func (c *cache) prune(logger lager.Logger) {
+	c.pruneLock.Lock()
+	defer c.pruneLock.Unlock()
+
+	now := c.clock.Now()
+	if now.Sub(c.lastPrune) < c.ttl {
+		return
+	}
+	c.lastPrune = now
+
+	err := c.store.Prune(now.Add(-c.ttl))
+	if err != nil {
+		logger.Error("failed-to-prune-entries", err)
+	}
+}
+
+func (c *cache) Reuse(logger lager.Logger, entry Entry, request cc_messages.StagingRequestFromCC) error {
+	logger = logger.Session("staging-result-cache-reuse", lager.Data{"cached-staging-guid": entry.StagingGuid})
+
+	if request.LifecycleData == nil {
+		return errors.New("missing lifecycle data")
+	}
+
+	var lifecycleData cc_messages.BuildpackStagingData
+	err := json.Unmarshal(*request.LifecycleData, &lifecycleData)
+	if err != nil {
+		return err
+	}
+
+	uploadURL, err := backend.DropletUploadURL(c.ccUploaderURL, request.AppId, lifecycleData.DropletUploadUri)
+	if err != nil {
+		return err
+	}
+
+	uploadTimeout := c.config().UploadTimeout(logger, request.Lifecycle, lifecycleData.Stack, request)
+	uploadURL = backend.AddTimeoutParamToURL(*uploadURL, uploadTimeout)
+
+	downloadURL := strings.Replace(c.dropletURL, StagingGuidPlaceholder, entry.StagingGuid, -1)
+
+	logger.Info("copying-droplet", lager.Data{"from": downloadURL})
+
+	download, err := c.httpClient.Get(downloadURL)
+	if err != nil {
+		return err
+	}
+	defer download.Body.Close()
+
+	if download.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloading cached droplet failed with status %d", download.StatusCode)
+	}
+
+	droplet, size, err := sizedBody(download)
+	if err != nil {
+		return err
+	}
+	defer droplet.Close()
+
+	uploadRequest, err := http.NewRequest("POST", uploadURL.String(), droplet)
+	if err != nil {
+		return err
+	}
+	uploadRequest.ContentLength = size
+	uploadRequest.Header.Set("Content-Type", "application/octet-stream")
+
+	upload, err := c.httpClient.Do(uploadRequest)
+	if err != nil {
+		return err
+	}
+	defer upload.Body.Close()
+
+	if upload.StatusCode < 200 || upload.StatusCode > 299 {
+		return fmt.Errorf("uploading cached droplet failed with status %d", upload.StatusCode)
+	}
+
+	logger.Info("copied-droplet")
+	return nil
+}
+
+func (c *cache) Begin(logger lager.Logger, reuse PendingReuse) error {
+	err := c.store.PutPending(reuse)
+	if err != nil {
+		logger.Error("failed-to-journal-reuse", err, lager.Data{"staging-guid": reuse.StagingGuid})
+	}
+	return err
+}
+
+func (c *cache) Finish(logger lager.Logger, stagingGuid string) {
+	err := c.store.DeletePending(stagingGuid)
+	if err != nil {
+		logger.Error("failed-to-forget-reuse", err, lager.Data{"staging-guid": stagingGuid})
+	}
+}
+
+func (c *cache) Pending(logger lager.Logger) []PendingReuse {
+	reuses, err := c.store.Pending()
+	if err != nil {
+		logger.Error("failed-to-list-pending-reuses", err)
+	}
+	return reuses
+}
+
+// sizedBody returns the body of a download along with its size. A download
+// of unknown length, e.g. a chunked one, is spooled to a temporary file
+// first, since cc-uploader needs to know the size of an upload.
+func sizedBody(download *http.Response) (io.ReadCloser, int64, error) {
+	if download.ContentLength >= 0 {
+		return ioutil.NopCloser(download.Body), download.ContentLength, nil
+	}
+
+	spool, err := ioutil.TempFile("", "cached-droplet")
+	if err != nil {
+		return nil, 0, err
+	}
+	os.Remove(spool.Name())
+
+	size, err := io.Copy(spool, download.Body)
+	if err == nil {
+		_, err = spool.Seek(0, 0)
+	}
+	if err != nil {
+		spool.Close()
+		return nil, 0, err
+	}
+
+	return spool, size, nil
+}
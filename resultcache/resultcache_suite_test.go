@@ -0,0 +1,13 @@
+package resultcache_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestResultCache(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Result Cache Suite")
+}
@@ -0,0 +1,390 @@
+package resultcache_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/resultcache"
+	fake_metric_sender "github.com/cloudfoundry/dropsonde/metric_sender/fake"
+	"github.com/cloudfoundry/dropsonde/metrics"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("Result Cache", func() {
+	var (
+		logger           lager.Logger
+		fakeMetricSender *fake_metric_sender.FakeMetricSender
+		fakeClock        *fakeclock.FakeClock
+
+		storeDir     string
+		dropletStore *ghttp.Server
+		ccUploader   *ghttp.Server
+
+		lifecycles      map[string]string
+		stagingTimeouts map[string]backend.TimeoutPolicy
+		cache           resultcache.Cache
+		request         cc_messages.StagingRequestFromCC
+	)
+
+	buildRequest := func(lifecycleData string) cc_messages.StagingRequestFromCC {
+		rawData := json.RawMessage(lifecycleData)
+		return cc_messages.StagingRequestFromCC{
+			AppId:         "app-id",
+			Lifecycle:     "buildpack",
+			LifecycleData: &rawData,
+			Environment: []*models.EnvironmentVariable{
+				{Name: "FOO", Value: "bar"},
+				{Name: "BAZ", Value: "qux"},
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+
+		fakeMetricSender = fake_metric_sender.NewFakeMetricSender()
+		metrics.Initialize(fakeMetricSender, nil)
+
+		var err error
+		storeDir, err = ioutil.TempDir("", "result-cache")
+		Expect(err).NotTo(HaveOccurred())
+
+		dropletStore = ghttp.NewServer()
+		ccUploader = ghttp.NewServer()
+
+		store, err := resultcache.NewFileStore(storeDir)
+		Expect(err).NotTo(HaveOccurred())
+
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+
+		lifecycles = map[string]string{"buildpack/cflinuxfs2": "buildpack_app_lifecycle.tgz"}
+		stagingTimeouts = map[string]backend.TimeoutPolicy{}
+		cache = resultcache.New(
+			store,
+			func() backend.Config {
+				return backend.Config{Lifecycles: lifecycles, StagingTimeouts: stagingTimeouts}
+			},
+			ccUploader.URL(),
+			dropletStore.URL()+"/droplets/:staging_guid",
+			nil,
+			time.Hour,
+			fakeClock,
+		)
+
+		request = buildRequest(`{
+			"stack": "cflinuxfs2",
+			"app_bits_checksum": {"type": "sha256", "value": "abc123"},
+			"droplet_upload_uri": "http://cc.example.com/droplets/new-droplet",
+			"buildpacks": [{"name": "ruby", "key": "ruby-key", "url": "http://file-server/ruby.zip"}]
+		}`)
+	})
+
+	AfterEach(func() {
+		dropletStore.Close()
+		ccUploader.Close()
+		os.RemoveAll(storeDir)
+	})
+
+	Describe("Key", func() {
+		It("is stable for identical inputs", func() {
+			key, ok := cache.Key(request)
+			Expect(ok).To(BeTrue())
+			Expect(key).NotTo(BeEmpty())
+
+			request.Environment = []*models.EnvironmentVariable{
+				{Name: "BAZ", Value: "qux"},
+				{Name: "FOO", Value: "bar"},
+			}
+			sameKey, ok := cache.Key(request)
+			Expect(ok).To(BeTrue())
+			Expect(sameKey).To(Equal(key))
+		})
+
+		It("changes when the app bits change", func() {
+			key, _ := cache.Key(request)
+
+			otherKey, ok := cache.Key(buildRequest(`{
+				"stack": "cflinuxfs2",
+				"app_bits_checksum": {"type": "sha256", "value": "def456"},
+				"buildpacks": [{"name": "ruby", "key": "ruby-key", "url": "http://file-server/ruby.zip"}]
+			}`))
+			Expect(ok).To(BeTrue())
+			Expect(otherKey).NotTo(Equal(key))
+		})
+
+		It("changes when the buildpacks change", func() {
+			key, _ := cache.Key(request)
+
+			otherKey, ok := cache.Key(buildRequest(`{
+				"stack": "cflinuxfs2",
+				"app_bits_checksum": {"type": "sha256", "value": "abc123"},
+				"buildpacks": [{"name": "ruby", "key": "ruby-key-v2", "url": "http://file-server/ruby.zip"}]
+			}`))
+			Expect(ok).To(BeTrue())
+			Expect(otherKey).NotTo(Equal(key))
+		})
+
+		It("changes when the environment changes", func() {
+			key, _ := cache.Key(request)
+
+			request.Environment = append(request.Environment, &models.EnvironmentVariable{Name: "NEW", Value: "var"})
+			otherKey, ok := cache.Key(request)
+			Expect(ok).To(BeTrue())
+			Expect(otherKey).NotTo(Equal(key))
+		})
+
+		It("changes when the lifecycle bundle is reconfigured", func() {
+			key, _ := cache.Key(request)
+
+			lifecycles = map[string]string{"buildpack/cflinuxfs2": "buildpack_app_lifecycle-v2.tgz"}
+			otherKey, ok := cache.Key(request)
+			Expect(ok).To(BeTrue())
+			Expect(otherKey).NotTo(Equal(key))
+		})
+
+		It("is not available without an app bits checksum", func() {
+			_, ok := cache.Key(buildRequest(`{"stack": "cflinuxfs2"}`))
+			Expect(ok).To(BeFalse())
+		})
+
+		It("is not available for custom buildpacks", func() {
+			_, ok := cache.Key(buildRequest(`{
+				"stack": "cflinuxfs2",
+				"app_bits_checksum": {"type": "sha256", "value": "abc123"},
+				"buildpacks": [{"name": "custom", "key": "https://github.com/some/buildpack", "url": "https://github.com/some/buildpack"}]
+			}`))
+			Expect(ok).To(BeFalse())
+		})
+
+		It("is not available for stacks without a lifecycle bundle", func() {
+			_, ok := cache.Key(buildRequest(`{
+				"stack": "unknown-stack",
+				"app_bits_checksum": {"type": "sha256", "value": "abc123"}
+			}`))
+			Expect(ok).To(BeFalse())
+		})
+
+		It("is not available for docker stagings", func() {
+			request.Lifecycle = "docker"
+			_, ok := cache.Key(request)
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Describe("Lookup and Record", func() {
+		It("misses until a result is recorded", func() {
+			_, found := cache.Lookup(logger, "some-key")
+			Expect(found).To(BeFalse())
+			Expect(fakeMetricSender.GetCounter("StagingResultCacheMisses")).To(Equal(uint64(1)))
+
+			cache.Record(logger, "some-key", "staging-guid", []byte(`{"result":{}}`))
+
+			entry, found := cache.Lookup(logger, "some-key")
+			Expect(found).To(BeTrue())
+			Expect(entry.StagingGuid).To(Equal("staging-guid"))
+			Expect(entry.Response).To(MatchJSON(`{"result":{}}`))
+			Expect(fakeMetricSender.GetCounter("StagingResultCacheHits")).To(Equal(uint64(1)))
+		})
+
+		It("misses once a result is older than the ttl", func() {
+			cache.Record(logger, "some-key", "staging-guid", []byte(`{}`))
+
+			fakeClock.Increment(time.Hour + time.Second)
+
+			_, found := cache.Lookup(logger, "some-key")
+			Expect(found).To(BeFalse())
+			Expect(filepath.Join(storeDir, "some-key.json")).NotTo(BeAnExistingFile())
+		})
+
+		It("prunes expired entries from the store when recording", func() {
+			cache.Record(logger, "old-key", "old-staging-guid", []byte(`{}`))
+
+			fakeClock.Increment(time.Hour + time.Second)
+			cache.Record(logger, "new-key", "new-staging-guid", []byte(`{}`))
+
+			Expect(filepath.Join(storeDir, "old-key.json")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(storeDir, "new-key.json")).To(BeAnExistingFile())
+		})
+
+		It("persists entries in the store directory", func() {
+			cache.Record(logger, "some-key", "staging-guid", []byte(`{}`))
+
+			store, err := resultcache.NewFileStore(storeDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			entry, found, err := store.Get("some-key")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeTrue())
+			Expect(entry.StagingGuid).To(Equal("staging-guid"))
+		})
+	})
+
+	Describe("pending reuses", func() {
+		var reuse resultcache.PendingReuse
+
+		BeforeEach(func() {
+			reuse = resultcache.PendingReuse{
+				StagingGuid:    "staging-guid",
+				RequestId:      "request-id",
+				Request:        request,
+				TaskGuid:       "staging-guid",
+				Domain:         "staging-domain",
+				TaskDefinition: &models.TaskDefinition{MemoryMb: 1024},
+			}
+		})
+
+		It("survives a restart until it is finished", func() {
+			Expect(cache.Begin(logger, reuse)).To(Succeed())
+
+			store, err := resultcache.NewFileStore(storeDir)
+			Expect(err).NotTo(HaveOccurred())
+			restarted := resultcache.New(store, func() backend.Config { return backend.Config{} }, "", "", nil, time.Hour, fakeClock)
+
+			pending := restarted.Pending(logger)
+			Expect(pending).To(HaveLen(1))
+			Expect(pending[0].StagingGuid).To(Equal("staging-guid"))
+			Expect(pending[0].Request.AppId).To(Equal("app-id"))
+			Expect(pending[0].TaskDefinition.MemoryMb).To(BeEquivalentTo(1024))
+
+			restarted.Finish(logger, "staging-guid")
+			Expect(cache.Pending(logger)).To(BeEmpty())
+		})
+
+		It("is not pruned with the cached results", func() {
+			Expect(cache.Begin(logger, reuse)).To(Succeed())
+
+			fakeClock.Increment(time.Hour + time.Second)
+			cache.Record(logger, "new-key", "new-staging-guid", []byte(`{}`))
+
+			Expect(cache.Pending(logger)).To(HaveLen(1))
+		})
+	})
+
+	Describe("Reuse", func() {
+		var (
+			entry    resultcache.Entry
+			reuseErr error
+		)
+
+		BeforeEach(func() {
+			entry = resultcache.Entry{StagingGuid: "cached-staging-guid"}
+		})
+
+		JustBeforeEach(func() {
+			reuseErr = cache.Reuse(logger, entry, request)
+		})
+
+		Context("when the cached droplet can be copied", func() {
+			BeforeEach(func() {
+				dropletStore.AppendHandlers(ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/droplets/cached-staging-guid"),
+					ghttp.RespondWith(http.StatusOK, "droplet-bits"),
+				))
+
+				ccUploader.AppendHandlers(ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/v1/droplet/app-id", "cc-droplet-upload-uri=http%3A%2F%2Fcc.example.com%2Fdroplets%2Fnew-droplet&timeout=900"),
+					ghttp.VerifyBody([]byte("droplet-bits")),
+					ghttp.RespondWith(http.StatusCreated, nil),
+				))
+			})
+
+			It("uploads the cached droplet to the new droplet location", func() {
+				Expect(reuseErr).NotTo(HaveOccurred())
+				Expect(dropletStore.ReceivedRequests()).To(HaveLen(1))
+				Expect(ccUploader.ReceivedRequests()).To(HaveLen(1))
+			})
+		})
+
+		Context("when the cached droplet is downloaded without a content length", func() {
+			BeforeEach(func() {
+				request.Timeout = 60
+
+				dropletStore.AppendHandlers(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte("droplet-"))
+					w.(http.Flusher).Flush()
+					w.Write([]byte("bits"))
+				})
+
+				ccUploader.AppendHandlers(ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/v1/droplet/app-id", "cc-droplet-upload-uri=http%3A%2F%2Fcc.example.com%2Fdroplets%2Fnew-droplet&timeout=60"),
+					func(w http.ResponseWriter, r *http.Request) {
+						Expect(r.ContentLength).To(BeEquivalentTo(len("droplet-bits")))
+					},
+					ghttp.VerifyBody([]byte("droplet-bits")),
+					ghttp.RespondWith(http.StatusCreated, nil),
+				))
+			})
+
+			It("uploads it with its size", func() {
+				Expect(reuseErr).NotTo(HaveOccurred())
+				Expect(ccUploader.ReceivedRequests()).To(HaveLen(1))
+			})
+		})
+
+		Context("when the operator bounds staging uploads", func() {
+			BeforeEach(func() {
+				dropletStore.AppendHandlers(ghttp.RespondWith(http.StatusOK, "droplet-bits"))
+				ccUploader.AppendHandlers(ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/v1/droplet/app-id", "cc-droplet-upload-uri=http%3A%2F%2Fcc.example.com%2Fdroplets%2Fnew-droplet&timeout=300"),
+					ghttp.RespondWith(http.StatusCreated, nil),
+				))
+			})
+
+			Context("with an upload timeout", func() {
+				BeforeEach(func() {
+					stagingTimeouts["buildpack/cflinuxfs2"] = backend.TimeoutPolicy{Upload: 5 * time.Minute}
+				})
+
+				It("gives cc-uploader the upload timeout", func() {
+					Expect(reuseErr).NotTo(HaveOccurred())
+				})
+			})
+
+			Context("with a maximum staging timeout below the requested one", func() {
+				BeforeEach(func() {
+					request.Timeout = 3600
+					stagingTimeouts["buildpack"] = backend.TimeoutPolicy{Maximum: 5 * time.Minute}
+				})
+
+				It("gives cc-uploader the capped timeout", func() {
+					Expect(reuseErr).NotTo(HaveOccurred())
+				})
+			})
+		})
+
+		Context("when the cached droplet is gone", func() {
+			BeforeEach(func() {
+				dropletStore.AppendHandlers(ghttp.RespondWith(http.StatusNotFound, nil))
+			})
+
+			It("returns an error without uploading", func() {
+				Expect(reuseErr).To(HaveOccurred())
+				Expect(ccUploader.ReceivedRequests()).To(BeEmpty())
+			})
+		})
+
+		Context("when the upload fails", func() {
+			BeforeEach(func() {
+				dropletStore.AppendHandlers(ghttp.RespondWith(http.StatusOK, "droplet-bits"))
+				ccUploader.AppendHandlers(ghttp.RespondWith(http.StatusInternalServerError, nil))
+			})
+
+			It("returns an error", func() {
+				Expect(reuseErr).To(HaveOccurred())
+			})
+		})
+	})
+})
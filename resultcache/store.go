@@ -0,0 +1,190 @@
+package resultcache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+)
+
+type Entry struct {
+	StagingGuid string          `json:"staging_guid"`
+	Response    json.RawMessage `json:"response"`
+	RecordedAt  time.Time       `json:"recorded_at"`
+}
+
+// PendingReuse is a reuse of a cached staging result whose outcome has not
+// been reported yet, along with the staging task to desire instead.
+type PendingReuse struct {
+	StagingGuid    string                           `json:"staging_guid"`
+	RequestId      string                           `json:"request_id,omitempty"`
+	Request        cc_messages.StagingRequestFromCC `json:"request"`
+	TaskGuid       string                           `json:"task_guid"`
+	Domain         string                           `json:"domain"`
+	TaskDefinition *models.TaskDefinition           `json:"task_definition"`
+}
+
+type Store interface {
+	Get(key string) (Entry, bool, error)
+	Put(key string, entry Entry) error
+	Delete(key string) error
+	// Prune deletes the entries recorded before cutoff.
+	Prune(cutoff time.Time) error
+
+	PutPending(reuse PendingReuse) error
+	DeletePending(stagingGuid string) error
+	Pending() ([]PendingReuse, error)
+}
+
+type fileStore struct {
+	dir string
+}
+
+// NewFileStore returns a Store that indexes entries as one JSON file per key
+// in dir, and pending reuses as one JSON file per staging guid in its
+// pending directory.
+func NewFileStore(dir string) (Store, error) {
+	err := os.MkdirAll(filepath.Join(dir, "pending"), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileStore{dir: dir}, nil
+}
+
+func (s *fileStore) Get(key string) (Entry, bool, error) {
+	var entry Entry
+
+	payload, err := ioutil.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return entry, false, nil
+	}
+	if err != nil {
+		return entry, false, err
+	}
+
+	err = json.Unmarshal(payload, &entry)
+	if err != nil {
+		return entry, false, err
+	}
+
+	return entry, true, nil
+}
+
+func (s *fileStore) Put(key string, entry Entry) error {
+	return writeJSON(s.path(key), entry)
+}
+
+// writeJSON replaces the file at path with v in one step, so that a crash
+// cannot leave it half written.
+func writeJSON(path string, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), "entry")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmpFile.Write(payload)
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), path)
+}
+
+func (s *fileStore) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *fileStore) Prune(cutoff time.Time) error {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+
+		key := strings.TrimSuffix(file.Name(), ".json")
+		entry, found, err := s.Get(key)
+		if err != nil || !found || !entry.RecordedAt.Before(cutoff) {
+			continue
+		}
+
+		err = s.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *fileStore) PutPending(reuse PendingReuse) error {
+	return writeJSON(s.pendingPath(reuse.StagingGuid), reuse)
+}
+
+func (s *fileStore) DeletePending(stagingGuid string) error {
+	err := os.Remove(s.pendingPath(stagingGuid))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *fileStore) Pending() ([]PendingReuse, error) {
+	files, err := ioutil.ReadDir(filepath.Join(s.dir, "pending"))
+	if err != nil {
+		return nil, err
+	}
+
+	reuses := []PendingReuse{}
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+
+		payload, err := ioutil.ReadFile(filepath.Join(s.dir, "pending", file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		var reuse PendingReuse
+		err = json.Unmarshal(payload, &reuse)
+		if err != nil {
+			return nil, err
+		}
+
+		reuses = append(reuses, reuse)
+	}
+
+	return reuses, nil
+}
+
+func (s *fileStore) path(key string) string {
+	return filepath.Join(s.dir, key+".json")
+}
+
+func (s *fileStore) pendingPath(stagingGuid string) string {
+	return filepath.Join(s.dir, "pending", stagingGuid+".json")
+}
@@ -6,10 +6,14 @@ const (
 	StageRoute            = "Stage"
 	StopStagingRoute      = "StopStaging"
 	StagingCompletedRoute = "StagingCompleted"
+	OpenAPIRoute          = "OpenAPI"
+	CapabilitiesRoute     = "Capabilities"
 )
 
 var Routes = rata.Routes{
 	{Path: "/v1/staging/:staging_guid", Method: "PUT", Name: StageRoute},
 	{Path: "/v1/staging/:staging_guid", Method: "DELETE", Name: StopStagingRoute},
 	{Path: "/v1/staging/:staging_guid/completed", Method: "POST", Name: StagingCompletedRoute},
+	{Path: "/v1/openapi.json", Method: "GET", Name: OpenAPIRoute},
+	{Path: "/v1/capabilities", Method: "GET", Name: CapabilitiesRoute},
 }
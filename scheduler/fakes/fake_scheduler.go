@@ -0,0 +1,221 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/scheduler"
+)
+
+type FakeScheduler struct {
+	SubmitStub        func(logger lager.Logger, request cc_messages.StagingRequestFromCC, job scheduler.Job) error
+	submitMutex       sync.RWMutex
+	submitArgsForCall []struct {
+		logger  lager.Logger
+		request cc_messages.StagingRequestFromCC
+		job     scheduler.Job
+	}
+	submitReturns struct {
+		result1 error
+	}
+	ResubmitStub        func(logger lager.Logger, previousTaskGuid string, job scheduler.Job) error
+	resubmitMutex       sync.RWMutex
+	resubmitArgsForCall []struct {
+		logger           lager.Logger
+		previousTaskGuid string
+		job              scheduler.Job
+	}
+	resubmitReturns struct {
+		result1 error
+	}
+	RemoveStub        func(logger lager.Logger, guid string) (scheduler.Job, bool)
+	removeMutex       sync.RWMutex
+	removeArgsForCall []struct {
+		logger lager.Logger
+		guid   string
+	}
+	removeReturns struct {
+		result1 scheduler.Job
+		result2 bool
+	}
+	RemoveAppStub        func(logger lager.Logger, appId, exceptTaskGuid string) []scheduler.Job
+	removeAppMutex       sync.RWMutex
+	removeAppArgsForCall []struct {
+		logger         lager.Logger
+		appId          string
+		exceptTaskGuid string
+	}
+	removeAppReturns struct {
+		result1 []scheduler.Job
+	}
+	CompletedStub        func(logger lager.Logger, taskGuid string)
+	completedMutex       sync.RWMutex
+	completedArgsForCall []struct {
+		logger   lager.Logger
+		taskGuid string
+	}
+}
+
+func (fake *FakeScheduler) Submit(logger lager.Logger, request cc_messages.StagingRequestFromCC, job scheduler.Job) error {
+	fake.submitMutex.Lock()
+	fake.submitArgsForCall = append(fake.submitArgsForCall, struct {
+		logger  lager.Logger
+		request cc_messages.StagingRequestFromCC
+		job     scheduler.Job
+	}{logger, request, job})
+	fake.submitMutex.Unlock()
+	if fake.SubmitStub != nil {
+		return fake.SubmitStub(logger, request, job)
+	} else {
+		return fake.submitReturns.result1
+	}
+}
+
+func (fake *FakeScheduler) SubmitCallCount() int {
+	fake.submitMutex.RLock()
+	defer fake.submitMutex.RUnlock()
+	return len(fake.submitArgsForCall)
+}
+
+func (fake *FakeScheduler) SubmitArgsForCall(i int) (lager.Logger, cc_messages.StagingRequestFromCC, scheduler.Job) {
+	fake.submitMutex.RLock()
+	defer fake.submitMutex.RUnlock()
+	return fake.submitArgsForCall[i].logger, fake.submitArgsForCall[i].request, fake.submitArgsForCall[i].job
+}
+
+func (fake *FakeScheduler) SubmitReturns(result1 error) {
+	fake.SubmitStub = nil
+	fake.submitReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeScheduler) Resubmit(logger lager.Logger, previousTaskGuid string, job scheduler.Job) error {
+	fake.resubmitMutex.Lock()
+	fake.resubmitArgsForCall = append(fake.resubmitArgsForCall, struct {
+		logger           lager.Logger
+		previousTaskGuid string
+		job              scheduler.Job
+	}{logger, previousTaskGuid, job})
+	fake.resubmitMutex.Unlock()
+	if fake.ResubmitStub != nil {
+		return fake.ResubmitStub(logger, previousTaskGuid, job)
+	} else {
+		return fake.resubmitReturns.result1
+	}
+}
+
+func (fake *FakeScheduler) ResubmitCallCount() int {
+	fake.resubmitMutex.RLock()
+	defer fake.resubmitMutex.RUnlock()
+	return len(fake.resubmitArgsForCall)
+}
+
+func (fake *FakeScheduler) ResubmitArgsForCall(i int) (lager.Logger, string, scheduler.Job) {
+	fake.resubmitMutex.RLock()
+	defer fake.resubmitMutex.RUnlock()
+	return fake.resubmitArgsForCall[i].logger, fake.resubmitArgsForCall[i].previousTaskGuid, fake.resubmitArgsForCall[i].job
+}
+
+func (fake *FakeScheduler) ResubmitReturns(result1 error) {
+	fake.ResubmitStub = nil
+	fake.resubmitReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeScheduler) Remove(logger lager.Logger, guid string) (scheduler.Job, bool) {
+	fake.removeMutex.Lock()
+	fake.removeArgsForCall = append(fake.removeArgsForCall, struct {
+		logger lager.Logger
+		guid   string
+	}{logger, guid})
+	fake.removeMutex.Unlock()
+	if fake.RemoveStub != nil {
+		return fake.RemoveStub(logger, guid)
+	} else {
+		return fake.removeReturns.result1, fake.removeReturns.result2
+	}
+}
+
+func (fake *FakeScheduler) RemoveCallCount() int {
+	fake.removeMutex.RLock()
+	defer fake.removeMutex.RUnlock()
+	return len(fake.removeArgsForCall)
+}
+
+func (fake *FakeScheduler) RemoveArgsForCall(i int) (lager.Logger, string) {
+	fake.removeMutex.RLock()
+	defer fake.removeMutex.RUnlock()
+	return fake.removeArgsForCall[i].logger, fake.removeArgsForCall[i].guid
+}
+
+func (fake *FakeScheduler) RemoveReturns(result1 scheduler.Job, result2 bool) {
+	fake.RemoveStub = nil
+	fake.removeReturns = struct {
+		result1 scheduler.Job
+		result2 bool
+	}{result1, result2}
+}
+
+func (fake *FakeScheduler) RemoveApp(logger lager.Logger, appId string, exceptTaskGuid string) []scheduler.Job {
+	fake.removeAppMutex.Lock()
+	fake.removeAppArgsForCall = append(fake.removeAppArgsForCall, struct {
+		logger         lager.Logger
+		appId          string
+		exceptTaskGuid string
+	}{logger, appId, exceptTaskGuid})
+	fake.removeAppMutex.Unlock()
+	if fake.RemoveAppStub != nil {
+		return fake.RemoveAppStub(logger, appId, exceptTaskGuid)
+	} else {
+		return fake.removeAppReturns.result1
+	}
+}
+
+func (fake *FakeScheduler) RemoveAppCallCount() int {
+	fake.removeAppMutex.RLock()
+	defer fake.removeAppMutex.RUnlock()
+	return len(fake.removeAppArgsForCall)
+}
+
+func (fake *FakeScheduler) RemoveAppArgsForCall(i int) (lager.Logger, string, string) {
+	fake.removeAppMutex.RLock()
+	defer fake.removeAppMutex.RUnlock()
+	return fake.removeAppArgsForCall[i].logger, fake.removeAppArgsForCall[i].appId, fake.removeAppArgsForCall[i].exceptTaskGuid
+}
+
+func (fake *FakeScheduler) RemoveAppReturns(result1 []scheduler.Job) {
+	fake.RemoveAppStub = nil
+	fake.removeAppReturns = struct {
+		result1 []scheduler.Job
+	}{result1}
+}
+
+func (fake *FakeScheduler) Completed(logger lager.Logger, taskGuid string) {
+	fake.completedMutex.Lock()
+	fake.completedArgsForCall = append(fake.completedArgsForCall, struct {
+		logger   lager.Logger
+		taskGuid string
+	}{logger, taskGuid})
+	fake.completedMutex.Unlock()
+	if fake.CompletedStub != nil {
+		fake.CompletedStub(logger, taskGuid)
+	}
+}
+
+func (fake *FakeScheduler) CompletedCallCount() int {
+	fake.completedMutex.RLock()
+	defer fake.completedMutex.RUnlock()
+	return len(fake.completedArgsForCall)
+}
+
+func (fake *FakeScheduler) CompletedArgsForCall(i int) (lager.Logger, string) {
+	fake.completedMutex.RLock()
+	defer fake.completedMutex.RUnlock()
+	return fake.completedArgsForCall[i].logger, fake.completedArgsForCall[i].taskGuid
+}
+
+var _ scheduler.Scheduler = new(FakeScheduler)
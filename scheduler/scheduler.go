@@ -0,0 +1,428 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/runtimeschema/metric"
+	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/cc_client"
+	"code.cloudfoundry.org/stager/diego_errors"
+	"github.com/tedsuo/ifrit"
+)
+
+const (
+	DefaultReconcileInterval = 30 * time.Second
+
+	stagingQueueDepth = metric.Metric("StagingQueueDepth")
+)
+
+//go:generate counterfeiter -o fakes/fake_scheduler.go . Scheduler
+type Scheduler interface {
+	// Submit queues a staging task to be desired once the concurrency budget
+	// allows it.
+	Submit(logger lager.Logger, request cc_messages.StagingRequestFromCC, job Job) error
+	// Resubmit queues another attempt of a staging whose previous task
+	// failed, accounting it to the same tenant. The attempt is desired no
+	// earlier than job.NotBefore.
+	Resubmit(logger lager.Logger, previousTaskGuid string, job Job) error
+	// Remove takes a staging task that has not been desired yet off the
+	// queue, found by its task guid or its staging guid.
+	Remove(logger lager.Logger, guid string) (Job, bool)
+	// RemoveApp takes the staging tasks of an app other than exceptTaskGuid
+	// off the queue.
+	RemoveApp(logger lager.Logger, appId, exceptTaskGuid string) []Job
+	// Completed returns the budget held by a desired staging task.
+	Completed(logger lager.Logger, taskGuid string)
+}
+
+type Runner interface {
+	ifrit.Runner
+	Scheduler
+}
+
+// scheduler releases queued staging tasks to the BBS under a concurrency
+// budget, sharing it between tenants by weighted fair queuing. The budget
+// is per stager instance; instances do not coordinate. A queued job is
+// persisted before Submit returns. The tasks holding budget are persisted
+// to statePath once per pass of the run loop, since they are reconciled
+// with the BBS after a restart anyway.
+type scheduler struct {
+	logger      lager.Logger
+	bbsClient   bbs.Client
+	ccClient    cc_client.CcClient
+	clock       clock.Clock
+	tenants     TenantPolicy
+	classifier  *diego_errors.Classifier
+	concurrency int
+	interval    time.Duration
+	statePath   string
+
+	lock  sync.Mutex
+	state *state
+	wake  chan struct{}
+	// dirty is set when the state file is behind; dispatched holds the
+	// jobs whose files are deleted once it has caught up.
+	dirty      bool
+	dispatched []string
+}
+
+func New(
+	logger lager.Logger,
+	bbsClient bbs.Client,
+	ccClient cc_client.CcClient,
+	clock clock.Clock,
+	tenants TenantPolicy,
+	classifier *diego_errors.Classifier,
+	concurrency int,
+	interval time.Duration,
+	statePath string,
+) (Runner, error) {
+	state, err := loadState(statePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &scheduler{
+		logger:      logger.Session("scheduler"),
+		bbsClient:   bbsClient,
+		ccClient:    ccClient,
+		clock:       clock,
+		tenants:     tenants,
+		classifier:  classifier,
+		concurrency: concurrency,
+		interval:    interval,
+		statePath:   statePath,
+		state:       state,
+		wake:        make(chan struct{}, 1),
+	}, nil
+}
+
+func (s *scheduler) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	ticker := s.clock.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	close(ready)
+
+	s.reconcile()
+	s.dispatch()
+	s.flush()
+
+	for {
+		select {
+		case <-ticker.C():
+			s.reconcile()
+			s.dispatch()
+		case <-s.wake:
+			s.dispatch()
+		case <-signals:
+			s.flush()
+			return nil
+		}
+		s.flush()
+	}
+}
+
+func (s *scheduler) Submit(logger lager.Logger, request cc_messages.StagingRequestFromCC, job Job) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.known(job.TaskGuid) {
+		return nil
+	}
+
+	job.Tenant = s.tenants.TenantKey(request)
+	job.AppId = request.AppId
+	return s.enqueue(logger, job)
+}
+
+func (s *scheduler) Resubmit(logger lager.Logger, previousTaskGuid string, job Job) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.known(job.TaskGuid) {
+		return nil
+	}
+
+	tenant, inFlight := s.state.InFlight[previousTaskGuid]
+	if !inFlight {
+		tenant = s.tenants.TenantKey(cc_messages.StagingRequestFromCC{AppId: job.AppId})
+	}
+	job.Tenant = tenant
+
+	// the previous attempt has finished and no longer holds budget
+	delete(s.state.InFlight, previousTaskGuid)
+
+	err := s.enqueue(logger, job)
+	if err != nil {
+		if inFlight {
+			s.state.InFlight[previousTaskGuid] = tenant
+		}
+		return err
+	}
+	s.dirty = s.dirty || inFlight
+
+	if delay := time.Unix(0, job.NotBefore).Sub(s.clock.Now()); delay > 0 {
+		go func() {
+			<-s.clock.After(delay)
+			s.notify()
+		}()
+	}
+
+	return nil
+}
+
+// enqueue adds a job to the queue and persists the job. It must be called
+// with the lock held.
+func (s *scheduler) enqueue(logger lager.Logger, job Job) error {
+	job.VirtualStart = math.Max(s.state.VirtualTime, s.state.TenantFinishes[job.Tenant])
+	job.VirtualFinish = job.VirtualStart + 1/s.tenants.weight(job.Tenant)
+
+	previousFinish, hadFinish := s.state.TenantFinishes[job.Tenant]
+	s.state.TenantFinishes[job.Tenant] = job.VirtualFinish
+	job.QueuedAt = s.clock.Now().UnixNano()
+	s.state.Queue = append(s.state.Queue, job)
+
+	err := saveJob(s.statePath, job)
+	if err != nil {
+		s.state.Queue = s.state.Queue[:len(s.state.Queue)-1]
+		if hadFinish {
+			s.state.TenantFinishes[job.Tenant] = previousFinish
+		} else {
+			delete(s.state.TenantFinishes, job.Tenant)
+		}
+		return err
+	}
+
+	logger.Info("queued-staging-task", lager.Data{"task-guid": job.TaskGuid, "tenant": job.Tenant, "queue-depth": len(s.state.Queue)})
+	stagingQueueDepth.Send(len(s.state.Queue))
+	s.notify()
+
+	return nil
+}
+
+func (s *scheduler) Remove(logger lager.Logger, guid string) (Job, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i, job := range s.state.Queue {
+		if job.TaskGuid != guid && job.StagingGuid != guid {
+			continue
+		}
+
+		s.state.Queue = append(s.state.Queue[:i], s.state.Queue[i+1:]...)
+		s.deleteJob(logger, job.TaskGuid)
+		stagingQueueDepth.Send(len(s.state.Queue))
+
+		logger.Info("removed-queued-staging-task", lager.Data{"task-guid": job.TaskGuid})
+		return job, true
+	}
+
+	return Job{}, false
+}
+
+func (s *scheduler) RemoveApp(logger lager.Logger, appId, exceptTaskGuid string) []Job {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	removed := []Job{}
+	queue := s.state.Queue[:0]
+	for _, job := range s.state.Queue {
+		if appId == "" || job.AppId != appId || job.TaskGuid == exceptTaskGuid {
+			queue = append(queue, job)
+			continue
+		}
+
+		removed = append(removed, job)
+		s.deleteJob(logger, job.TaskGuid)
+		logger.Info("removed-queued-staging-task", lager.Data{"task-guid": job.TaskGuid})
+	}
+	s.state.Queue = queue
+
+	if len(removed) > 0 {
+		stagingQueueDepth.Send(len(s.state.Queue))
+	}
+
+	return removed
+}
+
+func (s *scheduler) Completed(logger lager.Logger, taskGuid string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.state.InFlight[taskGuid]; !ok {
+		return
+	}
+
+	delete(s.state.InFlight, taskGuid)
+	s.dirty = true
+	s.notify()
+}
+
+func (s *scheduler) dispatch() {
+	logger := s.logger.Session("dispatch")
+
+	for {
+		job, ok := s.next(logger)
+		if !ok {
+			return
+		}
+
+		logger.Info("desiring-task", lager.Data{"task-guid": job.TaskGuid, "tenant": job.Tenant, "request-id": job.RequestId})
+
+		err := s.bbsClient.DesireTask(logger, job.TaskGuid, job.Domain, job.TaskDefinition)
+		if err != nil && !models.ErrResourceExists.Equal(err) {
+			logger.Error("staging-failed", err, lager.Data{"task-guid": job.TaskGuid, "request-id": job.RequestId})
+			s.deleteJob(logger, job.TaskGuid)
+			s.Completed(logger, job.TaskGuid)
+			backend.EmitStagingRejection(logger, s.classifier, job.TaskDefinition.LogGuid, err)
+			s.reportFailure(logger, job, err)
+		}
+	}
+}
+
+// next moves the queued job with the earliest virtual finish time in flight,
+// unless the concurrency budget is used up. Jobs that are not due yet are
+// passed over.
+func (s *scheduler) next(logger lager.Logger) (Job, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.state.Queue) == 0 || len(s.state.InFlight) >= s.concurrency {
+		return Job{}, false
+	}
+
+	now := s.clock.Now().UnixNano()
+	nextIndex := -1
+	for i, job := range s.state.Queue {
+		if job.NotBefore > now {
+			continue
+		}
+		if nextIndex < 0 || job.VirtualFinish < s.state.Queue[nextIndex].VirtualFinish {
+			nextIndex = i
+		}
+	}
+	if nextIndex < 0 {
+		return Job{}, false
+	}
+
+	job := s.state.Queue[nextIndex]
+	s.state.Queue = append(s.state.Queue[:nextIndex], s.state.Queue[nextIndex+1:]...)
+	s.state.VirtualTime = math.Max(s.state.VirtualTime, job.VirtualStart)
+	s.state.InFlight[job.TaskGuid] = job.Tenant
+	s.dirty = true
+	s.dispatched = append(s.dispatched, job.TaskGuid)
+	stagingQueueDepth.Send(len(s.state.Queue))
+
+	// a tenant whose last job started at or before the virtual time starts
+	// its next job at the virtual time either way
+	for tenant, finish := range s.state.TenantFinishes {
+		if finish <= s.state.VirtualTime {
+			delete(s.state.TenantFinishes, tenant)
+		}
+	}
+
+	return job, true
+}
+
+// reconcile returns the budget of in-flight tasks whose completion callback
+// never reached this stager, e.g. because it was down at the time.
+func (s *scheduler) reconcile() {
+	logger := s.logger.Session("reconcile")
+
+	s.lock.Lock()
+	taskGuids := make([]string, 0, len(s.state.InFlight))
+	for taskGuid := range s.state.InFlight {
+		taskGuids = append(taskGuids, taskGuid)
+	}
+	s.lock.Unlock()
+
+	for _, taskGuid := range taskGuids {
+		task, err := s.bbsClient.TaskByGuid(logger, taskGuid)
+		if err != nil && !models.ErrResourceNotFound.Equal(err) {
+			logger.Error("failed-to-fetch-task", err, lager.Data{"task-guid": taskGuid})
+			continue
+		}
+
+		if err == nil && (task.State == models.Task_Pending || task.State == models.Task_Running) {
+			continue
+		}
+
+		logger.Info("releasing-finished-task", lager.Data{"task-guid": taskGuid})
+		s.Completed(logger, taskGuid)
+	}
+}
+
+func (s *scheduler) reportFailure(logger lager.Logger, job Job, err error) {
+	response := cc_messages.StagingResponseForCC{
+		Error: backend.SanitizeError(s.classifier, err),
+	}
+	responseJson, _ := json.Marshal(response)
+
+	err = s.ccClient.StagingComplete(job.StagingGuid, job.CompletionCallback, responseJson, cc_client.RequestHeaders(job.RequestId), logger)
+	if err != nil {
+		logger.Error("cc-staging-complete-failed", err, lager.Data{"task-guid": job.TaskGuid, "request-id": job.RequestId})
+	}
+}
+
+func (s *scheduler) known(taskGuid string) bool {
+	if _, ok := s.state.InFlight[taskGuid]; ok {
+		return true
+	}
+
+	for _, job := range s.state.Queue {
+		if job.TaskGuid == taskGuid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// flush brings the state file up to date, then deletes the files of the
+// jobs that were desired since the last flush. If the stager stops in
+// between, such a job is found in flight when its file is loaded again.
+func (s *scheduler) flush() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.dirty {
+		return
+	}
+
+	logger := s.logger.Session("flush")
+
+	err := s.state.save(s.statePath)
+	if err != nil {
+		logger.Error("failed-to-persist-state", err)
+		return
+	}
+	s.dirty = false
+
+	for _, taskGuid := range s.dispatched {
+		s.deleteJob(logger, taskGuid)
+	}
+	s.dispatched = nil
+}
+
+func (s *scheduler) deleteJob(logger lager.Logger, taskGuid string) {
+	err := deleteJob(s.statePath, taskGuid)
+	if err != nil {
+		logger.Error("failed-to-delete-queued-job", err, lager.Data{"task-guid": taskGuid})
+	}
+}
+
+func (s *scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
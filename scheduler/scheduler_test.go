@@ -0,0 +1,462 @@
+package scheduler_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/bbs/fake_bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/cc_client/fakes"
+	"code.cloudfoundry.org/stager/scheduler"
+	fake_log_sender "github.com/cloudfoundry/dropsonde/log_sender/fake"
+	"github.com/cloudfoundry/dropsonde/logs"
+	fake_metric_sender "github.com/cloudfoundry/dropsonde/metric_sender/fake"
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Scheduler", func() {
+	const interval = 30 * time.Second
+
+	var (
+		logger           lager.Logger
+		fakeMetricSender *fake_metric_sender.FakeMetricSender
+		fakeBBS          *fake_bbs.FakeClient
+		fakeCC           *fakes.FakeCcClient
+		fakeClock        *fakeclock.FakeClock
+
+		stateDir    string
+		statePath   string
+		concurrency int
+		tenants     scheduler.TenantPolicy
+
+		runner  scheduler.Runner
+		process ifrit.Process
+	)
+
+	newRunner := func() scheduler.Runner {
+		runner, err := scheduler.New(logger, fakeBBS, fakeCC, fakeClock, tenants, nil, concurrency, interval, statePath)
+		Expect(err).NotTo(HaveOccurred())
+		return runner
+	}
+
+	start := func() {
+		process = ifrit.Invoke(runner)
+	}
+
+	submit := func(taskGuid, appId string) {
+		request := cc_messages.StagingRequestFromCC{AppId: appId}
+		err := runner.Submit(logger, request, scheduler.Job{
+			TaskGuid:           taskGuid,
+			Domain:             "staging-domain",
+			StagingGuid:        taskGuid,
+			CompletionCallback: "https://cc.example.com/" + taskGuid,
+			RequestId:          "request-" + taskGuid,
+			TaskDefinition:     &models.TaskDefinition{Annotation: taskGuid, LogGuid: "log-" + appId},
+		})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	desiredGuids := func() []string {
+		guids := []string{}
+		for i := 0; i < fakeBBS.DesireTaskCallCount(); i++ {
+			_, guid, _, _ := fakeBBS.DesireTaskArgsForCall(i)
+			guids = append(guids, guid)
+		}
+		return guids
+	}
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+
+		fakeMetricSender = fake_metric_sender.NewFakeMetricSender()
+		metrics.Initialize(fakeMetricSender, nil)
+
+		fakeBBS = &fake_bbs.FakeClient{}
+		fakeCC = &fakes.FakeCcClient{}
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+
+		var err error
+		stateDir, err = ioutil.TempDir("", "scheduler")
+		Expect(err).NotTo(HaveOccurred())
+		statePath = filepath.Join(stateDir, "state.json")
+
+		concurrency = 2
+		tenants = scheduler.TenantPolicy{}
+	})
+
+	JustBeforeEach(func() {
+		runner = newRunner()
+	})
+
+	AfterEach(func() {
+		if process != nil {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive(BeNil()))
+			process = nil
+		}
+		os.RemoveAll(stateDir)
+	})
+
+	It("desires queued tasks up to the concurrency budget", func() {
+		submit("task-1", "app-1")
+		submit("task-2", "app-2")
+		submit("task-3", "app-3")
+		start()
+
+		Eventually(fakeBBS.DesireTaskCallCount).Should(Equal(2))
+		Consistently(fakeBBS.DesireTaskCallCount).Should(Equal(2))
+		Expect(desiredGuids()).To(Equal([]string{"task-1", "task-2"}))
+
+		_, _, domain, taskDef := fakeBBS.DesireTaskArgsForCall(0)
+		Expect(domain).To(Equal("staging-domain"))
+		Expect(taskDef.Annotation).To(Equal("task-1"))
+
+		Expect(fakeMetricSender.GetValue("StagingQueueDepth").Value).To(BeEquivalentTo(1))
+	})
+
+	It("desires the next task when an in-flight task completes", func() {
+		submit("task-1", "app-1")
+		submit("task-2", "app-2")
+		submit("task-3", "app-3")
+		start()
+
+		Eventually(fakeBBS.DesireTaskCallCount).Should(Equal(2))
+
+		runner.Completed(logger, "task-1")
+		Eventually(fakeBBS.DesireTaskCallCount).Should(Equal(3))
+		Expect(desiredGuids()[2]).To(Equal("task-3"))
+	})
+
+	It("ignores tasks that are submitted twice", func() {
+		submit("task-1", "app-1")
+		submit("task-1", "app-1")
+		start()
+
+		Eventually(fakeBBS.DesireTaskCallCount).Should(Equal(1))
+		Consistently(fakeBBS.DesireTaskCallCount).Should(Equal(1))
+	})
+
+	Describe("fairness", func() {
+		BeforeEach(func() {
+			concurrency = 1
+		})
+
+		It("interleaves the tasks of a tenant's bulk restage with other tenants' tasks", func() {
+			submit("bulk-1", "tenant-a")
+			submit("bulk-2", "tenant-a")
+			submit("bulk-3", "tenant-a")
+			submit("push-1", "tenant-b")
+			start()
+
+			for i, expectedGuid := range []string{"bulk-1", "push-1", "bulk-2", "bulk-3"} {
+				Eventually(fakeBBS.DesireTaskCallCount).Should(Equal(i + 1))
+				Expect(desiredGuids()[i]).To(Equal(expectedGuid))
+				runner.Completed(logger, expectedGuid)
+			}
+		})
+
+		Context("when tenants are weighted", func() {
+			BeforeEach(func() {
+				tenants.Weights = map[string]float64{"tenant-b": 2}
+			})
+
+			It("gives heavier tenants a larger share", func() {
+				submit("a-1", "tenant-a")
+				submit("a-2", "tenant-a")
+				submit("b-1", "tenant-b")
+				submit("b-2", "tenant-b")
+				submit("b-3", "tenant-b")
+				start()
+
+				for i, expectedGuid := range []string{"b-1", "a-1", "b-2", "b-3", "a-2"} {
+					Eventually(fakeBBS.DesireTaskCallCount).Should(Equal(i + 1))
+					Expect(desiredGuids()[i]).To(Equal(expectedGuid))
+					runner.Completed(logger, expectedGuid)
+				}
+			})
+		})
+
+		Context("when tenants are keyed by an environment variable", func() {
+			BeforeEach(func() {
+				tenants.EnvVar = "TENANT"
+			})
+
+			It("accounts requests to the tenant named in their environment", func() {
+				Expect(tenants.TenantKey(cc_messages.StagingRequestFromCC{
+					AppId:       "some-app",
+					Environment: []*models.EnvironmentVariable{{Name: "TENANT", Value: "org-1"}},
+				})).To(Equal("org-1"))
+
+				Expect(tenants.TenantKey(cc_messages.StagingRequestFromCC{AppId: "some-app"})).To(Equal("some-app"))
+			})
+		})
+
+		Context("when tenants are keyed by an app id prefix", func() {
+			BeforeEach(func() {
+				tenants.AppIdPrefixLength = 4
+			})
+
+			It("accounts requests to the prefix of their app id", func() {
+				Expect(tenants.TenantKey(cc_messages.StagingRequestFromCC{AppId: "org1-app"})).To(Equal("org1"))
+			})
+		})
+	})
+
+	Describe("persistence", func() {
+		It("desires tasks queued before a restart", func() {
+			submit("task-1", "app-1")
+			submit("task-2", "app-2")
+
+			runner = newRunner()
+			start()
+
+			Eventually(fakeBBS.DesireTaskCallCount).Should(Equal(2))
+			Expect(desiredGuids()).To(Equal([]string{"task-1", "task-2"}))
+		})
+
+		Context("when tasks were in flight before a restart", func() {
+			BeforeEach(func() {
+				concurrency = 1
+			})
+
+			JustBeforeEach(func() {
+				submit("task-1", "app-1")
+				submit("task-2", "app-2")
+				start()
+				Eventually(fakeBBS.DesireTaskCallCount).Should(Equal(1))
+
+				process.Signal(os.Interrupt)
+				Eventually(process.Wait()).Should(Receive(BeNil()))
+				process = nil
+
+				runner = newRunner()
+			})
+
+			It("keeps holding the budget for tasks that are still running", func() {
+				fakeBBS.TaskByGuidReturns(&models.Task{TaskGuid: "task-1", State: models.Task_Running}, nil)
+				start()
+
+				Eventually(fakeBBS.TaskByGuidCallCount).Should(Equal(1))
+				Consistently(fakeBBS.DesireTaskCallCount).Should(Equal(1))
+			})
+
+			It("releases the budget of tasks that finished while it was down", func() {
+				fakeBBS.TaskByGuidReturns(nil, models.ErrResourceNotFound)
+				start()
+
+				Eventually(fakeBBS.DesireTaskCallCount).Should(Equal(2))
+				Expect(desiredGuids()[1]).To(Equal("task-2"))
+			})
+		})
+
+		It("persists each queued task in its own file, readable only by the stager", func() {
+			submit("task-1", "app-1")
+
+			info, err := os.Stat(filepath.Join(statePath+".queue", "task-1.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+			Expect(statePath).NotTo(BeAnExistingFile())
+		})
+
+		It("deletes the file of a task once it is desired and the state is flushed", func() {
+			submit("task-1", "app-1")
+			start()
+
+			Eventually(statePath).Should(BeAnExistingFile())
+			Eventually(filepath.Join(statePath+".queue", "task-1.json")).ShouldNot(BeAnExistingFile())
+		})
+
+		It("forgets the virtual finish of tenants that have caught up with the virtual time", func() {
+			submit("task-1", "app-1")
+			submit("task-2", "app-1")
+			submit("task-3", "app-2")
+			start()
+
+			Eventually(fakeBBS.DesireTaskCallCount).Should(Equal(2))
+			runner.Completed(logger, "task-1")
+			Eventually(fakeBBS.DesireTaskCallCount).Should(Equal(3))
+
+			tenantFinishes := func() map[string]float64 {
+				var persisted struct {
+					TenantFinishes map[string]float64 `json:"tenant_finishes"`
+				}
+				payload, err := ioutil.ReadFile(statePath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(json.Unmarshal(payload, &persisted)).To(Succeed())
+				return persisted.TenantFinishes
+			}
+			Eventually(tenantFinishes).Should(Equal(map[string]float64{"app-1": 2}))
+		})
+
+		It("fails to start from a corrupt state file", func() {
+			Expect(ioutil.WriteFile(statePath, []byte("{"), 0600)).To(Succeed())
+
+			_, err := scheduler.New(logger, fakeBBS, fakeCC, fakeClock, tenants, nil, concurrency, interval, statePath)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Remove", func() {
+		It("takes a queued task off the queue", func() {
+			submit("task-1", "app-1")
+
+			job, ok := runner.Remove(logger, "task-1")
+			Expect(ok).To(BeTrue())
+			Expect(job.CompletionCallback).To(Equal("https://cc.example.com/task-1"))
+
+			start()
+			Consistently(fakeBBS.DesireTaskCallCount).Should(Equal(0))
+		})
+
+		It("finds a queued retry by its staging guid", func() {
+			err := runner.Resubmit(logger, "task-1", scheduler.Job{
+				TaskGuid:    "task-1-attempt-2",
+				StagingGuid: "task-1",
+				AppId:       "app-1",
+				NotBefore:   fakeClock.Now().Add(time.Minute).UnixNano(),
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			job, ok := runner.Remove(logger, "task-1")
+			Expect(ok).To(BeTrue())
+			Expect(job.TaskGuid).To(Equal("task-1-attempt-2"))
+		})
+
+		It("does not find unknown tasks", func() {
+			_, ok := runner.Remove(logger, "unknown")
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Describe("RemoveApp", func() {
+		It("takes the other queued tasks of the app off the queue", func() {
+			submit("task-1", "app-1")
+			submit("task-2", "app-2")
+			submit("task-3", "app-1")
+			submit("task-4", "app-1")
+
+			jobs := runner.RemoveApp(logger, "app-1", "task-4")
+			Expect(jobs).To(HaveLen(2))
+			Expect(jobs[0].TaskGuid).To(Equal("task-1"))
+			Expect(jobs[1].TaskGuid).To(Equal("task-3"))
+
+			start()
+			Eventually(fakeBBS.DesireTaskCallCount).Should(Equal(2))
+			Consistently(fakeBBS.DesireTaskCallCount).Should(Equal(2))
+			Expect(desiredGuids()).To(ConsistOf("task-2", "task-4"))
+		})
+	})
+
+	Describe("Resubmit", func() {
+		resubmit := func(previousTaskGuid string, notBefore time.Time) {
+			err := runner.Resubmit(logger, previousTaskGuid, scheduler.Job{
+				TaskGuid:       previousTaskGuid + "-attempt-2",
+				Domain:         "staging-domain",
+				StagingGuid:    previousTaskGuid,
+				AppId:          "app-1",
+				TaskDefinition: &models.TaskDefinition{},
+				NotBefore:      notBefore.UnixNano(),
+			})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		BeforeEach(func() {
+			concurrency = 1
+		})
+
+		It("takes over the budget of the failed attempt", func() {
+			submit("task-1", "app-1")
+			submit("task-2", "app-2")
+			start()
+
+			Eventually(fakeBBS.DesireTaskCallCount).Should(Equal(1))
+
+			resubmit("task-1", fakeClock.Now())
+			Eventually(fakeBBS.DesireTaskCallCount).Should(Equal(2))
+			Expect(desiredGuids()).To(ConsistOf("task-1", "task-2"))
+
+			runner.Completed(logger, "task-2")
+			Eventually(fakeBBS.DesireTaskCallCount).Should(Equal(3))
+			Expect(desiredGuids()[2]).To(Equal("task-1-attempt-2"))
+		})
+
+		It("does not desire the attempt before it is due", func() {
+			start()
+			Eventually(fakeClock.WatcherCount).Should(Equal(1))
+
+			resubmit("task-1", fakeClock.Now().Add(time.Minute))
+			Eventually(fakeClock.WatcherCount).Should(Equal(2))
+			Consistently(fakeBBS.DesireTaskCallCount).Should(Equal(0))
+
+			fakeClock.Increment(time.Minute)
+			Eventually(fakeBBS.DesireTaskCallCount).Should(Equal(1))
+			Expect(desiredGuids()).To(Equal([]string{"task-1-attempt-2"}))
+		})
+
+		It("keeps a pending attempt across a restart", func() {
+			resubmit("task-1", fakeClock.Now().Add(time.Minute))
+
+			runner = newRunner()
+			start()
+			Eventually(fakeClock.WatcherCount).Should(Equal(1))
+			Consistently(fakeBBS.DesireTaskCallCount).Should(Equal(0))
+
+			fakeClock.Increment(time.Minute)
+			Eventually(fakeBBS.DesireTaskCallCount).Should(Equal(1))
+		})
+	})
+
+	Context("when desiring a task fails", func() {
+		BeforeEach(func() {
+			concurrency = 1
+			fakeBBS.DesireTaskStub = func(_ lager.Logger, guid, _ string, _ *models.TaskDefinition) error {
+				if guid == "task-1" {
+					return errors.New("boom")
+				}
+				return nil
+			}
+		})
+
+		It("reports the failure to CC and moves on to the next task", func() {
+			submit("task-1", "app-1")
+			submit("task-2", "app-2")
+			start()
+
+			Eventually(fakeCC.StagingCompleteCallCount).Should(Equal(1))
+			guid, payload, _ := fakeCC.StagingCompleteArgsForCall(0)
+			Expect(guid).To(Equal("task-1"))
+			Expect(payload).To(MatchJSON(`{"error":{"id":"StagingError","message":"staging failed"}}`))
+			Expect(fakeCC.StagingCompleteHeadersForCall(0).Get("X-Vcap-Request-Id")).To(Equal("request-task-1"))
+
+			Eventually(fakeBBS.DesireTaskCallCount).Should(Equal(2))
+		})
+
+		It("tells the user in the app's logs", func() {
+			fakeLogSender := fake_log_sender.NewFakeLogSender()
+			logs.Initialize(fakeLogSender)
+
+			submit("task-1", "app-1")
+			start()
+
+			Eventually(fakeCC.StagingCompleteCallCount).Should(Equal(1))
+			appLogs := fakeLogSender.GetLogs()
+			Expect(appLogs).To(HaveLen(1))
+			Expect(appLogs[0].AppId).To(Equal("log-app-1"))
+			Expect(appLogs[0].SourceType).To(Equal("STG"))
+			Expect(appLogs[0].Message).To(Equal("Staging failed: the staging task could not be started"))
+		})
+	})
+})
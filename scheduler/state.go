@@ -0,0 +1,179 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"code.cloudfoundry.org/bbs/models"
+)
+
+type Job struct {
+	TaskGuid           string                 `json:"task_guid"`
+	Domain             string                 `json:"domain"`
+	StagingGuid        string                 `json:"staging_guid"`
+	CompletionCallback string                 `json:"completion_callback"`
+	Tenant             string                 `json:"tenant"`
+	RequestId          string                 `json:"request_id,omitempty"`
+	TaskDefinition     *models.TaskDefinition `json:"task_definition"`
+	// AppId identifies the job when a newer staging of the app supersedes
+	// it, and accounts a resubmitted job whose previous attempt this
+	// scheduler does not know to a tenant.
+	AppId string `json:"app_id,omitempty"`
+	// NotBefore delays desiring the job until the given time, in
+	// nanoseconds since the epoch, e.g. to back off a retry.
+	NotBefore int64 `json:"not_before,omitempty"`
+	// QueuedAt restores the order of the queue after a restart.
+	QueuedAt int64 `json:"queued_at"`
+
+	// VirtualStart and VirtualFinish order jobs across tenants for weighted
+	// fair queuing.
+	VirtualStart  float64 `json:"virtual_start"`
+	VirtualFinish float64 `json:"virtual_finish"`
+}
+
+// state is everything the scheduler needs to pick up where it left off after
+// a restart. Queued jobs are persisted one file each in the queue directory
+// next to the state file, so that queueing or dequeuing a job does not
+// rewrite the others.
+type state struct {
+	VirtualTime    float64            `json:"virtual_time"`
+	TenantFinishes map[string]float64 `json:"tenant_finishes"`
+	InFlight       map[string]string  `json:"in_flight"`
+	Queue          []Job              `json:"-"`
+}
+
+func newState() *state {
+	return &state{
+		TenantFinishes: map[string]float64{},
+		Queue:          []Job{},
+		InFlight:       map[string]string{},
+	}
+}
+
+func queueDir(path string) string {
+	return path + ".queue"
+}
+
+func jobPath(path, taskGuid string) string {
+	return filepath.Join(queueDir(path), taskGuid+".json")
+}
+
+func loadState(path string) (*state, error) {
+	s := newState()
+
+	payload, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		err = json.Unmarshal(payload, s)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.TenantFinishes == nil {
+		s.TenantFinishes = map[string]float64{}
+	}
+	if s.InFlight == nil {
+		s.InFlight = map[string]string{}
+	}
+
+	err = os.MkdirAll(queueDir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(queueDir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+
+		payload, err := ioutil.ReadFile(filepath.Join(queueDir(path), file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		var job Job
+		err = json.Unmarshal(payload, &job)
+		if err != nil {
+			return nil, err
+		}
+
+		// the job was desired, but its file outlived the stager
+		if _, ok := s.InFlight[job.TaskGuid]; ok {
+			deleteJob(path, job.TaskGuid)
+			continue
+		}
+
+		s.Queue = append(s.Queue, job)
+		if job.VirtualFinish > s.TenantFinishes[job.Tenant] {
+			s.TenantFinishes[job.Tenant] = job.VirtualFinish
+		}
+	}
+	sort.Stable(byQueuedAt(s.Queue))
+
+	return s, nil
+}
+
+type byQueuedAt []Job
+
+func (jobs byQueuedAt) Len() int           { return len(jobs) }
+func (jobs byQueuedAt) Less(i, j int) bool { return jobs[i].QueuedAt < jobs[j].QueuedAt }
+func (jobs byQueuedAt) Swap(i, j int)      { jobs[i], jobs[j] = jobs[j], jobs[i] }
+
+// save atomically replaces the state file.
+func (s *state) save(path string) error {
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	return writeAtomically(path, payload)
+}
+
+// saveJob persists a queued job. It holds the task definition, including
+// its environment, so the file is only readable by the stager.
+func saveJob(path string, job Job) error {
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return writeAtomically(jobPath(path, job.TaskGuid), payload)
+}
+
+func deleteJob(path, taskGuid string) error {
+	err := os.Remove(jobPath(path, taskGuid))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func writeAtomically(path string, payload []byte) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+
+	_, err = tmpFile.Write(payload)
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), path)
+}
@@ -0,0 +1,38 @@
+package scheduler
+
+import "code.cloudfoundry.org/runtimeschema/cc_messages"
+
+const DefaultTenantWeight = 1.0
+
+// TenantPolicy determines which tenant a staging request is accounted to and
+// how large a share of the concurrency budget each tenant gets.
+type TenantPolicy struct {
+	// EnvVar names a staging environment variable holding the tenant key.
+	EnvVar string `json:"env_var,omitempty"`
+	// AppIdPrefixLength is the number of leading app id characters used as
+	// the tenant key when EnvVar is unset or absent. Zero uses the whole id.
+	AppIdPrefixLength int                `json:"app_id_prefix_length,omitempty"`
+	Weights           map[string]float64 `json:"weights,omitempty"`
+}
+
+func (p TenantPolicy) TenantKey(request cc_messages.StagingRequestFromCC) string {
+	if p.EnvVar != "" {
+		for _, envVar := range request.Environment {
+			if envVar.Name == p.EnvVar && envVar.Value != "" {
+				return envVar.Value
+			}
+		}
+	}
+
+	if p.AppIdPrefixLength > 0 && len(request.AppId) > p.AppIdPrefixLength {
+		return request.AppId[:p.AppIdPrefixLength]
+	}
+	return request.AppId
+}
+
+func (p TenantPolicy) weight(tenant string) float64 {
+	if weight, ok := p.Weights[tenant]; ok && weight > 0 {
+		return weight
+	}
+	return DefaultTenantWeight
+}
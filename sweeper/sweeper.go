@@ -0,0 +1,192 @@
+package sweeper
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/runtimeschema/metric"
+	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/cc_client"
+	"code.cloudfoundry.org/stager/diego_errors"
+	"github.com/tedsuo/ifrit"
+)
+
+const (
+	DefaultTimeoutMargin   = 5 * time.Minute
+	DefaultCompletedExpiry = 30 * time.Minute
+
+	orphanedStagingTasksCounter = metric.Counter("OrphanedStagingTasksRemoved")
+)
+
+// sweeper removes staging tasks that have outlived their recipe timeout or
+// whose completion callback has been failing for too long. CC is told that
+// the staging of an expired completed task failed before the task is
+// deleted, so that the staging does not stay pending forever.
+type sweeper struct {
+	logger          lager.Logger
+	bbsClient       bbs.Client
+	ccClient        cc_client.CcClient
+	clock           clock.Clock
+	taskDomain      string
+	interval        time.Duration
+	timeoutMargin   time.Duration
+	completedExpiry time.Duration
+}
+
+func New(
+	logger lager.Logger,
+	bbsClient bbs.Client,
+	ccClient cc_client.CcClient,
+	clock clock.Clock,
+	taskDomain string,
+	interval time.Duration,
+	timeoutMargin time.Duration,
+	completedExpiry time.Duration,
+) ifrit.Runner {
+	return &sweeper{
+		logger:          logger.Session("sweeper"),
+		bbsClient:       bbsClient,
+		ccClient:        ccClient,
+		clock:           clock,
+		taskDomain:      taskDomain,
+		interval:        interval,
+		timeoutMargin:   timeoutMargin,
+		completedExpiry: completedExpiry,
+	}
+}
+
+func (s *sweeper) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	ticker := s.clock.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	close(ready)
+
+	for {
+		select {
+		case <-ticker.C():
+			s.sweep()
+		case <-signals:
+			return nil
+		}
+	}
+}
+
+func (s *sweeper) sweep() {
+	logger := s.logger.Session("sweep")
+
+	tasks, err := s.bbsClient.TasksByDomain(logger, s.taskDomain)
+	if err != nil {
+		logger.Error("failed-to-fetch-tasks", err)
+		return
+	}
+
+	now := s.clock.Now()
+	for _, task := range tasks {
+		switch task.State {
+		case models.Task_Pending, models.Task_Running:
+			age := now.Sub(time.Unix(0, task.CreatedAt))
+			if age > recipeTimeout(task)+s.timeoutMargin {
+				s.cancel(logger, task, age)
+			}
+		case models.Task_Completed:
+			age := now.Sub(time.Unix(0, task.UpdatedAt))
+			if age > s.completedExpiry {
+				s.delete(logger, task, age)
+			}
+		}
+	}
+}
+
+func (s *sweeper) cancel(logger lager.Logger, task *models.Task, age time.Duration) {
+	logger = logger.Session("cancel-orphaned-task", orphanData(task, age))
+
+	err := s.bbsClient.CancelTask(logger, task.TaskGuid)
+	if err != nil {
+		logger.Error("failed-to-cancel-task", err)
+		return
+	}
+
+	orphanedStagingTasksCounter.Increment()
+	logger.Info("cancelled-orphaned-task")
+}
+
+func (s *sweeper) delete(logger lager.Logger, task *models.Task, age time.Duration) {
+	logger = logger.Session("delete-orphaned-task", orphanData(task, age))
+
+	err := s.bbsClient.ResolvingTask(logger, task.TaskGuid)
+	if err != nil {
+		logger.Error("failed-to-resolve-task", err)
+		return
+	}
+
+	err = s.reportExpired(logger, task)
+	if err != nil {
+		logger.Error("failed-to-report-expired-staging", err)
+		return
+	}
+
+	err = s.bbsClient.DeleteTask(logger, task.TaskGuid)
+	if err != nil {
+		logger.Error("failed-to-delete-task", err)
+		return
+	}
+
+	orphanedStagingTasksCounter.Increment()
+	logger.Info("deleted-orphaned-task")
+}
+
+// reportExpired tells CC that the staging of a task failed, as its result
+// can no longer be delivered.
+func (s *sweeper) reportExpired(logger lager.Logger, task *models.Task) error {
+	if task.TaskDefinition == nil {
+		return nil
+	}
+
+	annotation, err := backend.ParseStagingTaskAnnotation(task.Annotation)
+	if err != nil {
+		return err
+	}
+
+	responseJson, err := json.Marshal(cc_messages.StagingResponseForCC{
+		Error: diego_errors.ErrStagingResultExpired.StagingError(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.ccClient.StagingComplete(annotation.StagingGuidFor(task.TaskGuid), annotation.CompletionCallback, responseJson, cc_client.RequestHeaders(annotation.RequestId), logger)
+}
+
+func orphanData(task *models.Task, age time.Duration) lager.Data {
+	data := lager.Data{
+		"task-guid": task.TaskGuid,
+		"state":     task.State.String(),
+		"age":       age.String(),
+	}
+
+	if task.TaskDefinition != nil {
+		annotation, err := backend.ParseStagingTaskAnnotation(task.Annotation)
+		if err == nil {
+			data["lifecycle"] = annotation.Lifecycle
+			data["completion-callback"] = annotation.CompletionCallback
+		}
+	}
+
+	return data
+}
+
+func recipeTimeout(task *models.Task) time.Duration {
+	if task.TaskDefinition != nil && task.Action != nil {
+		if timeoutAction := task.Action.GetTimeoutAction(); timeoutAction != nil {
+			return time.Duration(timeoutAction.TimeoutMs) * time.Millisecond
+		}
+	}
+
+	return backend.DefaultStagingTimeout
+}
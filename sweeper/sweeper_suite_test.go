@@ -0,0 +1,13 @@
+package sweeper_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestSweeper(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Sweeper Suite")
+}
@@ -0,0 +1,143 @@
+package sweeper_test
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/bbs/fake_bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/cc_client/fakes"
+	"code.cloudfoundry.org/stager/sweeper"
+	fake_metric_sender "github.com/cloudfoundry/dropsonde/metric_sender/fake"
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sweeper", func() {
+	const (
+		interval        = time.Minute
+		timeoutMargin   = 5 * time.Minute
+		completedExpiry = 30 * time.Minute
+	)
+
+	var (
+		fakeMetricSender *fake_metric_sender.FakeMetricSender
+		fakeBBS          *fake_bbs.FakeClient
+		fakeCC           *fakes.FakeCcClient
+		fakeClock        *fakeclock.FakeClock
+
+		tasks    []*models.Task
+		fetchErr error
+		process  ifrit.Process
+	)
+
+	newTask := func(guid string, state models.Task_State, timeout, age time.Duration) *models.Task {
+		createdAt := fakeClock.Now().Add(-age).UnixNano()
+		return &models.Task{
+			TaskGuid:  guid,
+			State:     state,
+			CreatedAt: createdAt,
+			UpdatedAt: createdAt,
+			TaskDefinition: &models.TaskDefinition{
+				Annotation: `{"lifecycle":"buildpack","completion_callback":"https://cc.example.com/` + guid + `","staging_guid":"staging-` + guid + `","request_id":"request-` + guid + `"}`,
+				Action:     models.WrapAction(models.Timeout(&models.RunAction{Path: "ls", User: "vcap"}, timeout)),
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		fakeMetricSender = fake_metric_sender.NewFakeMetricSender()
+		metrics.Initialize(fakeMetricSender, nil)
+
+		fakeBBS = &fake_bbs.FakeClient{}
+		fakeCC = &fakes.FakeCcClient{}
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		fetchErr = nil
+
+		tasks = []*models.Task{
+			newTask("healthy-running", models.Task_Running, 15*time.Minute, 16*time.Minute),
+			newTask("orphaned-running", models.Task_Running, 15*time.Minute, 21*time.Minute),
+			newTask("orphaned-pending", models.Task_Pending, 5*time.Minute, 11*time.Minute),
+			newTask("recently-completed", models.Task_Completed, 15*time.Minute, 10*time.Minute),
+			newTask("stuck-completed", models.Task_Completed, 15*time.Minute, time.Hour),
+		}
+	})
+
+	JustBeforeEach(func() {
+		fakeBBS.TasksByDomainReturns(tasks, fetchErr)
+
+		runner := sweeper.New(lagertest.NewTestLogger("test"), fakeBBS, fakeCC, fakeClock, "staging-domain", interval, timeoutMargin, completedExpiry)
+		process = ifrit.Invoke(runner)
+
+		fakeClock.WaitForWatcherAndIncrement(interval)
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive(BeNil()))
+	})
+
+	It("cancels pending and running tasks that are past their recipe timeout", func() {
+		Eventually(fakeBBS.CancelTaskCallCount).Should(Equal(2))
+		Consistently(fakeBBS.CancelTaskCallCount).Should(Equal(2))
+
+		_, guid := fakeBBS.CancelTaskArgsForCall(0)
+		Expect(guid).To(Equal("orphaned-running"))
+		_, guid = fakeBBS.CancelTaskArgsForCall(1)
+		Expect(guid).To(Equal("orphaned-pending"))
+	})
+
+	It("resolves and deletes completed tasks whose callback never succeeded", func() {
+		Eventually(fakeBBS.DeleteTaskCallCount).Should(Equal(1))
+
+		Expect(fakeBBS.ResolvingTaskCallCount()).To(Equal(1))
+		_, guid := fakeBBS.ResolvingTaskArgsForCall(0)
+		Expect(guid).To(Equal("stuck-completed"))
+		_, guid = fakeBBS.DeleteTaskArgsForCall(0)
+		Expect(guid).To(Equal("stuck-completed"))
+	})
+
+	It("tells CC that the staging of a deleted task failed", func() {
+		Eventually(fakeCC.StagingCompleteCallCount).Should(Equal(1))
+
+		guid, payload, _ := fakeCC.StagingCompleteArgsForCall(0)
+		Expect(guid).To(Equal("staging-stuck-completed"))
+		Expect(payload).To(MatchJSON(`{"error":{"id":"StagingError","message":"staging result expired before it could be delivered"}}`))
+		Expect(fakeCC.StagingCompleteHeadersForCall(0).Get("X-Vcap-Request-Id")).To(Equal("request-stuck-completed"))
+	})
+
+	Context("when CC cannot be told", func() {
+		BeforeEach(func() {
+			fakeCC.StagingCompleteReturns(errors.New("cc down"))
+		})
+
+		It("keeps the task", func() {
+			Eventually(fakeCC.StagingCompleteCallCount).Should(Equal(1))
+			Consistently(fakeBBS.DeleteTaskCallCount).Should(Equal(0))
+		})
+	})
+
+	It("reports the removed tasks", func() {
+		Eventually(func() uint64 {
+			return fakeMetricSender.GetCounter("OrphanedStagingTasksRemoved")
+		}).Should(Equal(uint64(3)))
+	})
+
+	Context("when fetching tasks fails", func() {
+		BeforeEach(func() {
+			tasks = nil
+			fetchErr = errors.New("bbs down")
+		})
+
+		It("does not remove anything", func() {
+			Consistently(fakeBBS.CancelTaskCallCount).Should(Equal(0))
+			Consistently(fakeBBS.DeleteTaskCallCount).Should(Equal(0))
+		})
+	})
+})
@@ -0,0 +1,188 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	instrumentationScope = "code.cloudfoundry.org/stager"
+
+	statusCodeOk    = 1
+	statusCodeError = 2
+)
+
+// The following types are the OTLP/JSON encoding of a trace export request.
+
+type otlpExportRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func encodeSpans(serviceName string, spans []SpanData) ([]byte, error) {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		encoded := otlpSpan{
+			TraceID:           span.Context.TraceID.String(),
+			SpanID:            span.Context.SpanID.String(),
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: unixNano(span.Start),
+			EndTimeUnixNano:   unixNano(span.End),
+			Status:            otlpStatus{Code: statusCodeOk},
+		}
+
+		if span.ParentSpanID.IsValid() {
+			encoded.ParentSpanID = span.ParentSpanID.String()
+		}
+
+		for key, value := range span.Attributes {
+			encoded.Attributes = append(encoded.Attributes, otlpAttribute{Key: key, Value: otlpValue{StringValue: value}})
+		}
+
+		if span.Error != "" {
+			encoded.Status = otlpStatus{Code: statusCodeError, Message: span.Error}
+		}
+
+		otlpSpans = append(otlpSpans, encoded)
+	}
+
+	return json.Marshal(otlpExportRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: serviceName}}},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: instrumentationScope},
+				Spans: otlpSpans,
+			}},
+		}},
+	})
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+type otlpExporter struct {
+	url        string
+	headers    map[string]string
+	httpClient *http.Client
+}
+
+// NewOTLPExporter returns an Exporter that sends spans to an OpenTelemetry
+// collector using OTLP/HTTP with JSON encoding.
+func NewOTLPExporter(endpoint string, headers map[string]string, httpClient *http.Client) Exporter {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &otlpExporter{
+		url:        strings.TrimRight(endpoint, "/") + "/v1/traces",
+		headers:    headers,
+		httpClient: httpClient,
+	}
+}
+
+func (e *otlpExporter) Export(serviceName string, spans []SpanData) error {
+	payload, err := encodeSpans(serviceName, spans)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest("POST", e.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := e.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("exporting spans failed with status %d", response.StatusCode)
+	}
+
+	return nil
+}
+
+type writerExporter struct {
+	lock   sync.Mutex
+	writer io.Writer
+}
+
+// NewWriterExporter returns an Exporter that writes each batch of spans to
+// writer as a line of OTLP/JSON, e.g. to a file or stdout.
+func NewWriterExporter(writer io.Writer) Exporter {
+	return &writerExporter{writer: writer}
+}
+
+func (e *writerExporter) Export(serviceName string, spans []SpanData) error {
+	payload, err := encodeSpans(serviceName, spans)
+	if err != nil {
+		return err
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	_, err = e.writer.Write(append(payload, '\n'))
+	return err
+}
@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const TraceparentHeader = "traceparent"
+
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+func (id SpanID) IsValid() bool  { return id != SpanID{} }
+
+// SpanContext is the part of a span that is propagated between processes,
+// as described by the W3C Trace Context recommendation.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (c SpanContext) IsValid() bool {
+	return c.TraceID.IsValid() && c.SpanID.IsValid()
+}
+
+// Traceparent formats the span context as a version 00 traceparent header.
+func (c SpanContext) Traceparent() string {
+	flags := "00"
+	if c.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", c.TraceID, c.SpanID, flags)
+}
+
+func ParseTraceparent(traceparent string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+
+	// future versions may append fields, but version 00 has exactly four
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+
+	var c SpanContext
+	if !decodeHex(parts[1], c.TraceID[:]) || !decodeHex(parts[2], c.SpanID[:]) || !c.IsValid() {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+
+	var flags [1]byte
+	if !decodeHex(parts[3], flags[:]) {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	c.Sampled = flags[0]&0x01 == 0x01
+
+	return c, nil
+}
+
+// Extract returns the span context carried by the traceparent header, or an
+// invalid span context if there is none.
+func Extract(header http.Header) SpanContext {
+	c, err := ParseTraceparent(header.Get(TraceparentHeader))
+	if err != nil {
+		return SpanContext{}
+	}
+	return c
+}
+
+func Inject(c SpanContext, header http.Header) {
+	if c.IsValid() {
+		header.Set(TraceparentHeader, c.Traceparent())
+	}
+}
+
+func decodeHex(s string, dst []byte) bool {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	rand.Read(id[:])
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	rand.Read(id[:])
+	return id
+}
@@ -0,0 +1,78 @@
+package tracing_test
+
+import (
+	"net/http"
+
+	"code.cloudfoundry.org/stager/tracing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SpanContext", func() {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	Describe("ParseTraceparent", func() {
+		It("parses a valid traceparent", func() {
+			c, err := tracing.ParseTraceparent(traceparent)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(c.TraceID.String()).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+			Expect(c.SpanID.String()).To(Equal("00f067aa0ba902b7"))
+			Expect(c.Sampled).To(BeTrue())
+			Expect(c.Traceparent()).To(Equal(traceparent))
+		})
+
+		It("reads the sampled flag", func() {
+			c, err := tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.Sampled).To(BeFalse())
+		})
+
+		It("accepts additional fields of future versions", func() {
+			_, err := tracing.ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("rejects malformed traceparents", func() {
+			for _, invalid := range []string{
+				"",
+				"garbage",
+				"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+				"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+				"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+				"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+				"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+				"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+				"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+			} {
+				_, err := tracing.ParseTraceparent(invalid)
+				Expect(err).To(Equal(tracing.ErrInvalidTraceparent), invalid)
+			}
+		})
+	})
+
+	Describe("Extract and Inject", func() {
+		It("round-trips the span context through headers", func() {
+			header := http.Header{}
+			header.Set("traceparent", traceparent)
+
+			c := tracing.Extract(header)
+			Expect(c.IsValid()).To(BeTrue())
+
+			outbound := http.Header{}
+			tracing.Inject(c, outbound)
+			Expect(outbound.Get("traceparent")).To(Equal(traceparent))
+		})
+
+		It("extracts an invalid span context when there is no traceparent", func() {
+			Expect(tracing.Extract(http.Header{}).IsValid()).To(BeFalse())
+		})
+
+		It("does not inject invalid span contexts", func() {
+			outbound := http.Header{}
+			tracing.Inject(tracing.SpanContext{}, outbound)
+			Expect(outbound).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,189 @@
+package tracing
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+)
+
+const (
+	DefaultFlushInterval = 5 * time.Second
+	maxBatchSize         = 512
+	maxQueuedSpans       = 2048
+)
+
+// SpanKind values match those of the OpenTelemetry protocol.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+type SpanData struct {
+	Name         string
+	Kind         SpanKind
+	Context      SpanContext
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]string
+	Error        string
+}
+
+type Exporter interface {
+	Export(serviceName string, spans []SpanData) error
+}
+
+// Tracer records spans and hands them to its exporter in batches while it
+// runs. A nil *Tracer still continues incoming traces so that they can be
+// propagated, but neither starts new traces nor records anything.
+type Tracer struct {
+	logger        lager.Logger
+	serviceName   string
+	exporter      Exporter
+	clock         clock.Clock
+	flushInterval time.Duration
+	spans         chan SpanData
+}
+
+func NewTracer(logger lager.Logger, serviceName string, exporter Exporter, clock clock.Clock, flushInterval time.Duration) *Tracer {
+	return &Tracer{
+		logger:        logger.Session("tracer"),
+		serviceName:   serviceName,
+		exporter:      exporter,
+		clock:         clock,
+		flushInterval: flushInterval,
+		spans:         make(chan SpanData, maxQueuedSpans),
+	}
+}
+
+// Start begins a span that continues the trace of parent, or a new trace if
+// parent is invalid.
+func (t *Tracer) Start(parent SpanContext, name string, kind SpanKind) *Span {
+	data := SpanData{
+		Name:       name,
+		Kind:       kind,
+		Attributes: map[string]string{},
+	}
+
+	switch {
+	case parent.IsValid():
+		data.Context = SpanContext{TraceID: parent.TraceID, SpanID: newSpanID(), Sampled: parent.Sampled}
+		data.ParentSpanID = parent.SpanID
+	case t != nil:
+		data.Context = SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Sampled: true}
+	}
+
+	if t != nil {
+		data.Start = t.clock.Now()
+	}
+
+	return &Span{tracer: t, data: data}
+}
+
+func (t *Tracer) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	ticker := t.clock.NewTicker(t.flushInterval)
+	defer ticker.Stop()
+
+	close(ready)
+
+	batch := []SpanData{}
+	for {
+		select {
+		case span := <-t.spans:
+			batch = append(batch, span)
+			if len(batch) >= maxBatchSize {
+				batch = t.flush(batch)
+			}
+		case <-ticker.C():
+			batch = t.flush(batch)
+		case <-signals:
+			t.drain(batch)
+			return nil
+		}
+	}
+}
+
+func (t *Tracer) record(span SpanData) {
+	select {
+	case t.spans <- span:
+	default:
+		t.logger.Info("dropped-span", lager.Data{"name": span.Name})
+	}
+}
+
+func (t *Tracer) drain(batch []SpanData) {
+	for {
+		select {
+		case span := <-t.spans:
+			batch = append(batch, span)
+		default:
+			t.flush(batch)
+			return
+		}
+	}
+}
+
+func (t *Tracer) flush(batch []SpanData) []SpanData {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	err := t.exporter.Export(t.serviceName, batch)
+	if err != nil {
+		t.logger.Error("failed-to-export-spans", err, lager.Data{"spans": len(batch)})
+	}
+
+	return []SpanData{}
+}
+
+type Span struct {
+	tracer *Tracer
+	lock   sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+func (s *Span) Context() SpanContext {
+	return s.data.Context
+}
+
+func (s *Span) SetAttribute(key, value string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.data.Attributes[key] = value
+}
+
+func (s *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.data.Error = err.Error()
+}
+
+func (s *Span) End() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.ended || s.tracer == nil {
+		return
+	}
+	s.ended = true
+
+	if !s.data.Context.Sampled {
+		return
+	}
+
+	s.data.End = s.tracer.clock.Now()
+	s.tracer.record(s.data)
+}
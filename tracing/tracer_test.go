@@ -0,0 +1,193 @@
+package tracing_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/tracing"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("Tracer", func() {
+	const flushInterval = time.Second
+
+	var (
+		fakeClock *fakeclock.FakeClock
+		output    *gbytes.Buffer
+		tracer    *tracing.Tracer
+		process   ifrit.Process
+
+		parent tracing.SpanContext
+	)
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Unix(1000, 0))
+		output = gbytes.NewBuffer()
+		tracer = tracing.NewTracer(lagertest.NewTestLogger("test"), "stager", tracing.NewWriterExporter(output), fakeClock, flushInterval)
+		process = ifrit.Invoke(tracer)
+
+		var err error
+		parent, err = tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive(BeNil()))
+	})
+
+	exportedSpans := func() []map[string]interface{} {
+		var request struct {
+			ResourceSpans []struct {
+				Resource struct {
+					Attributes []map[string]interface{} `json:"attributes"`
+				} `json:"resource"`
+				ScopeSpans []struct {
+					Spans []map[string]interface{} `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		Expect(json.Unmarshal(output.Contents(), &request)).To(Succeed())
+		return request.ResourceSpans[0].ScopeSpans[0].Spans
+	}
+
+	It("continues the trace of the parent span", func() {
+		span := tracer.Start(parent, "stage", tracing.SpanKindServer)
+
+		Expect(span.Context().TraceID).To(Equal(parent.TraceID))
+		Expect(span.Context().SpanID).NotTo(Equal(parent.SpanID))
+		Expect(span.Context().Sampled).To(BeTrue())
+	})
+
+	It("starts a new trace without a parent", func() {
+		span := tracer.Start(tracing.SpanContext{}, "stage", tracing.SpanKindServer)
+
+		Expect(span.Context().IsValid()).To(BeTrue())
+		Expect(span.Context().TraceID).NotTo(Equal(parent.TraceID))
+	})
+
+	It("exports ended spans in OTLP/JSON when flushing", func() {
+		span := tracer.Start(parent, "stage", tracing.SpanKindServer)
+		span.SetAttribute("staging_guid", "some-guid")
+		fakeClock.Increment(time.Second)
+		span.SetError(errors.New("boom"))
+		span.End()
+
+		fakeClock.WaitForWatcher()
+		Eventually(func() []byte {
+			fakeClock.Increment(flushInterval)
+			return output.Contents()
+		}).ShouldNot(BeEmpty())
+
+		spans := exportedSpans()
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0]).To(HaveKeyWithValue("traceId", "4bf92f3577b34da6a3ce929d0e0e4736"))
+		Expect(spans[0]).To(HaveKeyWithValue("parentSpanId", "00f067aa0ba902b7"))
+		Expect(spans[0]).To(HaveKeyWithValue("name", "stage"))
+		Expect(spans[0]).To(HaveKeyWithValue("kind", BeEquivalentTo(2)))
+		Expect(spans[0]).To(HaveKeyWithValue("startTimeUnixNano", "1000000000000"))
+		Expect(spans[0]).To(HaveKeyWithValue("endTimeUnixNano", "1001000000000"))
+		Expect(spans[0]["status"]).To(Equal(map[string]interface{}{"code": float64(2), "message": "boom"}))
+		Expect(spans[0]["attributes"]).To(ConsistOf(map[string]interface{}{
+			"key":   "staging_guid",
+			"value": map[string]interface{}{"stringValue": "some-guid"},
+		}))
+	})
+
+	It("flushes pending spans when stopped", func() {
+		tracer.Start(parent, "stage", tracing.SpanKindServer).End()
+
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive(BeNil()))
+
+		Expect(exportedSpans()).To(HaveLen(1))
+	})
+
+	It("does not export spans of unsampled traces", func() {
+		parent.Sampled = false
+		tracer.Start(parent, "stage", tracing.SpanKindServer).End()
+
+		fakeClock.WaitForWatcherAndIncrement(flushInterval)
+		Consistently(output.Contents).Should(BeEmpty())
+	})
+
+	Context("when the tracer is nil", func() {
+		It("still propagates span contexts", func() {
+			var nilTracer *tracing.Tracer
+
+			span := nilTracer.Start(parent, "stage", tracing.SpanKindServer)
+			Expect(span.Context().TraceID).To(Equal(parent.TraceID))
+			Expect(span.Context().IsValid()).To(BeTrue())
+
+			span.End()
+		})
+
+		It("does not start new traces", func() {
+			var nilTracer *tracing.Tracer
+
+			span := nilTracer.Start(tracing.SpanContext{}, "stage", tracing.SpanKindServer)
+			Expect(span.Context().IsValid()).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("OTLP Exporter", func() {
+	var (
+		collector *ghttp.Server
+		exporter  tracing.Exporter
+		exportErr error
+	)
+
+	BeforeEach(func() {
+		collector = ghttp.NewServer()
+		exporter = tracing.NewOTLPExporter(collector.URL()+"/", map[string]string{"Authorization": "Bearer token"}, nil)
+	})
+
+	AfterEach(func() {
+		collector.Close()
+	})
+
+	JustBeforeEach(func() {
+		exportErr = exporter.Export("stager", []tracing.SpanData{{Name: "stage", Kind: tracing.SpanKindServer}})
+	})
+
+	Context("when the collector accepts the spans", func() {
+		BeforeEach(func() {
+			collector.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/v1/traces"),
+				ghttp.VerifyHeaderKV("Content-Type", "application/json"),
+				ghttp.VerifyHeaderKV("Authorization", "Bearer token"),
+				func(w http.ResponseWriter, req *http.Request) {
+					var payload map[string]interface{}
+					Expect(json.NewDecoder(req.Body).Decode(&payload)).To(Succeed())
+					Expect(payload).To(HaveKey("resourceSpans"))
+				},
+			))
+		})
+
+		It("posts the spans to the traces endpoint", func() {
+			Expect(exportErr).NotTo(HaveOccurred())
+			Expect(collector.ReceivedRequests()).To(HaveLen(1))
+		})
+	})
+
+	Context("when the collector rejects the spans", func() {
+		BeforeEach(func() {
+			collector.AppendHandlers(ghttp.RespondWith(http.StatusBadRequest, nil))
+		})
+
+		It("returns an error", func() {
+			Expect(exportErr).To(HaveOccurred())
+		})
+	})
+})